@@ -0,0 +1,40 @@
+// Command fakeapi runs local stand-ins for the third-party APIs used by Elfume.
+//
+//	go run ./cmd/fakeapi -addr :4000
+//
+// Then point the API at it, e.g. for the GitHub image store:
+//
+//	GITHUB_API_URL=http://localhost:4000/github
+//	GITHUB_RAW_URL=http://localhost:4000/github/raw
+//
+// or for Midtrans payments, with -midtrans-notify pointing at the API's notification endpoint:
+//
+//	MIDTRANS_SNAP_URL=http://localhost:4000/midtrans
+//
+// or for shipping rates and tracking:
+//
+//	RAJAONGKIR_URL=http://localhost:4000/rajaongkir
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/GilangAndhika/elfume/fakeapi"
+)
+
+func main() {
+	addr := flag.String("addr", ":4000", "address to listen on")
+	midtransNotify := flag.String("midtrans-notify", "http://localhost:3000/payments/notification", "where the fake Midtrans sends payment notifications")
+	flag.Parse()
+
+	mux := http.NewServeMux()
+	mux.Handle("/github/", http.StripPrefix("/github", fakeapi.NewGithub(os.Getenv("GITHUB_TOKEN"))))
+	mux.Handle("/rajaongkir/", http.StripPrefix("/rajaongkir", fakeapi.NewRajaOngkir(os.Getenv("RAJAONGKIR_API_KEY"))))
+	mux.Handle("/midtrans/", http.StripPrefix("/midtrans", fakeapi.NewMidtrans(os.Getenv("MIDTRANS_SERVER_KEY"), *midtransNotify)))
+
+	log.Printf("Fake third-party APIs listening on %s (GitHub under /github, Midtrans under /midtrans, RajaOngkir under /rajaongkir)\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,266 @@
+// Command flashload storms a flash sale of a running Elfume API with buyers and checks nothing was oversold.
+//
+//	go run ./cmd/flashload -url http://localhost:3000 -sale <flash_sale_id> -perfume <perfume_id> -buyers 500
+//
+// It registers the buyers, logs them in, then releases all of them at once. Every buyer tries to buy
+// -attempts times, so the per-user limit is hit as well as the quota, and retries when the waiting room
+// sends them away. Afterwards it compares the orders placed with the flash sale's counters and exits with
+// status 1 when more bottles were sold than the quota, or the counters disagree with the orders.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// flashSaleItem is the part of a flash sale perfume the harness checks
+type flashSaleItem struct {
+	PerfumeID    string `json:"perfume_id"`
+	Quota        int    `json:"quota"`
+	Sold         int    `json:"sold"`
+	Remaining    int    `json:"remaining"`
+	PerUserLimit int    `json:"per_user_limit"`
+}
+
+// tally counts what happened to the purchase attempts
+type tally struct {
+	sync.Mutex
+	statuses  map[int]int
+	bottles   int
+	perBuyer  map[int]int
+	latencies []time.Duration
+	retries   int
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:3000", "base URL of the API")
+	saleID := flag.String("sale", "", "ID of a live flash sale")
+	perfumeID := flag.String("perfume", "", "ID of a perfume of the flash sale")
+	buyers := flag.Int("buyers", 200, "number of buyers")
+	attempts := flag.Int("attempts", 2, "purchases each buyer tries")
+	quantity := flag.Int("quantity", 1, "bottles per purchase")
+	maxWait := flag.Duration("max-wait", time.Minute, "how long a buyer keeps retrying while the waiting room is full")
+	flag.Parse()
+
+	if *saleID == "" || *perfumeID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	client := &http.Client{Timeout: 2 * time.Minute, Transport: &http.Transport{MaxIdleConnsPerHost: *buyers}}
+
+	before, err := fetchItem(client, *baseURL, *saleID, *perfumeID)
+	if err != nil {
+		log.Fatalf("Failed to read the flash sale: %v", err)
+	}
+	log.Printf("Before: quota %d, sold %d, remaining %d, per-user limit %d", before.Quota, before.Sold, before.Remaining, before.PerUserLimit)
+
+	// Sign up the buyers first, so the storm only measures buying
+	run := strconv.FormatInt(time.Now().Unix(), 36)
+	tokens := make([]string, *buyers)
+	var signup sync.WaitGroup
+	signupSlots := make(chan struct{}, 32)
+	for i := range tokens {
+		signup.Add(1)
+		go func(i int) {
+			defer signup.Done()
+			signupSlots <- struct{}{}
+			defer func() { <-signupSlots }()
+			token, err := signUp(client, *baseURL, run, i)
+			if err != nil {
+				log.Fatalf("Failed to sign up buyer %d: %v", i, err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	signup.Wait()
+	log.Printf("Signed up %d buyers, releasing them", *buyers)
+
+	result := &tally{statuses: map[int]int{}, perBuyer: map[int]int{}}
+	start := make(chan struct{})
+	var storm sync.WaitGroup
+	for i, token := range tokens {
+		storm.Add(1)
+		go func(i int, token string) {
+			defer storm.Done()
+			<-start
+			for attempt := 0; attempt < *attempts; attempt++ {
+				buy(client, *baseURL, *saleID, *perfumeID, token, i, *quantity, *maxWait, result)
+			}
+		}(i, token)
+	}
+	began := time.Now()
+	close(start)
+	storm.Wait()
+	elapsed := time.Since(began)
+
+	after, err := fetchItem(client, *baseURL, *saleID, *perfumeID)
+	if err != nil {
+		log.Fatalf("Failed to read the flash sale: %v", err)
+	}
+
+	codes := []int{}
+	for code := range result.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Printf("\n%d purchases by %d buyers in %s\n", *buyers**attempts, *buyers, elapsed.Round(time.Millisecond))
+	for _, code := range codes {
+		fmt.Printf("  %d %-24s %d\n", code, http.StatusText(code), result.statuses[code])
+	}
+	fmt.Printf("  waiting room retries        %d\n", result.retries)
+	if len(result.latencies) > 0 {
+		sort.Slice(result.latencies, func(i, j int) bool { return result.latencies[i] < result.latencies[j] })
+		percentile := func(p float64) time.Duration {
+			return result.latencies[int(p*float64(len(result.latencies)-1))].Round(time.Millisecond)
+		}
+		fmt.Printf("  latency p50 %s, p95 %s, p99 %s\n", percentile(0.50), percentile(0.95), percentile(0.99))
+	}
+	fmt.Printf("\nAfter: quota %d, sold %d, remaining %d\n", after.Quota, after.Sold, after.Remaining)
+	fmt.Printf("Bottles in orders placed: %d\n", result.bottles)
+
+	failed := false
+	check := func(ok bool, format string, args ...interface{}) {
+		status := "ok  "
+		if !ok {
+			status, failed = "FAIL", true
+		}
+		fmt.Printf("%s %s\n", status, fmt.Sprintf(format, args...))
+	}
+	check(after.Sold <= after.Quota, "sold %d is within the quota %d", after.Sold, after.Quota)
+	check(after.Remaining >= 0, "remaining %d is not negative", after.Remaining)
+	check(after.Sold+after.Remaining == after.Quota, "sold + remaining = quota")
+	check(after.Sold-before.Sold == result.bottles, "the sale counted %d new bottles, the orders hold %d", after.Sold-before.Sold, result.bottles)
+	if before.PerUserLimit > 0 {
+		over := 0
+		for _, bought := range result.perBuyer {
+			if bought > before.PerUserLimit {
+				over++
+			}
+		}
+		check(over == 0, "%d buyers bought more than the limit of %d", over, before.PerUserLimit)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fetchItem reads the counters of the perfume from the flash sale
+func fetchItem(client *http.Client, baseURL, saleID, perfumeID string) (*flashSaleItem, error) {
+	resp, err := client.Get(baseURL + "/flash-sales/" + saleID)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		FlashSale struct {
+			Items []flashSaleItem `json:"items"`
+		} `json:"flash_sale"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	for _, item := range body.FlashSale.Items {
+		if item.PerfumeID == perfumeID {
+			return &item, nil
+		}
+	}
+	return nil, fmt.Errorf("perfume %s is not part of the flash sale", perfumeID)
+}
+
+// signUp registers a buyer and logs them in, returning their token
+func signUp(client *http.Client, baseURL, run string, i int) (string, error) {
+	username := fmt.Sprintf("flashload-%s-%d", run, i)
+	password := "flashload-" + run
+	user := map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"phone":    fmt.Sprintf("0812%08d", rand.Intn(100000000)),
+		"password": password,
+	}
+	if status, _, err := postJSON(client, baseURL+"/auth/register", "", user); err != nil || status != http.StatusCreated {
+		return "", fmt.Errorf("register: status %d, %v", status, err)
+	}
+
+	status, body, err := postJSON(client, baseURL+"/auth/login", "", map[string]string{"username": username, "password": password})
+	if err != nil || status != http.StatusOK {
+		return "", fmt.Errorf("login: status %d, %v", status, err)
+	}
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &login); err != nil {
+		return "", err
+	}
+	return login.Token, nil
+}
+
+// buy tries one purchase, retrying while the waiting room is full
+func buy(client *http.Client, baseURL, saleID, perfumeID, token string, buyer, quantity int, maxWait time.Duration, result *tally) {
+	deadline := time.Now().Add(maxWait)
+	for {
+		began := time.Now()
+		status, _, err := postJSON(client, baseURL+"/flash-sales/"+saleID+"/buy", token, map[string]interface{}{"perfume_id": perfumeID, "quantity": quantity})
+		latency := time.Since(began)
+		if err != nil {
+			status = 0
+		}
+
+		if status == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+			result.Lock()
+			result.retries++
+			result.Unlock()
+			// Spread the retries out, like buyers refreshing at different moments
+			time.Sleep(time.Duration(500+rand.Intn(1500)) * time.Millisecond)
+			continue
+		}
+
+		result.Lock()
+		result.statuses[status]++
+		result.latencies = append(result.latencies, latency)
+		if status == http.StatusCreated {
+			result.bottles += quantity
+			result.perBuyer[buyer] += quantity
+		}
+		result.Unlock()
+		return
+	}
+}
+
+// postJSON sends a JSON body, with the token cookie when one is given
+func postJSON(client *http.Client, url, token string, payload interface{}) (int, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
+}
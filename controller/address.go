@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAddresses lists the logged in customer's saved addresses, the default first
+func GetAddresses(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	addresses, err := repository.GetAddresses(*userID)
+	if err != nil {
+		return addressError(c, "Failed to retrieve addresses", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "Addresses retrieved successfully",
+		"addresses": addresses,
+	})
+}
+
+// GetAddress returns one of the logged in customer's addresses
+func GetAddress(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	address, err := repository.GetAddress(c.Params("id"), *userID)
+	if err != nil {
+		return addressError(c, "Failed to retrieve address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Address retrieved successfully",
+		"address": address,
+	})
+}
+
+// CreateAddress saves a new address for the logged in customer
+func CreateAddress(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	var request model.AddressRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	address, err := repository.CreateAddress(*userID, request)
+	if err != nil {
+		return addressError(c, "Failed to create address", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Address created successfully",
+		"address": address,
+	})
+}
+
+// UpdateAddress replaces one of the logged in customer's addresses
+func UpdateAddress(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	var request model.AddressRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	address, err := repository.UpdateAddress(c.Params("id"), *userID, request)
+	if err != nil {
+		return addressError(c, "Failed to update address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Address updated successfully",
+		"address": address,
+	})
+}
+
+// SetDefaultAddress makes one of the logged in customer's addresses their default
+func SetDefaultAddress(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	address, err := repository.SetDefaultAddress(c.Params("id"), *userID)
+	if err != nil {
+		return addressError(c, "Failed to set default address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Default address set successfully",
+		"address": address,
+	})
+}
+
+// DeleteAddress removes one of the logged in customer's addresses
+func DeleteAddress(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	if err := repository.DeleteAddress(c.Params("id"), *userID); err != nil {
+		return addressError(c, "Failed to delete address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Address deleted successfully",
+	})
+}
+
+// addressError maps address errors to a status
+func addressError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidAddress):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrAddressNotFound):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetInactivePerfumes lists archived (?state=archived) or soft deleted (?state=deleted, default) perfumes
+func GetInactivePerfumes(c *fiber.Ctx) error {
+	perfumes, err := repository.GetInactivePerfumes(c.Query("state", repository.StateDeleted))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to retrieve perfumes",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Perfumes retrieved successfully",
+		"perfumes": perfumes,
+	})
+}
+
+// ArchivePerfume hides a perfume from the catalogue without deleting it
+func ArchivePerfume(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	// Only archive if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	err = repository.ArchivePerfume(perfumeID, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to archive perfume",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume archived successfully",
+	})
+}
+
+// RestorePerfume brings back an archived or soft deleted perfume
+func RestorePerfume(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	if err := repository.RestorePerfume(perfumeID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to restore perfume",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume restored successfully",
+	})
+}
+
+// GetInactiveUsers lists archived (?state=archived) or soft deleted (?state=deleted, default) users
+func GetInactiveUsers(c *fiber.Ctx) error {
+	users, err := repository.GetInactiveUsers(c.Query("state", repository.StateDeleted))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to retrieve users",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Users retrieved successfully",
+		"users":   users,
+	})
+}
+
+// ArchiveUser deactivates a user account without deleting it
+func ArchiveUser(c *fiber.Ctx) error {
+	userID := c.Params("id")
+
+	// Only archive if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	err = repository.ArchiveUser(userID, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to archive user",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User archived successfully",
+	})
+}
+
+// RestoreUser brings back an archived or soft deleted user
+func RestoreUser(c *fiber.Ctx) error {
+	userID := c.Params("id")
+
+	if err := repository.RestoreUser(userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to restore user",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User restored successfully",
+	})
+}
@@ -160,6 +160,11 @@ func Login(ctx *fiber.Ctx) error {
 		Secure:   true,                          // Set to true if using HTTPS
 	})
 
+	// Carry the guest cart over to the customer's cart
+	if guestToken := ctx.Cookies(guestCartCookie); repository.IsGuestCartToken(guestToken) {
+		mergeGuestCart(ctx, guestToken, foundUser.UserID)
+	}
+
 	// Return token in response
 	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Login successful",
@@ -197,8 +202,17 @@ func UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only update if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
 	// Update the user in the database
-	err := repository.UpdateUser(userID, updatedUser)
+	err = repository.UpdateUser(userID, updatedUser, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Failed to update user",
@@ -211,13 +225,60 @@ func UpdateUser(c *fiber.Ctx) error {
 	})
 }
 
+// PatchUser handles partial updates with JSON Merge Patch or JSON Patch, only the supplied fields change
+func PatchUser(c *fiber.Ctx) error {
+	// Get user ID from params
+	userID := c.Params("id")
+
+	// Users may only patch themselves, admins anyone
+	admin := isAdmin(c)
+	if loggedIn := loggedInUserID(c); !admin && (loggedIn == nil || loggedIn.Hex() != userID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "Forbidden"})
+	}
+
+	// Only patch if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	user, err := repository.PatchUser(userID, c.Get(fiber.HeaderContentType), c.Body(), expectedVersion, admin)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return c.Status(patchErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to update user",
+			"error":   err.Error(),
+		})
+	}
+
+	// Never send the password hash back
+	user.Password = ""
+
+	setETag(c, user.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User updated successfully",
+		"user":    user,
+	})
+}
+
 // DeleteUser handles deleting an existing user
 func DeleteUser(c *fiber.Ctx) error {
 	// Get user ID from URL params
 	userID := c.Params("id")
 
+	// Only delete if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
 	// Delete the user from the database
-	err := repository.DeleteUser(userID)
+	err = repository.DeleteUser(userID, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Failed to delete user",
@@ -244,6 +305,12 @@ func GetUserByID(c *fiber.Ctx) error {
 		})
 	}
 
+	// Let clients revalidate their cached copy cheaply
+	setETag(c, user.Version)
+	if notModified(c, user.Version) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "User retrieved successfully",
 		"user":    user,
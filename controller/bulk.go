@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PreviewBulkUpdate counts the perfumes a bulk filter selects and whether applying to them needs confirmation
+func PreviewBulkUpdate(c *fiber.Ctx) error {
+	var request model.BulkRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	matched, err := repository.CountBulkPerfumes(request.Filter)
+	if err != nil {
+		return bulkError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":               "Bulk filter previewed successfully",
+		"matched":               matched,
+		"requires_confirmation": matched > repository.BulkConfirmThreshold(),
+	})
+}
+
+// RunBulkUpdate applies operations to every perfume a filter selects, or reports what would change with dry_run
+func RunBulkUpdate(c *fiber.Ctx) error {
+	var request model.BulkRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	result, err := repository.RunBulkUpdate(request, revisionAuthor(c))
+	if errors.Is(err, repository.ErrBulkConfirmationRequired) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"message": "Confirmation required",
+			"error":   err.Error(),
+			"matched": result.Matched,
+		})
+	}
+	if err != nil {
+		return bulkError(c, err)
+	}
+
+	message := "Bulk update applied successfully"
+	if result.DryRun {
+		message = "Bulk update dry run completed"
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": message,
+		"result":  result,
+	})
+}
+
+// GetBulkOperations lists the most recent applied bulk updates
+func GetBulkOperations(c *fiber.Ctx) error {
+	operations, err := repository.GetBulkOperations()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to retrieve bulk operations",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Bulk operations retrieved successfully",
+		"operations": operations,
+	})
+}
+
+// GetBulkOperation returns an applied bulk update with every perfume it changed
+func GetBulkOperation(c *fiber.Ctx) error {
+	operation, err := repository.GetBulkOperation(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Bulk operation not found",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "Bulk operation retrieved successfully",
+		"operation": operation,
+	})
+}
+
+// bulkError reports an invalid bulk request as 400 and anything else as 500
+func bulkError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, repository.ErrInvalidBulk) {
+		status = fiber.StatusBadRequest
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": "Failed to run bulk update",
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// guestCartCookie holds the token of a guest cart
+const guestCartCookie = "cart"
+
+// loggedInUserID returns the ID of the logged in user, the route needs JWTMiddleware or OptionalJWT
+func loggedInUserID(c *fiber.Ctx) *primitive.ObjectID {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	userID, _ := claims["user_id"].(string)
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil
+	}
+	return &objID
+}
+
+// cartOwner identifies the cart of a request: the logged in user's, or the guest cart of the cookie.
+// A guest cart still in the cookie of a logged in user is merged into the user's cart. With create,
+// a guest without a cart gets a new cookie so the cart can be found again.
+func cartOwner(c *fiber.Ctx, create bool) repository.CartOwner {
+	guestToken := c.Cookies(guestCartCookie)
+	if !repository.IsGuestCartToken(guestToken) {
+		guestToken = ""
+	}
+
+	if userID := loggedInUserID(c); userID != nil {
+		if guestToken != "" {
+			mergeGuestCart(c, guestToken, *userID)
+		}
+		return repository.CartOwner{UserID: userID}
+	}
+
+	if guestToken == "" && create {
+		guestToken = repository.NewGuestCartToken()
+	}
+	if guestToken != "" && create {
+		// Every change keeps the cookie alive as long as the cart
+		c.Cookie(&fiber.Cookie{
+			Name:     guestCartCookie,
+			Value:    guestToken,
+			Expires:  time.Now().Add(repository.GuestCartTTL()),
+			HTTPOnly: true,
+			Secure:   true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	return repository.CartOwner{GuestToken: guestToken}
+}
+
+// mergeGuestCart moves a guest cart into the user's cart and clears the cookie. A failure is only logged,
+// the guest cart stays and is merged on the next request.
+func mergeGuestCart(c *fiber.Ctx, guestToken string, userID primitive.ObjectID) {
+	if err := repository.MergeGuestCart(guestToken, userID); err != nil {
+		log.Printf("Failed to merge guest cart into the cart of user %s: %v", userID.Hex(), err)
+		return
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     guestCartCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// GetCart returns the cart of the guest or customer, checked against the current prices and stock
+func GetCart(c *fiber.Ctx) error {
+	cart, err := repository.GetCart(cartOwner(c, false))
+	if err != nil {
+		return cartError(c, "Failed to retrieve cart", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Cart retrieved successfully",
+		"cart":    cart,
+	})
+}
+
+// AddCartItem puts a perfume in the cart
+func AddCartItem(c *fiber.Ctx) error {
+	var request model.CartItemRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	cart, err := repository.AddCartItem(cartOwner(c, true), request)
+	if err != nil {
+		return cartError(c, "Failed to add perfume to cart", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume added to cart",
+		"cart":    cart,
+	})
+}
+
+// UpdateCartItem changes the quantity of a cart line, 0 removes it
+func UpdateCartItem(c *fiber.Ctx) error {
+	var request model.CartItemRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	cart, err := repository.UpdateCartItem(cartOwner(c, true), c.Params("itemId"), request.Quantity)
+	if err != nil {
+		return cartError(c, "Failed to update cart", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Cart updated successfully",
+		"cart":    cart,
+	})
+}
+
+// RemoveCartItem removes a line from the cart
+func RemoveCartItem(c *fiber.Ctx) error {
+	cart, err := repository.RemoveCartItem(cartOwner(c, true), c.Params("itemId"))
+	if err != nil {
+		return cartError(c, "Failed to remove perfume from cart", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume removed from cart",
+		"cart":    cart,
+	})
+}
+
+// AddPromoCode applies a voucher code to the cart
+func AddPromoCode(c *fiber.Ctx) error {
+	var request model.PromoCodeRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	cart, err := repository.AddPromoCode(cartOwner(c, true), request.Code)
+	if err != nil {
+		return cartError(c, "Failed to apply voucher", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Voucher applied",
+		"cart":    cart,
+	})
+}
+
+// RemovePromoCode takes a voucher code off the cart
+func RemovePromoCode(c *fiber.Ctx) error {
+	cart, err := repository.RemovePromoCode(cartOwner(c, true), c.Params("code"))
+	if err != nil {
+		return cartError(c, "Failed to remove voucher", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Voucher removed",
+		"cart":    cart,
+	})
+}
+
+// ClearCart empties the cart
+func ClearCart(c *fiber.Ctx) error {
+	if err := repository.ClearCart(cartOwner(c, false)); err != nil {
+		return cartError(c, "Failed to clear cart", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Cart cleared successfully",
+	})
+}
+
+// cartError maps cart errors to a status: 400 for changes that are not allowed, 404 for unknown lines and vouchers,
+// 409 when the cart kept changing under concurrent requests
+func cartError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidCart):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrCartItemNotFound), errors.Is(err, repository.ErrPromotionNotFound):
+		status = fiber.StatusNotFound
+	case isVersionConflict(err):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CreateCategory handles the creation of a new category in the fragrance family tree
+func CreateCategory(c *fiber.Ctx) error {
+	var category model.Category
+
+	// Parse request body
+	if err := c.BodyParser(&category); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	if category.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Category name is required",
+		})
+	}
+
+	// Insert the category under its parent
+	if err := repository.CreateCategory(&category); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to create category",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Category created successfully",
+		"category": category,
+	})
+}
+
+// GetAllCategories returns every category as a flat list
+func GetAllCategories(c *fiber.Ctx) error {
+	categories, err := repository.GetAllCategories()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to fetch categories",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Categories retrieved successfully",
+		"categories": categories,
+	})
+}
+
+// GetCategoryTree returns the categories nested under their parents
+func GetCategoryTree(c *fiber.Ctx) error {
+	tree, err := repository.GetCategoryTree()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to fetch category tree",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Category tree retrieved successfully",
+		"categories": tree,
+	})
+}
+
+// GetCategoryByID returns a single category together with its breadcrumb
+func GetCategoryByID(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+
+	category, err := repository.GetCategoryByID(categoryID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Category not found",
+			"error":   err.Error(),
+		})
+	}
+
+	breadcrumb, err := repository.GetCategoryBreadcrumb(categoryID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to build breadcrumb",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Category retrieved successfully",
+		"category":   category,
+		"breadcrumb": breadcrumb,
+	})
+}
+
+// GetCategoryPerfumes returns the perfumes in a category, including its descendant categories
+func GetCategoryPerfumes(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+
+	if _, err := repository.GetCategoryByID(categoryID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Category not found",
+			"error":   err.Error(),
+		})
+	}
+
+	perfumes, err := repository.GetPerfumesByCategory(categoryID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to fetch perfumes",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Perfumes retrieved successfully",
+		"perfumes": perfumes,
+	})
+}
+
+// UpdateCategory handles renaming or moving a category
+func UpdateCategory(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+
+	// Parse request body
+	var updatedCategory model.Category
+	if err := c.BodyParser(&updatedCategory); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	if updatedCategory.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Category name is required",
+		})
+	}
+
+	if err := repository.UpdateCategory(categoryID, updatedCategory); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to update category",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Category updated successfully",
+	})
+}
+
+// DeleteCategory handles deleting a category without children
+func DeleteCategory(c *fiber.Ctx) error {
+	categoryID := c.Params("id")
+
+	if err := repository.DeleteCategory(categoryID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to delete category",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Category deleted successfully",
+	})
+}
+
+// SetPerfumeCategories replaces the categories assigned to a perfume
+func SetPerfumeCategories(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	// Parse request body
+	var request model.PerfumeCategoryRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	categoryIDs, err := repository.ParseCategoryIDs(request.CategoryIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid categories",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := repository.SetPerfumeCategories(perfumeID, categoryIDs); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to update perfume categories",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":      "Perfume categories updated successfully",
+		"category_ids": categoryIDs,
+	})
+}
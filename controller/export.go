@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"time"
+
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportTimeout bounds how long one export may keep its database cursor open
+const exportTimeout = 30 * time.Minute
+
+// ExportPerfumes streams the published perfumes as CSV, NDJSON or a product feed (?format=csv|ndjson|feed),
+// filtered with the same query parameters as GetFilteredPerfumes
+func ExportPerfumes(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	contentType, ok := repository.ExportContentTypes[format]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Unknown export format, use csv, ndjson or feed",
+		})
+	}
+
+	// The body is written after the handler returns, so the cursor gets its own context
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	stream, err := repository.OpenPerfumeStream(ctx, searchFilters(c))
+	if err != nil {
+		cancel()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to export perfumes",
+			"error":   err.Error(),
+		})
+	}
+
+	// Nothing from c may be used once streaming starts, read the links now
+	productPrefix := productURL(c, "")
+	exportOptions := repository.ExportOptions{
+		SiteURL:    c.BaseURL(),
+		ProductURL: func(slug string) string { return productPrefix + slug },
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	if format != "feed" {
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="perfumes-`+time.Now().Format("20060102")+"."+format+`"`)
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer stream.Close(ctx)
+
+		if _, err := repository.WritePerfumeExport(ctx, w, format, stream, exportOptions); err != nil {
+			log.Printf("Perfume export stopped: %v", err)
+		}
+		w.Flush()
+	})
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetFlashSales lists flash sales, ?state=upcoming, live or ended. By default the upcoming and live ones.
+func GetFlashSales(c *fiber.Ctx) error {
+	sales, err := repository.GetFlashSales(c.Query("state"))
+	if err != nil {
+		return flashSaleError(c, "Failed to retrieve flash sales", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "Flash sales retrieved successfully",
+		"flash_sales": sales,
+	})
+}
+
+// GetFlashSale returns a flash sale with the bottles left of each perfume
+func GetFlashSale(c *fiber.Ctx) error {
+	sale, err := repository.GetFlashSale(c.Params("id"))
+	if err != nil {
+		return flashSaleError(c, "Failed to retrieve flash sale", err)
+	}
+
+	// Shoppers refresh the countdown page a lot, a second of caching takes most of that off the database
+	c.Set(fiber.HeaderCacheControl, "public, max-age=1")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Flash sale retrieved successfully",
+		"flash_sale": sale,
+	})
+}
+
+// CreateFlashSale schedules a flash sale, reserving the quotas from stock
+func CreateFlashSale(c *fiber.Ctx) error {
+	var request model.FlashSaleRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	sale, err := repository.CreateFlashSale(request, revisionAuthor(c))
+	if err != nil {
+		return flashSaleError(c, "Failed to create flash sale", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Flash sale created successfully",
+		"flash_sale": sale,
+	})
+}
+
+// EndFlashSale stops a flash sale early and puts the unsold bottles back into stock
+func EndFlashSale(c *fiber.Ctx) error {
+	sale, err := repository.EndFlashSale(c.Params("id"))
+	if err != nil {
+		return flashSaleError(c, "Failed to end flash sale", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Flash sale ended successfully",
+		"flash_sale": sale,
+	})
+}
+
+// BuyFlashSale orders a perfume of a live flash sale at its flash price
+func BuyFlashSale(c *fiber.Ctx) error {
+	var request model.FlashSalePurchaseRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	order, err := repository.BuyFlashSale(c.Params("id"), *userID, revisionAuthor(c), request)
+	if err != nil {
+		return flashSaleError(c, "Failed to buy flash sale perfume", err)
+	}
+
+	c.Location("/orders/" + order.OrderID.Hex())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Order placed successfully",
+		"order":   order,
+	})
+}
+
+// flashSaleError maps flash sale errors to a status: 400 for requests that are not allowed, 403 past the
+// per-user limit, 404 for unknown sales, 409 when sold out or the stock changed while reserving it
+func flashSaleError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidFlashSale), errors.Is(err, repository.ErrInvalidCart), errors.Is(err, repository.ErrInvalidOrder):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrFlashSaleLimit):
+		status = fiber.StatusForbidden
+	case errors.Is(err, repository.ErrFlashSaleNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, repository.ErrFlashSaleSoldOut), isVersionConflict(err):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
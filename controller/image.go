@@ -0,0 +1,339 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readImageUploads collects the image files of a multipart request.
+// Files are sent as repeated "images" fields (the single "image" field is still accepted),
+// and the optional repeated "alt_text" and "kind" fields are matched to the files by position.
+func readImageUploads(c *fiber.Ctx) ([]model.FumeImgUpload, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	files := append(form.File["image"], form.File["images"]...)
+	altTexts := form.Value["alt_text"]
+	kinds := form.Value["kind"]
+
+	uploads := []model.FumeImgUpload{}
+	for i, file := range files {
+		// Reject oversized files before reading them into memory
+		if file.Size > repository.MaxImageBytes() {
+			return nil, fmt.Errorf("%w: %s is larger than %d bytes", repository.ErrInvalidImage, file.Filename, repository.MaxImageBytes())
+		}
+
+		content, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		upload := model.FumeImgUpload{
+			FileName:    file.Filename,
+			Content:     content,
+			ContentType: file.Header.Get("Content-Type"),
+		}
+		if i < len(altTexts) {
+			upload.AltText = altTexts[i]
+		}
+		if i < len(kinds) {
+			upload.Kind = kinds[i]
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, nil
+}
+
+// imageErrorStatus answers 400 for rejected images, 409 when the perfume kept changing
+// during the update and 500 for everything else
+func imageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrInvalidImage):
+		return fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrVersionConflict):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+// galleryEditStatus answers 409 when the perfume kept changing during a gallery edit, otherwise fallback
+func galleryEditStatus(err error, fallback int) int {
+	if errors.Is(err, repository.ErrVersionConflict) {
+		return fiber.StatusConflict
+	}
+	return fallback
+}
+
+// GetPerfumeImages returns the image gallery of a perfume
+func GetPerfumeImages(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	images, err := repository.GetPerfumeImages(perfumeID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Perfume not found",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Images retrieved successfully",
+		"images":  images,
+	})
+}
+
+// AddPerfumeImages handles uploading extra images to a perfume gallery
+func AddPerfumeImages(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	uploads, err := readImageUploads(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid image upload",
+			"error":   err.Error(),
+		})
+	}
+	if len(uploads) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Image file is required",
+		})
+	}
+
+	images, err := repository.AddPerfumeImages(perfumeID, uploads)
+	if err != nil {
+		return c.Status(imageErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to add images",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Images added successfully",
+		"images":  images,
+	})
+}
+
+// UpdatePerfumeImage handles changing the alt text and kind of an image
+func UpdatePerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	imageID := c.Params("imageId")
+
+	// Parse request body
+	var request model.PerfumeImageUpdateRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	images, err := repository.UpdatePerfumeImage(perfumeID, imageID, request)
+	if err != nil {
+		return c.Status(galleryEditStatus(err, fiber.StatusNotFound)).JSON(fiber.Map{
+			"message": "Failed to update image",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Image updated successfully",
+		"images":  images,
+	})
+}
+
+// SetPrimaryPerfumeImage handles choosing the primary image of a perfume
+func SetPrimaryPerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	imageID := c.Params("imageId")
+
+	images, err := repository.SetPrimaryPerfumeImage(perfumeID, imageID)
+	if err != nil {
+		return c.Status(galleryEditStatus(err, fiber.StatusNotFound)).JSON(fiber.Map{
+			"message": "Failed to set primary image",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Primary image updated successfully",
+		"images":  images,
+	})
+}
+
+// ReorderPerfumeImages handles changing the order of the gallery
+func ReorderPerfumeImages(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	// Parse request body
+	var request model.PerfumeImageOrderRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	images, err := repository.ReorderPerfumeImages(perfumeID, request.ImageIDs)
+	if err != nil {
+		return c.Status(galleryEditStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
+			"message": "Failed to reorder images",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Images reordered successfully",
+		"images":  images,
+	})
+}
+
+// DeletePerfumeImage handles removing a single image from the gallery
+func DeletePerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	imageID := c.Params("imageId")
+
+	images, err := repository.DeletePerfumeImage(perfumeID, imageID)
+	if err != nil {
+		return c.Status(galleryEditStatus(err, fiber.StatusNotFound)).JSON(fiber.Map{
+			"message": "Failed to delete image",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Image deleted successfully",
+		"images":  images,
+	})
+}
+
+// ReplacePerfumeImage handles uploading a new file for an existing gallery image
+func ReplacePerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	imageID := c.Params("imageId")
+
+	uploads, err := readImageUploads(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid image upload",
+			"error":   err.Error(),
+		})
+	}
+	if len(uploads) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Exactly one image file is required",
+		})
+	}
+
+	images, err := repository.ReplacePerfumeImage(perfumeID, imageID, uploads[0])
+	if err != nil {
+		return c.Status(imageErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to replace image",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Image replaced successfully",
+		"images":  images,
+	})
+}
+
+// ReconcileImages handles finding stored images no perfume references. It only lists them unless
+// dry_run=false asks to delete them.
+func ReconcileImages(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dry_run", true)
+
+	orphans, err := repository.ReconcileImages(c.Context(), repository.ImageOrphanGrace(), dryRun)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to reconcile images",
+			"error":   err.Error(),
+		})
+	}
+
+	message := "Orphaned images deleted successfully"
+	if dryRun {
+		message = "Orphaned images found"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": message,
+		"dry_run": dryRun,
+		"images":  orphans,
+	})
+}
+
+// uploadErrorStatus maps the errors of the direct upload flow to status codes
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrUploadsNotSupported):
+		return fiber.StatusNotImplemented
+	case errors.Is(err, repository.ErrUploadNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, repository.ErrObjectNotFound), errors.Is(err, repository.ErrUploadInProgress):
+		return fiber.StatusConflict
+	}
+	return imageErrorStatus(err)
+}
+
+// PresignPerfumeImage hands out a URL the client uploads an image to directly, bypassing the API
+func PresignPerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	// Parse request body
+	var request model.PresignUploadRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	// Make sure the perfume exists
+	if _, err := repository.GetPerfumeByID(perfumeID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Perfume not found",
+			"error":   err.Error(),
+		})
+	}
+
+	upload, err := repository.CreateImageUpload(perfumeID, request)
+	if err != nil {
+		return c.Status(uploadErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to create upload",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Upload URL created successfully",
+		"upload":  upload,
+	})
+}
+
+// ConfirmPerfumeImage validates a directly uploaded image and adds it to the perfume gallery
+func ConfirmPerfumeImage(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	uploadID := c.Params("uploadId")
+
+	images, err := repository.ConfirmImageUpload(perfumeID, uploadID)
+	if err != nil {
+		return c.Status(uploadErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to confirm upload",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Upload confirmed successfully",
+		"images":  images,
+	})
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportPerfumes starts an import of perfumes from a CSV or XLSX file, the rows are imported in the background
+func ImportPerfumes(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Spreadsheet file is required",
+			"error":   err.Error(),
+		})
+	}
+	content, err := readFile(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to read file",
+			"error":   err.Error(),
+		})
+	}
+
+	// Optional column mapping, e.g. {"Nama Produk": "name", "Harga": "price"}
+	var mapping map[string]string
+	if value := c.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid mapping",
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	dryRun, _ := strconv.ParseBool(c.FormValue("dry_run", c.Query("dry_run")))
+
+	job, err := repository.StartPerfumeImport(model.PerfumeImportRequest{
+		FileName: file.Filename,
+		Content:  content,
+		Mapping:  mapping,
+		DryRun:   dryRun,
+		Author:   revisionAuthor(c),
+	})
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, repository.ErrInvalidImport) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"message": "Failed to start import",
+			"error":   err.Error(),
+		})
+	}
+
+	c.Location("/fume/import/" + job.JobID.Hex())
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Import started",
+		"job":     job,
+	})
+}
+
+// GetImportJob returns the progress and row errors of an import
+func GetImportJob(c *fiber.Ctx) error {
+	job, err := repository.GetImportJob(c.Params("jobId"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Import job not found",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Import job retrieved successfully",
+		"job":     job,
+	})
+}
+
+// GetImportJobs lists the most recent imports
+func GetImportJobs(c *fiber.Ctx) error {
+	jobs, err := repository.GetImportJobs()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to retrieve import jobs",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Import jobs retrieved successfully",
+		"jobs":    jobs,
+	})
+}
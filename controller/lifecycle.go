@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPerfumesByStatus lists the perfumes in a lifecycle status (?status=draft by default)
+func GetPerfumesByStatus(c *fiber.Ctx) error {
+	perfumes, err := repository.GetPerfumesByStatus(c.Query("status", model.StatusDraft))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to retrieve perfumes",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Perfumes retrieved successfully",
+		"perfumes": perfumes,
+	})
+}
+
+// UpdatePerfumeStatus handles publishing, scheduling, unpublishing and discontinuing a perfume
+func UpdatePerfumeStatus(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	// Parse request body
+	var request model.PerfumeStatusRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	// Only update if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	perfume, err := repository.UpdatePerfumeStatus(perfumeID, request, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		status := fiber.StatusNotFound
+		if errors.Is(err, repository.ErrInvalidLifecycle) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"message": "Failed to update perfume status",
+			"error":   err.Error(),
+		})
+	}
+
+	setETag(c, perfume.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume status updated successfully",
+		"perfume": perfume,
+	})
+}
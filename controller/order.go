@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PlaceOrder turns the customer's cart into an order, taking the stock of every line
+func PlaceOrder(c *fiber.Ctx) error {
+	var request model.OrderRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	order, err := repository.PlaceOrder(*userID, revisionAuthor(c), request)
+	var conflict *repository.OrderConflictError
+	if errors.As(err, &conflict) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"message": "Some items in the cart cannot be ordered",
+			"error":   err.Error(),
+			"items":   conflict.Items,
+		})
+	}
+	if err != nil {
+		return orderError(c, "Failed to place order", err)
+	}
+
+	c.Location("/orders/" + order.OrderID.Hex())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Order placed successfully",
+		"order":   order,
+	})
+}
+
+// GetOrder returns an order of the logged in customer, admins can read every order
+func GetOrder(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if isAdmin(c) {
+		userID = nil
+	}
+
+	order, err := repository.GetOrder(c.Params("id"), userID)
+	if err != nil {
+		return orderError(c, "Failed to retrieve order", err)
+	}
+
+	setETag(c, order.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Order retrieved successfully",
+		"order":   order,
+	})
+}
+
+// GetMyOrders lists the orders of the logged in customer, newest first
+func GetMyOrders(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	return listOrders(c, model.OrderFilter{
+		UserID: userID,
+		Status: c.Query("status"),
+		Page:   c.QueryInt("page", 1),
+		Limit:  c.QueryInt("limit", 50),
+	})
+}
+
+// GetAllOrders lists the orders of every customer by status and the date they were placed
+func GetAllOrders(c *fiber.Ctx) error {
+	filter := model.OrderFilter{
+		Status: c.Query("status"),
+		Page:   c.QueryInt("page", 1),
+		Limit:  c.QueryInt("limit", 50),
+	}
+
+	if userID := c.Query("user_id"); userID != "" {
+		objID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid user ID",
+				"error":   err.Error(),
+			})
+		}
+		filter.UserID = &objID
+	}
+
+	var err error
+	if filter.From, err = parseQueryDate(c.Query("from"), false); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid from date",
+			"error":   err.Error(),
+		})
+	}
+	if filter.To, err = parseQueryDate(c.Query("to"), true); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid to date",
+			"error":   err.Error(),
+		})
+	}
+
+	return listOrders(c, filter)
+}
+
+func listOrders(c *fiber.Ctx, filter model.OrderFilter) error {
+	orders, total, err := repository.GetOrders(filter)
+	if err != nil {
+		return orderError(c, "Failed to retrieve orders", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Orders retrieved successfully",
+		"orders":  orders,
+		"total":   total,
+	})
+}
+
+// parseQueryDate reads an RFC 3339 time or a plain date. A plain date used as the end of a range
+// includes that whole day.
+func parseQueryDate(value string, endOfDay bool) (*primitive.DateTime, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		date := primitive.NewDateTimeFromTime(parsed)
+		return &date, nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an RFC 3339 time or a YYYY-MM-DD date", value)
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	date := primitive.NewDateTimeFromTime(parsed)
+	return &date, nil
+}
+
+// CancelOrder cancels an order of the logged in customer before it is packed. Admins can cancel any order until it is shipped.
+func CancelOrder(c *fiber.Ctx) error {
+	var request model.OrderStatusRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			})
+		}
+	}
+	request.Status = model.OrderCancelled
+
+	actor, customerID := model.ActorCustomer, loggedInUserID(c)
+	if isAdmin(c) {
+		actor, customerID = model.ActorAdmin, nil
+	}
+
+	order, err := repository.TransitionOrder(c.Params("id"), request, actor, revisionAuthor(c), customerID, nil)
+	if err != nil {
+		return orderError(c, "Failed to cancel order", err)
+	}
+
+	setETag(c, order.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Order cancelled successfully",
+		"order":   order,
+	})
+}
+
+// UpdateOrderStatus moves an order to another status
+func UpdateOrderStatus(c *fiber.Ctx) error {
+	var request model.OrderStatusRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	// Only change the status if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	order, err := repository.TransitionOrder(c.Params("id"), request, model.ActorAdmin, revisionAuthor(c), nil, expectedVersion)
+	if expectedVersion != nil && isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return orderError(c, "Failed to update order status", err)
+	}
+
+	setETag(c, order.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Order status updated successfully",
+		"order":   order,
+	})
+}
+
+// orderError maps order errors to a status
+func orderError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidOrder):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrOrderNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, repository.ErrTransitionForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, repository.ErrInvalidTransition), isVersionConflict(err):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StartPayment starts paying an order of the logged in customer and returns the Snap token and payment page
+func StartPayment(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if userID == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+	}
+
+	payment, err := repository.StartPayment(c.Params("id"), *userID)
+	if err != nil {
+		return paymentError(c, "Failed to start payment", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Payment started successfully",
+		"payment": payment,
+	})
+}
+
+// GetOrderPayments lists the payment attempts of an order
+func GetOrderPayments(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if isAdmin(c) {
+		userID = nil
+	}
+
+	payments, err := repository.GetOrderPayments(c.Params("id"), userID)
+	if err != nil {
+		return paymentError(c, "Failed to retrieve payments", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Payments retrieved successfully",
+		"payments": payments,
+	})
+}
+
+// PaymentNotification receives the payment provider's notifications. Anything but a 2xx makes the provider
+// send the notification again later, so only notifications we can never accept are answered with 4xx.
+func PaymentNotification(c *fiber.Ctx) error {
+	payment, err := repository.HandlePaymentNotification(c.Body())
+	if err != nil {
+		return paymentError(c, "Failed to process payment notification", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Notification processed",
+		"status":  payment.Status,
+	})
+}
+
+// paymentError maps payment errors to a status
+func paymentError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrPaymentsDisabled):
+		status = fiber.StatusServiceUnavailable
+	case errors.Is(err, repository.ErrInvalidPayment):
+		status = fiber.StatusConflict
+	case errors.Is(err, repository.ErrInvalidNotification):
+		status = fiber.StatusForbidden
+	case errors.Is(err, repository.ErrOrderNotFound), errors.Is(err, repository.ErrPaymentNotFound):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
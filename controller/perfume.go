@@ -1,10 +1,10 @@
 package controller
 
 import (
-	"encoding/base64"
+	"errors"
 	"io/ioutil"
 	"mime/multipart"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/GilangAndhika/elfume/model"
@@ -19,44 +19,72 @@ func CreatePerfume(c *fiber.Ctx) error {
 	perfume := model.Perfume{
 		Name:        c.FormValue("name"),
 		Brand:       c.FormValue("brand"),
+		SKU:         strings.TrimSpace(c.FormValue("sku")),
 		Types:       c.FormValue("types"),
 		Categories:  c.FormValue("categories"),
 		Sizes:       c.FormValue("sizes"),
 		Price:       c.FormValue("price"),
 		Description: c.FormValue("description"),
 		Stock:       c.FormValue("stock"),
+		Weight:      c.FormValue("weight"),
+		Status:      c.FormValue("status"),
+		Tags:        splitTags(c.FormValue("tags")),
 	}
 
-	// Get uploaded file
-	file, err := c.FormFile("image")
+	// New perfumes start as drafts unless a status is sent
+	var err error
+	if perfume.PublishAt, err = parseFormDate(c, "publish_at"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid publish_at",
+			"error":   err.Error(),
+		})
+	}
+	if perfume.UnpublishAt, err = parseFormDate(c, "unpublish_at"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid unpublish_at",
+			"error":   err.Error(),
+		})
+	}
+	if err := repository.NormalizePerfumeLifecycle(&perfume); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid status",
+			"error":   err.Error(),
+		})
+	}
+
+	// Resolve category tree assignments (comma separated IDs)
+	categoryIDs, err := repository.ParseCategoryIDs(strings.Split(c.FormValue("category_ids"), ","))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Image file is required",
+			"message": "Invalid categories",
 			"error":   err.Error(),
 		})
 	}
+	perfume.CategoryIDs = categoryIDs
 
-	// Read file as base64
-	base64String, err := readFileAsBase64(file)
+	// Read uploaded images (at least one is required)
+	uploads, err := readImageUploads(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Failed to process image file",
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid image upload",
 			"error":   err.Error(),
 		})
 	}
+	if len(uploads) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Image file is required",
+		})
+	}
 
 	// Assign ID and timestamps
 	perfume.PerfumeID = primitive.NewObjectID()
 	perfume.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	perfume.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 
-	// Generate a unique filename using perfume ID
-	fileName := perfume.PerfumeID.Hex() + filepath.Ext(file.Filename)
-
-	// Upload image and save perfume
-	err = repository.CreatePerfume(&perfume, base64String, fileName)
+	// Upload images and save perfume
+	err = repository.CreatePerfume(&perfume, uploads)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		return c.Status(imageErrorStatus(err)).JSON(fiber.Map{
 			"message": "Failed to create perfume",
 			"error":   err.Error(),
 		})
@@ -68,23 +96,42 @@ func CreatePerfume(c *fiber.Ctx) error {
 	})
 }
 
-// readFileAsBase64 converts a file to a Base64 encoded string
-func readFileAsBase64(file *multipart.FileHeader) (string, error) {
+// parseFormDate reads an optional RFC 3339 timestamp from a form field
+func parseFormDate(c *fiber.Ctx, field string) (*primitive.DateTime, error) {
+	value := c.FormValue(field)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	date := primitive.NewDateTimeFromTime(parsed)
+	return &date, nil
+}
+
+// splitTags lists the comma separated tags of a form field, e.g. "sale, bestseller"
+func splitTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// readFile reads the content of an uploaded file
+func readFile(file *multipart.FileHeader) ([]byte, error) {
 	// Open the uploaded file
 	f, err := file.Open()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer f.Close()
 
 	// Read file content
-	fileBytes, err := ioutil.ReadAll(f)
-	if err != nil {
-		return "", err
-	}
-
-	// Encode file content to Base64
-	return base64.StdEncoding.EncodeToString(fileBytes), nil
+	return ioutil.ReadAll(f)
 }
 
 // GetAllPerfumes returns all perfumes from the database
@@ -111,12 +158,25 @@ func GetPerfumeByID(c *fiber.Ctx) error {
 		})
 	}
 
+	// Drafts, scheduled and discontinued perfumes are only visible to admins
+	if !repository.IsPerfumePublic(perfume) && !isAdmin(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Perfume not found",
+		})
+	}
+
+	// Let clients revalidate their cached copy cheaply
+	setCanonicalLink(c, perfume)
+	setETag(c, perfume.Version)
+	if notModified(c, perfume.Version) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
 	return c.JSON(perfume)
 }
 
-// GetFilteredPerfumes returns perfumes with optional filters
-func GetFilteredPerfumes(c *fiber.Ctx) error {
-	// Get query parameters (e.g., ?name=Dior&size=100ml)
+// searchFilters reads the search criteria from the query (e.g., ?name=Dior&size=100ml)
+func searchFilters(c *fiber.Ctx) map[string]string {
 	filters := make(map[string]string)
 	if name := c.Query("name"); name != "" {
 		filters["name"] = name
@@ -130,15 +190,25 @@ func GetFilteredPerfumes(c *fiber.Ctx) error {
 	if category := c.Query("categories"); category != "" {
 		filters["categories"] = category
 	}
+	if categoryID := c.Query("category_id"); categoryID != "" {
+		filters["category_id"] = categoryID
+	}
 	if types := c.Query("types"); types != "" {
 		filters["types"] = types
 	}
 	if price := c.Query("price"); price != "" {
 		filters["price"] = price
 	}
+	if tag := c.Query("tag"); tag != "" {
+		filters["tags"] = tag
+	}
+	return filters
+}
 
+// GetFilteredPerfumes returns perfumes with optional filters
+func GetFilteredPerfumes(c *fiber.Ctx) error {
 	// Fetch perfumes with filters
-	perfumes, err := repository.GetFilteredPerfumes(filters)
+	perfumes, err := repository.GetFilteredPerfumes(searchFilters(c))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Failed to fetch perfumes",
@@ -166,8 +236,45 @@ func UpdatePerfume(c *fiber.Ctx) error {
 		})
 	}
 
+	// Only update if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	// A multipart request may carry a new primary image, read it before touching the fields
+	var uploads []model.FumeImgUpload
+	if form, err := c.MultipartForm(); err == nil && len(form.File["image"]) > 0 {
+		uploads, err = readImageUploads(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid image upload",
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	// Validate and store the new image first, so a rejected image leaves the fields untouched
+	var replacement *model.PerfumeImage
+	if len(uploads) > 0 {
+		uploaded, err := repository.UploadPerfumeImage(perfumeID, uploads[0])
+		if err != nil {
+			return c.Status(imageErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to replace image",
+				"error":   err.Error(),
+			})
+		}
+		replacement = &uploaded
+	}
+
 	// Update the perfume in the database
-	err := repository.UpdatePerfume(perfumeID, updatedPerfume)
+	err = repository.UpdatePerfume(perfumeID, updatedPerfume, expectedVersion, revisionAuthor(c))
+	if err != nil && replacement != nil {
+		repository.DiscardPerfumeImage(*replacement)
+	}
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Failed to update perfume",
@@ -175,8 +282,61 @@ func UpdatePerfume(c *fiber.Ctx) error {
 		})
 	}
 
+	// Swap in the primary image once the fields were saved. The fields stay saved if that fails,
+	// so the response says so instead of reporting the whole update as failed.
+	if replacement != nil {
+		if _, err := repository.SwapPerfumeImage(perfumeID, "", *replacement); err != nil {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"message":     "Perfume updated, but the image was not replaced",
+				"image_error": err.Error(),
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Perfume updated successfully",
+	})
+}
+
+// patchErrorStatus maps patch errors to status codes, anything else means the document was not found
+func patchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrUnsupportedPatch):
+		return fiber.StatusUnsupportedMediaType
+	case errors.Is(err, repository.ErrPatchTestFailed):
+		return fiber.StatusConflict
+	case errors.Is(err, repository.ErrInvalidPatch):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusNotFound
+}
+
+// PatchPerfume handles partial updates with JSON Merge Patch or JSON Patch, only the supplied fields change
+func PatchPerfume(c *fiber.Ctx) error {
+	// Get perfume ID from params
+	perfumeID := c.Params("id")
+
+	// Only patch if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	perfume, err := repository.PatchPerfume(perfumeID, c.Get(fiber.HeaderContentType), c.Body(), expectedVersion, revisionAuthor(c))
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return c.Status(patchErrorStatus(err)).JSON(fiber.Map{
+			"message": "Failed to update perfume",
+			"error":   err.Error(),
+		})
+	}
+
+	setETag(c, perfume.Version)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Perfume updated successfully",
+		"perfume": perfume,
 	})
 }
 
@@ -185,8 +345,17 @@ func DeletePerfume(c *fiber.Ctx) error {
 	// Get perfume ID from URL params
 	perfumeID := c.Params("id")
 
+	// Only delete if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
 	// Delete the perfume from the database
-	err := repository.DeletePerfume(perfumeID)
+	err = repository.DeletePerfume(perfumeID, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "Failed to delete perfume",
@@ -210,11 +379,33 @@ func CreatePerfumeWithoutImage(c *fiber.Ctx) error {
 		})
 	}
 
+	// Make sure every assigned category exists
+	categoryIDs := make([]string, len(perfume.CategoryIDs))
+	for i, categoryID := range perfume.CategoryIDs {
+		categoryIDs[i] = categoryID.Hex()
+	}
+	validCategoryIDs, err := repository.ParseCategoryIDs(categoryIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid categories",
+			"error":   err.Error(),
+		})
+	}
+	perfume.CategoryIDs = validCategoryIDs
+
+	// New perfumes start as drafts unless a status is sent
+	if err := repository.NormalizePerfumeLifecycle(perfume); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid status",
+			"error":   err.Error(),
+		})
+	}
+
 	perfume.PerfumeID = primitive.NewObjectID()
 	perfume.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	perfume.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 
-	err := repository.CreatePerfumeWithImageURL(perfume)
+	err = repository.CreatePerfumeWithImageURL(perfume)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Failed to create perfume",
@@ -226,4 +417,4 @@ func CreatePerfumeWithoutImage(c *fiber.Ctx) error {
 		"message": "Perfume created successfully",
 		"perfume": perfume,
 	})
-}
\ No newline at end of file
+}
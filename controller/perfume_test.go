@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitTags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", []string{}},
+		{"sale", []string{"sale"}},
+		{"sale, bestseller", []string{"sale", "bestseller"}},
+		{" sale ,, bestseller, ", []string{"sale", "bestseller"}},
+		{" , ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := splitTags(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTags(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPromotions lists promotions. ?active=true or false filters on the switch, ?code= finds a voucher.
+func GetPromotions(c *fiber.Ctx) error {
+	var active *bool
+	if value := c.Query("active"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid active filter",
+				"error":   err.Error(),
+			})
+		}
+		active = &parsed
+	}
+
+	promotions, err := repository.GetPromotions(active, c.Query("code"))
+	if err != nil {
+		return promotionError(c, "Failed to retrieve promotions", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":    "Promotions retrieved successfully",
+		"promotions": promotions,
+	})
+}
+
+// GetPromotion returns a promotion with how often it was used
+func GetPromotion(c *fiber.Ctx) error {
+	promotion, err := repository.GetPromotion(c.Params("id"))
+	if err != nil {
+		return promotionError(c, "Failed to retrieve promotion", err)
+	}
+
+	setETag(c, promotion.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "Promotion retrieved successfully",
+		"promotion": promotion,
+	})
+}
+
+// CreatePromotion adds a voucher or an automatic promotion
+func CreatePromotion(c *fiber.Ctx) error {
+	var request model.PromotionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	promotion, err := repository.CreatePromotion(request, revisionAuthor(c))
+	if err != nil {
+		return promotionError(c, "Failed to create promotion", err)
+	}
+
+	setETag(c, promotion.Version)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":   "Promotion created successfully",
+		"promotion": promotion,
+	})
+}
+
+// UpdatePromotion replaces the rules of a promotion
+func UpdatePromotion(c *fiber.Ctx) error {
+	// Only update if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	var request model.PromotionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	promotion, err := repository.UpdatePromotion(c.Params("id"), request, expectedVersion)
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return promotionError(c, "Failed to update promotion", err)
+	}
+
+	setETag(c, promotion.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "Promotion updated successfully",
+		"promotion": promotion,
+	})
+}
+
+// DeletePromotion removes a promotion
+func DeletePromotion(c *fiber.Ctx) error {
+	if err := repository.DeletePromotion(c.Params("id")); err != nil {
+		return promotionError(c, "Failed to delete promotion", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Promotion deleted successfully",
+	})
+}
+
+// promotionError maps promotion errors to a status: 400 for invalid rules, 404 for unknown promotions
+func promotionError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidPromotion):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrPromotionNotFound):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
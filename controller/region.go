@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// regionCacheControl lets browsers and CDNs keep region lists, the dataset rarely changes
+const regionCacheControl = "public, max-age=86400"
+
+// GetProvinces lists the provinces, the first cascading dropdown of an address form
+func GetProvinces(c *fiber.Ctx) error {
+	regions, err := repository.GetRegionChildren("", c.Query("q"))
+	if err != nil {
+		return regionError(c, "Failed to retrieve regions", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, regionCacheControl)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Regions retrieved successfully",
+		"regions": regions,
+	})
+}
+
+// GetRegion returns a region by its code
+func GetRegion(c *fiber.Ctx) error {
+	region, err := repository.GetRegion(c.Params("code"))
+	if err != nil {
+		return regionError(c, "Failed to retrieve region", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, regionCacheControl)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Region retrieved successfully",
+		"region":  region,
+	})
+}
+
+// GetRegionChildren lists the regions inside a region: the cities of a province, the districts of a city
+// or the sub-districts of a district
+func GetRegionChildren(c *fiber.Ctx) error {
+	regions, err := repository.GetRegionChildren(c.Params("code"), c.Query("q"))
+	if err != nil {
+		return regionError(c, "Failed to retrieve regions", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, regionCacheControl)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Regions retrieved successfully",
+		"regions": regions,
+	})
+}
+
+// regionError maps region errors to a status
+func regionError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, repository.ErrRegionNotFound) {
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPerfumeRevisions returns the change history of a perfume, newest first
+func GetPerfumeRevisions(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+
+	revisions, err := repository.GetPerfumeRevisions(perfumeID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Failed to retrieve revisions",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "Revisions retrieved successfully",
+		"revisions": revisions,
+	})
+}
+
+// RestorePerfumeRevision puts a perfume back to a revision, recorded as a new change
+func RestorePerfumeRevision(c *fiber.Ctx) error {
+	perfumeID := c.Params("id")
+	revisionID := c.Params("revisionId")
+
+	// Only restore if the client still has the current version
+	expectedVersion, err := ifMatchVersion(c)
+	if err != nil {
+		return preconditionFailed(c, err)
+	}
+
+	err = repository.RestorePerfumeRevision(perfumeID, revisionID, expectedVersion, revisionAuthor(c))
+	if isVersionConflict(err) {
+		return preconditionFailed(c, err)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Failed to restore revision",
+			"error":   err.Error(),
+		})
+	}
+
+	perfume, err := repository.GetPerfumeByID(perfumeID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Perfume not found",
+			"error":   err.Error(),
+		})
+	}
+
+	setETag(c, perfume.Version)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Revision restored successfully",
+		"perfume": perfume,
+	})
+}
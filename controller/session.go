@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"github.com/GilangAndhika/elfume/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// isAdmin reports whether the request carries a valid token of an admin,
+// the route needs JWTMiddleware or OptionalJWT for the token to be read
+func isAdmin(c *fiber.Ctx) bool {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	roleID, _ := claims["role_id"].(string)
+	return roleID == model.RoleAdmin
+}
+
+// revisionAuthor identifies the logged in user for the revision history, requests without a token are anonymous
+func revisionAuthor(c *fiber.Ctx) model.RevisionAuthor {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return model.RevisionAuthor{Username: "anonymous"}
+	}
+
+	author := model.RevisionAuthor{Username: "unknown"}
+	if username, ok := claims["username"].(string); ok && username != "" {
+		author.Username = username
+	}
+	if userID, ok := claims["user_id"].(string); ok {
+		if objID, err := primitive.ObjectIDFromHex(userID); err == nil {
+			author.UserID = &objID
+		}
+	}
+	return author
+}
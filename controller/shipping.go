@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// QuoteShipping returns the courier rates for the given perfumes, or the caller's cart, to a destination
+func QuoteShipping(c *fiber.Ctx) error {
+	var request model.ShippingQuoteRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	quote, err := repository.QuoteShipping(request, cartOwner(c, false))
+	if err != nil {
+		return shippingError(c, "Failed to quote shipping", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Shipping quoted successfully",
+		"quote":   quote,
+	})
+}
+
+// CreateShipment records the airway bill of a packed order and marks it shipped
+func CreateShipment(c *fiber.Ctx) error {
+	var request model.ShipmentRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+
+	shipment, err := repository.CreateShipment(c.Params("id"), request, revisionAuthor(c))
+	if err != nil {
+		return shippingError(c, "Failed to create shipment", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Shipment created successfully",
+		"shipment": shipment,
+	})
+}
+
+// GetShipment returns the shipment of an order of the logged in customer with its tracking, admins can read every shipment
+func GetShipment(c *fiber.Ctx) error {
+	userID := loggedInUserID(c)
+	if isAdmin(c) {
+		userID = nil
+	}
+
+	shipment, err := repository.GetShipment(c.Params("id"), userID)
+	if err != nil {
+		return shippingError(c, "Failed to retrieve shipment", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Shipment retrieved successfully",
+		"shipment": shipment,
+	})
+}
+
+// RefreshShipment checks the tracking of an order's shipment with the courier right away
+func RefreshShipment(c *fiber.Ctx) error {
+	shipment, err := repository.RefreshShipment(c.Params("id"))
+	if err != nil {
+		return shippingError(c, "Failed to track shipment", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "Shipment tracked successfully",
+		"shipment": shipment,
+	})
+}
+
+// shippingError maps shipping errors to a status
+func shippingError(c *fiber.Ctx, message string, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, repository.ErrInvalidShipping):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, repository.ErrOrderNotFound), errors.Is(err, repository.ErrShipmentNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, repository.ErrTransitionForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, repository.ErrInvalidTransition), isVersionConflict(err):
+		status = fiber.StatusConflict
+	case errors.Is(err, repository.ErrTrackingUnsupported):
+		status = fiber.StatusNotImplemented
+	case errors.Is(err, repository.ErrShippingUnavailable):
+		// The courier API did not answer, the request can be tried again
+		status = fiber.StatusBadGateway
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
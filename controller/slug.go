@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"encoding/xml"
+	"os"
+	"strings"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// productURL is the canonical URL of a perfume page. PRODUCT_URL_PREFIX points it at the storefront
+// (e.g. https://elfume.com/perfume/), by default it is the slug endpoint of this API.
+func productURL(c *fiber.Ctx, slug string) string {
+	prefix := os.Getenv("PRODUCT_URL_PREFIX")
+	if prefix == "" {
+		prefix = c.BaseURL() + "/fume/slug/"
+	}
+	return prefix + slug
+}
+
+// setCanonicalLink tells clients and crawlers which URL of a perfume is the canonical one
+func setCanonicalLink(c *fiber.Ctx, perfume *model.Perfume) {
+	if perfume.Slug != "" {
+		c.Set(fiber.HeaderLink, "<"+productURL(c, perfume.Slug)+`>; rel="canonical"`)
+	}
+}
+
+// GetPerfumeBySlug returns a perfume by its slug, old slugs redirect permanently to the current one
+func GetPerfumeBySlug(c *fiber.Ctx) error {
+	slug := c.Params("slug")
+	perfume, err := repository.GetPerfumeBySlug(slug)
+	if err != nil || (!repository.IsPerfumePublic(perfume) && !isAdmin(c)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Perfume not found",
+		})
+	}
+
+	if perfume.Slug != "" && perfume.Slug != strings.ToLower(slug) {
+		return c.Redirect("/fume/slug/"+perfume.Slug, fiber.StatusMovedPermanently)
+	}
+
+	// Let clients revalidate their cached copy cheaply
+	setCanonicalLink(c, perfume)
+	setETag(c, perfume.Version)
+	if notModified(c, perfume.Version) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	return c.JSON(perfume)
+}
+
+// sitemapURLSet is the urlset document of the sitemap protocol
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// GetSitemap lists the canonical URL of every published perfume as sitemap.xml
+func GetSitemap(c *fiber.Ctx) error {
+	perfumes, err := repository.GetSitemapPerfumes(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to build sitemap",
+			"error":   err.Error(),
+		})
+	}
+
+	urlSet := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: []sitemapURL{}}
+	for _, perfume := range perfumes {
+		entry := sitemapURL{Loc: productURL(c, perfume.Slug)}
+		if perfume.UpdatedAt != 0 {
+			entry.LastMod = perfume.UpdatedAt.Time().UTC().Format("2006-01-02")
+		}
+		urlSet.URLs = append(urlSet.URLs, entry)
+	}
+
+	body, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to build sitemap",
+			"error":   err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	return c.Send(append([]byte(xml.Header), body...))
+}
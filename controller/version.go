@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GilangAndhika/elfume/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errPreconditionFailed is returned for If-Match headers that cannot match any version
+var errPreconditionFailed = errors.New("If-Match does not match the current version")
+
+// etag formats a document version as a strong entity tag
+func etag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// setETag adds the ETag header for a document version
+func setETag(c *fiber.Ctx, version int64) {
+	c.Set(fiber.HeaderETag, etag(version))
+}
+
+// notModified reports whether the client's If-None-Match already names the current version,
+// weak tags are compared by value as RFC 9110 requires for If-None-Match
+func notModified(c *fiber.Ctx, version int64) bool {
+	header := c.Get(fiber.HeaderIfNoneMatch)
+	if header == "" {
+		return false
+	}
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion reads the version a write is conditional on. No header or "*" returns nil,
+// meaning any version is accepted. Tags this API never issues cannot match and fail the precondition.
+func ifMatchVersion(c *fiber.Ctx) (*int64, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	// A document has a single current version, so only the first tag can be checked
+	tag := strings.TrimSpace(strings.Split(header, ",")[0])
+	if !strings.HasPrefix(tag, "\"") || !strings.HasSuffix(tag, "\"") || len(tag) < 2 {
+		return nil, errPreconditionFailed
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return nil, errPreconditionFailed
+	}
+
+	return &version, nil
+}
+
+// preconditionFailed answers 412 for a stale or malformed If-Match
+func preconditionFailed(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+		"message": "The resource was modified, fetch it again and retry",
+		"error":   err.Error(),
+	})
+}
+
+// isVersionConflict reports whether a write failed because of its If-Match version
+func isVersionConflict(err error) bool {
+	return errors.Is(err, repository.ErrVersionConflict)
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// withRequestHeader runs check inside a request that carries the header. check runs on the server's
+// goroutine, so it reports with t.Errorf only.
+func withRequestHeader(t *testing.T, name, value string, check func(c *fiber.Ctx)) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		check(c)
+		return nil
+	})
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	if value != "" {
+		req.Header.Set(name, value)
+	}
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	version := func(v int64) *int64 { return &v }
+
+	tests := []struct {
+		name   string
+		header string
+		want   *int64
+		err    bool
+	}{
+		{"no header", "", nil, false},
+		{"any version", "*", nil, false},
+		{"any version with spaces", " * ", nil, false},
+		{"version", `"7"`, version(7), false},
+		{"surrounding spaces", `  "7"  `, version(7), false},
+		{"only the first tag counts", `"3", "4"`, version(3), false},
+		{"weak tags never match", `W/"7"`, nil, true},
+		{"unquoted", `7`, nil, true},
+		{"a lone quote", `"`, nil, true},
+		{"not a version", `"abc"`, nil, true},
+		{"empty tag", `""`, nil, true},
+		{"too large", `"99999999999999999999"`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRequestHeader(t, fiber.HeaderIfMatch, tt.header, func(c *fiber.Ctx) {
+				got, err := ifMatchVersion(c)
+				if tt.err {
+					if !errors.Is(err, errPreconditionFailed) {
+						t.Errorf("ifMatchVersion = %v, %v, want errPreconditionFailed", got, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("ifMatchVersion: %v", err)
+					return
+				}
+				if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+					t.Errorf("ifMatchVersion = %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"no header", "", false},
+		{"current version", `"5"`, true},
+		{"older version", `"4"`, false},
+		{"weak tag compares by value", `W/"5"`, true},
+		{"any version", "*", true},
+		{"one of several", `"3", W/"4",  "5"`, true},
+		{"none of several", `"3", "4"`, false},
+		{"unquoted", `5`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRequestHeader(t, fiber.HeaderIfNoneMatch, tt.header, func(c *fiber.Ctx) {
+				if got := notModified(c, 5); got != tt.want {
+					t.Errorf("notModified = %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
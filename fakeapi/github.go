@@ -0,0 +1,310 @@
+// Package fakeapi contains in-memory stand-ins for the third-party HTTP APIs Elfume talks to,
+// so the API can be developed and exercised without network access or real credentials.
+package fakeapi
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GithubFault is a canned failure returned instead of the next API responses
+type GithubFault struct {
+	Status         int   `json:"status"`           // HTTP status to answer with (e.g. 403, 429, 503)
+	Count          int   `json:"count"`            // How many requests fail, defaults to 1
+	RetryAfter     int   `json:"retry_after"`      // Seconds sent in Retry-After
+	RateLimitReset int64 `json:"rate_limit_reset"` // Seconds from now sent as X-RateLimit-Reset with X-RateLimit-Remaining: 0
+}
+
+// GithubCommit records a change made through the fake Contents API
+type GithubCommit struct {
+	Message   string            `json:"message"`
+	Path      string            `json:"path"`
+	Branch    string            `json:"branch"`
+	Author    map[string]string `json:"author"`
+	Committer map[string]string `json:"committer"`
+	Deleted   bool              `json:"deleted"`
+	At        time.Time         `json:"at"`
+}
+
+type githubFile struct {
+	content []byte
+	sha     string
+}
+
+// Github is a fake of the parts of the GitHub REST API used by the image store:
+// the Contents API, the recursive Git Trees API and raw file downloads.
+//
+// Routes (relative to where the handler is mounted):
+//
+//	GET|PUT|DELETE /repos/{owner}/{repo}/contents/{path...}
+//	GET            /repos/{owner}/{repo}/git/trees/{branch}
+//	GET            /raw/{owner}/{repo}/{branch}/{path...}
+//	POST           /_fake/faults   queue failures for the next requests (body: GithubFault)
+//	GET            /_fake/commits  list the commits made so far
+//	POST           /_fake/reset    forget every file, fault and commit
+type Github struct {
+	Token string // Bearer token required on API calls, empty accepts any token
+
+	mu      sync.Mutex
+	files   map[string]map[string]*githubFile // branch -> path -> file
+	faults  []GithubFault
+	commits []GithubCommit
+	mux     *http.ServeMux
+}
+
+// NewGithub creates an empty fake GitHub API
+func NewGithub(token string) *Github {
+	g := &Github{Token: token, files: map[string]map[string]*githubFile{}}
+
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("GET /repos/{owner}/{repo}/contents/{path...}", g.api(g.getContents))
+	g.mux.HandleFunc("PUT /repos/{owner}/{repo}/contents/{path...}", g.api(g.putContents))
+	g.mux.HandleFunc("DELETE /repos/{owner}/{repo}/contents/{path...}", g.api(g.deleteContents))
+	g.mux.HandleFunc("GET /repos/{owner}/{repo}/git/trees/{branch}", g.api(g.getTree))
+	g.mux.HandleFunc("GET /raw/{owner}/{repo}/{branch}/{path...}", g.getRaw)
+	g.mux.HandleFunc("POST /_fake/faults", g.addFault)
+	g.mux.HandleFunc("GET /_fake/commits", g.listCommits)
+	g.mux.HandleFunc("POST /_fake/reset", g.reset)
+
+	return g
+}
+
+func (g *Github) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// api wraps API handlers with token checking and fault injection
+func (g *Github) api(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.Token != "" && r.Header.Get("Authorization") != "Bearer "+g.Token {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+
+		g.mu.Lock()
+		var fault *GithubFault
+		if len(g.faults) > 0 {
+			current := g.faults[0]
+			fault = &current
+			g.faults[0].Count--
+			if g.faults[0].Count <= 0 {
+				g.faults = g.faults[1:]
+			}
+		}
+		g.mu.Unlock()
+
+		if fault != nil {
+			if fault.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(fault.RetryAfter))
+			}
+			if fault.RateLimitReset > 0 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix()+fault.RateLimitReset, 10))
+			}
+			writeJSON(w, fault.Status, map[string]string{"message": http.StatusText(fault.Status)})
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// branchFiles returns the files of a branch, creating the branch on first use
+func (g *Github) branchFiles(branch string) map[string]*githubFile {
+	if branch == "" {
+		branch = "main"
+	}
+	if g.files[branch] == nil {
+		g.files[branch] = map[string]*githubFile{}
+	}
+	return g.files[branch]
+}
+
+func (g *Github) getContents(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := r.PathValue("path")
+	file, ok := g.branchFiles(r.URL.Query().Get("ref"))[path]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type":     "file",
+		"path":     path,
+		"sha":      file.sha,
+		"size":     len(file.content),
+		"encoding": "base64",
+		"content":  base64.StdEncoding.EncodeToString(file.content),
+	})
+}
+
+func (g *Github) putContents(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message   string            `json:"message"`
+		Content   string            `json:"content"`
+		SHA       string            `json:"sha"`
+		Branch    string            `json:"branch"`
+		Author    map[string]string `json:"author"`
+		Committer map[string]string `json:"committer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(request.Content)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "content is not valid Base64"})
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := r.PathValue("path")
+	files := g.branchFiles(request.Branch)
+	existing, exists := files[path]
+	switch {
+	case exists && request.SHA == "":
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Invalid request.\n\n\"sha\" wasn't supplied."})
+		return
+	case exists && request.SHA != existing.sha:
+		writeJSON(w, http.StatusConflict, map[string]string{"message": fmt.Sprintf("%s does not match %s", path, request.SHA)})
+		return
+	}
+
+	sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+	files[path] = &githubFile{content: content, sha: hex.EncodeToString(sum[:])}
+	g.commits = append(g.commits, GithubCommit{
+		Message: request.Message, Path: path, Branch: request.Branch,
+		Author: request.Author, Committer: request.Committer, At: time.Now(),
+	})
+
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"content": map[string]interface{}{"path": path, "sha": files[path].sha, "size": len(content)},
+	})
+}
+
+func (g *Github) deleteContents(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message   string            `json:"message"`
+		SHA       string            `json:"sha"`
+		Branch    string            `json:"branch"`
+		Author    map[string]string `json:"author"`
+		Committer map[string]string `json:"committer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := r.PathValue("path")
+	files := g.branchFiles(request.Branch)
+	existing, exists := files[path]
+	switch {
+	case !exists:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	case request.SHA != existing.sha:
+		writeJSON(w, http.StatusConflict, map[string]string{"message": fmt.Sprintf("%s does not match %s", path, request.SHA)})
+		return
+	}
+
+	delete(files, path)
+	g.commits = append(g.commits, GithubCommit{
+		Message: request.Message, Path: path, Branch: request.Branch,
+		Author: request.Author, Committer: request.Committer, Deleted: true, At: time.Now(),
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"content": nil})
+}
+
+func (g *Github) getTree(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	files := g.branchFiles(r.PathValue("branch"))
+	paths := []string{}
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tree := []map[string]interface{}{}
+	for _, path := range paths {
+		tree = append(tree, map[string]interface{}{"path": path, "type": "blob", "sha": files[path].sha})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tree": tree, "truncated": false})
+}
+
+func (g *Github) getRaw(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	file, ok := g.branchFiles(r.PathValue("branch"))[r.PathValue("path")]
+	g.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(file.content))
+	w.Write(file.content)
+}
+
+func (g *Github) addFault(w http.ResponseWriter, r *http.Request) {
+	var fault GithubFault
+	if err := json.NewDecoder(r.Body).Decode(&fault); err != nil || fault.Status == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "status is required"})
+		return
+	}
+	if fault.Count <= 0 {
+		fault.Count = 1
+	}
+
+	g.mu.Lock()
+	g.faults = append(g.faults, fault)
+	g.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, fault)
+}
+
+func (g *Github) listCommits(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, g.commits)
+}
+
+func (g *Github) reset(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	g.files = map[string]map[string]*githubFile{}
+	g.faults = nil
+	g.commits = nil
+	g.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON answers with a JSON body
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,330 @@
+package fakeapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MidtransTransaction is a Snap transaction created on the fake
+type MidtransTransaction struct {
+	OrderID           string                 `json:"order_id"`
+	GrossAmount       int64                  `json:"gross_amount"`
+	Token             string                 `json:"token"`
+	TransactionID     string                 `json:"transaction_id"`
+	TransactionStatus string                 `json:"transaction_status"`
+	FraudStatus       string                 `json:"fraud_status,omitempty"`
+	PaymentType       string                 `json:"payment_type,omitempty"`
+	NotificationURL   string                 `json:"notification_url,omitempty"`
+	Request           map[string]interface{} `json:"request"`
+	CreatedAt         time.Time              `json:"created_at"`
+}
+
+// MidtransPayment asks the fake to move a transaction to a status and notify the API about it
+type MidtransPayment struct {
+	OrderID           string `json:"order_id"`
+	TransactionStatus string `json:"transaction_status"` // Defaults to settlement
+	FraudStatus       string `json:"fraud_status"`
+	PaymentType       string `json:"payment_type"` // Defaults to bank_transfer
+}
+
+// Midtrans is a fake of the Midtrans Snap API and its HTTP notifications.
+//
+// Routes (relative to where the handler is mounted):
+//
+//	POST /snap/v1/transactions        create a transaction, answers with its token and payment page
+//	GET  /snap/v2/vtweb/{token}       the payment page
+//	GET  /v2/{order_id}/status        the status of a transaction
+//	POST /_fake/pay                   change a transaction and send its signed notification (body: MidtransPayment)
+//	GET  /_fake/transactions          list the transactions created so far
+//	POST /_fake/reset                 forget every transaction
+type Midtrans struct {
+	ServerKey       string // Server key required as Basic auth and used to sign notifications
+	NotificationURL string // Where notifications go unless a transaction was created with X-Override-Notification
+
+	mu           sync.Mutex
+	transactions map[string]*MidtransTransaction // order_id -> transaction
+	client       *http.Client
+	mux          *http.ServeMux
+}
+
+// NewMidtrans creates a fake Midtrans API without transactions
+func NewMidtrans(serverKey, notificationURL string) *Midtrans {
+	m := &Midtrans{
+		ServerKey:       serverKey,
+		NotificationURL: notificationURL,
+		transactions:    map[string]*MidtransTransaction{},
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+
+	m.mux = http.NewServeMux()
+	m.mux.HandleFunc("POST /snap/v1/transactions", m.createTransaction)
+	m.mux.HandleFunc("GET /snap/v2/vtweb/{token}", m.paymentPage)
+	m.mux.HandleFunc("GET /v2/{order_id}/status", m.getStatus)
+	m.mux.HandleFunc("POST /_fake/pay", m.pay)
+	m.mux.HandleFunc("GET /_fake/transactions", m.listTransactions)
+	m.mux.HandleFunc("POST /_fake/reset", m.reset)
+
+	return m
+}
+
+func (m *Midtrans) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the server key sent as the Basic auth user name
+func (m *Midtrans) authorized(w http.ResponseWriter, r *http.Request) bool {
+	user, _, ok := r.BasicAuth()
+	if m.ServerKey != "" && (!ok || user != m.ServerKey) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"error_messages": []string{"Access denied due to unauthorized transaction, please check client or server key"},
+		})
+		return false
+	}
+	return true
+}
+
+func (m *Midtrans) createTransaction(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		return
+	}
+
+	var request map[string]interface{}
+	var details struct {
+		TransactionDetails struct {
+			OrderID     string `json:"order_id"`
+			GrossAmount int64  `json:"gross_amount"`
+		} `json:"transaction_details"`
+		ItemDetails []struct {
+			Price    int64 `json:"price"`
+			Quantity int64 `json:"quantity"`
+		} `json:"item_details"`
+	}
+	var body bytes.Buffer
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error_messages": []string{"request body is not valid JSON"}})
+		return
+	}
+	json.NewEncoder(&body).Encode(request)
+	json.Unmarshal(body.Bytes(), &details)
+
+	// Snap checks the order ID, the amount and that the items add up to it
+	order := details.TransactionDetails
+	messages := []string{}
+	if order.OrderID == "" {
+		messages = append(messages, "transaction_details.order_id is required")
+	}
+	if order.GrossAmount <= 0 {
+		messages = append(messages, "transaction_details.gross_amount must be greater than or equal to 0.01")
+	}
+	if len(details.ItemDetails) > 0 {
+		var total int64
+		for _, item := range details.ItemDetails {
+			total += item.Price * item.Quantity
+		}
+		if total != order.GrossAmount {
+			messages = append(messages, "transaction_details.gross_amount is not equal to the sum of item_details")
+		}
+	}
+	if len(messages) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error_messages": messages})
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.transactions[order.OrderID]; exists {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error_messages": []string{"transaction_details.order_id has already been taken"},
+		})
+		return
+	}
+
+	transaction := &MidtransTransaction{
+		OrderID:           order.OrderID,
+		GrossAmount:       order.GrossAmount,
+		Token:             randomHex(16),
+		TransactionID:     randomHex(16),
+		TransactionStatus: "pending",
+		NotificationURL:   r.Header.Get("X-Override-Notification"),
+		Request:           request,
+		CreatedAt:         time.Now(),
+	}
+	m.transactions[order.OrderID] = transaction
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"token":        transaction.Token,
+		"redirect_url": fmt.Sprintf("http://%s%s/snap/v2/vtweb/%s", r.Host, mountPrefix(r, "/snap/v1/transactions"), transaction.Token),
+	})
+}
+
+func (m *Midtrans) paymentPage(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	var found *MidtransTransaction
+	for _, transaction := range m.transactions {
+		if transaction.Token == r.PathValue("token") {
+			copied := *transaction
+			found = &copied
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if found == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!doctype html><title>Fake Midtrans</title><h1>Order %s</h1><p>Amount: Rp %d</p><p>Status: %s</p>"+
+		"<p>Pay with <code>POST /_fake/pay {\"order_id\": %q}</code></p>\n",
+		found.OrderID, found.GrossAmount, found.TransactionStatus, found.OrderID)
+}
+
+func (m *Midtrans) getStatus(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		return
+	}
+
+	m.mu.Lock()
+	transaction, ok := m.transactions[r.PathValue("order_id")]
+	var notification map[string]string
+	if ok {
+		notification = m.notification(transaction)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"status_code": "404", "status_message": "Transaction doesn't exist."})
+		return
+	}
+	writeJSON(w, http.StatusOK, notification)
+}
+
+// pay moves a transaction to a status and posts the notification to the API, answering with what the API answered
+func (m *Midtrans) pay(w http.ResponseWriter, r *http.Request) {
+	var payment MidtransPayment
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil || payment.OrderID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "order_id is required"})
+		return
+	}
+	if payment.TransactionStatus == "" {
+		payment.TransactionStatus = "settlement"
+	}
+	if payment.PaymentType == "" {
+		payment.PaymentType = "bank_transfer"
+	}
+
+	m.mu.Lock()
+	transaction, ok := m.transactions[payment.OrderID]
+	if !ok {
+		m.mu.Unlock()
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Transaction doesn't exist."})
+		return
+	}
+	transaction.TransactionStatus = payment.TransactionStatus
+	transaction.FraudStatus = payment.FraudStatus
+	transaction.PaymentType = payment.PaymentType
+	notification := m.notification(transaction)
+	target := transaction.NotificationURL
+	if target == "" {
+		target = m.NotificationURL
+	}
+	m.mu.Unlock()
+
+	if target == "" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"notification": notification, "delivered": false})
+		return
+	}
+
+	body, _ := json.Marshal(notification)
+	resp, err := m.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"notification": notification, "error": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	var answer interface{}
+	json.NewDecoder(resp.Body).Decode(&answer)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"notification": notification,
+		"delivered":    true,
+		"status":       resp.StatusCode,
+		"response":     answer,
+	})
+}
+
+// notification builds the signed notification of a transaction, the caller holds the lock
+func (m *Midtrans) notification(transaction *MidtransTransaction) map[string]string {
+	statusCode := "200"
+	switch transaction.TransactionStatus {
+	case "pending":
+		statusCode = "201"
+	case "deny", "cancel", "expire", "failure":
+		statusCode = "202"
+	}
+	grossAmount := strconv.FormatInt(transaction.GrossAmount, 10) + ".00"
+	sum := sha512.Sum512([]byte(transaction.OrderID + statusCode + grossAmount + m.ServerKey))
+
+	notification := map[string]string{
+		"transaction_time":   transaction.CreatedAt.Format("2006-01-02 15:04:05"),
+		"transaction_status": transaction.TransactionStatus,
+		"transaction_id":     transaction.TransactionID,
+		"status_code":        statusCode,
+		"signature_key":      hex.EncodeToString(sum[:]),
+		"payment_type":       transaction.PaymentType,
+		"order_id":           transaction.OrderID,
+		"gross_amount":       grossAmount,
+		"currency":           "IDR",
+	}
+	if transaction.FraudStatus != "" {
+		notification["fraud_status"] = transaction.FraudStatus
+	}
+	return notification
+}
+
+func (m *Midtrans) listTransactions(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	transactions := []*MidtransTransaction{}
+	for _, transaction := range m.transactions {
+		transactions = append(transactions, transaction)
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].CreatedAt.Before(transactions[j].CreatedAt) })
+
+	writeJSON(w, http.StatusOK, transactions)
+}
+
+func (m *Midtrans) reset(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.transactions = map[string]*MidtransTransaction{}
+	m.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mountPrefix returns the path the handler is mounted under, from the original request path and the route
+func mountPrefix(r *http.Request, route string) string {
+	path := r.RequestURI
+	if len(path) >= len(route) && path[len(path)-len(route):] == route {
+		return path[:len(path)-len(route)]
+	}
+	return ""
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,346 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RajaOngkirWaybill is an airway bill known to the fake, with its manifest oldest first
+type RajaOngkirWaybill struct {
+	Courier   string               `json:"courier"`
+	Waybill   string               `json:"waybill"`
+	Delivered bool                 `json:"delivered"`
+	Returned  bool                 `json:"returned"`
+	Receiver  string               `json:"receiver"`
+	Manifest  []RajaOngkirManifest `json:"manifest"`
+}
+
+// RajaOngkirManifest is a scan of a parcel
+type RajaOngkirManifest struct {
+	Description string    `json:"description"`
+	City        string    `json:"city"`
+	At          time.Time `json:"at"`
+}
+
+// RajaOngkirFault is a canned failure returned instead of the next API responses
+type RajaOngkirFault struct {
+	Status int `json:"status"` // HTTP status to answer with (e.g. 500, 503)
+	Count  int `json:"count"`  // How many requests fail, defaults to 1
+}
+
+// RajaOngkirRequest is a request the fake received
+type RajaOngkirRequest struct {
+	Path string            `json:"path"`
+	Form map[string]string `json:"form"`
+	At   time.Time         `json:"at"`
+}
+
+// rajaOngkirService is a service of a courier and what it costs per kilogram within a city and beyond it
+type rajaOngkirService struct {
+	Service     string
+	Description string
+	Local       float64
+	Distant     float64
+	ETD         string
+}
+
+// rajaOngkirCouriers are the couriers the fake prices, by the code used in requests
+var rajaOngkirCouriers = map[string]struct {
+	Code     string
+	Name     string
+	Services []rajaOngkirService
+}{
+	"jne": {"jne", "Jalur Nugraha Ekakurir (JNE)", []rajaOngkirService{
+		{"OKE", "Ongkos Kirim Ekonomis", 7000, 19000, "3-6"},
+		{"REG", "Layanan Reguler", 9000, 24000, "2-3"},
+		{"YES", "Yakin Esok Sampai", 18000, 40000, "1-1"},
+	}},
+	"jnt": {"J&T", "J&T Express", []rajaOngkirService{
+		{"EZ", "Regular Service", 8000, 22000, "2-4"},
+	}},
+	"sicepat": {"sicepat", "SiCepat Express", []rajaOngkirService{
+		{"REG", "Layanan Reguler", 8500, 23000, "2-3"},
+		{"BEST", "Besok Sampai Tujuan", 15000, 35000, "1"},
+	}},
+}
+
+// RajaOngkir is a fake of the RajaOngkir Pro API: shipping costs and waybill tracking. Costs are made up
+// but stable, a parcel within one city or subdistrict is cheaper than one sent further.
+//
+// Routes (relative to where the handler is mounted):
+//
+//	POST /cost                               rates of the couriers for a parcel (form: origin, destination, weight, courier)
+//	POST /waybill                            manifest of an airway bill (form: waybill, courier)
+//	POST /_fake/waybills                     add or replace an airway bill (body: RajaOngkirWaybill)
+//	POST /_fake/waybills/{waybill}/manifest  add a scan to an airway bill (body: RajaOngkirManifest, at defaults to now)
+//	POST /_fake/faults                       queue failures for the next requests (body: RajaOngkirFault)
+//	GET  /_fake/requests                     list the API requests received so far
+//	POST /_fake/reset                        forget every waybill, fault and request
+type RajaOngkir struct {
+	Key string // API key required in the key header, empty accepts any key
+
+	mu       sync.Mutex
+	waybills map[string]*RajaOngkirWaybill // courier:waybill -> waybill
+	faults   []RajaOngkirFault
+	requests []RajaOngkirRequest
+	mux      *http.ServeMux
+}
+
+// NewRajaOngkir creates a fake RajaOngkir API without waybills
+func NewRajaOngkir(key string) *RajaOngkir {
+	r := &RajaOngkir{Key: key, waybills: map[string]*RajaOngkirWaybill{}}
+
+	r.mux = http.NewServeMux()
+	r.mux.HandleFunc("POST /cost", r.api(r.cost))
+	r.mux.HandleFunc("POST /waybill", r.api(r.waybill))
+	r.mux.HandleFunc("POST /_fake/waybills", r.putWaybill)
+	r.mux.HandleFunc("POST /_fake/waybills/{waybill}/manifest", r.addManifest)
+	r.mux.HandleFunc("POST /_fake/faults", r.addFault)
+	r.mux.HandleFunc("GET /_fake/requests", r.listRequests)
+	r.mux.HandleFunc("POST /_fake/reset", r.reset)
+
+	return r
+}
+
+func (r *RajaOngkir) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// writeRajaOngkir answers in the envelope every RajaOngkir response has
+func writeRajaOngkir(w http.ResponseWriter, status int, description string, fields map[string]interface{}) {
+	body := map[string]interface{}{"status": map[string]interface{}{"code": status, "description": description}}
+	for key, value := range fields {
+		body[key] = value
+	}
+	writeJSON(w, status, map[string]interface{}{"rajaongkir": body})
+}
+
+// api wraps API handlers with key checking, request recording and fault injection
+func (r *RajaOngkir) api(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.Key != "" && req.Header.Get("key") != r.Key {
+			writeRajaOngkir(w, http.StatusBadRequest, "Invalid key. API key tidak ditemukan di database RajaOngkir.", nil)
+			return
+		}
+		req.ParseForm()
+
+		r.mu.Lock()
+		form := map[string]string{}
+		for key := range req.PostForm {
+			form[key] = req.PostForm.Get(key)
+		}
+		r.requests = append(r.requests, RajaOngkirRequest{Path: req.URL.Path, Form: form, At: time.Now()})
+
+		var fault *RajaOngkirFault
+		if len(r.faults) > 0 {
+			current := r.faults[0]
+			fault = &current
+			r.faults[0].Count--
+			if r.faults[0].Count <= 0 {
+				r.faults = r.faults[1:]
+			}
+		}
+		r.mu.Unlock()
+
+		if fault != nil {
+			writeRajaOngkir(w, fault.Status, http.StatusText(fault.Status), nil)
+			return
+		}
+
+		handler(w, req)
+	}
+}
+
+func (r *RajaOngkir) cost(w http.ResponseWriter, req *http.Request) {
+	origin := req.PostForm.Get("origin")
+	destination := req.PostForm.Get("destination")
+	if _, err := strconv.Atoi(origin); err != nil {
+		writeRajaOngkir(w, http.StatusBadRequest, "Bad request. Asal di-set kosong atau tidak valid.", nil)
+		return
+	}
+	if _, err := strconv.Atoi(destination); err != nil {
+		writeRajaOngkir(w, http.StatusBadRequest, "Bad request. Tujuan di-set kosong atau tidak valid.", nil)
+		return
+	}
+	weight, err := strconv.Atoi(req.PostForm.Get("weight"))
+	if err != nil || weight <= 0 {
+		writeRajaOngkir(w, http.StatusBadRequest, "Bad request. Berat barang harus diisi.", nil)
+		return
+	}
+
+	local := origin == destination && req.PostForm.Get("originType") == req.PostForm.Get("destinationType")
+	kilograms := math.Max(1, math.Ceil(float64(weight)/1000))
+
+	results := []map[string]interface{}{}
+	for _, code := range strings.Split(req.PostForm.Get("courier"), ":") {
+		courier, ok := rajaOngkirCouriers[strings.ToLower(strings.TrimSpace(code))]
+		if !ok {
+			writeRajaOngkir(w, http.StatusBadRequest, "Bad request. Kurir tidak valid.", nil)
+			return
+		}
+		costs := []map[string]interface{}{}
+		for _, service := range courier.Services {
+			perKg := service.Distant
+			if local {
+				perKg = service.Local
+			}
+			costs = append(costs, map[string]interface{}{
+				"service":     service.Service,
+				"description": service.Description,
+				"cost":        []map[string]interface{}{{"value": perKg * kilograms, "etd": service.ETD, "note": ""}},
+			})
+		}
+		results = append(results, map[string]interface{}{"code": courier.Code, "name": courier.Name, "costs": costs})
+	}
+
+	writeRajaOngkir(w, http.StatusOK, "OK", map[string]interface{}{
+		"query":   map[string]interface{}{"origin": origin, "destination": destination, "weight": weight, "courier": req.PostForm.Get("courier")},
+		"results": results,
+	})
+}
+
+func (r *RajaOngkir) waybill(w http.ResponseWriter, req *http.Request) {
+	key := strings.ToLower(req.PostForm.Get("courier")) + ":" + strings.ToUpper(req.PostForm.Get("waybill"))
+
+	r.mu.Lock()
+	waybill, ok := r.waybills[key]
+	var copied RajaOngkirWaybill
+	if ok {
+		copied = *waybill
+		copied.Manifest = append([]RajaOngkirManifest{}, waybill.Manifest...)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		writeRajaOngkir(w, http.StatusBadRequest, "Invalid waybill. Resi yang Anda masukkan salah atau belum terdaftar.", nil)
+		return
+	}
+
+	wib := time.FixedZone("WIB", 7*60*60)
+	status := "ON PROCESS"
+	switch {
+	case copied.Delivered:
+		status = "DELIVERED"
+	case copied.Returned:
+		status = "RETURN TO SHIPPER"
+	case len(copied.Manifest) == 0:
+		status = "MANIFESTED"
+	}
+
+	// Couriers list the newest scan first
+	manifest := []map[string]string{}
+	for i := len(copied.Manifest) - 1; i >= 0; i-- {
+		scan := copied.Manifest[i]
+		manifest = append(manifest, map[string]string{
+			"manifest_code":        strconv.Itoa(i + 1),
+			"manifest_description": scan.Description,
+			"manifest_date":        scan.At.In(wib).Format("2006-01-02"),
+			"manifest_time":        scan.At.In(wib).Format("15:04"),
+			"city_name":            scan.City,
+		})
+	}
+
+	deliveryStatus := map[string]string{"status": status, "pod_receiver": "", "pod_date": "", "pod_time": ""}
+	if copied.Delivered {
+		deliveredAt := time.Now()
+		if len(copied.Manifest) > 0 {
+			deliveredAt = copied.Manifest[len(copied.Manifest)-1].At
+		}
+		deliveryStatus["pod_receiver"] = copied.Receiver
+		deliveryStatus["pod_date"] = deliveredAt.In(wib).Format("2006-01-02")
+		deliveryStatus["pod_time"] = deliveredAt.In(wib).Format("15:04")
+	}
+
+	writeRajaOngkir(w, http.StatusOK, "OK", map[string]interface{}{
+		"result": map[string]interface{}{
+			"delivered":       copied.Delivered,
+			"summary":         map[string]string{"courier_code": copied.Courier, "waybill_number": copied.Waybill, "status": status},
+			"delivery_status": deliveryStatus,
+			"manifest":        manifest,
+		},
+	})
+}
+
+func (r *RajaOngkir) putWaybill(w http.ResponseWriter, req *http.Request) {
+	var waybill RajaOngkirWaybill
+	if err := json.NewDecoder(req.Body).Decode(&waybill); err != nil || waybill.Courier == "" || waybill.Waybill == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "courier and waybill are required"})
+		return
+	}
+	waybill.Courier = strings.ToLower(waybill.Courier)
+	waybill.Waybill = strings.ToUpper(waybill.Waybill)
+	for i := range waybill.Manifest {
+		if waybill.Manifest[i].At.IsZero() {
+			waybill.Manifest[i].At = time.Now()
+		}
+	}
+
+	r.mu.Lock()
+	r.waybills[waybill.Courier+":"+waybill.Waybill] = &waybill
+	r.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, waybill)
+}
+
+func (r *RajaOngkir) addManifest(w http.ResponseWriter, req *http.Request) {
+	var scan RajaOngkirManifest
+	if err := json.NewDecoder(req.Body).Decode(&scan); err != nil || scan.Description == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "description is required"})
+		return
+	}
+	if scan.At.IsZero() {
+		scan.At = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	number := strings.ToUpper(req.PathValue("waybill"))
+	for _, waybill := range r.waybills {
+		if waybill.Waybill == number {
+			waybill.Manifest = append(waybill.Manifest, scan)
+			writeJSON(w, http.StatusCreated, waybill)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "waybill not found"})
+}
+
+func (r *RajaOngkir) addFault(w http.ResponseWriter, req *http.Request) {
+	var fault RajaOngkirFault
+	if err := json.NewDecoder(req.Body).Decode(&fault); err != nil || fault.Status == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "status is required"})
+		return
+	}
+	if fault.Count <= 0 {
+		fault.Count = 1
+	}
+
+	r.mu.Lock()
+	r.faults = append(r.faults, fault)
+	r.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, fault)
+}
+
+func (r *RajaOngkir) listRequests(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, r.requests)
+}
+
+func (r *RajaOngkir) reset(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.waybills = map[string]*RajaOngkirWaybill{}
+	r.faults = nil
+	r.requests = nil
+	r.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
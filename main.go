@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/repository"
 	"github.com/GilangAndhika/elfume/routes"
 
 	"github.com/gofiber/fiber/v2"
@@ -22,14 +26,66 @@ func main() {
 	// Initialize db connection
 	config.ConnectDB()
 
-	// Create a new Fiber app
-	app := fiber.New()
+	// Create the indexes the repositories rely on
+	if err := repository.EnsureIndexes(); err != nil {
+		log.Fatal("Failed to create indexes: ", err)
+	}
+
+	// Give perfumes created before slugs existed their slug
+	if updated, err := repository.BackfillPerfumeSlugs(context.Background()); err != nil {
+		log.Printf("Failed to backfill perfume slugs: %v", err)
+	} else if updated > 0 {
+		log.Printf("Added slugs to %d perfumes", updated)
+	}
+
+	// Load the Indonesian region dataset (REGIONS_FILE) for addresses
+	if seeded, err := repository.SeedRegions(context.Background()); err != nil {
+		log.Fatal("Failed to seed regions: ", err)
+	} else if seeded > 0 {
+		log.Printf("Seeded %d regions", seeded)
+	}
+
+	// Imports that were running when an instance stopped will never finish
+	if failed, err := repository.FailInterruptedImportJobs(context.Background()); err != nil {
+		log.Printf("Failed to check interrupted imports: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted imports as failed", failed)
+	}
+
+	// Initialize image storage backend (IMAGE_STORE=github|local|s3)
+	if err := repository.InitImageStore(); err != nil {
+		log.Fatal("Failed to initialize image store: ", err)
+	}
+
+	// Initialize the payment provider (PAYMENT_PROVIDER=midtrans), checkout works without one but orders cannot be paid
+	if err := repository.InitPaymentProvider(); err != nil {
+		log.Fatal("Failed to initialize payment provider: ", err)
+	}
+	if repository.Payments == nil {
+		log.Println("No payment provider configured, payments are disabled")
+	}
+
+	// Initialize the shipping provider (SHIPPING_PROVIDER=rajaongkir|table)
+	if err := repository.InitShippingProvider(); err != nil {
+		log.Fatal("Failed to initialize shipping provider: ", err)
+	}
+
+	// Create a new Fiber app, the body limit leaves room for several product images per request
+	app := fiber.New(fiber.Config{
+		BodyLimit: 50 * 1024 * 1024,
+	})
+
+	// Serve images ourselves when they are kept on the local filesystem
+	if store, ok := repository.Images.(*repository.LocalImageStore); ok && strings.HasPrefix(store.BaseURL, "/") {
+		app.Static(store.BaseURL, store.Dir)
+	}
 
 	// Middleware
 	app.Use(cors.New(cors.Config{
-		AllowHeaders: "Origin, Content-Type, Accept",
-		AllowOrigins: "*",
-		AllowMethods: "GET, POST, PUT, DELETE",
+		AllowHeaders:  "Origin, Content-Type, Accept, Idempotency-Key, If-Match, If-None-Match",
+		ExposeHeaders: "ETag, Idempotent-Replayed, Link",
+		AllowOrigins:  "*",
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE",
 	}))
 
 	app.Use(logger.New(logger.Config{
@@ -39,6 +95,55 @@ func main() {
 	// Routes
 	routes.URL(app)
 
+	// Background jobs
+	runEvery("IMAGE_RECONCILE_INTERVAL", 24*time.Hour, func(ctx context.Context) error {
+		// Deleting is opt-in, by default the job only reports what it would delete
+		deletes := repository.ImageReconcileDeletes()
+		orphans, err := repository.ReconcileImages(ctx, repository.ImageOrphanGrace(), !deletes)
+		switch {
+		case len(orphans) > 0 && deletes:
+			log.Printf("Deleted %d orphaned images", len(orphans))
+		case len(orphans) > 0:
+			log.Printf("Found %d orphaned images, set IMAGE_RECONCILE_DELETE=true to delete them: %s", len(orphans), strings.Join(orphans, ", "))
+		}
+		return err
+	})
+	runEvery("PURGE_INTERVAL", 24*time.Hour, func(ctx context.Context) error {
+		purged, err := repository.PurgeDeleted(ctx, repository.SoftDeleteRetention())
+		if purged > 0 {
+			log.Printf("Purged %d deleted documents", purged)
+		}
+		return err
+	})
+	runEvery("PUBLISH_SCHEDULER_INTERVAL", time.Minute, func(ctx context.Context) error {
+		publishedCount, discontinuedCount, err := repository.RunPerfumeScheduler(ctx)
+		if publishedCount > 0 || discontinuedCount > 0 {
+			log.Printf("Published %d and discontinued %d perfumes", publishedCount, discontinuedCount)
+		}
+		return err
+	})
+	runEvery("IMAGE_CLEANUP_INTERVAL", 10*time.Minute, func(ctx context.Context) error {
+		deleted, err := repository.RetryImageCleanup(ctx)
+		if len(deleted) > 0 {
+			log.Printf("Deleted %d images left behind by failed operations", len(deleted))
+		}
+		return err
+	})
+	runEvery("SHIPPING_TRACKING_INTERVAL", 15*time.Minute, func(ctx context.Context) error {
+		delivered, err := repository.PollShipments(ctx)
+		if delivered > 0 {
+			log.Printf("Marked %d orders delivered", delivered)
+		}
+		return err
+	})
+	runEvery("FLASH_SALE_CLOSE_INTERVAL", time.Minute, func(ctx context.Context) error {
+		closed, err := repository.CloseEndedFlashSales(ctx)
+		if closed > 0 {
+			log.Printf("Closed %d flash sales and returned their unsold quota to stock", closed)
+		}
+		return err
+	})
+
 	// Gunakan port dari environment variable Heroku
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -48,3 +153,39 @@ func main() {
 	log.Printf("Starting server on port %s...\n", port)
 	log.Fatal(app.Listen(":" + port))
 }
+
+// runEvery starts a background job on the interval read from the environment variable
+// (a Go duration such as "30m"), using fallback when it is unset and skipping the job when it is "0".
+// Each run takes a lock named after the variable for one interval, so when several instances of the
+// API share a database only one of them runs the job per interval.
+func runEvery(envName string, fallback time.Duration, job func(ctx context.Context) error) {
+	interval := fallback
+	if value := os.Getenv(envName); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid %s %q, using %s", envName, value, fallback)
+		} else {
+			interval = parsed
+		}
+	}
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			acquired, err := repository.AcquireLock(ctx, envName, interval)
+			if err != nil {
+				log.Printf("Background job %s skipped: %v", envName, err)
+			} else if acquired {
+				if err := job(ctx); err != nil {
+					log.Printf("Background job %s failed: %v", envName, err)
+				}
+			}
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/GilangAndhika/elfume/model"
+	"github.com/GilangAndhika/elfume/repository"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// IdempotencyHeader is the request header clients put their retry key in
+const IdempotencyHeader = "Idempotency-Key"
+
+// Idempotency makes retries of a non-idempotent route safe. The first request with a given
+// Idempotency-Key runs normally and its response is stored, retries with the same key get the
+// stored response back instead of running the handler again. Requests without the header are not affected.
+func Idempotency(scope string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get(IdempotencyHeader))
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > 255 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Idempotency-Key must be at most 255 characters"})
+		}
+
+		requestHash, err := hashRequest(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Failed to read request body", "error": err.Error()})
+		}
+
+		// Keys of logged in users are their own, so two customers picking the same key never see each other's response
+		recordKey := scope + ":" + key
+		if claims, ok := c.Locals("user").(jwt.MapClaims); ok {
+			if userID, _ := claims["user_id"].(string); userID != "" {
+				recordKey = scope + ":" + userID + ":" + key
+			}
+		}
+		record, started, err := repository.BeginIdempotentRequest(recordKey, requestHash)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "Failed to check idempotency key", "error": err.Error()})
+		}
+
+		if !started {
+			// The key was used before, never run the handler twice
+			if record.RequestHash != requestHash {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"message": "Idempotency-Key was already used with a different request"})
+			}
+			if record.Status != model.IdempotencyCompleted {
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "A request with this Idempotency-Key is still being processed"})
+			}
+
+			c.Set("Idempotent-Replayed", "true")
+			if record.ContentType != "" {
+				c.Set(fiber.HeaderContentType, record.ContentType)
+			}
+			return c.Status(record.ResponseStatus).Send(record.ResponseBody)
+		}
+
+		if err := c.Next(); err != nil {
+			repository.ReleaseIdempotentRequest(record)
+			return err
+		}
+
+		// Server errors are not remembered so the client can retry once we have recovered
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			if err := repository.ReleaseIdempotentRequest(record); err != nil {
+				log.Printf("Failed to release idempotency key %s: %v", recordKey, err)
+			}
+			return nil
+		}
+
+		contentType := string(c.Response().Header.ContentType())
+		if err := repository.CompleteIdempotentRequest(record, status, contentType, c.Response().Body()); err != nil {
+			log.Printf("Failed to store idempotent response for %s: %v", recordKey, err)
+		}
+
+		return nil
+	}
+}
+
+// hashRequest fingerprints the method, path and payload of a request. Multipart bodies are
+// hashed by field and file content rather than raw bytes, because clients pick a new
+// boundary on every retry.
+func hashRequest(c *fiber.Ctx) (string, error) {
+	hash := sha256.New()
+	io.WriteString(hash, c.Method()+" "+c.Path()+"\n")
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		hash.Write(c.Body())
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	fields := make([]string, 0, len(form.Value))
+	for name := range form.Value {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	for _, name := range fields {
+		for _, value := range form.Value[name] {
+			io.WriteString(hash, "field "+name+"="+value+"\n")
+		}
+	}
+
+	files := make([]string, 0, len(form.File))
+	for name := range form.File {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		for _, header := range form.File[name] {
+			io.WriteString(hash, "file "+name+"="+header.Filename+"\n")
+			file, err := header.Open()
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(hash, file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
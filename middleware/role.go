@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// RequireRole only lets users with one of the given role IDs through, it must run after JWTMiddleware
+func RequireRole(roleIDs ...string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(jwt.MapClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "Unauthorized"})
+		}
+
+		roleID, _ := claims["role_id"].(string)
+		for _, allowed := range roleIDs {
+			if roleID == allowed {
+				return c.Next()
+			}
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "Forbidden"})
+	}
+}
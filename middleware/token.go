@@ -48,3 +48,21 @@ func JWTMiddleware() func(c *fiber.Ctx) error {
 		return c.Next()
 	}
 }
+
+// OptionalJWT reads the token like JWTMiddleware when one is sent, but lets anonymous requests through
+func OptionalJWT() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		tokenStr := c.Cookies("token")
+		if tokenStr == "" {
+			return c.Next()
+		}
+
+		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+			return jwtSecret, nil
+		})
+		if err == nil && token.Valid {
+			c.Locals("user", token.Claims.(jwt.MapClaims))
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/GilangAndhika/elfume/repository"
+	"github.com/gofiber/fiber/v2"
+)
+
+// waitingRoomRetryAfter is the Retry-After sent to requests turned away from a full waiting room, in seconds
+const waitingRoomRetryAfter = 5
+
+// WaitingRoom protects a busy route of this instance from more traffic than it can handle. At most
+// FLASH_SALE_CONCURRENCY requests run at a time, up to FLASH_SALE_QUEUE more wait in line for their turn,
+// first come first served, for at most FLASH_SALE_QUEUE_WAIT. Requests that find the line full or wait too
+// long get 503 Service Unavailable with a Retry-After, so buyers retry later instead of piling up.
+func WaitingRoom() func(c *fiber.Ctx) error {
+	slots := make(chan struct{}, repository.FlashSaleConcurrency())
+	queueSize := int64(repository.FlashSaleQueueSize())
+	maxWait := repository.FlashSaleQueueWait()
+	var waiting atomic.Int64
+
+	busy := func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(waitingRoomRetryAfter))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"message":     "So many shoppers are buying right now that you are in the waiting room, please try again shortly",
+			"retry_after": waitingRoomRetryAfter,
+		})
+	}
+
+	return func(c *fiber.Ctx) error {
+		// A free slot lets the request straight in
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			return c.Next()
+		default:
+		}
+
+		if waiting.Add(1) > queueSize {
+			waiting.Add(-1)
+			return busy(c)
+		}
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+			waiting.Add(-1)
+			defer func() { <-slots }()
+			return c.Next()
+		case <-timer.C:
+			waiting.Add(-1)
+			return busy(c)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Address is a saved delivery address of a customer. Region names are copied from the region dataset so the
+// address reads the same if the dataset is updated later.
+type Address struct {
+	AddressID       primitive.ObjectID `json:"address_id" bson:"_id"`
+	UserID          primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Label           string             `json:"label" bson:"label"` // e.g. Rumah, Kantor
+	Recipient       string             `json:"recipient" bson:"recipient"`
+	Phone           string             `json:"phone" bson:"phone"` // International format, 628...
+	Street          string             `json:"street" bson:"street"`
+	ProvinceCode    string             `json:"province_code" bson:"province_code"`
+	Province        string             `json:"province" bson:"province"`
+	CityCode        string             `json:"city_code" bson:"city_code"`
+	City            string             `json:"city" bson:"city"`
+	DistrictCode    string             `json:"district_code,omitempty" bson:"district_code,omitempty"`
+	District        string             `json:"district,omitempty" bson:"district,omitempty"`
+	SubdistrictCode string             `json:"subdistrict_code,omitempty" bson:"subdistrict_code,omitempty"`
+	Subdistrict     string             `json:"subdistrict,omitempty" bson:"subdistrict,omitempty"`
+	PostalCode      string             `json:"postal_code" bson:"postal_code"`
+	IsDefault       bool               `json:"is_default" bson:"is_default"` // Exactly one address of a customer is the default
+	CreatedAt       primitive.DateTime `json:"created_at" bson:"created_at"`
+	UpdatedAt       primitive.DateTime `json:"updated_at" bson:"updated_at"`
+}
+
+// AddressRequest creates or replaces an address. The region is picked with the codes of the cascading
+// dropdowns, down to the sub-district when the dataset has them.
+type AddressRequest struct {
+	Label           string `json:"label"`
+	Recipient       string `json:"recipient"`
+	Phone           string `json:"phone"`
+	Street          string `json:"street"`
+	ProvinceCode    string `json:"province_code"`
+	CityCode        string `json:"city_code"`
+	DistrictCode    string `json:"district_code"`
+	SubdistrictCode string `json:"subdistrict_code"`
+	PostalCode      string `json:"postal_code"` // Defaults to the sub-district's
+	IsDefault       bool   `json:"is_default"`
+}
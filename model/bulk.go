@@ -0,0 +1,71 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// BulkRequest applies operations to every perfume matching a filter
+type BulkRequest struct {
+	Filter     BulkFilter      `json:"filter"`
+	Operations []BulkOperation `json:"operations"`
+	DryRun     bool            `json:"dry_run"`
+	Confirm    int64           `json:"confirm"` // Number of matched perfumes, required above the confirmation threshold
+}
+
+// BulkFilter selects perfumes with the criteria of /fume/search plus IDs and status.
+// Unlike a search it also matches drafts, scheduled, discontinued and archived perfumes.
+type BulkFilter struct {
+	IDs        []string `json:"ids,omitempty" bson:"ids,omitempty"`
+	Status     string   `json:"status,omitempty" bson:"status,omitempty"`
+	Name       string   `json:"name,omitempty" bson:"name,omitempty"`
+	Brand      string   `json:"brand,omitempty" bson:"brand,omitempty"`
+	Size       string   `json:"size,omitempty" bson:"size,omitempty"`
+	Categories string   `json:"categories,omitempty" bson:"categories,omitempty"`
+	CategoryID string   `json:"category_id,omitempty" bson:"category_id,omitempty"`
+	Types      string   `json:"types,omitempty" bson:"types,omitempty"`
+	Price      string   `json:"price,omitempty" bson:"price,omitempty"`
+	Tag        string   `json:"tag,omitempty" bson:"tag,omitempty"`
+}
+
+// BulkOperation is one step of a bulk update, applied to each perfume in order
+type BulkOperation struct {
+	Op    string      `json:"op" bson:"op"`                           // set, price_percent, stock_adjust, add_tag or remove_tag
+	Field string      `json:"field,omitempty" bson:"field,omitempty"` // Field changed by set
+	Value interface{} `json:"value" bson:"value"`
+}
+
+// Bulk operations
+const (
+	BulkSet          = "set"
+	BulkPricePercent = "price_percent"
+	BulkStockAdjust  = "stock_adjust"
+	BulkAddTag       = "add_tag"
+	BulkRemoveTag    = "remove_tag"
+)
+
+// BulkResult reports what a bulk update changed. Applied updates are kept as an audit record.
+type BulkResult struct {
+	BulkID      primitive.ObjectID   `json:"bulk_id" bson:"_id"`
+	Filter      BulkFilter           `json:"filter" bson:"filter"`
+	Operations  []BulkOperation      `json:"operations" bson:"operations"`
+	DryRun      bool                 `json:"dry_run" bson:"dry_run"`
+	Matched     int64                `json:"matched" bson:"matched"`
+	Changed     int                  `json:"changed" bson:"changed"` // With dry_run, how many would change
+	Unchanged   int                  `json:"unchanged" bson:"unchanged"`
+	AffectedIDs []primitive.ObjectID `json:"affected_ids" bson:"affected_ids"`
+	Failures    []BulkFailure        `json:"failures" bson:"failures"`
+	Preview     []BulkChange         `json:"preview,omitempty" bson:"-"` // First changes of a dry run
+	Author      RevisionAuthor       `json:"author" bson:"author"`
+	CreatedAt   primitive.DateTime   `json:"created_at" bson:"created_at"`
+}
+
+// BulkChange is what a bulk update changes on one perfume
+type BulkChange struct {
+	PerfumeID primitive.ObjectID `json:"perfume_id"`
+	Name      string             `json:"name"`
+	Changes   []FieldChange      `json:"changes"`
+}
+
+// BulkFailure is a perfume a bulk update could not change
+type BulkFailure struct {
+	PerfumeID primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	Error     string             `json:"error" bson:"error"`
+}
@@ -0,0 +1,68 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Cart holds the perfumes a shopper is about to buy. A guest cart is found by the token in its cookie,
+// a customer's cart by the user ID. Carts that are not touched expire through a TTL index on expires_at.
+type Cart struct {
+	CartID     primitive.ObjectID  `json:"cart_id" bson:"_id"`
+	UserID     *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	GuestToken string              `json:"-" bson:"guest_token,omitempty"`
+	Items      []CartItem          `json:"items" bson:"items"`
+	PromoCodes []string            `json:"promo_codes" bson:"promo_codes"` // Voucher codes the shopper applied
+	Version    int64               `json:"version" bson:"version"`
+	ExpiresAt  primitive.DateTime  `json:"expires_at" bson:"expires_at"`
+	CreatedAt  primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt  primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+
+	// Worked out from the current perfumes and promotions every time the cart is read
+	Subtotal         float64            `json:"subtotal" bson:"-"`
+	Discount         float64            `json:"discount" bson:"-"`
+	Total            float64            `json:"total" bson:"-"` // Subtotal less the discount, before shipping
+	ItemCount        int                `json:"item_count" bson:"-"`
+	Promotions       []AppliedPromotion `json:"promotions" bson:"-"`
+	Notices          []CartNotice       `json:"notices" bson:"-"`
+	PromotionNotices []PromotionNotice  `json:"promotion_notices" bson:"-"`
+}
+
+// CartItem is a line of a cart, one perfume in one size
+type CartItem struct {
+	ItemID    primitive.ObjectID `json:"item_id" bson:"_id"`
+	PerfumeID primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	Size      string             `json:"size" bson:"size"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	Price     string             `json:"price" bson:"price"` // Price the shopper last saw, a change is reported as a notice
+	AddedAt   primitive.DateTime `json:"added_at" bson:"added_at"`
+
+	// Current details of the perfume, filled when the cart is read
+	Name      string  `json:"name" bson:"-"`
+	Brand     string  `json:"brand" bson:"-"`
+	Slug      string  `json:"slug" bson:"-"`
+	Image     string  `json:"image" bson:"-"`
+	Stock     int     `json:"stock" bson:"-"`
+	Available bool    `json:"available" bson:"-"` // False when the perfume can no longer be bought, such lines are left out of the subtotal
+	LineTotal float64 `json:"line_total" bson:"-"`
+}
+
+// CartItemRequest adds a perfume to a cart or changes its quantity
+type CartItemRequest struct {
+	PerfumeID string `json:"perfume_id"`
+	Size      string `json:"size"`
+	Quantity  int    `json:"quantity"`
+}
+
+// CartNotice tells the shopper about a line that changed since the cart was last read or cannot be bought
+type CartNotice struct {
+	ItemID    primitive.ObjectID `json:"item_id"`
+	PerfumeID primitive.ObjectID `json:"perfume_id"`
+	Code      string             `json:"code"` // price_changed, quantity_reduced, out_of_stock or unavailable
+	Message   string             `json:"message"`
+}
+
+// Cart notice codes
+const (
+	CartPriceChanged    = "price_changed"
+	CartQuantityReduced = "quantity_reduced"
+	CartOutOfStock      = "out_of_stock"
+	CartUnavailable     = "unavailable"
+)
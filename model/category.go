@@ -0,0 +1,30 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type Category struct {
+	CategoryID primitive.ObjectID   `json:"category_id" bson:"_id"`
+	Name       string               `json:"name" bson:"name"`
+	Slug       string               `json:"slug" bson:"slug"`
+	ParentID   *primitive.ObjectID  `json:"parent_id" bson:"parent_id"` // Direct parent, nil for root families (e.g. Floral)
+	Ancestors  []primitive.ObjectID `json:"ancestors" bson:"ancestors"` // Path from the root down to the direct parent
+	CreatedAt  primitive.DateTime   `json:"created_at" bson:"created_at"`
+	UpdatedAt  primitive.DateTime   `json:"updated_at" bson:"updated_at"`
+}
+
+// CategoryNode is a category with its children, used to render the whole tree
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// Breadcrumb is one step of the path from a root category to a category
+type Breadcrumb struct {
+	CategoryID primitive.ObjectID `json:"category_id"`
+	Name       string             `json:"name"`
+	Slug       string             `json:"slug"`
+}
+
+type PerfumeCategoryRequest struct {
+	CategoryIDs []string `json:"category_ids"`
+}
@@ -0,0 +1,66 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// FlashSale is a time-boxed campaign selling a few perfumes at a special price. Each perfume has a quota of
+// bottles reserved from its stock when the sale is created, buyers take bottles from the quota only.
+type FlashSale struct {
+	FlashSaleID primitive.ObjectID `json:"flash_sale_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"` // e.g. "12.12 Midnight Sale"
+	StartsAt    primitive.DateTime `json:"starts_at" bson:"starts_at"`
+	EndsAt      primitive.DateTime `json:"ends_at" bson:"ends_at"`
+	Items       []FlashSaleItem    `json:"items" bson:"items"`
+	Closed      bool               `json:"closed" bson:"closed"` // Ended and the unsold quota went back into stock
+	Version     int64              `json:"version" bson:"version"`
+	CreatedBy   RevisionAuthor     `json:"created_by" bson:"created_by"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	UpdatedAt   primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	State       string             `json:"state" bson:"-"` // Computed on read, see FlashSale states
+}
+
+// FlashSaleItem is a perfume of a flash sale with its quota
+type FlashSaleItem struct {
+	PerfumeID    primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	Name         string             `json:"name" bson:"name"`
+	Brand        string             `json:"brand" bson:"brand"`
+	Slug         string             `json:"slug" bson:"slug"`
+	Image        string             `json:"image" bson:"image"`
+	RegularPrice float64            `json:"regular_price" bson:"regular_price"`
+	Price        float64            `json:"price" bson:"price"`
+	Quota        int                `json:"quota" bson:"quota"`
+	Sold         int                `json:"sold" bson:"sold"`
+	Remaining    int                `json:"remaining" bson:"remaining"`           // Quota not sold yet, only ever changed with a guarded $inc
+	PerUserLimit int                `json:"per_user_limit" bson:"per_user_limit"` // Bottles one customer can buy, 0 for no limit
+}
+
+// FlashSale states
+const (
+	FlashSaleUpcoming = "upcoming"
+	FlashSaleLive     = "live"
+	FlashSaleEnded    = "ended"
+)
+
+// FlashSaleRequest creates a flash sale
+type FlashSaleRequest struct {
+	Name     string                 `json:"name"`
+	StartsAt *primitive.DateTime    `json:"starts_at"`
+	EndsAt   *primitive.DateTime    `json:"ends_at"`
+	Items    []FlashSaleItemRequest `json:"items"`
+}
+
+// FlashSaleItemRequest puts a perfume in a flash sale
+type FlashSaleItemRequest struct {
+	PerfumeID    string  `json:"perfume_id"`
+	Price        float64 `json:"price"`
+	Quota        int     `json:"quota"`
+	PerUserLimit int     `json:"per_user_limit"`
+}
+
+// FlashSalePurchaseRequest buys a perfume of a live flash sale
+type FlashSalePurchaseRequest struct {
+	PerfumeID string          `json:"perfume_id"`
+	Size      string          `json:"size"`
+	Quantity  int             `json:"quantity"`
+	Shipping  *ShippingChoice `json:"shipping"` // A rate quoted for this perfume and quantity, nil to collect at the store
+	Notes     string          `json:"notes"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key header
+type IdempotencyRecord struct {
+	Key            string             `json:"key" bson:"_id"`                   // Scope and client key, e.g. "perfume.create:3f9c..."
+	RequestHash    string             `json:"request_hash" bson:"request_hash"` // Hash of method, path and body, a reused key must send the same request
+	Status         string             `json:"status" bson:"status"`             // processing or completed
+	ResponseStatus int                `json:"response_status" bson:"response_status"`
+	ResponseBody   []byte             `json:"response_body" bson:"response_body"`
+	ContentType    string             `json:"content_type" bson:"content_type"`
+	LockedUntil    primitive.DateTime `json:"locked_until" bson:"locked_until"` // While processing, another request may take the key over after this
+	CreatedAt      primitive.DateTime `json:"created_at" bson:"created_at"`
+	ExpiresAt      primitive.DateTime `json:"expires_at" bson:"expires_at"`
+}
+
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+)
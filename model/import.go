@@ -0,0 +1,51 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ImportJob tracks a spreadsheet import of perfumes that runs in the background
+type ImportJob struct {
+	JobID           primitive.ObjectID  `json:"job_id" bson:"_id"`
+	FileName        string              `json:"file_name" bson:"file_name"`
+	Format          string              `json:"format" bson:"format"` // csv or xlsx
+	DryRun          bool                `json:"dry_run" bson:"dry_run"`
+	Mapping         map[string]string   `json:"mapping" bson:"mapping"` // Spreadsheet column to perfume field
+	Status          string              `json:"status" bson:"status"`
+	TotalRows       int                 `json:"total_rows" bson:"total_rows"`
+	ProcessedRows   int                 `json:"processed_rows" bson:"processed_rows"`
+	Created         int                 `json:"created" bson:"created"` // With dry_run, how many would be created
+	Updated         int                 `json:"updated" bson:"updated"` // With dry_run, how many would be updated
+	Unchanged       int                 `json:"unchanged" bson:"unchanged"`
+	Failed          int                 `json:"failed" bson:"failed"`
+	Errors          []ImportRowError    `json:"errors" bson:"errors"`
+	ErrorsTruncated bool                `json:"errors_truncated" bson:"errors_truncated"`
+	Error           string              `json:"error,omitempty" bson:"error,omitempty"` // Why the whole job failed
+	CreatedBy       RevisionAuthor      `json:"created_by" bson:"created_by"`
+	CreatedAt       primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt       primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+	FinishedAt      *primitive.DateTime `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// PerfumeImportRequest is a spreadsheet to import, Mapping maps column titles to perfume fields
+type PerfumeImportRequest struct {
+	FileName string
+	Content  []byte
+	Mapping  map[string]string
+	DryRun   bool
+	Author   RevisionAuthor
+}
+
+// ImportRowError is a problem with one row, the row number is the one shown by the spreadsheet
+type ImportRowError struct {
+	Row     int    `json:"row" bson:"row"`
+	Column  string `json:"column,omitempty" bson:"column,omitempty"`
+	Value   string `json:"value,omitempty" bson:"value,omitempty"`
+	Message string `json:"message" bson:"message"`
+}
+
+// Import job statuses
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
@@ -0,0 +1,105 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Order is a purchase placed from a customer's cart. The lines keep the perfume details and prices of the
+// moment the order was placed, later catalogue changes do not alter it.
+type Order struct {
+	OrderID          primitive.ObjectID  `json:"order_id" bson:"_id"`
+	OrderNumber      string              `json:"order_number" bson:"order_number"` // Readable reference for customers, e.g. ELF-20240401-3F9A1C
+	UserID           primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Items            []OrderItem         `json:"items" bson:"items"`
+	ItemCount        int                 `json:"item_count" bson:"item_count"`
+	Subtotal         float64             `json:"subtotal" bson:"subtotal"`
+	Discount         float64             `json:"discount" bson:"discount"`
+	ShippingCost     float64             `json:"shipping_cost" bson:"shipping_cost"`
+	ShippingDiscount float64             `json:"shipping_discount" bson:"shipping_discount"`
+	Total            float64             `json:"total" bson:"total"`                               // Subtotal plus shipping, less the discounts
+	Promotions       []AppliedPromotion  `json:"promotions,omitempty" bson:"promotions,omitempty"` // Promotions redeemed by the order
+	Shipping         *OrderShipping      `json:"shipping,omitempty" bson:"shipping,omitempty"`     // Courier and destination chosen at checkout
+	Notes            string              `json:"notes" bson:"notes"`
+	Status           string              `json:"status" bson:"status"`
+	History          []OrderStatusChange `json:"history" bson:"history"`                                 // Every status the order went through, oldest first
+	StockReturned    bool                `json:"stock_returned" bson:"stock_returned"`                   // The bottles went back into stock when the order was cancelled or refunded
+	FlashSaleID      *primitive.ObjectID `json:"flash_sale_id,omitempty" bson:"flash_sale_id,omitempty"` // Bought in this flash sale, its bottles go back to its quota
+	Version          int64               `json:"version" bson:"version"`
+	CreatedAt        primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt        primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+}
+
+// OrderItem is a perfume bought in an order, as it was when the order was placed
+type OrderItem struct {
+	PerfumeID primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Name      string             `json:"name" bson:"name"`
+	Brand     string             `json:"brand" bson:"brand"`
+	Slug      string             `json:"slug" bson:"slug"`
+	Image     string             `json:"image" bson:"image"`
+	Size      string             `json:"size" bson:"size"`
+	UnitPrice float64            `json:"unit_price" bson:"unit_price"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	LineTotal float64            `json:"line_total" bson:"line_total"`
+	Discount  float64            `json:"discount" bson:"discount"` // Share of the order's discount taken off this line
+}
+
+// Order statuses
+const (
+	OrderPendingPayment = "pending_payment"
+	OrderPaid           = "paid"
+	OrderPacked         = "packed"
+	OrderShipped        = "shipped"
+	OrderDelivered      = "delivered"
+	OrderCancelled      = "cancelled"
+	OrderRefunded       = "refunded"
+)
+
+// Who moves an order to another status
+const (
+	ActorCustomer = "customer"
+	ActorAdmin    = "admin"
+	ActorSystem   = "system" // Payment notifications and background jobs
+)
+
+// OrderStatusChange is a step in the history of an order
+type OrderStatusChange struct {
+	From  string             `json:"from,omitempty" bson:"from,omitempty"`
+	To    string             `json:"to" bson:"to"`
+	Actor string             `json:"actor" bson:"actor"` // customer, admin or system
+	By    RevisionAuthor     `json:"by" bson:"by"`
+	Note  string             `json:"note,omitempty" bson:"note,omitempty"`
+	At    primitive.DateTime `json:"at" bson:"at"`
+}
+
+// OrderStatusRequest moves an order to another status
+type OrderStatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// OrderFilter selects orders for a listing
+type OrderFilter struct {
+	UserID *primitive.ObjectID
+	Status string
+	From   *primitive.DateTime // Placed at or after
+	To     *primitive.DateTime // Placed before
+	Page   int
+	Limit  int
+}
+
+// OrderRequest places an order from the customer's cart
+type OrderRequest struct {
+	Notes    string          `json:"notes"`
+	Shipping *ShippingChoice `json:"shipping"` // Rate of a shipping quote, orders without one are collected at the store
+}
+
+// OrderConflict is a cart line that kept an order from being placed
+type OrderConflict struct {
+	ItemID    primitive.ObjectID `json:"item_id"`
+	PerfumeID primitive.ObjectID `json:"perfume_id"`
+	Name      string             `json:"name"`
+	Size      string             `json:"size"`
+	Requested int                `json:"requested"`
+	Available int                `json:"available"`       // Bottles left, for every line of the same perfume together
+	Reason    string             `json:"reason"`          // out_of_stock, unavailable or price_changed
+	Price     string             `json:"price,omitempty"` // Current price when it changed
+}
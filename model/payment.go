@@ -0,0 +1,60 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Payment is an attempt to pay an order through the payment provider. A customer can start a new
+// attempt when the previous one failed or expired, each gets its own reference at the provider.
+type Payment struct {
+	PaymentID     primitive.ObjectID  `json:"payment_id" bson:"_id"`
+	OrderID       primitive.ObjectID  `json:"order_id" bson:"order_id"`
+	UserID        primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Provider      string              `json:"provider" bson:"provider"`
+	Reference     string              `json:"reference" bson:"reference"` // Order ID sent to the provider, unique per attempt
+	Amount        float64             `json:"amount" bson:"amount"`
+	Status        string              `json:"status" bson:"status"`                 // pending, paid, failed, expired or refunded
+	Token         string              `json:"token" bson:"token"`                   // Snap token for the payment popup
+	RedirectURL   string              `json:"redirect_url" bson:"redirect_url"`     // Payment page of the provider
+	TransactionID string              `json:"transaction_id" bson:"transaction_id"` // Set by the first notification
+	PaymentType   string              `json:"payment_type" bson:"payment_type"`     // e.g. bank_transfer, gopay, credit_card
+	Events        []PaymentEvent      `json:"events" bson:"events"`                 // Notifications received, each one once
+	ExpiresAt     *primitive.DateTime `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedAt     primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt     primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+}
+
+// Payment statuses
+const (
+	PaymentPending  = "pending"
+	PaymentPaid     = "paid"
+	PaymentFailed   = "failed"
+	PaymentExpired  = "expired"
+	PaymentRefunded = "refunded"
+)
+
+// PaymentEvent is a notification the provider sent about a payment
+type PaymentEvent struct {
+	Key               string             `json:"key" bson:"key"` // Identifies repeated deliveries of the same notification
+	TransactionID     string             `json:"transaction_id" bson:"transaction_id"`
+	TransactionStatus string             `json:"transaction_status" bson:"transaction_status"`
+	FraudStatus       string             `json:"fraud_status,omitempty" bson:"fraud_status,omitempty"`
+	PaymentType       string             `json:"payment_type" bson:"payment_type"`
+	Status            string             `json:"status" bson:"status"` // The payment status the notification means
+	ReceivedAt        primitive.DateTime `json:"received_at" bson:"received_at"`
+}
+
+// PaymentSession is where a customer pays, as returned by the provider
+type PaymentSession struct {
+	Token       string
+	RedirectURL string
+}
+
+// PaymentNotification is a verified notification from the provider
+type PaymentNotification struct {
+	Reference         string
+	TransactionID     string
+	TransactionStatus string
+	FraudStatus       string
+	PaymentType       string
+	GrossAmount       float64
+	Status            string // The payment status it means, empty when it does not change the status
+}
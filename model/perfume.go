@@ -3,27 +3,125 @@ package model
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type Perfume struct {
-	PerfumeID   primitive.ObjectID `json:"perfume_id" bson:"_id"`
-	Name        string             `json:"name" bson:"name"`
-	Brand       string             `json:"brand" bson:"brand"`
-	Types       string             `json:"types" bson:"types"`           // Types of the perfume (e.g. Eau de Parfum, Pure Perfume, etc.)
-	Categories  string             `json:"categories" bson:"categories"` // Fragrance categories (e.g. Floral, Fresh, Woody, etc.)
-	Sizes       string             `json:"sizes" bson:"sizes"`           // Available sizes of the perfume (e.g. 50ml, 100ml, 200ml, etc.)
-	Image       string             `json:"image" bson:"image"`
-	Price       string             `json:"price" bson:"price"`
-	Description string             `json:"description" bson:"description"`
-	Stock       string             `json:"stock" bson:"stock"`
-	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
-	UpdatedAt   primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	PerfumeID   primitive.ObjectID   `json:"perfume_id" bson:"_id"`
+	Name        string               `json:"name" bson:"name"`
+	Slug        string               `json:"slug" bson:"slug"` // Unique URL name derived from brand and name
+	SKU         string               `json:"sku" bson:"sku"`   // Optional stock keeping unit, unique when set
+	Brand       string               `json:"brand" bson:"brand"`
+	Types       string               `json:"types" bson:"types"`               // Types of the perfume (e.g. Eau de Parfum, Pure Perfume, etc.)
+	Categories  string               `json:"categories" bson:"categories"`     // Fragrance categories (e.g. Floral, Fresh, Woody, etc.)
+	CategoryIDs []primitive.ObjectID `json:"category_ids" bson:"category_ids"` // Nodes of the category tree the perfume is assigned to
+	Tags        []string             `json:"tags" bson:"tags"`                 // Free labels for merchandising (e.g. sale, bestseller)
+	Sizes       string               `json:"sizes" bson:"sizes"`               // Available sizes of the perfume (e.g. 50ml, 100ml, 200ml, etc.)
+	Image       string               `json:"image" bson:"image"`               // URL of the primary image, kept for clients that only show one picture
+	Images      []PerfumeImage       `json:"images" bson:"images"`             // Gallery ordered by position
+	Price       string               `json:"price" bson:"price"`
+	Description string               `json:"description" bson:"description"`
+	Stock       string               `json:"stock" bson:"stock"`
+	Weight      string               `json:"weight" bson:"weight"`                                 // Shipping weight of one bottle in grams, packaging included
+	Version     int64                `json:"version" bson:"version"`                               // Incremented on every write, used for ETags and If-Match
+	Status      string               `json:"status" bson:"status"`                                 // draft, scheduled, published or discontinued
+	PublishAt   *primitive.DateTime  `json:"publish_at,omitempty" bson:"publish_at,omitempty"`     // When a scheduled perfume goes live
+	UnpublishAt *primitive.DateTime  `json:"unpublish_at,omitempty" bson:"unpublish_at,omitempty"` // When a published perfume is discontinued
+	Archived    bool                 `json:"archived" bson:"archived"`                             // Hidden from listings but still readable by ID
+	ArchivedAt  *primitive.DateTime  `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	DeletedAt   *primitive.DateTime  `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // Soft deleted, purged after the retention period
+	CreatedAt   primitive.DateTime   `json:"created_at" bson:"created_at"`
+	UpdatedAt   primitive.DateTime   `json:"updated_at" bson:"updated_at"`
 }
 
+// Perfume lifecycle statuses, only published perfumes are shown to the public
+const (
+	StatusDraft        = "draft"
+	StatusScheduled    = "scheduled"
+	StatusPublished    = "published"
+	StatusDiscontinued = "discontinued"
+)
+
+// PerfumeSlug is a slug a perfume has or had, old slugs redirect to the perfume's current one
+type PerfumeSlug struct {
+	Slug      string             `json:"slug" bson:"_id"`
+	PerfumeID primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+// PerfumeStatusRequest moves a perfume through its lifecycle
+type PerfumeStatusRequest struct {
+	Status      string              `json:"status"`
+	PublishAt   *primitive.DateTime `json:"publish_at"`
+	UnpublishAt *primitive.DateTime `json:"unpublish_at"`
+}
+
+type PerfumeImage struct {
+	ImageID     primitive.ObjectID `json:"image_id" bson:"_id"`
+	URL         string             `json:"url" bson:"url"`
+	FileName    string             `json:"file_name" bson:"file_name"` // Key of the file in the image store
+	AltText     string             `json:"alt_text" bson:"alt_text"`
+	Kind        string             `json:"kind" bson:"kind"` // What the image shows (e.g. bottle, box, lifestyle)
+	IsPrimary   bool               `json:"is_primary" bson:"is_primary"`
+	Position    int                `json:"position" bson:"position"`
+	Width       int                `json:"width" bson:"width"`
+	Height      int                `json:"height" bson:"height"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Renditions  []ImageRendition   `json:"renditions" bson:"renditions"` // Resized copies stored next to the original
+}
+
+type ImageRendition struct {
+	Name        string `json:"name" bson:"name"`     // thumbnail, medium or large
+	Format      string `json:"format" bson:"format"` // jpeg, png or webp
+	URL         string `json:"url" bson:"url"`
+	FileName    string `json:"file_name" bson:"file_name"`
+	Width       int    `json:"width" bson:"width"`
+	Height      int    `json:"height" bson:"height"`
+	ContentType string `json:"content_type" bson:"content_type"`
+}
+
+// FumeImgUpload is an image file received from the client, waiting to be stored
 type FumeImgUpload struct {
-	PerfumeID primitive.ObjectID `json:"perfume_id" bson:"_id"`
-	Image     string             `json:"image" bson:"image"`
-	FileName  string             `json:"file_name" bson:"file_name"`
+	FileName    string
+	Content     []byte
+	ContentType string
+	AltText     string
+	Kind        string
+}
+
+// ImageCleanup is a stored file whose deletion failed and is retried in the background
+type ImageCleanup struct {
+	Key       string             `json:"key" bson:"_id"`
+	Reason    string             `json:"reason" bson:"reason"`
+	Status    string             `json:"status" bson:"status"`
+	LastError string             `json:"last_error" bson:"last_error"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+type PerfumeImageUpdateRequest struct {
+	AltText string `json:"alt_text"`
+	Kind    string `json:"kind"`
+}
+
+type PerfumeImageOrderRequest struct {
+	ImageIDs []string `json:"image_ids"`
 }
 
 type GithubUploadRequest struct {
-	Message string `json:"message"`
-	Content string `json:"content"`
+	Message   string                `json:"message"`
+	Content   string                `json:"content"`
+	SHA       string                `json:"sha,omitempty"` // Blob SHA of the file being replaced, required to overwrite
+	Branch    string                `json:"branch,omitempty"`
+	Author    *GithubCommitIdentity `json:"author,omitempty"`
+	Committer *GithubCommitIdentity `json:"committer,omitempty"`
+}
+
+type GithubDeleteRequest struct {
+	Message   string                `json:"message"`
+	SHA       string                `json:"sha"`
+	Branch    string                `json:"branch,omitempty"`
+	Author    *GithubCommitIdentity `json:"author,omitempty"`
+	Committer *GithubCommitIdentity `json:"committer,omitempty"`
+}
+
+type GithubCommitIdentity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
 }
@@ -0,0 +1,103 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Promotion is a discount marketing runs. With a code it is a voucher the customer applies to their cart,
+// without one it applies automatically to every cart it fits.
+type Promotion struct {
+	PromotionID  primitive.ObjectID  `json:"promotion_id" bson:"_id"`
+	Name         string              `json:"name" bson:"name"` // Shown to customers, e.g. "Ramadan 10%"
+	Description  string              `json:"description" bson:"description"`
+	Code         string              `json:"code,omitempty" bson:"code,omitempty"` // Voucher code in upper case, empty for automatic promotions
+	Type         string              `json:"type" bson:"type"`
+	Value        float64             `json:"value" bson:"value"`                   // Percent off, rupiah off, or the most shipping taken off (0 is all of it)
+	MaxDiscount  float64             `json:"max_discount" bson:"max_discount"`     // Cap of a percentage discount, 0 for none
+	BuyQuantity  int                 `json:"buy_quantity" bson:"buy_quantity"`     // Buy X ...
+	GetQuantity  int                 `json:"get_quantity" bson:"get_quantity"`     // ... get Y of the cheapest free
+	Scope        PromotionScope      `json:"scope" bson:"scope"`                   // Which perfumes count, empty for all of them
+	MinSpend     float64             `json:"min_spend" bson:"min_spend"`           // Subtotal of the perfumes in scope needed
+	UsageLimit   int                 `json:"usage_limit" bson:"usage_limit"`       // Orders that can use it, 0 for no limit
+	PerUserLimit int                 `json:"per_user_limit" bson:"per_user_limit"` // Orders of one customer that can use it, 0 for no limit
+	UsedCount    int                 `json:"used_count" bson:"used_count"`
+	Stackable    bool                `json:"stackable" bson:"stackable"` // Combines with other stackable promotions, otherwise it applies alone
+	Priority     int                 `json:"priority" bson:"priority"`   // Stacked promotions apply highest first
+	Active       bool                `json:"active" bson:"active"`
+	StartsAt     *primitive.DateTime `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	EndsAt       *primitive.DateTime `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	Version      int64               `json:"version" bson:"version"`
+	CreatedBy    RevisionAuthor      `json:"created_by" bson:"created_by"`
+	CreatedAt    primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt    primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+}
+
+// Promotion types
+const (
+	PromotionPercentage   = "percentage"    // Value percent off the perfumes in scope
+	PromotionFixed        = "fixed"         // Value rupiah off the perfumes in scope
+	PromotionFreeShipping = "free_shipping" // Shipping off, up to Value
+	PromotionBuyXGetY     = "buy_x_get_y"   // Of every BuyQuantity + GetQuantity bottles in scope, the GetQuantity cheapest are free
+)
+
+// PromotionScope limits a promotion to some perfumes. A perfume is in scope when it matches any of the lists.
+type PromotionScope struct {
+	Brands      []string             `json:"brands" bson:"brands"`
+	CategoryIDs []primitive.ObjectID `json:"category_ids" bson:"category_ids"` // Categories below these count as well
+	PerfumeIDs  []primitive.ObjectID `json:"perfume_ids" bson:"perfume_ids"`
+}
+
+// PromotionRequest creates or replaces a promotion
+type PromotionRequest struct {
+	Name         string              `json:"name"`
+	Description  string              `json:"description"`
+	Code         string              `json:"code"`
+	Type         string              `json:"type"`
+	Value        float64             `json:"value"`
+	MaxDiscount  float64             `json:"max_discount"`
+	BuyQuantity  int                 `json:"buy_quantity"`
+	GetQuantity  int                 `json:"get_quantity"`
+	Brands       []string            `json:"brands"`
+	CategoryIDs  []string            `json:"category_ids"`
+	PerfumeIDs   []string            `json:"perfume_ids"`
+	MinSpend     float64             `json:"min_spend"`
+	UsageLimit   int                 `json:"usage_limit"`
+	PerUserLimit int                 `json:"per_user_limit"`
+	Stackable    bool                `json:"stackable"`
+	Priority     int                 `json:"priority"`
+	Active       *bool               `json:"active"` // Defaults to true
+	StartsAt     *primitive.DateTime `json:"starts_at"`
+	EndsAt       *primitive.DateTime `json:"ends_at"`
+}
+
+// AppliedPromotion is a promotion that lowered the price of a cart or order
+type AppliedPromotion struct {
+	PromotionID      primitive.ObjectID `json:"promotion_id" bson:"promotion_id"`
+	Name             string             `json:"name" bson:"name"`
+	Code             string             `json:"code,omitempty" bson:"code,omitempty"`
+	Type             string             `json:"type" bson:"type"`
+	Discount         float64            `json:"discount" bson:"discount"`                   // Taken off the perfumes
+	ShippingDiscount float64            `json:"shipping_discount" bson:"shipping_discount"` // Taken off shipping, known once shipping is picked
+}
+
+// PromotionNotice tells the shopper why a voucher they applied does not lower the price
+type PromotionNotice struct {
+	Code    string `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Reasons a voucher does not apply
+const (
+	PromotionNotFound      = "not_found"
+	PromotionNotActive     = "not_active" // Switched off, not started yet or ended
+	PromotionUsedUp        = "used_up"
+	PromotionUserLimit     = "user_limit"
+	PromotionMinSpend      = "min_spend"
+	PromotionNoItems       = "no_eligible_items"
+	PromotionNoShipping    = "no_shipping"    // Free shipping on an order collected at the store
+	PromotionNotCombinable = "not_combinable" // A better combination of promotions was applied instead
+)
+
+// PromoCodeRequest applies a voucher code to a cart
+type PromoCodeRequest struct {
+	Code string `json:"code"`
+}
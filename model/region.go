@@ -0,0 +1,23 @@
+package model
+
+// Region is an administrative region of Indonesia. Codes are the dotted Kemendagri codes, a region's parent
+// is its code without the last part: 32 Jawa Barat, 32.73 Kota Bandung, 32.73.02 Coblong, 32.73.02.1006 Dago.
+type Region struct {
+	Code         string `json:"code" bson:"_id"`
+	Name         string `json:"name" bson:"name"`
+	Level        string `json:"level" bson:"level"`
+	Parent       string `json:"parent,omitempty" bson:"parent,omitempty"`
+	PostalCode   string `json:"postal_code,omitempty" bson:"postal_code,omitempty"`     // Sub-districts only
+	RajaOngkirID string `json:"rajaongkir_id,omitempty" bson:"rajaongkir_id,omitempty"` // RajaOngkir ID of a city, or of a district (RajaOngkir's subdistrict)
+}
+
+// Region levels, from the largest
+const (
+	RegionProvince    = "province"    // Provinsi
+	RegionCity        = "city"        // Kabupaten or kota
+	RegionDistrict    = "district"    // Kecamatan
+	RegionSubdistrict = "subdistrict" // Kelurahan or desa
+)
+
+// RegionLevels are the levels in the order regions nest
+var RegionLevels = []string{RegionProvince, RegionCity, RegionDistrict, RegionSubdistrict}
@@ -0,0 +1,37 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PerfumeRevision records one change of a perfume's fields
+type PerfumeRevision struct {
+	RevisionID   primitive.ObjectID  `json:"revision_id" bson:"_id"`
+	PerfumeID    primitive.ObjectID  `json:"perfume_id" bson:"perfume_id"`
+	Version      int64               `json:"version" bson:"version"` // Perfume version the change produced
+	Source       string              `json:"source" bson:"source"`   // update, patch, restore, import or bulk
+	RestoredFrom *primitive.ObjectID `json:"restored_from,omitempty" bson:"restored_from,omitempty"`
+	Author       RevisionAuthor      `json:"author" bson:"author"`
+	Changes      []FieldChange       `json:"changes" bson:"changes"`
+	Snapshot     map[string]string   `json:"snapshot" bson:"snapshot"` // Tracked fields after the change, used to restore the revision
+	CreatedAt    primitive.DateTime  `json:"created_at" bson:"created_at"`
+}
+
+// RevisionAuthor is the user who made a change, anonymous changes only have a username
+type RevisionAuthor struct {
+	UserID   *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Username string              `json:"username" bson:"username"`
+}
+
+// FieldChange is the old and new value of one field
+type FieldChange struct {
+	Field string `json:"field" bson:"field"`
+	From  string `json:"from" bson:"from"`
+	To    string `json:"to" bson:"to"`
+}
+
+const (
+	RevisionUpdate  = "update"
+	RevisionPatch   = "patch"
+	RevisionRestore = "restore"
+	RevisionImport  = "import"
+	RevisionBulk    = "bulk"
+)
@@ -0,0 +1,116 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ShippingLocation is where a parcel is sent from or to. RajaOngkir needs the city or subdistrict ID, the rate
+// table only the city and province names.
+type ShippingLocation struct {
+	SubdistrictID string `json:"subdistrict_id,omitempty" bson:"subdistrict_id,omitempty"` // RajaOngkir subdistrict (kecamatan) ID
+	CityID        string `json:"city_id,omitempty" bson:"city_id,omitempty"`               // RajaOngkir city ID
+	City          string `json:"city" bson:"city"`
+	Province      string `json:"province" bson:"province"`
+	PostalCode    string `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+}
+
+// ShippingRate is what a courier service charges for a parcel
+type ShippingRate struct {
+	Courier     string  `json:"courier" bson:"courier"` // jne, jnt or sicepat
+	CourierName string  `json:"courier_name" bson:"courier_name"`
+	Service     string  `json:"service" bson:"service"` // e.g. REG, YES, EZ, BEST
+	Description string  `json:"description" bson:"description"`
+	Cost        float64 `json:"cost" bson:"cost"`
+	ETD         string  `json:"etd" bson:"etd"` // Estimated days in transit, e.g. 2-3
+}
+
+// ShippingQuote is a set of rates for the weight of a cart to a destination. Checkout refers to a quote so
+// the customer pays the rate they were shown.
+type ShippingQuote struct {
+	QuoteID     primitive.ObjectID `json:"quote_id" bson:"_id"`
+	Provider    string             `json:"provider" bson:"provider"` // rajaongkir or table
+	Destination ShippingLocation   `json:"destination" bson:"destination"`
+	Address     *Address           `json:"address,omitempty" bson:"address,omitempty"` // The saved address quoted for
+	Weight      int                `json:"weight" bson:"weight"`                       // Grams, packaging included
+	Rates       []ShippingRate     `json:"rates" bson:"rates"`
+	Estimated   bool               `json:"estimated" bson:"estimated"` // Rates come from the table because the courier API failed
+	ExpiresAt   primitive.DateTime `json:"expires_at" bson:"expires_at"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+// ShippingQuoteRequest asks for rates. Without items the rates are for the caller's cart. Logged in customers
+// can send one of their saved addresses instead of a destination.
+type ShippingQuoteRequest struct {
+	AddressID   string            `json:"address_id"`
+	Destination ShippingLocation  `json:"destination"`
+	Items       []CartItemRequest `json:"items"`
+}
+
+// ShippingChoice is the rate picked at checkout
+type ShippingChoice struct {
+	QuoteID string `json:"quote_id"`
+	Courier string `json:"courier"`
+	Service string `json:"service"`
+}
+
+// OrderShipping is how an order is shipped, as quoted at checkout
+type OrderShipping struct {
+	Destination ShippingLocation `json:"destination" bson:"destination"`
+	Address     *Address         `json:"address,omitempty" bson:"address,omitempty"` // Copy of the saved address, for the shipping label
+	Courier     string           `json:"courier" bson:"courier"`
+	CourierName string           `json:"courier_name" bson:"courier_name"`
+	Service     string           `json:"service" bson:"service"`
+	Cost        float64          `json:"cost" bson:"cost"`
+	ETD         string           `json:"etd" bson:"etd"`
+	Weight      int              `json:"weight" bson:"weight"`
+}
+
+// Shipment is the parcel of an order handed to a courier, followed by its airway bill number
+type Shipment struct {
+	ShipmentID    primitive.ObjectID  `json:"shipment_id" bson:"_id"`
+	OrderID       primitive.ObjectID  `json:"order_id" bson:"order_id"`
+	UserID        primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Courier       string              `json:"courier" bson:"courier"`
+	Service       string              `json:"service" bson:"service"`
+	AWB           string              `json:"awb" bson:"awb"` // Airway bill (resi) number
+	Status        string              `json:"status" bson:"status"`
+	Events        []TrackingEvent     `json:"events" bson:"events"` // Courier manifest, oldest first
+	DeliveredAt   *primitive.DateTime `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	Receiver      string              `json:"receiver,omitempty" bson:"receiver,omitempty"` // Who signed for the parcel
+	LastError     string              `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CheckedAt     *primitive.DateTime `json:"checked_at,omitempty" bson:"checked_at,omitempty"`
+	NextCheckAt   *primitive.DateTime `json:"next_check_at,omitempty" bson:"next_check_at,omitempty"` // Unset once tracking stops
+	TrackAttempts int                 `json:"track_attempts" bson:"track_attempts"`
+	CreatedBy     RevisionAuthor      `json:"created_by" bson:"created_by"`
+	CreatedAt     primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt     primitive.DateTime  `json:"updated_at" bson:"updated_at"`
+}
+
+// Shipment statuses
+const (
+	ShipmentCreated   = "created"    // The courier has the AWB but did not scan the parcel yet
+	ShipmentInTransit = "in_transit" // The parcel is on its way
+	ShipmentDelivered = "delivered"
+	ShipmentReturned  = "returned" // The parcel goes back to the warehouse
+)
+
+// TrackingEvent is a line of the courier manifest
+type TrackingEvent struct {
+	Description string             `json:"description" bson:"description"`
+	Location    string             `json:"location" bson:"location"`
+	At          primitive.DateTime `json:"at" bson:"at"`
+}
+
+// Tracking is what the courier reports about an AWB
+type Tracking struct {
+	Status      string
+	Events      []TrackingEvent
+	DeliveredAt *primitive.DateTime
+	Receiver    string
+}
+
+// ShipmentRequest hands the parcel of an order to a courier. Courier and service default to the ones chosen at checkout.
+type ShipmentRequest struct {
+	AWB     string `json:"awb"`
+	Courier string `json:"courier"`
+	Service string `json:"service"`
+	Note    string `json:"note"`
+}
@@ -0,0 +1,46 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ImageUpload is a direct-to-storage upload handed out by the presign endpoint,
+// the object is attached to the perfume once the client confirms it
+type ImageUpload struct {
+	UploadID    primitive.ObjectID `json:"upload_id" bson:"_id,omitempty"`
+	PerfumeID   primitive.ObjectID `json:"perfume_id" bson:"perfume_id"`
+	Key         string             `json:"key" bson:"key"`                   // Object key the client uploads to
+	FileName    string             `json:"file_name" bson:"file_name"`       // Original file name reported by the client
+	ContentType string             `json:"content_type" bson:"content_type"` // Content type the upload URL was signed for
+	Size        int64              `json:"size" bson:"size"`                 // Size announced by the client
+	AltText     string             `json:"alt_text" bson:"alt_text"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Status      string             `json:"status" bson:"status"`                         // pending, confirming, confirmed or failed
+	ImageID     primitive.ObjectID `json:"image_id,omitempty" bson:"image_id,omitempty"` // Gallery image created on confirm
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   primitive.DateTime `json:"created_at" bson:"created_at"`
+	ExpiresAt   primitive.DateTime `json:"expires_at" bson:"expires_at"` // The upload URL stops working after this
+}
+
+const (
+	UploadPending    = "pending"
+	UploadConfirming = "confirming"
+	UploadConfirmed  = "confirmed"
+	UploadFailed     = "failed"
+)
+
+// PresignUploadRequest describes the file the client is about to upload
+type PresignUploadRequest struct {
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	AltText     string `json:"alt_text"`
+	Kind        string `json:"kind"`
+}
+
+// PresignUploadResponse tells the client where and how to upload the file
+type PresignUploadResponse struct {
+	UploadID  string             `json:"upload_id"`
+	UploadURL string             `json:"upload_url"`
+	Method    string             `json:"method"`
+	Headers   map[string]string  `json:"headers"` // Headers the upload request must send unchanged
+	ExpiresAt primitive.DateTime `json:"expires_at"`
+}
@@ -5,15 +5,19 @@ import (
 )
 
 type User struct {
-	UserID    primitive.ObjectID `json:"user_id" bson:"_id"`
-	Username  string             `json:"username" bson:"username"`
-	Email     string             `json:"email" bson:"email"`
-	Password  string             `json:"password" bson:"password"`
-	Phone     string             `json:"phone" bson:"phone"`
-	RoleID    primitive.ObjectID `json:"role_id" bson:"role_id"`
-	RoleName  string             `json:"role_name" bson:"role_name"`
-	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
-	UpdatedAt primitive.DateTime `json:"updated_at" bson:"updated_at"`
+	UserID     primitive.ObjectID  `json:"user_id" bson:"_id"`
+	Username   string              `json:"username" bson:"username"`
+	Email      string              `json:"email" bson:"email"`
+	Password   string              `json:"password" bson:"password"`
+	Phone      string              `json:"phone" bson:"phone"`
+	RoleID     primitive.ObjectID  `json:"role_id" bson:"role_id"`
+	RoleName   string              `json:"role_name" bson:"role_name"`
+	Version    int64               `json:"version" bson:"version"`   // Incremented on every write, used for ETags and If-Match
+	Archived   bool                `json:"archived" bson:"archived"` // Deactivated, hidden from listings and unable to log in
+	ArchivedAt *primitive.DateTime `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	DeletedAt  *primitive.DateTime `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // Soft deleted, purged after the retention period
+	CreatedAt  primitive.DateTime  `json:"created_at" bson:"created_at"`
+	UpdatedAt  primitive.DateTime  `json:"updated_at" bson:"updated_at"`
 }
 
 type Role struct {
@@ -0,0 +1,358 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidAddress is returned for addresses with missing or invalid fields
+var ErrInvalidAddress = errors.New("invalid address")
+
+// ErrAddressNotFound is returned when an address does not exist or belongs to another customer
+var ErrAddressNotFound = errors.New("address not found")
+
+// maxAddresses is how many addresses a customer can save
+const maxAddresses = 20
+
+// postalCodePattern matches Indonesian postal codes (kode pos)
+var postalCodePattern = regexp.MustCompile(`^[1-9][0-9]{4}$`)
+
+// phoneSeparators are left out of phone numbers before they are validated, people type them in many ways
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+// normalizePhone accepts 08..., 628... and +628... numbers and returns the international format
+func normalizePhone(phone string) (string, bool) {
+	local := phoneSeparators.Replace(strings.TrimSpace(phone))
+	local = strings.TrimPrefix(local, "+")
+	if strings.HasPrefix(local, "62") {
+		local = "0" + local[2:]
+	}
+	valid, formatted := IsPhoneValid(local)
+	return formatted, valid
+}
+
+// addressID parses the ID of an address, an invalid one is simply not found
+func addressID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrAddressNotFound
+	}
+	return objID, nil
+}
+
+// buildAddress validates a request and fills in the region names. The region must be picked down to the
+// deepest level the dataset has for it, at least the city.
+func buildAddress(ctx context.Context, request model.AddressRequest) (*model.Address, error) {
+	address := &model.Address{
+		Label:     strings.TrimSpace(request.Label),
+		Recipient: strings.TrimSpace(request.Recipient),
+		Street:    strings.TrimSpace(request.Street),
+	}
+	switch {
+	case address.Recipient == "" || len(address.Recipient) > 100:
+		return nil, fmt.Errorf("%w: recipient is required and at most 100 characters", ErrInvalidAddress)
+	case address.Street == "" || len(address.Street) > 200:
+		return nil, fmt.Errorf("%w: street is required and at most 200 characters", ErrInvalidAddress)
+	case len(address.Label) > 30:
+		return nil, fmt.Errorf("%w: label is at most 30 characters", ErrInvalidAddress)
+	}
+
+	phone, valid := normalizePhone(request.Phone)
+	if !valid {
+		return nil, fmt.Errorf("%w: phone must be an Indonesian mobile number such as 081234567890", ErrInvalidAddress)
+	}
+	address.Phone = phone
+
+	// The deepest code picks the region, the ones above it must agree with it
+	codes := []string{
+		strings.TrimSpace(request.ProvinceCode),
+		strings.TrimSpace(request.CityCode),
+		strings.TrimSpace(request.DistrictCode),
+		strings.TrimSpace(request.SubdistrictCode),
+	}
+	deepest := -1
+	for level, code := range codes {
+		if code != "" {
+			deepest = level
+		}
+	}
+	if deepest < 1 {
+		return nil, fmt.Errorf("%w: city_code is required", ErrInvalidAddress)
+	}
+	code := codes[deepest]
+	if !regionCodePattern.MatchString(code) || regionLevel(code) != model.RegionLevels[deepest] {
+		return nil, fmt.Errorf("%w: %s is not a %s code", ErrInvalidAddress, code, model.RegionLevels[deepest])
+	}
+	ancestry := regionAncestry(code)
+	for level := 0; level < deepest; level++ {
+		if codes[level] != "" && codes[level] != ancestry[level] {
+			return nil, fmt.Errorf("%w: %s is not in %s", ErrInvalidAddress, code, codes[level])
+		}
+	}
+
+	path, err := regionPath(ctx, code)
+	if err != nil {
+		if errors.Is(err, ErrRegionNotFound) {
+			return nil, fmt.Errorf("%w: unknown region %s", ErrInvalidAddress, code)
+		}
+		return nil, err
+	}
+	if deepest < len(model.RegionLevels)-1 {
+		hasChildren, err := hasRegionChildren(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if hasChildren {
+			return nil, fmt.Errorf("%w: %s_code is required for %s", ErrInvalidAddress, model.RegionLevels[deepest+1], path[model.RegionLevels[deepest]].Name)
+		}
+	}
+
+	address.ProvinceCode, address.Province = path[model.RegionProvince].Code, path[model.RegionProvince].Name
+	address.CityCode, address.City = path[model.RegionCity].Code, path[model.RegionCity].Name
+	address.DistrictCode, address.District = path[model.RegionDistrict].Code, path[model.RegionDistrict].Name
+	address.SubdistrictCode, address.Subdistrict = path[model.RegionSubdistrict].Code, path[model.RegionSubdistrict].Name
+
+	address.PostalCode = strings.TrimSpace(request.PostalCode)
+	if address.PostalCode == "" {
+		address.PostalCode = path[model.RegionSubdistrict].PostalCode
+	}
+	if !postalCodePattern.MatchString(address.PostalCode) {
+		return nil, fmt.Errorf("%w: postal_code must be 5 digits", ErrInvalidAddress)
+	}
+
+	return address, nil
+}
+
+// GetAddresses returns a customer's addresses, the default first
+func GetAddresses(userID primitive.ObjectID) ([]model.Address, error) {
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	opts := options.Find().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "updated_at", Value: -1}})
+	cursor, err := addressCollection.Find(context.TODO(), bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch addresses: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	addresses := []model.Address{}
+	if err = cursor.All(context.TODO(), &addresses); err != nil {
+		return nil, fmt.Errorf("failed to decode addresses: %v", err)
+	}
+
+	return addresses, nil
+}
+
+// GetAddress returns an address of a customer
+func GetAddress(id string, userID primitive.ObjectID) (*model.Address, error) {
+	objID, err := addressID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	var address model.Address
+	err = addressCollection.FindOne(context.TODO(), bson.M{"_id": objID, "user_id": userID}).Decode(&address)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch address: %v", err)
+	}
+
+	return &address, nil
+}
+
+// CreateAddress saves a new address for a customer. Their first address becomes the default.
+func CreateAddress(userID primitive.ObjectID, request model.AddressRequest) (*model.Address, error) {
+	ctx := context.TODO()
+	address, err := buildAddress(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	count, err := addressCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count addresses: %v", err)
+	}
+	if count >= maxAddresses {
+		return nil, fmt.Errorf("%w: at most %d addresses can be saved", ErrInvalidAddress, maxAddresses)
+	}
+
+	// The address is saved as not default first, the unique index allows one default per customer
+	now := primitive.NewDateTimeFromTime(time.Now())
+	address.AddressID = primitive.NewObjectID()
+	address.UserID = userID
+	address.CreatedAt = now
+	address.UpdatedAt = now
+	if _, err := addressCollection.InsertOne(ctx, address); err != nil {
+		return nil, fmt.Errorf("failed to save address: %v", err)
+	}
+
+	if request.IsDefault || count == 0 {
+		if err := setDefaultAddress(ctx, userID, address.AddressID); err != nil {
+			return nil, err
+		}
+	}
+
+	return GetAddress(address.AddressID.Hex(), userID)
+}
+
+// UpdateAddress replaces an address of a customer. An address is made the default with is_default, it stops
+// being the default only when another address becomes it.
+func UpdateAddress(id string, userID primitive.ObjectID, request model.AddressRequest) (*model.Address, error) {
+	objID, err := addressID(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.TODO()
+	address, err := buildAddress(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	result, err := addressCollection.UpdateOne(ctx, bson.M{"_id": objID, "user_id": userID}, bson.M{"$set": bson.M{
+		"label":            address.Label,
+		"recipient":        address.Recipient,
+		"phone":            address.Phone,
+		"street":           address.Street,
+		"province_code":    address.ProvinceCode,
+		"province":         address.Province,
+		"city_code":        address.CityCode,
+		"city":             address.City,
+		"district_code":    address.DistrictCode,
+		"district":         address.District,
+		"subdistrict_code": address.SubdistrictCode,
+		"subdistrict":      address.Subdistrict,
+		"postal_code":      address.PostalCode,
+		"updated_at":       primitive.NewDateTimeFromTime(time.Now()),
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update address: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrAddressNotFound
+	}
+
+	if request.IsDefault {
+		if err := setDefaultAddress(ctx, userID, objID); err != nil {
+			return nil, err
+		}
+	}
+
+	return GetAddress(id, userID)
+}
+
+// SetDefaultAddress makes an address the customer's default
+func SetDefaultAddress(id string, userID primitive.ObjectID) (*model.Address, error) {
+	objID, err := addressID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := setDefaultAddress(context.TODO(), userID, objID); err != nil {
+		return nil, err
+	}
+	return GetAddress(id, userID)
+}
+
+// DeleteAddress removes an address of a customer. When it was the default, the most recently updated of the
+// remaining addresses becomes the default.
+func DeleteAddress(id string, userID primitive.ObjectID) error {
+	objID, err := addressID(id)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	var removed model.Address
+	err = addressCollection.FindOneAndDelete(ctx, bson.M{"_id": objID, "user_id": userID}).Decode(&removed)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrAddressNotFound
+		}
+		return fmt.Errorf("failed to delete address: %v", err)
+	}
+	if !removed.IsDefault {
+		return nil
+	}
+
+	var next model.Address
+	opts := options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	err = addressCollection.FindOne(ctx, bson.M{"user_id": userID}, opts).Decode(&next)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch addresses: %v", err)
+	}
+	return setDefaultAddress(ctx, userID, next.AddressID)
+}
+
+// setDefaultAddress moves a customer's default to an address. Two requests doing this at once can collide on
+// the unique index of the default, the loser tries again.
+func setDefaultAddress(ctx context.Context, userID, addressID primitive.ObjectID) error {
+	addressCollection := config.MongoDB.Collection("addresses")
+
+	for attempt := 0; ; attempt++ {
+		_, err := addressCollection.UpdateMany(ctx, bson.M{"user_id": userID, "is_default": true, "_id": bson.M{"$ne": addressID}}, bson.M{"$set": bson.M{"is_default": false}})
+		if err != nil {
+			return fmt.Errorf("failed to update addresses: %v", err)
+		}
+
+		result, err := addressCollection.UpdateOne(ctx, bson.M{"_id": addressID, "user_id": userID}, bson.M{"$set": bson.M{"is_default": true}})
+		if mongo.IsDuplicateKeyError(err) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update address: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return ErrAddressNotFound
+		}
+		return nil
+	}
+}
+
+// addressDestination is where a parcel to an address goes. The RajaOngkir IDs come from the region dataset:
+// RajaOngkir's subdistricts are our districts (kecamatan).
+func addressDestination(ctx context.Context, address *model.Address) (model.ShippingLocation, error) {
+	destination := model.ShippingLocation{
+		City:       address.City,
+		Province:   address.Province,
+		PostalCode: address.PostalCode,
+	}
+
+	code := address.CityCode
+	if address.DistrictCode != "" {
+		code = address.DistrictCode
+	}
+	path, err := regionPath(ctx, code)
+	if errors.Is(err, ErrRegionNotFound) {
+		// The region was removed from the dataset since, the names are still good for the rate table
+		return destination, nil
+	}
+	if err != nil {
+		return destination, err
+	}
+	destination.CityID = path[model.RegionCity].RajaOngkirID
+	destination.SubdistrictID = path[model.RegionDistrict].RajaOngkirID
+	return destination, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/GilangAndhika/elfume/config"
@@ -12,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // CreateAccount handles user registration
@@ -37,6 +39,7 @@ func CreateAccount(user *model.User) error {
 		return err // Database error
 	}
 	user.RoleName = role.RoleName // Assign the fetched role_name
+	user.Version = 1
 
 	// Insert user into the database
 	_, err = usersCollection.InsertOne(context.TODO(), bson.M{
@@ -47,6 +50,7 @@ func CreateAccount(user *model.User) error {
 		"phone":      user.Phone,
 		"role_id":    user.RoleID,
 		"role_name":  user.RoleName,
+		"version":    user.Version,
 		"created_at": user.CreatedAt,
 		"updated_at": user.UpdatedAt,
 	})
@@ -95,9 +99,9 @@ func GetUserByID(id string) (*model.User, error) {
 	// Get database connection
 	userCollection := config.MongoDB.Collection("users")
 
-	// Find user by ID
+	// Find user by ID, soft deleted users no longer exist for the API
 	var user model.User
-	err = userCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&user)
+	err = userCollection.FindOne(context.TODO(), notDeleted(bson.M{"_id": objID})).Decode(&user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find user: %v", err)
 	}
@@ -110,13 +114,13 @@ func GetUserByID(id string) (*model.User, error) {
 func GetUserByEmailOrUsername(email, username string) (*model.User, error) {
 	collection := config.MongoDB.Collection("users")
 
-	// Query to find user by email OR username
-	filter := bson.M{
+	// Query to find user by email OR username, archived and deleted users cannot log in
+	filter := listed(bson.M{
 		"$or": []bson.M{
 			{"email": email},
 			{"username": username},
 		},
-	}
+	})
 
 	var user model.User
 	err := collection.FindOne(context.TODO(), filter).Decode(&user)
@@ -134,8 +138,8 @@ func GetAllUsers() ([]model.User, error) {
 	// Get database connection
 	userCollection := config.MongoDB.Collection("users")
 
-	// Find all users
-	cursor, err := userCollection.Find(context.TODO(), bson.M{})
+	// Find all users, archived and soft deleted ones are hidden
+	cursor, err := userCollection.Find(context.TODO(), listed(bson.M{}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch users: %v", err)
 	}
@@ -150,8 +154,9 @@ func GetAllUsers() ([]model.User, error) {
 	return users, nil
 }
 
-// UpdateUser updates an existing user's information by ID
-func UpdateUser(id string, updatedUser model.User) error {
+// UpdateUser updates an existing user's information by ID.
+// With an expected version the update only happens if nobody changed the user since it was read.
+func UpdateUser(id string, updatedUser model.User, expectedVersion *int64) error {
 	// Convert ID to ObjectID
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -173,24 +178,162 @@ func UpdateUser(id string, updatedUser model.User) error {
 			"role_id":   updatedUser.RoleID,
 			"updated_at": updatedUser.UpdatedAt,
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
 	// Perform the update
-	result, err := userCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
+	base := notDeleted(bson.M{"_id": objID})
+	result, err := userCollection.UpdateOne(context.TODO(), versionFilter(base, expectedVersion), update)
 	if err != nil {
 		return fmt.Errorf("failed to update user: %v", err)
 	}
 
 	// Check if the user was found and modified
 	if result.MatchedCount == 0 {
-		return fmt.Errorf("user not found")
+		return versionMissError(userCollection, base, fmt.Errorf("user not found"))
 	}
 
 	return nil
 }
 
-// DeleteUser deletes a user by ID
-func DeleteUser(id string) error {
+// PatchUser applies a JSON Merge Patch or JSON Patch to a user, only the supplied fields are changed.
+// Username and email must stay unique, phone numbers are stored in international format
+// and changing role_id also updates role_name. Only admins may change role_id, passwords cannot be patched.
+func PatchUser(id string, contentType string, body []byte, expectedVersion *int64, asAdmin bool) (*model.User, error) {
+	user, err := GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && *expectedVersion != user.Version {
+		return nil, ErrVersionConflict
+	}
+
+	// Get database connection
+	userCollection := config.MongoDB.Collection("users")
+	rolesCollection := config.MongoDB.Collection("roles")
+
+	// isTaken checks whether another user already uses the value
+	isTaken := func(field, value string) (bool, error) {
+		count, err := userCollection.CountDocuments(context.TODO(), bson.M{field: value, "_id": bson.M{"$ne": user.UserID}})
+		return count > 0, err
+	}
+
+	var roleName string
+	fields := map[string]patchField{
+		"username": func(value interface{}) (interface{}, error) {
+			username, err := requiredString(value)
+			if err != nil {
+				return nil, err
+			}
+			taken, err := isTaken("username", username.(string))
+			if err != nil {
+				return nil, fmt.Errorf("could not be checked: %v", err)
+			}
+			if taken {
+				return nil, errors.New("already exists")
+			}
+			return username, nil
+		},
+		"email": func(value interface{}) (interface{}, error) {
+			email, err := requiredString(value)
+			if err != nil {
+				return nil, err
+			}
+			if !IsEmailValid(email.(string)) {
+				return nil, errors.New("has an invalid format")
+			}
+			taken, err := isTaken("email", email.(string))
+			if err != nil {
+				return nil, fmt.Errorf("could not be checked: %v", err)
+			}
+			if taken {
+				return nil, errors.New("already exists")
+			}
+			return email, nil
+		},
+		"phone": func(value interface{}) (interface{}, error) {
+			phone, err := requiredString(value)
+			if err != nil {
+				return nil, err
+			}
+			// Accept the stored international format as well as the local one
+			local := phone.(string)
+			if strings.HasPrefix(local, "62") {
+				local = "0" + local[2:]
+			}
+			valid, formatted := IsPhoneValid(local)
+			if !valid {
+				return nil, errors.New("has an invalid format")
+			}
+			return formatted, nil
+		},
+		"role_id": func(value interface{}) (interface{}, error) {
+			text, ok := value.(string)
+			if !ok {
+				return nil, errors.New("must be a string")
+			}
+			roleID, err := primitive.ObjectIDFromHex(text)
+			if err != nil {
+				return nil, errors.New("must be a valid ID")
+			}
+			var role model.Role
+			err = rolesCollection.FindOne(context.TODO(), bson.M{"_id": roleID}).Decode(&role)
+			if err != nil {
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return nil, errors.New("role not found")
+				}
+				return nil, fmt.Errorf("could not be checked: %v", err)
+			}
+			roleName = role.RoleName
+			return roleID, nil
+		},
+	}
+	if !asAdmin {
+		// Customers cannot promote themselves
+		delete(fields, "role_id")
+	}
+
+	current, err := toPatchDocument(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user: %v", err)
+	}
+	// Never let a "test" operation probe the password hash
+	delete(current, "password")
+	changes, err := parsePatch(contentType, body, current)
+	if err != nil {
+		return nil, err
+	}
+	update, err := validatePatch(changes, fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(update) == 0 {
+		return user, nil
+	}
+	if roleName != "" {
+		update["role_name"] = roleName
+	}
+
+	update["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var patched model.User
+	base := notDeleted(bson.M{"_id": user.UserID})
+	filter := versionFilter(base, &user.Version)
+	err = userCollection.FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": update, "$inc": bson.M{"version": 1}}, opts).Decode(&patched)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, versionMissError(userCollection, base, fmt.Errorf("user not found"))
+		}
+		return nil, fmt.Errorf("failed to update user: %v", err)
+	}
+
+	return &patched, nil
+}
+
+// DeleteUser soft deletes a user by ID, with an expected version only if it is still at that version.
+// PurgeDeleted removes the user for good after the retention period.
+func DeleteUser(id string, expectedVersion *int64) error {
 	// Convert string ID to primitive.ObjectID
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -200,15 +343,20 @@ func DeleteUser(id string) error {
 	// Get database connection
 	userCollection := config.MongoDB.Collection("users")
 
-	// Delete user by ID
-	result, err := userCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	// Mark the user as deleted
+	base := notDeleted(bson.M{"_id": objID})
+	now := primitive.NewDateTimeFromTime(time.Now())
+	result, err := userCollection.UpdateOne(context.TODO(), versionFilter(base, expectedVersion), bson.M{
+		"$set": bson.M{"deleted_at": now, "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %v", err)
 	}
 
 	// Check if any document was actually deleted
-	if result.DeletedCount == 0 {
-		return fmt.Errorf("user not found")
+	if result.MatchedCount == 0 {
+		return versionMissError(userCollection, base, fmt.Errorf("user not found"))
 	}
 
 	return nil
@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidBulk is returned when a bulk request has an invalid filter or operation
+var ErrInvalidBulk = errors.New("invalid bulk request")
+
+// ErrBulkConfirmationRequired is returned when a bulk update matches more perfumes than
+// BulkConfirmThreshold and the request did not confirm the matched count
+var ErrBulkConfirmationRequired = errors.New("bulk update needs confirmation")
+
+// bulkPreviewSize is how many changes a dry run lists
+const bulkPreviewSize = 20
+
+// bulkSetFields are the fields a bulk set may change. Names and SKUs identify a single perfume and are left out.
+var bulkSetFields = map[string]patchField{
+	"brand":       requiredString,
+	"types":       optionalString,
+	"categories":  optionalString,
+	"sizes":       optionalString,
+	"description": optionalString,
+	"price":       numericString(false),
+	"stock":       numericString(true),
+	"weight":      numericString(true),
+}
+
+// BulkConfirmThreshold is how many perfumes a bulk update may change without confirmation, BULK_CONFIRM_THRESHOLD (default 100)
+func BulkConfirmThreshold() int64 {
+	if threshold, err := strconv.ParseInt(os.Getenv("BULK_CONFIRM_THRESHOLD"), 10, 64); err == nil && threshold >= 0 {
+		return threshold
+	}
+	return 100
+}
+
+// normalizeTags trims tags and drops empty and repeated ones
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// bulkFilterQuery builds the MongoDB query of a bulk filter. Every perfume that is not deleted can be
+// selected, so at least one criterion is required to keep a request from touching the whole catalogue by accident.
+func bulkFilterQuery(filter model.BulkFilter) (bson.M, error) {
+	query := notDeleted(bson.M{})
+	criteria := 0
+
+	if len(filter.IDs) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(filter.IDs))
+		for _, id := range filter.IDs {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid perfume ID %q", ErrInvalidBulk, id)
+			}
+			ids = append(ids, objID)
+		}
+		query["_id"] = bson.M{"$in": ids}
+		criteria++
+	}
+
+	switch filter.Status {
+	case "":
+	case model.StatusPublished:
+		// Perfumes created before statuses existed are published
+		query["status"] = bson.M{"$in": bson.A{model.StatusPublished, nil}}
+		criteria++
+	case model.StatusDraft, model.StatusScheduled, model.StatusDiscontinued:
+		query["status"] = filter.Status
+		criteria++
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidBulk, filter.Status)
+	}
+
+	// The remaining criteria work like the /fume/search query parameters
+	filters := map[string]string{}
+	for key, value := range map[string]string{
+		"name":        filter.Name,
+		"brand":       filter.Brand,
+		"sizes":       filter.Size,
+		"categories":  filter.Categories,
+		"category_id": filter.CategoryID,
+		"types":       filter.Types,
+		"price":       filter.Price,
+		"tags":        filter.Tag,
+	} {
+		if value != "" {
+			filters[key] = value
+		}
+	}
+	criteria += len(filters)
+
+	if criteria == 0 {
+		return nil, fmt.Errorf("%w: filter needs at least one criterion", ErrInvalidBulk)
+	}
+
+	query, err := addPerfumeFilters(query, filters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBulk, err)
+	}
+	return query, nil
+}
+
+// bulkValues are the fields of a perfume a bulk update works on
+type bulkValues struct {
+	fields map[string]string
+	tags   []string
+}
+
+// bulkStep changes the values of one perfume
+type bulkStep func(values *bulkValues)
+
+// compileBulkOperations validates the operations once and turns them into steps applied to every perfume
+func compileBulkOperations(operations []model.BulkOperation) ([]bulkStep, error) {
+	if len(operations) == 0 {
+		return nil, fmt.Errorf("%w: at least one operation is required", ErrInvalidBulk)
+	}
+
+	steps := make([]bulkStep, 0, len(operations))
+	for i, operation := range operations {
+		step, err := compileBulkOperation(operation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidBulk, i+1, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func compileBulkOperation(operation model.BulkOperation) (bulkStep, error) {
+	switch operation.Op {
+	case model.BulkSet:
+		validate, ok := bulkSetFields[operation.Field]
+		if !ok {
+			return nil, fmt.Errorf("field %q cannot be set in bulk", operation.Field)
+		}
+		value, err := validate(operation.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", operation.Field, err)
+		}
+		return func(values *bulkValues) {
+			values.fields[operation.Field] = value.(string)
+		}, nil
+
+	case model.BulkPricePercent:
+		percent, ok := bulkNumber(operation.Value)
+		if !ok || percent <= -100 {
+			return nil, errors.New("price_percent needs a number greater than -100")
+		}
+		return func(values *bulkValues) {
+			// Prices that are not numbers are left alone rather than guessed
+			price, err := strconv.ParseFloat(strings.TrimSpace(values.fields["price"]), 64)
+			if err != nil {
+				return
+			}
+			values.fields["price"] = strconv.FormatFloat(math.Round(price*(100+percent)/100), 'f', -1, 64)
+		}, nil
+
+	case model.BulkStockAdjust:
+		delta, ok := bulkNumber(operation.Value)
+		if !ok || delta != math.Trunc(delta) {
+			return nil, errors.New("stock_adjust needs a whole number")
+		}
+		return func(values *bulkValues) {
+			stock, _ := strconv.Atoi(strings.TrimSpace(values.fields["stock"]))
+			// Stock never goes below zero
+			stock = max(stock+int(delta), 0)
+			values.fields["stock"] = strconv.Itoa(stock)
+		}, nil
+
+	case model.BulkAddTag, model.BulkRemoveTag:
+		tag, _ := operation.Value.(string)
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("%s needs a tag", operation.Op)
+		}
+		if operation.Op == model.BulkAddTag {
+			return func(values *bulkValues) {
+				values.tags = normalizeTags(append(values.tags, tag))
+			}, nil
+		}
+		return func(values *bulkValues) {
+			kept := []string{}
+			for _, existing := range values.tags {
+				if !strings.EqualFold(existing, tag) {
+					kept = append(kept, existing)
+				}
+			}
+			values.tags = kept
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", operation.Op)
+}
+
+// bulkNumber accepts a number or a numeric string
+func bulkNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// bulkChanges applies the steps to a perfume and returns the fields to set along with what changed
+func bulkChanges(perfume *model.Perfume, steps []bulkStep) (bson.M, []model.FieldChange) {
+	before := perfumeSnapshot(perfume)
+	values := &bulkValues{fields: perfumeSnapshot(perfume), tags: normalizeTags(perfume.Tags)}
+	for _, step := range steps {
+		step(values)
+	}
+
+	set := bson.M{}
+	changes := diffSnapshots(before, values.fields)
+	for _, change := range changes {
+		set[change.Field] = change.To
+	}
+
+	beforeTags, afterTags := strings.Join(normalizeTags(perfume.Tags), ","), strings.Join(values.tags, ",")
+	if beforeTags != afterTags {
+		set["tags"] = values.tags
+		changes = append(changes, model.FieldChange{Field: "tags", From: beforeTags, To: afterTags})
+	}
+
+	return set, changes
+}
+
+// CountBulkPerfumes returns how many perfumes a bulk filter selects
+func CountBulkPerfumes(filter model.BulkFilter) (int64, error) {
+	query, err := bulkFilterQuery(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	count, err := perfumeCollection.CountDocuments(context.TODO(), query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count perfumes: %v", err)
+	}
+	return count, nil
+}
+
+// RunBulkUpdate applies the operations to every perfume the filter selects. Each perfume is written with the
+// version it was read at and gets its own revision, a perfume changed meanwhile is reported as a failure.
+// A dry run only reports what would change. Applied updates are stored as an audit record.
+func RunBulkUpdate(request model.BulkRequest, author model.RevisionAuthor) (*model.BulkResult, error) {
+	query, err := bulkFilterQuery(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := compileBulkOperations(request.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	matched, err := perfumeCollection.CountDocuments(context.TODO(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count perfumes: %v", err)
+	}
+	if !request.DryRun && matched > BulkConfirmThreshold() && request.Confirm != matched {
+		return &model.BulkResult{Filter: request.Filter, Operations: request.Operations, Matched: matched},
+			fmt.Errorf("%w: %d perfumes match, send \"confirm\": %d to apply", ErrBulkConfirmationRequired, matched, matched)
+	}
+
+	result := &model.BulkResult{
+		BulkID:      primitive.NewObjectID(),
+		Filter:      request.Filter,
+		Operations:  request.Operations,
+		DryRun:      request.DryRun,
+		Matched:     matched,
+		AffectedIDs: []primitive.ObjectID{},
+		Failures:    []model.BulkFailure{},
+		Author:      author,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+
+	cursor, err := perfumeCollection.Find(context.TODO(), query, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.TODO()) {
+		var perfume model.Perfume
+		if err := cursor.Decode(&perfume); err != nil {
+			return nil, fmt.Errorf("failed to decode perfume: %v", err)
+		}
+
+		set, changes := bulkChanges(&perfume, steps)
+		if len(changes) == 0 {
+			result.Unchanged++
+			continue
+		}
+
+		if request.DryRun {
+			result.Changed++
+			result.AffectedIDs = append(result.AffectedIDs, perfume.PerfumeID)
+			if len(result.Preview) < bulkPreviewSize {
+				result.Preview = append(result.Preview, model.BulkChange{PerfumeID: perfume.PerfumeID, Name: perfume.Name, Changes: changes})
+			}
+			continue
+		}
+
+		if err := writePerfumeFields(perfume.PerfumeID, set, &perfume.Version, author, model.RevisionBulk, nil); err != nil {
+			result.Failures = append(result.Failures, model.BulkFailure{PerfumeID: perfume.PerfumeID, Error: err.Error()})
+			continue
+		}
+		result.Changed++
+		result.AffectedIDs = append(result.AffectedIDs, perfume.PerfumeID)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+
+	if request.DryRun {
+		return result, nil
+	}
+
+	bulkCollection := config.MongoDB.Collection("bulk_operations")
+	if _, err := bulkCollection.InsertOne(context.TODO(), result); err != nil {
+		return result, fmt.Errorf("failed to save bulk operation: %v", err)
+	}
+
+	return result, nil
+}
+
+// GetBulkOperations returns the latest applied bulk updates, newest first
+func GetBulkOperations() ([]model.BulkResult, error) {
+	bulkCollection := config.MongoDB.Collection("bulk_operations")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(50).
+		SetProjection(bson.M{"affected_ids": 0, "failures": 0})
+	cursor, err := bulkCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bulk operations: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	operations := []model.BulkResult{}
+	if err = cursor.All(context.Background(), &operations); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk operations: %v", err)
+	}
+
+	return operations, nil
+}
+
+// GetBulkOperation returns an applied bulk update with the IDs of every perfume it changed
+func GetBulkOperation(id string) (*model.BulkResult, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bulk operation ID format: %v", err)
+	}
+
+	bulkCollection := config.MongoDB.Collection("bulk_operations")
+
+	var operation model.BulkResult
+	err = bulkCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&operation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("bulk operation not found")
+		}
+		return nil, fmt.Errorf("failed to fetch bulk operation: %v", err)
+	}
+
+	return &operation, nil
+}
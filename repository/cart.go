@@ -0,0 +1,531 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidCart is returned when a cart change is not allowed, such as a quantity above the limit or the stock
+var ErrInvalidCart = errors.New("invalid cart request")
+
+// ErrCartItemNotFound is returned when a cart has no line with the given ID
+var ErrCartItemNotFound = errors.New("cart item not found")
+
+// cartMaxItems is how many different lines a cart may have
+const cartMaxItems = 50
+
+// cartWriteAttempts is how often a cart change is retried when another request changed the cart meanwhile
+const cartWriteAttempts = 3
+
+// CartOwner identifies a cart: the user ID of a logged in customer, or the token of a guest cart
+type CartOwner struct {
+	UserID     *primitive.ObjectID
+	GuestToken string
+}
+
+func (owner CartOwner) filter() bson.M {
+	if owner.UserID != nil {
+		return bson.M{"user_id": *owner.UserID}
+	}
+	return bson.M{"guest_token": owner.GuestToken}
+}
+
+// valid reports whether the owner can have a cart at all
+func (owner CartOwner) valid() bool {
+	return owner.UserID != nil || owner.GuestToken != ""
+}
+
+// CartMaxQuantity is how many of one perfume a cart may hold, CART_MAX_QUANTITY (default 10)
+func CartMaxQuantity() int {
+	return envInt("CART_MAX_QUANTITY", 10)
+}
+
+// GuestCartTTL is how long an untouched guest cart is kept, CART_GUEST_TTL (default 30 days)
+func GuestCartTTL() time.Duration {
+	return envDuration("CART_GUEST_TTL", 30*24*time.Hour)
+}
+
+// UserCartTTL is how long an untouched customer cart is kept, CART_USER_TTL (default 90 days)
+func UserCartTTL() time.Duration {
+	return envDuration("CART_USER_TTL", 90*24*time.Hour)
+}
+
+func (owner CartOwner) ttl() time.Duration {
+	if owner.UserID != nil {
+		return UserCartTTL()
+	}
+	return GuestCartTTL()
+}
+
+// NewGuestCartToken returns a random token for the cookie of a guest cart, it cannot be guessed from other carts
+func NewGuestCartToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		// crypto/rand does not fail on supported platforms, fall back to an ID rather than no cart
+		return primitive.NewObjectID().Hex() + primitive.NewObjectID().Hex()
+	}
+	return hex.EncodeToString(token)
+}
+
+// IsGuestCartToken reports whether a cookie value looks like a token from NewGuestCartToken
+func IsGuestCartToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// findCart returns the owner's cart, or nil when there is none
+func findCart(ctx context.Context, owner CartOwner) (*model.Cart, error) {
+	if !owner.valid() {
+		return nil, nil
+	}
+
+	cartCollection := config.MongoDB.Collection("carts")
+
+	var cart model.Cart
+	err := cartCollection.FindOne(ctx, owner.filter()).Decode(&cart)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch cart: %v", err)
+	}
+	return &cart, nil
+}
+
+// saveCart inserts a new cart or replaces the stored one if it still has the version that was read.
+// It returns false when another request created or changed the cart meanwhile.
+func saveCart(ctx context.Context, owner CartOwner, cart *model.Cart) (bool, error) {
+	cartCollection := config.MongoDB.Collection("carts")
+
+	now := time.Now()
+	cart.UpdatedAt = primitive.NewDateTimeFromTime(now)
+	cart.ExpiresAt = primitive.NewDateTimeFromTime(now.Add(owner.ttl()))
+
+	if cart.CartID.IsZero() {
+		cart.CartID = primitive.NewObjectID()
+		cart.UserID = owner.UserID
+		if owner.UserID == nil {
+			cart.GuestToken = owner.GuestToken
+		}
+		cart.CreatedAt = cart.UpdatedAt
+		cart.Version = 1
+		if _, err := cartCollection.InsertOne(ctx, cart); err != nil {
+			cart.CartID = primitive.NilObjectID
+			if mongo.IsDuplicateKeyError(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to create cart: %v", err)
+		}
+		return true, nil
+	}
+
+	expectedVersion := cart.Version
+	cart.Version++
+	result, err := cartCollection.ReplaceOne(ctx, bson.M{"_id": cart.CartID, "version": expectedVersion}, cart)
+	if err != nil {
+		return false, fmt.Errorf("failed to save cart: %v", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// changeCart reads the owner's cart, lets change edit it and saves it. A cart changed by another request
+// meanwhile is read again and the change retried. A missing cart is created.
+func changeCart(owner CartOwner, change func(cart *model.Cart) error) (*model.Cart, error) {
+	if !owner.valid() {
+		return nil, fmt.Errorf("%w: no cart", ErrInvalidCart)
+	}
+
+	for attempt := 0; attempt < cartWriteAttempts; attempt++ {
+		cart, err := findCart(context.TODO(), owner)
+		if err != nil {
+			return nil, err
+		}
+		if cart == nil {
+			cart = &model.Cart{Items: []model.CartItem{}}
+		}
+
+		if err := change(cart); err != nil {
+			return nil, err
+		}
+
+		saved, err := saveCart(context.TODO(), owner, cart)
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			return cart, nil
+		}
+	}
+
+	return nil, ErrVersionConflict
+}
+
+// GetCart returns the owner's cart checked against the current perfumes. Owners without a cart get an empty one.
+func GetCart(owner CartOwner) (*model.Cart, error) {
+	cart, err := findCart(context.TODO(), owner)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		return &model.Cart{Items: []model.CartItem{}, Notices: []model.CartNotice{}}, nil
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// revalidateCart fills the lines with the current perfume details and works out the totals.
+// Quantities above the stock are lowered and new prices are taken over, both are reported as notices
+// and saved, so a notice about a change is only given once. Lines that cannot be bought stay in the
+// cart but are left out of the subtotal.
+func revalidateCart(ctx context.Context, owner CartOwner, cart *model.Cart) error {
+	perfumes, err := cartPerfumes(ctx, cart.Items)
+	if err != nil {
+		return err
+	}
+
+	cart.Subtotal, cart.ItemCount = 0, 0
+	cart.Notices = []model.CartNotice{}
+	changed := false
+
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		notice := func(code, message string) {
+			cart.Notices = append(cart.Notices, model.CartNotice{ItemID: item.ItemID, PerfumeID: item.PerfumeID, Code: code, Message: message})
+		}
+
+		perfume, ok := perfumes[item.PerfumeID]
+		if !ok || !isPerfumeBuyable(perfume) {
+			item.Available = false
+			notice(model.CartUnavailable, "This perfume is no longer available")
+			continue
+		}
+
+		item.Name, item.Brand, item.Slug, item.Image = perfume.Name, perfume.Brand, perfume.Slug, perfume.Image
+		item.Stock = perfumeStock(perfume)
+
+		if item.Stock <= 0 {
+			item.Available = false
+			notice(model.CartOutOfStock, "This perfume is out of stock")
+			continue
+		}
+		if item.Quantity > item.Stock {
+			notice(model.CartQuantityReduced, fmt.Sprintf("Only %d left in stock, the quantity was lowered from %d", item.Stock, item.Quantity))
+			item.Quantity = item.Stock
+			changed = true
+		}
+		if item.Price != perfume.Price {
+			notice(model.CartPriceChanged, fmt.Sprintf("The price changed from %s to %s", item.Price, perfume.Price))
+			item.Price = perfume.Price
+			changed = true
+		}
+
+		price, _ := strconv.ParseFloat(strings.TrimSpace(item.Price), 64)
+		item.Available = true
+		item.LineTotal = price * float64(item.Quantity)
+		cart.Subtotal += item.LineTotal
+		cart.ItemCount += item.Quantity
+	}
+
+	if changed {
+		// A cart changed meanwhile is revalidated again on its next read
+		if _, err := saveCart(ctx, owner, cart); err != nil {
+			log.Printf("Failed to save revalidated cart %s: %v", cart.CartID.Hex(), err)
+		}
+	}
+
+	return priceCart(ctx, owner, cart, perfumes)
+}
+
+// cartPerfumes loads the perfumes of the cart lines, including deleted ones so they can be reported
+func cartPerfumes(ctx context.Context, items []model.CartItem) (map[primitive.ObjectID]*model.Perfume, error) {
+	perfumes := map[primitive.ObjectID]*model.Perfume{}
+	if len(items) == 0 {
+		return perfumes, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.PerfumeID)
+	}
+
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	cursor, err := perfumeCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var perfume model.Perfume
+		if err := cursor.Decode(&perfume); err != nil {
+			return nil, fmt.Errorf("failed to decode perfume: %v", err)
+		}
+		perfumes[perfume.PerfumeID] = &perfume
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+
+	return perfumes, nil
+}
+
+// isPerfumeBuyable reports whether a perfume may be put in a cart: public and not archived
+func isPerfumeBuyable(perfume *model.Perfume) bool {
+	return !perfume.Archived && IsPerfumePublic(perfume)
+}
+
+// perfumeStock reads the stock of a perfume, a stock that is not a number counts as none
+func perfumeStock(perfume *model.Perfume) int {
+	stock, err := strconv.Atoi(strings.TrimSpace(perfume.Stock))
+	if err != nil || stock < 0 {
+		return 0
+	}
+	return stock
+}
+
+// perfumeSizes lists the sizes a perfume is sold in, e.g. "50ml, 100ml"
+func perfumeSizes(perfume *model.Perfume) []string {
+	sizes := []string{}
+	for _, size := range strings.Split(perfume.Sizes, ",") {
+		if size = strings.TrimSpace(size); size != "" {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// cartItemSize checks the requested size against the sizes of the perfume. Perfumes with a single
+// size need none, perfumes with several need one of them.
+func cartItemSize(perfume *model.Perfume, size string) (string, error) {
+	size = strings.TrimSpace(size)
+	sizes := perfumeSizes(perfume)
+
+	if size == "" {
+		switch len(sizes) {
+		case 0:
+			return "", nil
+		case 1:
+			return sizes[0], nil
+		}
+		return "", fmt.Errorf("%w: size is required, choose one of %s", ErrInvalidCart, strings.Join(sizes, ", "))
+	}
+	for _, available := range sizes {
+		if strings.EqualFold(available, size) {
+			return available, nil
+		}
+	}
+	return "", fmt.Errorf("%w: size %q is not available", ErrInvalidCart, size)
+}
+
+// checkCartQuantity checks a line quantity against the per perfume limit and the stock
+func checkCartQuantity(perfume *model.Perfume, quantity int) error {
+	if quantity < 1 {
+		return fmt.Errorf("%w: quantity must be at least 1", ErrInvalidCart)
+	}
+	if limit := CartMaxQuantity(); quantity > limit {
+		return fmt.Errorf("%w: at most %d of a perfume can be bought at once", ErrInvalidCart, limit)
+	}
+	if stock := perfumeStock(perfume); quantity > stock {
+		return fmt.Errorf("%w: only %d left in stock", ErrInvalidCart, stock)
+	}
+	return nil
+}
+
+// buyablePerfume returns a perfume that may be put in a cart
+func buyablePerfume(id string) (*model.Perfume, error) {
+	perfume, err := GetPerfumeByID(id)
+	if err != nil || !isPerfumeBuyable(perfume) {
+		return nil, fmt.Errorf("%w: perfume is not available", ErrInvalidCart)
+	}
+	return perfume, nil
+}
+
+// AddCartItem puts a perfume in the owner's cart, adding to the quantity of a line with the same perfume and size
+func AddCartItem(owner CartOwner, request model.CartItemRequest) (*model.Cart, error) {
+	if request.Quantity == 0 {
+		request.Quantity = 1
+	}
+	perfume, err := buyablePerfume(request.PerfumeID)
+	if err != nil {
+		return nil, err
+	}
+	size, err := cartItemSize(perfume, request.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := changeCart(owner, func(cart *model.Cart) error {
+		for i := range cart.Items {
+			item := &cart.Items[i]
+			if item.PerfumeID == perfume.PerfumeID && strings.EqualFold(item.Size, size) {
+				if err := checkCartQuantity(perfume, item.Quantity+request.Quantity); err != nil {
+					return err
+				}
+				item.Quantity += request.Quantity
+				item.Price = perfume.Price
+				return nil
+			}
+		}
+
+		if len(cart.Items) >= cartMaxItems {
+			return fmt.Errorf("%w: a cart holds at most %d different perfumes", ErrInvalidCart, cartMaxItems)
+		}
+		if err := checkCartQuantity(perfume, request.Quantity); err != nil {
+			return err
+		}
+		cart.Items = append(cart.Items, model.CartItem{
+			ItemID:    primitive.NewObjectID(),
+			PerfumeID: perfume.PerfumeID,
+			Size:      size,
+			Quantity:  request.Quantity,
+			Price:     perfume.Price,
+			AddedAt:   primitive.NewDateTimeFromTime(time.Now()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// UpdateCartItem changes the quantity of a cart line, a quantity of 0 removes it
+func UpdateCartItem(owner CartOwner, itemID string, quantity int) (*model.Cart, error) {
+	if quantity == 0 {
+		return RemoveCartItem(owner, itemID)
+	}
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return nil, ErrCartItemNotFound
+	}
+
+	cart, err := changeCart(owner, func(cart *model.Cart) error {
+		for i := range cart.Items {
+			item := &cart.Items[i]
+			if item.ItemID != objID {
+				continue
+			}
+			perfume, err := buyablePerfume(item.PerfumeID.Hex())
+			if err != nil {
+				return err
+			}
+			if err := checkCartQuantity(perfume, quantity); err != nil {
+				return err
+			}
+			item.Quantity = quantity
+			item.Price = perfume.Price
+			return nil
+		}
+		return ErrCartItemNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// RemoveCartItem removes a line from the owner's cart
+func RemoveCartItem(owner CartOwner, itemID string) (*model.Cart, error) {
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return nil, ErrCartItemNotFound
+	}
+
+	cart, err := changeCart(owner, func(cart *model.Cart) error {
+		for i, item := range cart.Items {
+			if item.ItemID == objID {
+				cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+				return nil
+			}
+		}
+		return ErrCartItemNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// ClearCart removes the owner's cart
+func ClearCart(owner CartOwner) error {
+	if !owner.valid() {
+		return nil
+	}
+
+	cartCollection := config.MongoDB.Collection("carts")
+	if _, err := cartCollection.DeleteOne(context.TODO(), owner.filter()); err != nil {
+		return fmt.Errorf("failed to clear cart: %v", err)
+	}
+	return nil
+}
+
+// MergeGuestCart moves the lines of a guest cart into the cart of a user who logged in and deletes the guest cart.
+// Lines for the same perfume and size are added up to the quantity limit, stock is checked on the next read.
+func MergeGuestCart(guestToken string, userID primitive.ObjectID) error {
+	guestOwner := CartOwner{GuestToken: guestToken}
+	guestCart, err := findCart(context.TODO(), guestOwner)
+	if err != nil || guestCart == nil {
+		return err
+	}
+
+	_, err = changeCart(CartOwner{UserID: &userID}, func(cart *model.Cart) error {
+		limit := CartMaxQuantity()
+	guestItems:
+		for _, guestItem := range guestCart.Items {
+			for i := range cart.Items {
+				item := &cart.Items[i]
+				if item.PerfumeID == guestItem.PerfumeID && strings.EqualFold(item.Size, guestItem.Size) {
+					item.Quantity = min(item.Quantity+guestItem.Quantity, limit)
+					continue guestItems
+				}
+			}
+			if len(cart.Items) < cartMaxItems {
+				cart.Items = append(cart.Items, guestItem)
+			}
+		}
+		for _, code := range guestCart.PromoCodes {
+			if !slices.Contains(cart.PromoCodes, code) && len(cart.PromoCodes) < cartMaxPromoCodes {
+				cart.PromoCodes = append(cart.PromoCodes, code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge guest cart: %v", err)
+	}
+
+	return ClearCart(guestOwner)
+}
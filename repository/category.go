@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateCategory inserts a new category, placing it under its parent when one is given
+func CreateCategory(category *model.Category) error {
+	// Get database connection
+	categoryCollection := config.MongoDB.Collection("categories")
+
+	// Build the ancestor path from the parent
+	category.Ancestors = []primitive.ObjectID{}
+	if category.ParentID != nil {
+		parent, err := getCategory(*category.ParentID)
+		if err != nil {
+			return err
+		}
+		category.Ancestors = append(append(category.Ancestors, parent.Ancestors...), parent.CategoryID)
+	}
+
+	// Assign ID, slug and timestamps
+	category.CategoryID = primitive.NewObjectID()
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	category.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	category.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	_, err := categoryCollection.InsertOne(context.TODO(), category)
+	if err != nil {
+		return fmt.Errorf("failed to insert category into database: %v", err)
+	}
+
+	return nil
+}
+
+// getCategory finds a category by its ObjectID
+func getCategory(id primitive.ObjectID) (*model.Category, error) {
+	categoryCollection := config.MongoDB.Collection("categories")
+
+	var category model.Category
+	err := categoryCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&category)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("category not found")
+		}
+		return nil, fmt.Errorf("failed to find category: %v", err)
+	}
+
+	return &category, nil
+}
+
+// GetCategoryByID retrieves a category by ID
+func GetCategoryByID(id string) (*model.Category, error) {
+	// Convert string ID to primitive.ObjectID
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid category ID format: %v", err)
+	}
+
+	return getCategory(objID)
+}
+
+// GetAllCategories returns every category as a flat list
+func GetAllCategories() ([]model.Category, error) {
+	// Get database connection
+	categoryCollection := config.MongoDB.Collection("categories")
+
+	// Find all categories sorted by name
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := categoryCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch categories: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	// Decode all categories
+	categories := []model.Category{}
+	if err = cursor.All(context.Background(), &categories); err != nil {
+		return nil, fmt.Errorf("failed to decode categories: %v", err)
+	}
+
+	return categories, nil
+}
+
+// GetCategoryTree returns the categories nested under their parents
+func GetCategoryTree() ([]*model.CategoryNode, error) {
+	categories, err := GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	// Index every category by ID first so children can find their parent in one pass
+	nodes := make(map[primitive.ObjectID]*model.CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.CategoryID] = &model.CategoryNode{Category: category, Children: []*model.CategoryNode{}}
+	}
+
+	roots := []*model.CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.CategoryID]
+		if category.ParentID == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent, ok := nodes[*category.ParentID]
+		if !ok {
+			// Parent is gone, show the orphan at the top level instead of hiding it
+			roots = append(roots, node)
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+	}
+
+	return roots, nil
+}
+
+// GetCategoryBreadcrumb returns the path from the root family down to the category itself
+func GetCategoryBreadcrumb(id string) ([]model.Breadcrumb, error) {
+	category, err := GetCategoryByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch all ancestors in a single query
+	categoryCollection := config.MongoDB.Collection("categories")
+	cursor, err := categoryCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": category.Ancestors}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ancestors: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var ancestors []model.Category
+	if err = cursor.All(context.Background(), &ancestors); err != nil {
+		return nil, fmt.Errorf("failed to decode ancestors: %v", err)
+	}
+
+	// Order the ancestors the same way as the stored path
+	byID := make(map[primitive.ObjectID]model.Category, len(ancestors))
+	for _, ancestor := range ancestors {
+		byID[ancestor.CategoryID] = ancestor
+	}
+
+	breadcrumb := []model.Breadcrumb{}
+	for _, ancestorID := range category.Ancestors {
+		if ancestor, ok := byID[ancestorID]; ok {
+			breadcrumb = append(breadcrumb, model.Breadcrumb{CategoryID: ancestor.CategoryID, Name: ancestor.Name, Slug: ancestor.Slug})
+		}
+	}
+	breadcrumb = append(breadcrumb, model.Breadcrumb{CategoryID: category.CategoryID, Name: category.Name, Slug: category.Slug})
+
+	return breadcrumb, nil
+}
+
+// GetDescendantCategoryIDs returns the category ID together with the IDs of every category below it
+func GetDescendantCategoryIDs(id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	categoryCollection := config.MongoDB.Collection("categories")
+
+	// Descendants carry the category somewhere in their ancestor path
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := categoryCollection.Find(context.TODO(), bson.M{"ancestors": id}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch descendant categories: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	ids := []primitive.ObjectID{id}
+	for cursor.Next(context.Background()) {
+		var descendant struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&descendant); err != nil {
+			return nil, fmt.Errorf("failed to decode descendant category: %v", err)
+		}
+		ids = append(ids, descendant.ID)
+	}
+
+	return ids, cursor.Err()
+}
+
+// UpdateCategory renames a category and/or moves it under another parent
+func UpdateCategory(id string, updatedCategory model.Category) error {
+	category, err := GetCategoryByID(id)
+	if err != nil {
+		return err
+	}
+
+	// Get database connection
+	categoryCollection := config.MongoDB.Collection("categories")
+
+	// Work out the new ancestor path, refusing to move a category below itself
+	ancestors := []primitive.ObjectID{}
+	if updatedCategory.ParentID != nil {
+		parent, err := getCategory(*updatedCategory.ParentID)
+		if err != nil {
+			return err
+		}
+		if parent.CategoryID == category.CategoryID {
+			return fmt.Errorf("category cannot be its own parent")
+		}
+		for _, ancestorID := range parent.Ancestors {
+			if ancestorID == category.CategoryID {
+				return fmt.Errorf("category cannot be moved under its own descendant")
+			}
+		}
+		ancestors = append(append(ancestors, parent.Ancestors...), parent.CategoryID)
+	}
+
+	slug := updatedCategory.Slug
+	if slug == "" {
+		slug = slugify(updatedCategory.Name)
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":       updatedCategory.Name,
+			"slug":       slug,
+			"parent_id":  updatedCategory.ParentID,
+			"ancestors":  ancestors,
+			"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}
+	if _, err := categoryCollection.UpdateOne(context.TODO(), bson.M{"_id": category.CategoryID}, update); err != nil {
+		return fmt.Errorf("failed to update category: %v", err)
+	}
+
+	// Rewrite the ancestor path of every descendant, keeping the part below this category
+	cursor, err := categoryCollection.Find(context.TODO(), bson.M{"ancestors": category.CategoryID})
+	if err != nil {
+		return fmt.Errorf("failed to fetch descendant categories: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	var descendants []model.Category
+	if err = cursor.All(context.Background(), &descendants); err != nil {
+		return fmt.Errorf("failed to decode descendant categories: %v", err)
+	}
+
+	for _, descendant := range descendants {
+		newAncestors := append([]primitive.ObjectID{}, ancestors...)
+		for i, ancestorID := range descendant.Ancestors {
+			if ancestorID == category.CategoryID {
+				newAncestors = append(newAncestors, descendant.Ancestors[i:]...)
+				break
+			}
+		}
+		_, err := categoryCollection.UpdateOne(context.TODO(), bson.M{"_id": descendant.CategoryID}, bson.M{
+			"$set": bson.M{"ancestors": newAncestors},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update descendant category: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteCategory deletes a leaf category and removes it from every perfume
+func DeleteCategory(id string) error {
+	// Convert string ID to primitive.ObjectID
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid category ID format: %v", err)
+	}
+
+	// Get database connection
+	categoryCollection := config.MongoDB.Collection("categories")
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	// Refuse to delete a category that still has children
+	children, err := categoryCollection.CountDocuments(context.TODO(), bson.M{"parent_id": objID})
+	if err != nil {
+		return fmt.Errorf("failed to count child categories: %v", err)
+	}
+	if children > 0 {
+		return fmt.Errorf("category still has child categories")
+	}
+
+	result, err := categoryCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	if err != nil {
+		return fmt.Errorf("failed to delete category: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("category not found")
+	}
+
+	// Unassign the category from perfumes
+	_, err = perfumeCollection.UpdateMany(context.TODO(), bson.M{"category_ids": objID}, bson.M{
+		"$pull": bson.M{"category_ids": objID},
+		"$inc":  bson.M{"version": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unassign category from perfumes: %v", err)
+	}
+
+	return nil
+}
+
+// ParseCategoryIDs converts hex IDs to ObjectIDs and checks that every category exists
+func ParseCategoryIDs(ids []string) ([]primitive.ObjectID, error) {
+	objIDs := []primitive.ObjectID{}
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid category ID format: %v", err)
+		}
+		if !seen[objID] {
+			seen[objID] = true
+			objIDs = append(objIDs, objID)
+		}
+	}
+
+	if len(objIDs) == 0 {
+		return objIDs, nil
+	}
+
+	categoryCollection := config.MongoDB.Collection("categories")
+	count, err := categoryCollection.CountDocuments(context.TODO(), bson.M{"_id": bson.M{"$in": objIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check categories: %v", err)
+	}
+	if int(count) != len(objIDs) {
+		return nil, fmt.Errorf("one or more categories not found")
+	}
+
+	return objIDs, nil
+}
+
+// SetPerfumeCategories replaces the categories a perfume is assigned to
+func SetPerfumeCategories(id string, categoryIDs []primitive.ObjectID) error {
+	// Convert string ID to primitive.ObjectID
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	result, err := perfumeCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, bson.M{
+		"$set": bson.M{
+			"category_ids": categoryIDs,
+			"updated_at":   primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update perfume categories: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("perfume not found")
+	}
+
+	return nil
+}
+
+// GetPerfumesByCategory returns perfumes assigned to the category or any of its descendants
+func GetPerfumesByCategory(id string) ([]model.Perfume, error) {
+	return GetFilteredPerfumes(map[string]string{"category_id": id})
+}
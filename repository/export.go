@@ -0,0 +1,303 @@
+package repository
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportContentTypes are the export formats and the content type each is served with
+var ExportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"feed":   "application/rss+xml; charset=utf-8",
+}
+
+// feedProductCategory is the Google product taxonomy category every perfume belongs to
+const feedProductCategory = "Health & Beauty > Personal Care > Cosmetics > Perfume & Cologne"
+
+// PerfumeStream reads perfumes one at a time from a MongoDB cursor, so an export never holds the catalogue in memory
+type PerfumeStream struct {
+	cursor *mongo.Cursor
+}
+
+// OpenPerfumeStream starts reading the published perfumes matching the same filters as GetFilteredPerfumes
+func OpenPerfumeStream(ctx context.Context, filters map[string]string) (*PerfumeStream, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	query, err := perfumeSearchFilter(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(500)
+	cursor, err := perfumeCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+
+	return &PerfumeStream{cursor: cursor}, nil
+}
+
+// Next returns the next perfume, or nil once every perfume was read
+func (s *PerfumeStream) Next(ctx context.Context) (*model.Perfume, error) {
+	if !s.cursor.Next(ctx) {
+		return nil, s.cursor.Err()
+	}
+
+	var perfume model.Perfume
+	if err := s.cursor.Decode(&perfume); err != nil {
+		return nil, fmt.Errorf("failed to decode perfume: %v", err)
+	}
+	return &perfume, nil
+}
+
+// Close releases the cursor
+func (s *PerfumeStream) Close(ctx context.Context) {
+	s.cursor.Close(ctx)
+}
+
+// ExportOptions are the links written into an export
+type ExportOptions struct {
+	SiteURL    string                   // Home page of the shop, the link of the feed channel
+	ProductURL func(slug string) string // Canonical URL of a perfume page
+}
+
+// WritePerfumeExport writes every perfume of the stream to w in the given format, returning how many were read.
+// Output is flushed regularly when w supports it, so clients receive a large export while it is produced.
+func WritePerfumeExport(ctx context.Context, w io.Writer, format string, stream *PerfumeStream, exportOptions ExportOptions) (int, error) {
+	var writeHeader, writeFooter func() error
+	var writePerfume func(perfume *model.Perfume) error
+
+	switch format {
+	case "csv":
+		writeHeader, writePerfume, writeFooter = csvExport(w, exportOptions)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		writePerfume = func(perfume *model.Perfume) error { return encoder.Encode(perfume) }
+	case "feed":
+		writeHeader, writePerfume, writeFooter = feedExport(w, exportOptions)
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	flusher, _ := w.(interface{ Flush() error })
+
+	if writeHeader != nil {
+		if err := writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	read := 0
+	for {
+		perfume, err := stream.Next(ctx)
+		if err != nil {
+			return read, err
+		}
+		if perfume == nil {
+			break
+		}
+		read++
+		if err := writePerfume(perfume); err != nil {
+			return read, err
+		}
+
+		if flusher != nil && read%100 == 0 {
+			if err := flusher.Flush(); err != nil {
+				return read, err
+			}
+		}
+	}
+
+	if writeFooter != nil {
+		if err := writeFooter(); err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// csvColumns are the columns of the CSV export
+var csvColumns = []string{
+	"perfume_id", "sku", "name", "brand", "slug", "url", "types", "categories", "category_ids",
+	"sizes", "price", "stock", "weight", "status", "image", "created_at", "updated_at",
+}
+
+// csvExport writes a spreadsheet friendly CSV, starting with a byte order mark so Excel reads it as UTF-8
+func csvExport(w io.Writer, exportOptions ExportOptions) (func() error, func(*model.Perfume) error, func() error) {
+	writer := csv.NewWriter(w)
+
+	writeHeader := func() error {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		writer.Write(csvColumns)
+		writer.Flush()
+		return writer.Error()
+	}
+
+	writePerfume := func(perfume *model.Perfume) error {
+		categoryIDs := make([]string, len(perfume.CategoryIDs))
+		for i, categoryID := range perfume.CategoryIDs {
+			categoryIDs[i] = categoryID.Hex()
+		}
+		status := perfume.Status
+		if status == "" {
+			status = model.StatusPublished
+		}
+		link := ""
+		if perfume.Slug != "" {
+			link = exportOptions.ProductURL(perfume.Slug)
+		}
+
+		row := []string{
+			perfume.PerfumeID.Hex(), perfume.SKU, perfume.Name, perfume.Brand, perfume.Slug, link,
+			perfume.Types, perfume.Categories, strings.Join(categoryIDs, ","), perfume.Sizes,
+			perfume.Price, perfume.Stock, perfume.Weight, status, perfume.Image,
+			perfume.CreatedAt.Time().UTC().Format(time.RFC3339), perfume.UpdatedAt.Time().UTC().Format(time.RFC3339),
+		}
+		for i := range row {
+			row[i] = csvSafe(row[i])
+		}
+
+		// The csv writer buffers rows itself, hand each one on so the output can be flushed
+		writer.Write(row)
+		writer.Flush()
+		return writer.Error()
+	}
+
+	return writeHeader, writePerfume, nil
+}
+
+// csvSafe keeps spreadsheet programs from running cell values as formulas
+func csvSafe(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// feedItem is an item of a Google Merchant Center style RSS 2.0 product feed
+type feedItem struct {
+	XMLName               xml.Name `xml:"item"`
+	ID                    string   `xml:"g:id"`
+	Title                 string   `xml:"title"`
+	Description           string   `xml:"description"`
+	Link                  string   `xml:"link"`
+	ImageLink             string   `xml:"g:image_link,omitempty"`
+	AdditionalImageLinks  []string `xml:"g:additional_image_link"`
+	Availability          string   `xml:"g:availability"`
+	Price                 string   `xml:"g:price"`
+	Brand                 string   `xml:"g:brand"`
+	MPN                   string   `xml:"g:mpn,omitempty"`
+	IdentifierExists      string   `xml:"g:identifier_exists,omitempty"`
+	Condition             string   `xml:"g:condition"`
+	ProductType           string   `xml:"g:product_type,omitempty"`
+	GoogleProductCategory string   `xml:"g:google_product_category"`
+	Size                  string   `xml:"g:size,omitempty"`
+}
+
+// FeedCurrency is the ISO 4217 currency of the prices in the product feed, FEED_CURRENCY (default IDR)
+func FeedCurrency() string {
+	if currency := os.Getenv("FEED_CURRENCY"); currency != "" {
+		return currency
+	}
+	return "IDR"
+}
+
+// feedExport writes an RSS 2.0 feed with the Google Merchant Center namespace.
+// Perfumes without a positive price or a slug to link to cannot be listed and are left out.
+func feedExport(w io.Writer, exportOptions ExportOptions) (func() error, func(*model.Perfume) error, func() error) {
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("    ", "  ")
+	currency := FeedCurrency()
+
+	title := os.Getenv("FEED_TITLE")
+	if title == "" {
+		title = "Elfume"
+	}
+
+	writeHeader := func() error {
+		var header strings.Builder
+		header.WriteString(xml.Header)
+		header.WriteString(`<rss version="2.0" xmlns:g="http://base.google.com/ns/1.0">` + "\n  <channel>\n    <title>")
+		xml.EscapeText(&header, []byte(title))
+		header.WriteString("</title>\n    <link>")
+		xml.EscapeText(&header, []byte(exportOptions.SiteURL))
+		header.WriteString("</link>\n    <description>")
+		xml.EscapeText(&header, []byte(title+" perfume catalogue"))
+		header.WriteString("</description>\n")
+		_, err := io.WriteString(w, header.String())
+		return err
+	}
+
+	writePerfume := func(perfume *model.Perfume) error {
+		price, err := strconv.ParseFloat(strings.TrimSpace(perfume.Price), 64)
+		if err != nil || price <= 0 || perfume.Slug == "" {
+			return nil
+		}
+
+		item := feedItem{
+			ID:                    perfume.PerfumeID.Hex(),
+			Title:                 strings.TrimSpace(perfume.Brand + " " + perfume.Name),
+			Description:           perfume.Description,
+			Link:                  exportOptions.ProductURL(perfume.Slug),
+			Availability:          "out_of_stock",
+			Price:                 strconv.FormatFloat(price, 'f', 2, 64) + " " + currency,
+			Brand:                 perfume.Brand,
+			MPN:                   perfume.SKU,
+			Condition:             "new",
+			ProductType:           perfume.Categories,
+			GoogleProductCategory: feedProductCategory,
+			Size:                  perfume.Sizes,
+		}
+		if perfume.SKU != "" {
+			item.ID = perfume.SKU
+		} else {
+			item.IdentifierExists = "no"
+		}
+		if item.Description == "" {
+			item.Description = item.Title
+		}
+		if stock, err := strconv.Atoi(strings.TrimSpace(perfume.Stock)); err == nil && stock > 0 {
+			item.Availability = "in_stock"
+		}
+		for _, image := range perfume.Images {
+			if image.IsPrimary {
+				item.ImageLink = image.URL
+			} else if len(item.AdditionalImageLinks) < 10 {
+				item.AdditionalImageLinks = append(item.AdditionalImageLinks, image.URL)
+			}
+		}
+		if item.ImageLink == "" {
+			item.ImageLink = perfume.Image
+		}
+
+		return encoder.Encode(item)
+	}
+
+	writeFooter := func() error {
+		_, err := io.WriteString(w, "\n  </channel>\n</rss>\n")
+		return err
+	}
+
+	return writeHeader, writePerfume, writeFooter
+}
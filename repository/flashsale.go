@@ -0,0 +1,630 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidFlashSale is returned for flash sales with missing or invalid fields, and purchases that are not allowed
+var ErrInvalidFlashSale = errors.New("invalid flash sale")
+
+// ErrFlashSaleNotFound is returned when a flash sale or one of its perfumes does not exist
+var ErrFlashSaleNotFound = errors.New("flash sale not found")
+
+// ErrFlashSaleSoldOut is returned when the quota of a perfume has fewer bottles left than requested
+var ErrFlashSaleSoldOut = errors.New("flash sale sold out")
+
+// ErrFlashSaleLimit is returned when a customer would buy more bottles than the per-user limit allows
+var ErrFlashSaleLimit = errors.New("flash sale purchase limit reached")
+
+// flashSaleMaxItems is how many perfumes a flash sale can have
+const flashSaleMaxItems = 50
+
+// flashSaleStockRetries is how often returning bottles to a perfume's stock is retried when the perfume keeps changing
+const flashSaleStockRetries = 5
+
+// FlashSaleConcurrency is how many flash sale purchases one instance processes at a time, FLASH_SALE_CONCURRENCY (default 64)
+func FlashSaleConcurrency() int {
+	return envInt("FLASH_SALE_CONCURRENCY", 64)
+}
+
+// FlashSaleQueueSize is how many purchases wait in one instance's waiting room, FLASH_SALE_QUEUE (default 1000)
+func FlashSaleQueueSize() int {
+	return envInt("FLASH_SALE_QUEUE", 1000)
+}
+
+// FlashSaleQueueWait is how long a purchase waits in the waiting room before it is turned away, FLASH_SALE_QUEUE_WAIT (default 10s)
+func FlashSaleQueueWait() time.Duration {
+	return envDuration("FLASH_SALE_QUEUE_WAIT", 10*time.Second)
+}
+
+// setFlashSaleState fills the computed state of a flash sale
+func setFlashSaleState(sale *model.FlashSale, now time.Time) {
+	switch {
+	case sale.Closed || !now.Before(sale.EndsAt.Time()):
+		sale.State = model.FlashSaleEnded
+	case now.Before(sale.StartsAt.Time()):
+		sale.State = model.FlashSaleUpcoming
+	default:
+		sale.State = model.FlashSaleLive
+	}
+}
+
+// CreateFlashSale saves a flash sale and reserves the quota of each perfume from its stock, in one transaction
+func CreateFlashSale(request model.FlashSaleRequest, author model.RevisionAuthor) (*model.FlashSale, error) {
+	now := time.Now()
+	sale := &model.FlashSale{
+		FlashSaleID: primitive.NewObjectID(),
+		Name:        strings.TrimSpace(request.Name),
+		Items:       []model.FlashSaleItem{},
+		Version:     1,
+		CreatedBy:   author,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		UpdatedAt:   primitive.NewDateTimeFromTime(now),
+	}
+
+	if sale.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidFlashSale)
+	}
+	if request.StartsAt == nil || request.EndsAt == nil {
+		return nil, fmt.Errorf("%w: starts_at and ends_at are required", ErrInvalidFlashSale)
+	}
+	if *request.EndsAt <= *request.StartsAt || !request.EndsAt.Time().After(now) {
+		return nil, fmt.Errorf("%w: ends_at must be after starts_at and in the future", ErrInvalidFlashSale)
+	}
+	sale.StartsAt, sale.EndsAt = *request.StartsAt, *request.EndsAt
+	if len(request.Items) == 0 || len(request.Items) > flashSaleMaxItems {
+		return nil, fmt.Errorf("%w: a flash sale has 1 to %d perfumes", ErrInvalidFlashSale, flashSaleMaxItems)
+	}
+
+	perfumeIDs := []primitive.ObjectID{}
+	for _, item := range request.Items {
+		perfumeID, err := primitive.ObjectIDFromHex(strings.TrimSpace(item.PerfumeID))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid perfume ID %q", ErrInvalidFlashSale, item.PerfumeID)
+		}
+		for _, seen := range perfumeIDs {
+			if seen == perfumeID {
+				return nil, fmt.Errorf("%w: perfume %s is listed twice", ErrInvalidFlashSale, item.PerfumeID)
+			}
+		}
+		if item.Quota < 1 || item.PerUserLimit < 0 || item.Price <= 0 {
+			return nil, fmt.Errorf("%w: every perfume needs a price above 0, a quota of at least 1 and a per_user_limit that is not negative", ErrInvalidFlashSale)
+		}
+		perfumeIDs = append(perfumeIDs, perfumeID)
+		sale.Items = append(sale.Items, model.FlashSaleItem{
+			PerfumeID:    perfumeID,
+			Price:        item.Price,
+			Quota:        item.Quota,
+			Remaining:    item.Quota,
+			PerUserLimit: item.PerUserLimit,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+
+	session, err := config.MongoClient.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		perfumeCollection := config.MongoDB.Collection("perfumes")
+		for i := range sale.Items {
+			item := &sale.Items[i]
+
+			var perfume model.Perfume
+			err := perfumeCollection.FindOne(sc, notDeleted(bson.M{"_id": item.PerfumeID})).Decode(&perfume)
+			if err != nil {
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return nil, fmt.Errorf("%w: perfume %s not found", ErrInvalidFlashSale, item.PerfumeID.Hex())
+				}
+				return nil, fmt.Errorf("failed to fetch perfume: %v", err)
+			}
+			if !isPerfumeBuyable(&perfume) {
+				return nil, fmt.Errorf("%w: %s is not for sale", ErrInvalidFlashSale, perfume.Name)
+			}
+			stock := perfumeStock(&perfume)
+			if item.Quota > stock {
+				return nil, fmt.Errorf("%w: only %d bottles of %s are in stock", ErrInvalidFlashSale, stock, perfume.Name)
+			}
+			item.Name, item.Brand, item.Slug, item.Image = perfume.Name, perfume.Brand, perfume.Slug, perfume.Image
+			item.RegularPrice, _ = strconv.ParseFloat(strings.TrimSpace(perfume.Price), 64)
+			if item.Price >= item.RegularPrice {
+				return nil, fmt.Errorf("%w: the flash price of %s must be below its price of %s", ErrInvalidFlashSale, perfume.Name, perfume.Price)
+			}
+
+			// The quota leaves the stock now, so the shop cannot sell the same bottles during the sale
+			result, err := perfumeCollection.UpdateOne(sc,
+				bson.M{"_id": perfume.PerfumeID, "version": versionMatch(perfume.Version)},
+				bson.M{
+					"$set": bson.M{"stock": strconv.Itoa(stock - item.Quota), "updated_at": primitive.NewDateTimeFromTime(now)},
+					"$inc": bson.M{"version": 1},
+				})
+			if err != nil {
+				return nil, fmt.Errorf("failed to reserve stock: %v", err)
+			}
+			if result.MatchedCount == 0 {
+				return nil, ErrVersionConflict
+			}
+		}
+
+		flashSaleCollection := config.MongoDB.Collection("flash_sales")
+		if _, err := flashSaleCollection.InsertOne(sc, sale); err != nil {
+			return nil, fmt.Errorf("failed to save flash sale: %v", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	setFlashSaleState(sale, now)
+	return sale, nil
+}
+
+// GetFlashSales lists flash sales by state, upcoming, live or ended. No state lists the upcoming and live ones.
+func GetFlashSales(state string) ([]model.FlashSale, error) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var filter bson.M
+	switch state {
+	case "":
+		filter = bson.M{"closed": false, "ends_at": bson.M{"$gt": now}}
+	case model.FlashSaleUpcoming:
+		filter = bson.M{"closed": false, "starts_at": bson.M{"$gt": now}}
+	case model.FlashSaleLive:
+		filter = bson.M{"closed": false, "starts_at": bson.M{"$lte": now}, "ends_at": bson.M{"$gt": now}}
+	case model.FlashSaleEnded:
+		filter = bson.M{"$or": bson.A{bson.M{"closed": true}, bson.M{"ends_at": bson.M{"$lte": now}}}}
+	default:
+		return nil, fmt.Errorf("%w: state must be upcoming, live or ended", ErrInvalidFlashSale)
+	}
+
+	flashSaleCollection := config.MongoDB.Collection("flash_sales")
+
+	opts := options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}})
+	if state == model.FlashSaleEnded {
+		opts.SetSort(bson.D{{Key: "ends_at", Value: -1}}).SetLimit(100)
+	}
+	cursor, err := flashSaleCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch flash sales: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	sales := []model.FlashSale{}
+	if err = cursor.All(context.TODO(), &sales); err != nil {
+		return nil, fmt.Errorf("failed to decode flash sales: %v", err)
+	}
+	for i := range sales {
+		setFlashSaleState(&sales[i], now.Time())
+	}
+
+	return sales, nil
+}
+
+// GetFlashSale returns a flash sale with what is left of each quota
+func GetFlashSale(id string) (*model.FlashSale, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrFlashSaleNotFound
+	}
+
+	flashSaleCollection := config.MongoDB.Collection("flash_sales")
+
+	var sale model.FlashSale
+	err = flashSaleCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&sale)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrFlashSaleNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch flash sale: %v", err)
+	}
+
+	setFlashSaleState(&sale, time.Now())
+	return &sale, nil
+}
+
+// EndFlashSale stops a flash sale now and puts its unsold quota back into stock
+func EndFlashSale(id string) (*model.FlashSale, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrFlashSaleNotFound
+	}
+
+	sale, err := closeFlashSale(context.TODO(), objID, true)
+	if err != nil {
+		return nil, err
+	}
+	if sale == nil {
+		if _, err := GetFlashSale(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: the flash sale already ended", ErrInvalidFlashSale)
+	}
+	return sale, nil
+}
+
+// CloseEndedFlashSales puts the unsold quota of flash sales that ended back into stock. It returns how many were closed.
+func CloseEndedFlashSales(ctx context.Context) (int, error) {
+	flashSaleCollection := config.MongoDB.Collection("flash_sales")
+
+	filter := bson.M{"closed": false, "ends_at": bson.M{"$lte": primitive.NewDateTimeFromTime(time.Now())}}
+	cursor, err := flashSaleCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch ended flash sales: %v", err)
+	}
+	var ended []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &ended); err != nil {
+		return 0, fmt.Errorf("failed to decode ended flash sales: %v", err)
+	}
+
+	closed := 0
+	for _, sale := range ended {
+		closedSale, err := closeFlashSale(ctx, sale.ID, false)
+		if err != nil {
+			return closed, err
+		}
+		if closedSale != nil {
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+// closeFlashSale marks a flash sale closed and returns its remaining quota to the perfumes' stock in one
+// transaction. Purchases only take bottles from sales that are not closed, so the remaining quota read
+// when closing is final. With endNow a sale that is still running ends now. Returns nil for sales that
+// were already closed.
+func closeFlashSale(ctx context.Context, id primitive.ObjectID, endNow bool) (*model.FlashSale, error) {
+	ctx, cancel := context.WithTimeout(ctx, orderTimeout)
+	defer cancel()
+
+	session, err := config.MongoClient.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	sale, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		now := primitive.NewDateTimeFromTime(time.Now())
+		filter := bson.M{"_id": id, "closed": false}
+		if !endNow {
+			filter["ends_at"] = bson.M{"$lte": now}
+		}
+
+		flashSaleCollection := config.MongoDB.Collection("flash_sales")
+
+		var sale model.FlashSale
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		// Ending early moves the end to now, sales that ended keep their end
+		update := bson.M{
+			"$set": bson.M{"closed": true, "updated_at": now},
+			"$min": bson.M{"ends_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+		err := flashSaleCollection.FindOneAndUpdate(sc, filter, update, opts).Decode(&sale)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return (*model.FlashSale)(nil), nil
+			}
+			return nil, fmt.Errorf("failed to close flash sale: %v", err)
+		}
+
+		for _, item := range sale.Items {
+			if item.Remaining > 0 {
+				if err := addPerfumeStock(sc, item.PerfumeID, item.Remaining); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return &sale, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	closed := sale.(*model.FlashSale)
+	if closed != nil {
+		setFlashSaleState(closed, time.Now())
+	}
+	return closed, nil
+}
+
+// addPerfumeStock adds bottles to the stock of a perfume. The stock is only written for the version read,
+// so a concurrent change fails with ErrVersionConflict instead of being overwritten. Deleted perfumes are skipped.
+func addPerfumeStock(ctx context.Context, perfumeID primitive.ObjectID, quantity int) error {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	var perfume model.Perfume
+	err := perfumeCollection.FindOne(ctx, notDeleted(bson.M{"_id": perfumeID})).Decode(&perfume)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch perfume: %v", err)
+	}
+
+	result, err := perfumeCollection.UpdateOne(ctx,
+		bson.M{"_id": perfumeID, "version": versionMatch(perfume.Version)},
+		bson.M{
+			"$set": bson.M{"stock": strconv.Itoa(perfumeStock(&perfume) + quantity), "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+			"$inc": bson.M{"version": 1},
+		})
+	if err != nil {
+		return fmt.Errorf("failed to update stock: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// flashSalePurchaseID is the ID of the document counting the bottles a customer bought of a flash sale perfume
+func flashSalePurchaseID(saleID, perfumeID, userID primitive.ObjectID) string {
+	return saleID.Hex() + ":" + perfumeID.Hex() + ":" + userID.Hex()
+}
+
+// BuyFlashSale places an order for a perfume of a live flash sale at its flash price.
+//
+// Hundreds of buyers hit the same quota within seconds, so nothing here reads a count and writes it back.
+// Each step is one guarded $inc the database applies atomically: the customer's count only grows while it
+// stays within the per-user limit, the quota only shrinks while enough bottles remain. A buyer who loses
+// the race gets a sold out error right away, without retries or transactions that would conflict on the
+// busy flash sale document. When a later step fails the earlier ones are undone.
+func BuyFlashSale(id string, userID primitive.ObjectID, author model.RevisionAuthor, request model.FlashSalePurchaseRequest) (*model.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+
+	sale, err := GetFlashSale(id)
+	if err != nil {
+		return nil, err
+	}
+	if sale.State != model.FlashSaleLive {
+		return nil, fmt.Errorf("%w: the flash sale is %s", ErrInvalidFlashSale, sale.State)
+	}
+	perfumeID, err := primitive.ObjectIDFromHex(strings.TrimSpace(request.PerfumeID))
+	if err != nil {
+		return nil, ErrFlashSaleNotFound
+	}
+	var item *model.FlashSaleItem
+	for i := range sale.Items {
+		if sale.Items[i].PerfumeID == perfumeID {
+			item = &sale.Items[i]
+		}
+	}
+	if item == nil {
+		return nil, fmt.Errorf("%w: the perfume is not part of this flash sale", ErrFlashSaleNotFound)
+	}
+
+	quantity := request.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	if quantity < 0 || quantity > CartMaxQuantity() {
+		return nil, fmt.Errorf("%w: quantity must be between 1 and %d", ErrInvalidFlashSale, CartMaxQuantity())
+	}
+	if item.PerUserLimit > 0 && quantity > item.PerUserLimit {
+		return nil, fmt.Errorf("%w: at most %d bottles per customer", ErrFlashSaleLimit, item.PerUserLimit)
+	}
+	if item.Remaining < quantity {
+		return nil, fmt.Errorf("%w: %d bottles left", ErrFlashSaleSoldOut, max(item.Remaining, 0))
+	}
+
+	perfumes, err := cartPerfumes(ctx, []model.CartItem{{PerfumeID: perfumeID}})
+	if err != nil {
+		return nil, err
+	}
+	perfume, ok := perfumes[perfumeID]
+	if !ok || !isPerfumeBuyable(perfume) {
+		return nil, fmt.Errorf("%w: the perfume is no longer for sale", ErrInvalidFlashSale)
+	}
+	size, err := cartItemSize(perfume, request.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	order := &model.Order{
+		OrderID: primitive.NewObjectID(),
+		UserID:  userID,
+		Items: []model.OrderItem{{
+			PerfumeID: perfume.PerfumeID,
+			SKU:       perfume.SKU,
+			Name:      perfume.Name,
+			Brand:     perfume.Brand,
+			Slug:      perfume.Slug,
+			Image:     perfume.Image,
+			Size:      size,
+			UnitPrice: item.Price,
+			Quantity:  quantity,
+			LineTotal: item.Price * float64(quantity),
+		}},
+		ItemCount: quantity,
+		Notes:     strings.TrimSpace(request.Notes),
+		Status:    model.OrderPendingPayment,
+		History: []model.OrderStatusChange{
+			{To: model.OrderPendingPayment, Actor: model.ActorCustomer, By: author, At: primitive.NewDateTimeFromTime(now)},
+		},
+		FlashSaleID: &sale.FlashSaleID,
+		Version:     1,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		UpdatedAt:   primitive.NewDateTimeFromTime(now),
+	}
+	order.OrderNumber = orderNumber(order.OrderID, now)
+	order.Subtotal = order.Items[0].LineTotal
+	order.Total = order.Subtotal
+
+	// Shipping is charged as quoted for the weight of the bottles bought
+	if request.Shipping != nil {
+		weight := parcelWeight([]model.CartItem{{PerfumeID: perfumeID, Quantity: quantity}}, perfumes)
+		shipping, err := orderShipping(ctx, request.Shipping, weight, userID)
+		if err != nil {
+			return nil, err
+		}
+		order.Shipping = shipping
+		order.ShippingCost = shipping.Cost
+		order.Total = order.Subtotal + order.ShippingCost
+	}
+
+	// Count the bottles against the customer's limit first, so one customer cannot drain the quota
+	purchaseID := flashSalePurchaseID(sale.FlashSaleID, perfumeID, userID)
+	if err := countFlashSalePurchase(ctx, purchaseID, sale.FlashSaleID, perfumeID, userID, quantity, item.PerUserLimit); err != nil {
+		return nil, err
+	}
+
+	// Take the bottles from the quota, only while the sale runs and enough remain
+	flashSaleCollection := config.MongoDB.Collection("flash_sales")
+	nowDate := primitive.NewDateTimeFromTime(time.Now())
+	result, err := flashSaleCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":       sale.FlashSaleID,
+			"closed":    false,
+			"starts_at": bson.M{"$lte": nowDate},
+			"ends_at":   bson.M{"$gt": nowDate},
+			"items":     bson.M{"$elemMatch": bson.M{"perfume_id": perfumeID, "remaining": bson.M{"$gte": quantity}}},
+		},
+		bson.M{"$inc": bson.M{"items.$.remaining": -quantity, "items.$.sold": quantity}})
+	if err != nil || result.MatchedCount == 0 {
+		uncountFlashSalePurchase(purchaseID, quantity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take flash sale quota: %v", err)
+		}
+		return nil, fmt.Errorf("%w: fewer than %d bottles left", ErrFlashSaleSoldOut, quantity)
+	}
+
+	orderCollection := config.MongoDB.Collection("orders")
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		uncountFlashSalePurchase(purchaseID, quantity)
+		giveBackFlashSaleQuota(sale.FlashSaleID, perfumeID, quantity)
+		return nil, fmt.Errorf("failed to save order: %v", err)
+	}
+
+	return order, nil
+}
+
+// countFlashSalePurchase adds bottles to what a customer bought of a flash sale perfume, failing with
+// ErrFlashSaleLimit when that would exceed the limit. A limit of 0 counts without a limit.
+func countFlashSalePurchase(ctx context.Context, purchaseID string, saleID, perfumeID, userID primitive.ObjectID, quantity, limit int) error {
+	purchaseCollection := config.MongoDB.Collection("flash_sale_purchases")
+
+	filter := bson.M{"_id": purchaseID}
+	if limit > 0 {
+		filter["count"] = bson.M{"$lte": limit - quantity}
+	}
+	counted := func() (bool, error) {
+		result, err := purchaseCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": quantity}})
+		if err != nil {
+			return false, fmt.Errorf("failed to count flash sale purchase: %v", err)
+		}
+		return result.MatchedCount > 0, nil
+	}
+	if ok, err := counted(); ok || err != nil {
+		return err
+	}
+
+	// The customer's first purchase, or the limit was reached: then the document exists and the insert fails
+	_, err := purchaseCollection.InsertOne(ctx, bson.M{"_id": purchaseID, "flash_sale_id": saleID, "perfume_id": perfumeID, "user_id": userID, "count": quantity})
+	if err == nil {
+		return nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to count flash sale purchase: %v", err)
+	}
+
+	// A concurrent first purchase of the same customer may have inserted the document between our
+	// update and insert, so only a second miss of the guarded update means the limit was reached
+	ok, err := counted()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: at most %d bottles per customer", ErrFlashSaleLimit, limit)
+	}
+	return nil
+}
+
+// uncountFlashSalePurchase takes bottles off what a customer bought, when their purchase failed.
+// Failures are logged, the customer may then buy fewer bottles than the limit allows.
+func uncountFlashSalePurchase(purchaseID string, quantity int) {
+	purchaseCollection := config.MongoDB.Collection("flash_sale_purchases")
+	_, err := purchaseCollection.UpdateOne(context.TODO(),
+		bson.M{"_id": purchaseID, "count": bson.M{"$gte": quantity}},
+		bson.M{"$inc": bson.M{"count": -quantity}})
+	if err != nil {
+		log.Printf("Failed to uncount flash sale purchase %s: %v", purchaseID, err)
+	}
+}
+
+// giveBackFlashSaleQuota returns the bottles of a failed purchase to the quota, or to the perfume's stock
+// when the sale was closed meanwhile. Failures are logged, the bottles are then missing from the stock.
+func giveBackFlashSaleQuota(saleID, perfumeID primitive.ObjectID, quantity int) {
+	ctx := context.TODO()
+	returned, err := returnFlashSaleQuota(ctx, saleID, perfumeID, quantity)
+	if err == nil && !returned {
+		for attempt := 0; attempt < flashSaleStockRetries; attempt++ {
+			if err = addPerfumeStock(ctx, perfumeID, quantity); !errors.Is(err, ErrVersionConflict) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to give back %d bottles of perfume %s from flash sale %s: %v", quantity, perfumeID.Hex(), saleID.Hex(), err)
+	}
+}
+
+// returnFlashSaleQuota puts bottles back into the quota of a flash sale that is not closed. It reports false
+// when the sale was closed, the bottles then belong to the perfume's stock.
+func returnFlashSaleQuota(ctx context.Context, saleID, perfumeID primitive.ObjectID, quantity int) (bool, error) {
+	flashSaleCollection := config.MongoDB.Collection("flash_sales")
+	result, err := flashSaleCollection.UpdateOne(ctx,
+		bson.M{"_id": saleID, "closed": false, "items": bson.M{"$elemMatch": bson.M{"perfume_id": perfumeID, "sold": bson.M{"$gte": quantity}}}},
+		bson.M{"$inc": bson.M{"items.$.remaining": quantity, "items.$.sold": -quantity}})
+	if err != nil {
+		return false, fmt.Errorf("failed to return flash sale quota: %v", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// returnFlashSaleOrder gives the bottles of a cancelled flash sale order back to the sale's quota and the
+// customer's limit, within the cancellation's transaction. Bottles of sales that were closed meanwhile are
+// left for returnOrderStock to put into the perfumes' stock.
+func returnFlashSaleOrder(ctx mongo.SessionContext, order *model.Order) ([]model.OrderItem, error) {
+	purchaseCollection := config.MongoDB.Collection("flash_sale_purchases")
+
+	toStock := []model.OrderItem{}
+	for _, item := range order.Items {
+		returned, err := returnFlashSaleQuota(ctx, *order.FlashSaleID, item.PerfumeID, item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if !returned {
+			toStock = append(toStock, item)
+			continue
+		}
+		_, err = purchaseCollection.UpdateOne(ctx,
+			bson.M{"_id": flashSalePurchaseID(*order.FlashSaleID, item.PerfumeID, order.UserID), "count": bson.M{"$gte": item.Quantity}},
+			bson.M{"$inc": bson.M{"count": -item.Quantity}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to uncount flash sale purchase: %v", err)
+		}
+	}
+	return toStock, nil
+}
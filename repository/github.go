@@ -0,0 +1,347 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+)
+
+// ErrGithubRateLimited is returned when GitHub asks us to wait longer than MaxRetryWait
+var ErrGithubRateLimited = errors.New("GitHub rate limit exceeded")
+
+// GithubClient talks to the GitHub Contents API with timeouts, retries and rate-limit handling
+type GithubClient struct {
+	BaseURL     string // API root, https://api.github.com unless pointed at a fake server
+	RawBaseURL  string // Root of the raw file host, https://raw.githubusercontent.com
+	Owner       string
+	Repo        string
+	Token       string
+	Branch      string // Branch the files are committed to
+	PathPrefix  string // Folder inside the repository the files are stored in
+	AuthorName  string // Commit author/committer identity, GitHub uses the token owner when empty
+	AuthorEmail string
+	HTTPClient  *http.Client
+	MaxRetries  int           // Retries after the first attempt
+	BaseBackoff time.Duration // First retry delay, doubled on every attempt
+	MaxBackoff  time.Duration // Upper bound of the exponential backoff
+	MaxWait     time.Duration // Longest Retry-After / rate-limit reset we are willing to sleep for
+}
+
+// NewGithubClient reads the GITHUB_* environment variables
+func NewGithubClient() (*GithubClient, error) {
+	client := &GithubClient{
+		BaseURL:     strings.TrimSuffix(envString("GITHUB_API_URL", "https://api.github.com"), "/"),
+		RawBaseURL:  strings.TrimSuffix(envString("GITHUB_RAW_URL", "https://raw.githubusercontent.com"), "/"),
+		Owner:       os.Getenv("GITHUB_OWNER"),
+		Repo:        os.Getenv("GITHUB_REPO"),
+		Token:       os.Getenv("GITHUB_TOKEN"),
+		Branch:      envString("GITHUB_BRANCH", "main"),
+		PathPrefix:  strings.Trim(os.Getenv("GITHUB_PATH_PREFIX"), "/"),
+		AuthorName:  os.Getenv("GITHUB_AUTHOR_NAME"),
+		AuthorEmail: os.Getenv("GITHUB_AUTHOR_EMAIL"),
+		HTTPClient:  &http.Client{Timeout: envDuration("GITHUB_TIMEOUT", 30*time.Second)},
+		MaxRetries:  envInt("GITHUB_MAX_RETRIES", 4),
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		MaxWait:     envDuration("GITHUB_MAX_RETRY_WAIT", time.Minute),
+	}
+
+	// Validate GitHub credentials
+	if client.Owner == "" || client.Repo == "" || client.Token == "" {
+		return nil, errors.New("GitHub connection details are missing in environment variables")
+	}
+
+	return client, nil
+}
+
+// envString reads an environment variable with a default
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envDuration reads a Go duration environment variable (e.g. "30s") with a default
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// repoPath places a key inside the configured path prefix
+func (c *GithubClient) repoPath(key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if c.PathPrefix == "" {
+		return key
+	}
+	return c.PathPrefix + "/" + key
+}
+
+// contentsURL returns the Contents API URL of a key
+func (c *GithubClient) contentsURL(key string) string {
+	return fmt.Sprintf("%s/repos/%s/%s/contents/%s", c.BaseURL, c.Owner, c.Repo, escapeGithubPath(c.repoPath(key)))
+}
+
+// RawURL returns the public download URL of a key
+func (c *GithubClient) RawURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", c.RawBaseURL, c.Owner, c.Repo, c.Branch, escapeGithubPath(c.repoPath(key)))
+}
+
+// escapeGithubPath escapes every path segment but keeps the slashes
+func escapeGithubPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// commitIdentity returns the author/committer sent with every change, nil lets GitHub decide
+func (c *GithubClient) commitIdentity() *model.GithubCommitIdentity {
+	if c.AuthorName == "" || c.AuthorEmail == "" {
+		return nil
+	}
+	return &model.GithubCommitIdentity{Name: c.AuthorName, Email: c.AuthorEmail}
+}
+
+// GetFileSHA returns the blob SHA of a file, or an empty string if it does not exist
+func (c *GithubClient) GetFileSHA(ctx context.Context, key string) (string, error) {
+	status, body, err := c.do(ctx, http.MethodGet, c.contentsURL(key)+"?ref="+url.QueryEscape(c.Branch), nil)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return "", nil
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("failed to look up file: %d, response: %s", status, string(body))
+	}
+
+	var file struct {
+		SHA string `json:"sha"`
+	}
+	if err := json.Unmarshal(body, &file); err != nil {
+		return "", fmt.Errorf("failed to decode file metadata: %v", err)
+	}
+
+	return file.SHA, nil
+}
+
+// PutFile creates or overwrites a file. 201 (created) and 200 (overwritten) are both success;
+// if the file changed between the SHA lookup and the write, the lookup is repeated once.
+func (c *GithubClient) PutFile(ctx context.Context, key string, content []byte, message string) error {
+	encoded := base64.StdEncoding.EncodeToString(content)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		// Overwriting an existing file requires its current blob SHA
+		sha, err := c.GetFileSHA(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(model.GithubUploadRequest{
+			Message:   message,
+			Content:   encoded,
+			SHA:       sha,
+			Branch:    c.Branch,
+			Author:    c.commitIdentity(),
+			Committer: c.commitIdentity(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+
+		status, body, err := c.do(ctx, http.MethodPut, c.contentsURL(key), payload)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case http.StatusCreated, http.StatusOK:
+			return nil
+		case http.StatusConflict, http.StatusUnprocessableEntity:
+			// SHA is stale (or missing because the file was created meanwhile), look it up again
+			if attempt == 0 {
+				continue
+			}
+		}
+		return fmt.Errorf("failed to upload file: %d, response: %s", status, string(body))
+	}
+
+	return nil
+}
+
+// DeleteFile removes a file, a file that does not exist is not an error
+func (c *GithubClient) DeleteFile(ctx context.Context, key string, message string) error {
+	sha, err := c.GetFileSHA(ctx, key)
+	if err != nil {
+		return err
+	}
+	if sha == "" {
+		return nil // Already gone
+	}
+
+	payload, err := json.Marshal(model.GithubDeleteRequest{
+		Message:   message,
+		SHA:       sha,
+		Branch:    c.Branch,
+		Author:    c.commitIdentity(),
+		Committer: c.commitIdentity(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	status, body, err := c.do(ctx, http.MethodDelete, c.contentsURL(key), payload)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("failed to delete file: %d, response: %s", status, string(body))
+	}
+
+	return nil
+}
+
+// ListFiles returns the keys of every file below the path prefix using the recursive Git Trees API
+func (c *GithubClient) ListFiles(ctx context.Context) ([]string, error) {
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", c.BaseURL, c.Owner, c.Repo, url.PathEscape(c.Branch))
+
+	status, body, err := c.do(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to list files: %d, response: %s", status, string(body))
+	}
+
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	if err := json.Unmarshal(body, &tree); err != nil {
+		return nil, fmt.Errorf("failed to decode file list: %v", err)
+	}
+	if tree.Truncated {
+		return nil, errors.New("file list is truncated, repository is too large to reconcile")
+	}
+
+	prefix := ""
+	if c.PathPrefix != "" {
+		prefix = c.PathPrefix + "/"
+	}
+	keys := []string{}
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" && strings.HasPrefix(entry.Path, prefix) {
+			keys = append(keys, strings.TrimPrefix(entry.Path, prefix))
+		}
+	}
+
+	return keys, nil
+}
+
+// do sends a request, retrying network errors, 5xx responses and rate limits.
+// It returns the status and body of the last response.
+func (c *GithubClient) do(ctx context.Context, method, apiURL string, payload []byte) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		// Send request
+		var wait time.Duration
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.MaxRetries {
+				return 0, nil, fmt.Errorf("failed to send request: %v", err)
+			}
+			wait = c.backoff(attempt)
+		} else {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			retry, retryWait, err := c.shouldRetry(resp)
+			if err != nil {
+				return resp.StatusCode, respBody, err
+			}
+			if !retry || attempt >= c.MaxRetries {
+				return resp.StatusCode, respBody, nil
+			}
+			wait = retryWait
+			if wait == 0 {
+				wait = c.backoff(attempt)
+			}
+		}
+
+		// Wait before the next attempt, giving up when the caller does
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry decides whether a response is worth retrying and how long GitHub asked us to wait
+func (c *GithubClient) shouldRetry(resp *http.Response) (bool, time.Duration, error) {
+	rateLimited := resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden &&
+			(resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"))
+
+	if !rateLimited {
+		return resp.StatusCode >= 500, 0, nil
+	}
+
+	// Secondary rate limits send Retry-After, the primary limit sends the reset time
+	wait := time.Duration(0)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		wait = time.Until(time.Unix(reset, 0)) + time.Second
+	}
+	if wait > c.MaxWait {
+		return false, 0, fmt.Errorf("%w, retry after %s", ErrGithubRateLimited, wait.Round(time.Second))
+	}
+
+	return true, wait, nil
+}
+
+// backoff returns the exponential delay for an attempt with up to 50% random jitter
+func (c *GithubClient) backoff(attempt int) time.Duration {
+	delay := c.BaseBackoff << attempt
+	if delay <= 0 || delay > c.MaxBackoff {
+		delay = c.MaxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
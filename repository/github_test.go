@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/fakeapi"
+)
+
+const testGithubToken = "test-token"
+
+// githubTestServer runs the fake GitHub API, optionally behind a wrapper that can interfere with requests
+func githubTestServer(t *testing.T, wrap func(fake http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	fake := fakeapi.NewGithub(testGithubToken)
+	var handler http.Handler = fake
+	if wrap != nil {
+		handler = wrap(fake)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testGithubClient is a client of the fake server with short backoffs so retries are quick
+func testGithubClient(server *httptest.Server) *GithubClient {
+	return &GithubClient{
+		BaseURL:     server.URL,
+		RawBaseURL:  server.URL + "/raw",
+		Owner:       "elfume",
+		Repo:        "images",
+		Token:       testGithubToken,
+		Branch:      "main",
+		HTTPClient:  server.Client(),
+		MaxRetries:  3,
+		BaseBackoff: time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		MaxWait:     5 * time.Second,
+	}
+}
+
+// addGithubFault queues failures for the next requests to the fake
+func addGithubFault(t *testing.T, server *httptest.Server, fault fakeapi.GithubFault) {
+	t.Helper()
+	body, _ := json.Marshal(fault)
+	resp, err := http.Post(server.URL+"/_fake/faults", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to add fault: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed to add fault: status %d", resp.StatusCode)
+	}
+}
+
+// githubCommits lists the commits made through the fake
+func githubCommits(t *testing.T, server *httptest.Server) []fakeapi.GithubCommit {
+	t.Helper()
+	resp, err := http.Get(server.URL + "/_fake/commits")
+	if err != nil {
+		t.Fatalf("failed to list commits: %v", err)
+	}
+	defer resp.Body.Close()
+	var commits []fakeapi.GithubCommit
+	if err := json.NewDecoder(resp.Body).Decode(&commits); err != nil {
+		t.Fatalf("failed to decode commits: %v", err)
+	}
+	return commits
+}
+
+// countRequests wraps the fake and counts the requests that reach it
+func countRequests(counter *int32) func(fake http.Handler) http.Handler {
+	return func(fake http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/_fake/") {
+				atomic.AddInt32(counter, 1)
+			}
+			fake.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestGithubRetriesServerErrors(t *testing.T) {
+	var requests int32
+	server := githubTestServer(t, countRequests(&requests))
+	client := testGithubClient(server)
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusBadGateway, Count: 2})
+	if err := client.PutFile(context.Background(), "a.jpg", []byte("a"), "Add a"); err != nil {
+		t.Fatalf("PutFile after two 502s: %v", err)
+	}
+	// Two failed lookups, the lookup that succeeds and the upload
+	if requests != 4 {
+		t.Errorf("requests = %d, want 4", requests)
+	}
+}
+
+func TestGithubGivesUpAfterMaxRetries(t *testing.T) {
+	var requests int32
+	server := githubTestServer(t, countRequests(&requests))
+	client := testGithubClient(server)
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusServiceUnavailable, Count: 10})
+	if _, err := client.GetFileSHA(context.Background(), "a.jpg"); err == nil {
+		t.Fatal("GetFileSHA succeeded while GitHub kept failing")
+	}
+	if want := int32(client.MaxRetries + 1); requests != want {
+		t.Errorf("requests = %d, want %d", requests, want)
+	}
+}
+
+func TestGithubDoesNotRetryClientErrors(t *testing.T) {
+	var requests int32
+	server := githubTestServer(t, countRequests(&requests))
+	client := testGithubClient(server)
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusForbidden})
+	if _, err := client.GetFileSHA(context.Background(), "a.jpg"); err == nil {
+		t.Fatal("GetFileSHA succeeded on a 403 without rate limit headers")
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+}
+
+func TestGithubRateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   fakeapi.GithubFault
+		minWait time.Duration
+	}{
+		{"403 with Retry-After", fakeapi.GithubFault{Status: http.StatusForbidden, RetryAfter: 1}, time.Second},
+		{"429 with Retry-After", fakeapi.GithubFault{Status: http.StatusTooManyRequests, RetryAfter: 1}, time.Second},
+		{"403 with X-RateLimit-Reset", fakeapi.GithubFault{Status: http.StatusForbidden, RateLimitReset: 1}, time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := githubTestServer(t, nil)
+			client := testGithubClient(server)
+
+			addGithubFault(t, server, test.fault)
+			started := time.Now()
+			if _, err := client.GetFileSHA(context.Background(), "a.jpg"); err != nil {
+				t.Fatalf("GetFileSHA after a rate limit: %v", err)
+			}
+			if waited := time.Since(started); waited < test.minWait {
+				t.Errorf("waited %s, want at least %s", waited, test.minWait)
+			}
+		})
+	}
+}
+
+func TestGithubRateLimitBeyondMaxWait(t *testing.T) {
+	var requests int32
+	server := githubTestServer(t, countRequests(&requests))
+	client := testGithubClient(server)
+	client.MaxWait = time.Second
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusTooManyRequests, RetryAfter: 120})
+	started := time.Now()
+	_, err := client.GetFileSHA(context.Background(), "a.jpg")
+	if !errors.Is(err, ErrGithubRateLimited) {
+		t.Fatalf("err = %v, want ErrGithubRateLimited", err)
+	}
+	if time.Since(started) > time.Second || requests != 1 {
+		t.Errorf("waited %s over %d requests, want an immediate failure", time.Since(started), requests)
+	}
+}
+
+func TestGithubPutCreatesThenOverwrites(t *testing.T) {
+	var statuses []int
+	server := githubTestServer(t, func(fake http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := httptest.NewRecorder()
+			fake.ServeHTTP(recorder, r)
+			if r.Method == http.MethodPut {
+				statuses = append(statuses, recorder.Code)
+			}
+			for key, values := range recorder.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+		})
+	})
+	client := testGithubClient(server)
+	ctx := context.Background()
+
+	if err := client.PutFile(ctx, "a.jpg", []byte("first"), "Add a"); err != nil {
+		t.Fatalf("PutFile create: %v", err)
+	}
+	if err := client.PutFile(ctx, "a.jpg", []byte("second"), "Replace a"); err != nil {
+		t.Fatalf("PutFile overwrite: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusCreated || statuses[1] != http.StatusOK {
+		t.Errorf("PUT statuses = %v, want [201 200]", statuses)
+	}
+
+	resp, err := http.Get(client.RawURL("a.jpg"))
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	defer resp.Body.Close()
+	if content, _ := io.ReadAll(resp.Body); string(content) != "second" {
+		t.Errorf("content = %q, want %q", content, "second")
+	}
+}
+
+func TestGithubPutRefetchesStaleSHA(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists bool // The file exists before the upload: a stale SHA gives 409, a missing one 422
+		status int
+	}{
+		{"409 stale sha", true, http.StatusConflict},
+		{"422 missing sha", false, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var interfere int32
+			var statuses []int
+			var client *GithubClient
+			server := githubTestServer(t, func(fake http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodPut {
+						fake.ServeHTTP(w, r)
+						return
+					}
+					// Another writer changes the file between our SHA lookup and our first upload
+					if atomic.CompareAndSwapInt32(&interfere, 1, 0) {
+						body, _ := json.Marshal(map[string]string{"message": "Concurrent", "content": "Y29uY3VycmVudA==", "branch": "main", "sha": currentSHA(t, client)})
+						concurrent := httptest.NewRequest(http.MethodPut, r.URL.Path, bytes.NewReader(body))
+						concurrent.Header.Set("Authorization", "Bearer "+testGithubToken)
+						fake.ServeHTTP(httptest.NewRecorder(), concurrent)
+					}
+					recorder := httptest.NewRecorder()
+					fake.ServeHTTP(recorder, r)
+					statuses = append(statuses, recorder.Code)
+					for key, values := range recorder.Header() {
+						w.Header()[key] = values
+					}
+					w.WriteHeader(recorder.Code)
+					w.Write(recorder.Body.Bytes())
+				})
+			})
+			client = testGithubClient(server)
+			ctx := context.Background()
+
+			if test.exists {
+				if err := client.PutFile(ctx, "a.jpg", []byte("old"), "Add a"); err != nil {
+					t.Fatalf("PutFile setup: %v", err)
+				}
+				statuses = nil
+			}
+
+			atomic.StoreInt32(&interfere, 1)
+			if err := client.PutFile(ctx, "a.jpg", []byte("ours"), "Replace a"); err != nil {
+				t.Fatalf("PutFile with a concurrent change: %v", err)
+			}
+			if len(statuses) != 2 || statuses[0] != test.status || statuses[1] != http.StatusOK {
+				t.Errorf("PUT statuses = %v, want [%d 200]", statuses, test.status)
+			}
+			resp, err := http.Get(client.RawURL("a.jpg"))
+			if err != nil {
+				t.Fatalf("failed to download: %v", err)
+			}
+			defer resp.Body.Close()
+			if content, _ := io.ReadAll(resp.Body); string(content) != "ours" {
+				t.Errorf("content = %q, want %q", content, "ours")
+			}
+		})
+	}
+}
+
+// currentSHA looks up the SHA of a.jpg for the concurrent writer, empty when it does not exist
+func currentSHA(t *testing.T, client *GithubClient) string {
+	sha, err := client.GetFileSHA(context.Background(), "a.jpg")
+	if err != nil {
+		t.Errorf("failed to look up sha: %v", err)
+	}
+	return sha
+}
+
+func TestGithubBranchPrefixAndCommitter(t *testing.T) {
+	server := githubTestServer(t, nil)
+	client := testGithubClient(server)
+	client.Branch = "assets"
+	client.PathPrefix = "perfumes/images"
+	client.AuthorName = "Elfume Bot"
+	client.AuthorEmail = "bot@elfume.com"
+	ctx := context.Background()
+
+	if err := client.PutFile(ctx, "a b.jpg", []byte("a"), "Add a"); err != nil {
+		t.Fatalf("PutFile: %v", err)
+	}
+	if err := client.DeleteFile(ctx, "a b.jpg", "Delete a"); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if err := client.PutFile(ctx, "c.jpg", []byte("c"), "Add c"); err != nil {
+		t.Fatalf("PutFile: %v", err)
+	}
+
+	commits := githubCommits(t, server)
+	if len(commits) != 3 {
+		t.Fatalf("commits = %d, want 3", len(commits))
+	}
+	for _, commit := range commits {
+		if commit.Branch != "assets" || !strings.HasPrefix(commit.Path, "perfumes/images/") {
+			t.Errorf("commit to %s:%s, want branch assets below perfumes/images/", commit.Branch, commit.Path)
+		}
+		if commit.Committer["name"] != "Elfume Bot" || commit.Author["email"] != "bot@elfume.com" {
+			t.Errorf("commit by %v / %v, want Elfume Bot <bot@elfume.com>", commit.Author, commit.Committer)
+		}
+	}
+	if commits[0].Path != "perfumes/images/a b.jpg" || !commits[1].Deleted {
+		t.Errorf("commits = %+v, want a b.jpg added then deleted", commits)
+	}
+
+	keys, err := client.ListFiles(ctx)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "c.jpg" {
+		t.Errorf("keys = %v, want [c.jpg]", keys)
+	}
+	if want := server.URL + "/raw/elfume/images/assets/perfumes/images/c.jpg"; client.RawURL("c.jpg") != want {
+		t.Errorf("RawURL = %s, want %s", client.RawURL("c.jpg"), want)
+	}
+
+	// Files on other branches are not ours
+	client.Branch = "main"
+	if sha, err := client.GetFileSHA(ctx, "c.jpg"); err != nil || sha != "" {
+		t.Errorf("GetFileSHA on main = %q, %v, want the file to be missing", sha, err)
+	}
+}
+
+func TestGithubContextTimeout(t *testing.T) {
+	server := githubTestServer(t, nil)
+	client := testGithubClient(server)
+	client.BaseBackoff = time.Second
+	client.MaxBackoff = time.Second
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusServiceUnavailable, Count: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := client.GetFileSHA(ctx, "a.jpg")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(started); waited > 500*time.Millisecond {
+		t.Errorf("returned after %s, want soon after the deadline", waited)
+	}
+}
+
+func TestGithubContextCancelledDuringRateLimit(t *testing.T) {
+	server := githubTestServer(t, nil)
+	client := testGithubClient(server)
+
+	addGithubFault(t, server, fakeapi.GithubFault{Status: http.StatusTooManyRequests, RetryAfter: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	started := time.Now()
+	err := client.PutFile(ctx, "a.jpg", []byte("a"), "Add a")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if waited := time.Since(started); waited > time.Second {
+		t.Errorf("returned after %s, want soon after the cancellation", waited)
+	}
+	if commits := githubCommits(t, server); len(commits) != 0 {
+		t.Errorf("commits = %d, want none", len(commits))
+	}
+}
+
+func TestGithubHTTPClientTimeout(t *testing.T) {
+	var requests int32
+	server := githubTestServer(t, func(fake http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The first request hangs longer than the client waits
+			if atomic.AddInt32(&requests, 1) == 1 {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(2 * time.Second):
+				}
+				return
+			}
+			fake.ServeHTTP(w, r)
+		})
+	})
+	client := testGithubClient(server)
+	client.HTTPClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	if _, err := client.GetFileSHA(context.Background(), "a.jpg"); err != nil {
+		t.Fatalf("GetFileSHA after a timed out attempt: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+}
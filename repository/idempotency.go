@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IdempotencyKeyTTL is how long a stored response can be replayed, IDEMPOTENCY_KEY_TTL (default 24h)
+func IdempotencyKeyTTL() time.Duration {
+	return envDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+}
+
+// IdempotencyLockTTL is how long a request holds its key while processing, IDEMPOTENCY_LOCK_TTL (default 5m).
+// A request that crashed or hung without releasing its key stops blocking retries after this.
+func IdempotencyLockTTL() time.Duration {
+	return envDuration("IDEMPOTENCY_LOCK_TTL", 5*time.Minute)
+}
+
+// BeginIdempotentRequest claims a key for a new request. When the key was used before,
+// the existing record is returned and started is false. A key still processing past its
+// lock is taken over by a retry of the same request.
+func BeginIdempotentRequest(key, requestHash string) (record *model.IdempotencyRecord, started bool, err error) {
+	idempotencyCollection := config.MongoDB.Collection("idempotency_keys")
+
+	now := time.Now()
+	record = &model.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		Status:      model.IdempotencyProcessing,
+		LockedUntil: primitive.NewDateTimeFromTime(now.Add(IdempotencyLockTTL())),
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(IdempotencyKeyTTL())),
+	}
+
+	// The unique _id makes the insert the lock, only one request can claim the key
+	_, err = idempotencyCollection.InsertOne(context.TODO(), record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, false, fmt.Errorf("failed to store idempotency key: %v", err)
+	}
+
+	// Take over the key when the request holding it let its lock run out. Keys stored before
+	// locks existed have no locked_until and count as expired.
+	result, err := idempotencyCollection.ReplaceOne(context.TODO(), bson.M{
+		"_id":          key,
+		"request_hash": requestHash,
+		"status":       model.IdempotencyProcessing,
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$lt": record.CreatedAt}},
+			bson.M{"locked_until": bson.M{"$exists": false}},
+		},
+	}, record)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to take over idempotency key: %v", err)
+	}
+	if result.MatchedCount == 1 {
+		return record, true, nil
+	}
+
+	var existing model.IdempotencyRecord
+	err = idempotencyCollection.FindOne(context.TODO(), bson.M{"_id": key}).Decode(&existing)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Released between our insert and the lookup, let the client try again
+			return nil, false, fmt.Errorf("idempotency key was released, retry the request")
+		}
+		return nil, false, fmt.Errorf("failed to find idempotency key: %v", err)
+	}
+
+	return &existing, false, nil
+}
+
+// CompleteIdempotentRequest stores the response so retries with the same key get it back.
+// A key another request took over after the lock ran out keeps the new owner's response.
+func CompleteIdempotentRequest(record *model.IdempotencyRecord, status int, contentType string, body []byte) error {
+	idempotencyCollection := config.MongoDB.Collection("idempotency_keys")
+
+	_, err := idempotencyCollection.UpdateOne(context.TODO(), bson.M{
+		"_id":          record.Key,
+		"status":       model.IdempotencyProcessing,
+		"locked_until": record.LockedUntil,
+	}, bson.M{
+		"$set": bson.M{
+			"status":          model.IdempotencyCompleted,
+			"response_status": status,
+			"response_body":   body,
+			"content_type":    contentType,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %v", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotentRequest forgets a key whose request failed on our side, so it can be retried.
+// A key another request took over after the lock ran out is left alone.
+func ReleaseIdempotentRequest(record *model.IdempotencyRecord) error {
+	idempotencyCollection := config.MongoDB.Collection("idempotency_keys")
+
+	_, err := idempotencyCollection.DeleteOne(context.TODO(), bson.M{
+		"_id":          record.Key,
+		"status":       model.IdempotencyProcessing,
+		"locked_until": record.LockedUntil,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+
+	return nil
+}
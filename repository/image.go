@@ -0,0 +1,439 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// uploadPerfumeImages runs each upload through the image pipeline and saves the cleaned
+// original and its renditions in the image store, returning the gallery entries
+func uploadPerfumeImages(perfumeID primitive.ObjectID, uploads []model.FumeImgUpload) ([]model.PerfumeImage, error) {
+	if Images == nil {
+		return nil, fmt.Errorf("image store is not configured")
+	}
+
+	// Validate, strip metadata and resize everything before the first file is stored
+	processedImages := make([]*ProcessedImage, len(uploads))
+	for i, upload := range uploads {
+		processed, err := ProcessImage(upload.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", upload.FileName, err)
+		}
+		processedImages[i] = processed
+	}
+
+	// Every saved file is deleted again if a later file fails, so a failed upload leaves nothing behind
+	var uploadSaga saga
+	save := func(key string, encoded EncodedImage) (string, error) {
+		savedURL, err := Images.Save(context.TODO(), key, encoded.Content, encoded.ContentType)
+		if err != nil {
+			return "", err
+		}
+		uploadSaga.onRollback(func() { deleteStoredKey(key, "upload failed") })
+		return savedURL, nil
+	}
+
+	images := []model.PerfumeImage{}
+	for i, upload := range uploads {
+		processed := processedImages[i]
+
+		// Name the files after the perfume and the image so a gallery never collides,
+		// the extension comes from the detected format instead of the client's file name
+		imageID := primitive.NewObjectID()
+		baseName := perfumeID.Hex() + "-" + imageID.Hex()
+
+		fileName := baseName + processed.Original.Extension
+		imageURL, err := save(fileName, processed.Original)
+		if err != nil {
+			uploadSaga.rollback()
+			return nil, fmt.Errorf("failed to upload image: %v", err)
+		}
+
+		image := model.PerfumeImage{
+			ImageID:     imageID,
+			URL:         imageURL,
+			FileName:    fileName,
+			AltText:     upload.AltText,
+			Kind:        upload.Kind,
+			Width:       processed.Original.Width,
+			Height:      processed.Original.Height,
+			ContentType: processed.Original.ContentType,
+			Renditions:  []model.ImageRendition{},
+		}
+
+		for _, rendition := range processed.Renditions {
+			renditionName := baseName + "-" + rendition.Name + rendition.Extension
+			renditionURL, err := save(renditionName, rendition)
+			if err != nil {
+				uploadSaga.rollback()
+				return nil, fmt.Errorf("failed to upload %s rendition: %v", rendition.Name, err)
+			}
+			image.Renditions = append(image.Renditions, model.ImageRendition{
+				Name:        rendition.Name,
+				Format:      rendition.Format,
+				URL:         renditionURL,
+				FileName:    renditionName,
+				Width:       rendition.Width,
+				Height:      rendition.Height,
+				ContentType: rendition.ContentType,
+			})
+		}
+
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// normalizePerfumeImages renumbers positions and makes sure exactly one image is primary
+func normalizePerfumeImages(images []model.PerfumeImage) []model.PerfumeImage {
+	if images == nil {
+		return []model.PerfumeImage{}
+	}
+
+	primary := -1
+	for i := range images {
+		images[i].Position = i
+		if images[i].IsPrimary && primary == -1 {
+			primary = i
+		}
+		images[i].IsPrimary = false
+	}
+	if primary == -1 && len(images) > 0 {
+		primary = 0
+	}
+	if primary >= 0 {
+		images[primary].IsPrimary = true
+	}
+
+	return images
+}
+
+// primaryImageURL returns the URL of the primary image, or an empty string for an empty gallery
+func primaryImageURL(images []model.PerfumeImage) string {
+	for _, image := range images {
+		if image.IsPrimary {
+			return image.URL
+		}
+	}
+	return ""
+}
+
+// galleryRetries is how often a gallery change is reapplied when the perfume changed while it was made
+const galleryRetries = 5
+
+// savePerfumeImages writes the whole gallery back and keeps the legacy image field in sync. The write
+// only goes through if the perfume is still at the version the gallery was read at.
+func savePerfumeImages(perfumeID primitive.ObjectID, version int64, images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	images = normalizePerfumeImages(images)
+	update := bson.M{
+		"$set": bson.M{
+			"images":     images,
+			"image":      primaryImageURL(images),
+			"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	base := bson.M{"_id": perfumeID}
+	result, err := perfumeCollection.UpdateOne(context.TODO(), versionFilter(base, &version), update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update perfume images: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, versionMissError(perfumeCollection, base, fmt.Errorf("perfume not found"))
+	}
+
+	return images, nil
+}
+
+// changePerfumeImages applies a change to the gallery and saves it. Every change rewrites the whole
+// gallery, so when another request saved the perfume in the meantime the change is applied again to
+// the fresh gallery instead of dropping the other request's images.
+func changePerfumeImages(id string, change func(images []model.PerfumeImage) ([]model.PerfumeImage, error)) ([]model.PerfumeImage, error) {
+	perfumeID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+
+	for attempt := 0; attempt < galleryRetries; attempt++ {
+		images, version, err := perfumeGallery(id)
+		if err != nil {
+			return nil, err
+		}
+		images, err = change(images)
+		if err != nil {
+			return nil, err
+		}
+		images, err = savePerfumeImages(perfumeID, version, images)
+		if !errors.Is(err, ErrVersionConflict) {
+			return images, err
+		}
+	}
+
+	return nil, fmt.Errorf("failed to update perfume images: %w", ErrVersionConflict)
+}
+
+// findPerfumeImage returns the index of an image inside the gallery
+func findPerfumeImage(images []model.PerfumeImage, imageID string) (int, error) {
+	objID, err := primitive.ObjectIDFromHex(imageID)
+	if err != nil {
+		return -1, fmt.Errorf("invalid image ID format: %v", err)
+	}
+	for i, image := range images {
+		if image.ImageID == objID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("image not found")
+}
+
+// perfumeGallery returns the gallery of a perfume ordered by position, and the version of the perfume it was read from
+func perfumeGallery(id string) ([]model.PerfumeImage, int64, error) {
+	perfume, err := GetPerfumeByID(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	images := perfume.Images
+	if len(images) == 0 && perfume.Image != "" {
+		// Perfumes created before galleries existed only have the single image URL,
+		// reuse the perfume ID so the image keeps a stable ID until the gallery is saved
+		images = []model.PerfumeImage{{ImageID: perfume.PerfumeID, URL: perfume.Image, AltText: perfume.Name, IsPrimary: true}}
+	}
+	if images == nil {
+		images = []model.PerfumeImage{}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Position < images[j].Position })
+
+	return images, perfume.Version, nil
+}
+
+// GetPerfumeImages returns the gallery of a perfume ordered by position
+func GetPerfumeImages(id string) ([]model.PerfumeImage, error) {
+	images, _, err := perfumeGallery(id)
+	return images, err
+}
+
+// AddPerfumeImages uploads new images and appends them to the end of the gallery
+func AddPerfumeImages(id string, uploads []model.FumeImgUpload) ([]model.PerfumeImage, error) {
+	if _, err := GetPerfumeImages(id); err != nil {
+		return nil, err
+	}
+
+	perfumeID, _ := primitive.ObjectIDFromHex(id)
+	uploaded, err := uploadPerfumeImages(perfumeID, uploads)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		return append(images, uploaded...), nil
+	})
+	if err != nil {
+		// The new files are not referenced by anything, do not leave them behind
+		deleteStoredImages(uploaded, "gallery update failed")
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// UpdatePerfumeImage changes the alt text and kind of an image
+func UpdatePerfumeImage(id, imageID string, request model.PerfumeImageUpdateRequest) ([]model.PerfumeImage, error) {
+	return changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		index, err := findPerfumeImage(images, imageID)
+		if err != nil {
+			return nil, err
+		}
+		images[index].AltText = request.AltText
+		images[index].Kind = request.Kind
+		return images, nil
+	})
+}
+
+// SetPrimaryPerfumeImage marks an image as the one shown in product listings
+func SetPrimaryPerfumeImage(id, imageID string) ([]model.PerfumeImage, error) {
+	return changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		index, err := findPerfumeImage(images, imageID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range images {
+			images[i].IsPrimary = i == index
+		}
+		return images, nil
+	})
+}
+
+// ReorderPerfumeImages puts the gallery in the given order, every image must be listed exactly once
+func ReorderPerfumeImages(id string, imageIDs []string) ([]model.PerfumeImage, error) {
+	return changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		if len(imageIDs) != len(images) {
+			return nil, fmt.Errorf("image order must list all %d images", len(images))
+		}
+
+		ordered := make([]model.PerfumeImage, 0, len(images))
+		used := make(map[int]bool, len(images))
+		for _, imageID := range imageIDs {
+			index, err := findPerfumeImage(images, imageID)
+			if err != nil {
+				return nil, err
+			}
+			if used[index] {
+				return nil, fmt.Errorf("image %s is listed more than once", imageID)
+			}
+			used[index] = true
+			ordered = append(ordered, images[index])
+		}
+		return ordered, nil
+	})
+}
+
+// DeletePerfumeImage removes an image from the gallery, promoting the next one if it was primary
+func DeletePerfumeImage(id, imageID string) ([]model.PerfumeImage, error) {
+	var removed model.PerfumeImage
+	images, err := changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		index, err := findPerfumeImage(images, imageID)
+		if err != nil {
+			return nil, err
+		}
+		removed = images[index]
+		return append(images[:index], images[index+1:]...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The gallery no longer points at the files, remove them from the store
+	deleteStoredImages([]model.PerfumeImage{removed}, "image deleted")
+
+	return images, nil
+}
+
+// ReplacePerfumeImage swaps the file behind a gallery image, keeping its position and primary flag.
+// An empty imageID replaces the primary image, or adds the first image to an empty gallery.
+func ReplacePerfumeImage(id, imageID string, upload model.FumeImgUpload) ([]model.PerfumeImage, error) {
+	// Make sure the image exists before anything is uploaded
+	images, err := GetPerfumeImages(id)
+	if err != nil {
+		return nil, err
+	}
+	if imageID != "" {
+		if _, err := findPerfumeImage(images, imageID); err != nil {
+			return nil, err
+		}
+	}
+
+	uploaded, err := UploadPerfumeImage(id, upload)
+	if err != nil {
+		return nil, err
+	}
+	return SwapPerfumeImage(id, imageID, uploaded)
+}
+
+// UploadPerfumeImage validates and stores a replacement image without putting it in the gallery yet.
+// The result goes to SwapPerfumeImage, or to DiscardPerfumeImage when it is not used after all.
+func UploadPerfumeImage(id string, upload model.FumeImgUpload) (model.PerfumeImage, error) {
+	perfumeID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return model.PerfumeImage{}, fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+	uploaded, err := uploadPerfumeImages(perfumeID, []model.FumeImgUpload{upload})
+	if err != nil {
+		return model.PerfumeImage{}, err
+	}
+	return uploaded[0], nil
+}
+
+// SwapPerfumeImage puts an image stored by UploadPerfumeImage in place of a gallery image and deletes
+// the old files. An empty imageID replaces the primary image, or adds the first image to an empty gallery.
+// When the gallery cannot be changed the uploaded files are deleted.
+func SwapPerfumeImage(id, imageID string, replacement model.PerfumeImage) ([]model.PerfumeImage, error) {
+	var replaced []model.PerfumeImage
+	images, err := changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		// Find the image being replaced
+		index := -1
+		if imageID != "" {
+			var err error
+			index, err = findPerfumeImage(images, imageID)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			for i, image := range images {
+				if image.IsPrimary {
+					index = i
+				}
+			}
+		}
+
+		replaced = nil
+		if index == -1 {
+			return append(images, replacement), nil
+		}
+
+		// Keep the descriptive fields unless new ones were sent
+		old := images[index]
+		swapped := replacement
+		swapped.IsPrimary = old.IsPrimary
+		if swapped.AltText == "" {
+			swapped.AltText = old.AltText
+		}
+		if swapped.Kind == "" {
+			swapped.Kind = old.Kind
+		}
+		images[index] = swapped
+		replaced = append(replaced, old)
+		return images, nil
+	})
+	if err != nil {
+		// The new files are not referenced by anything, do not leave them behind
+		DiscardPerfumeImage(replacement)
+		return nil, err
+	}
+
+	deleteStoredImages(replaced, "image replaced")
+
+	return images, nil
+}
+
+// DiscardPerfumeImage deletes the files of an image stored by UploadPerfumeImage that was never used
+func DiscardPerfumeImage(image model.PerfumeImage) {
+	deleteStoredImages([]model.PerfumeImage{image}, "gallery update failed")
+}
+
+// storedImageKeys returns the keys of the original and every rendition of an image
+func storedImageKeys(image model.PerfumeImage) []string {
+	keys := []string{}
+	if image.FileName != "" {
+		keys = append(keys, image.FileName)
+	}
+	for _, rendition := range image.Renditions {
+		if rendition.FileName != "" {
+			keys = append(keys, rendition.FileName)
+		}
+	}
+	return keys
+}
+
+// deleteStoredImages removes image files from the store. Files that cannot be deleted
+// are recorded as pending cleanups and retried by the background job.
+func deleteStoredImages(images []model.PerfumeImage, reason string) {
+	for _, image := range images {
+		for _, key := range storedImageKeys(image) {
+			deleteStoredKey(key, reason)
+		}
+	}
+}
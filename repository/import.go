@@ -0,0 +1,781 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidImport is returned when a file cannot be imported at all, problems with single rows are reported in the job
+var ErrInvalidImport = errors.New("invalid import")
+
+// caseInsensitive compares strings ignoring upper and lower case
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// maxImportErrors is how many row errors a job keeps, the failed count still covers every row
+const maxImportErrors = 500
+
+// importInterruptedAfter is how long a job may go without progress before it counts as interrupted
+const importInterruptedAfter = 10 * time.Minute
+
+// importExtraFields can be filled by an import besides the fields a PATCH may change
+var importExtraFields = map[string]bool{
+	"category_ids": true,
+	"status":       true,
+	"publish_at":   true,
+	"unpublish_at": true,
+	"image_urls":   true,
+}
+
+// importHeaderAliases lets common column names work without a mapping
+var importHeaderAliases = map[string]string{
+	"size":      "sizes",
+	"type":      "types",
+	"category":  "categories",
+	"image":     "image_urls",
+	"image_url": "image_urls",
+	"images":    "image_urls",
+}
+
+// importColumn is one column of the spreadsheet and the perfume field it fills, empty for ignored columns
+type importColumn struct {
+	Header string
+	Field  string
+}
+
+// MaxImportRows is the largest number of rows a single import accepts, IMPORT_MAX_ROWS (default 10000)
+func MaxImportRows() int {
+	return envInt("IMPORT_MAX_ROWS", 10000)
+}
+
+func isImportField(field string) bool {
+	_, ok := perfumePatchFields[field]
+	return ok || importExtraFields[field]
+}
+
+// normalizeHeader turns a column title like "Publish At" into publish_at
+func normalizeHeader(header string) string {
+	return strings.ToLower(strings.Join(strings.Fields(header), "_"))
+}
+
+// importColumns works out which field each column fills. Without a mapping the column titles are
+// used as field names, with one only the mapped columns are imported. The effective mapping is returned
+// so the job shows what was used.
+func importColumns(header []string, mapping map[string]string) ([]importColumn, map[string]string, error) {
+	columns := make([]importColumn, len(header))
+	used := map[string]string{}
+	effective := map[string]string{}
+
+	requested := map[string]string{}
+	for column, field := range mapping {
+		if !isImportField(field) {
+			return nil, nil, fmt.Errorf("%w: unknown field %q for column %q", ErrInvalidImport, field, column)
+		}
+		requested[strings.ToLower(strings.TrimSpace(column))] = field
+	}
+
+	for i, title := range header {
+		title = strings.TrimSpace(title)
+		columns[i].Header = title
+
+		field := ""
+		if len(mapping) > 0 {
+			field = requested[strings.ToLower(title)]
+			delete(requested, strings.ToLower(title))
+		} else {
+			field = normalizeHeader(title)
+			if alias, ok := importHeaderAliases[field]; ok {
+				field = alias
+			}
+			if !isImportField(field) {
+				field = ""
+			}
+		}
+		if field == "" {
+			continue
+		}
+
+		if other, ok := used[field]; ok {
+			return nil, nil, fmt.Errorf("%w: columns %q and %q both map to %s", ErrInvalidImport, other, title, field)
+		}
+		used[field] = title
+		columns[i].Field = field
+		effective[title] = field
+	}
+
+	if len(requested) > 0 {
+		missing := []string{}
+		for column := range requested {
+			missing = append(missing, column)
+		}
+		sort.Strings(missing)
+		return nil, nil, fmt.Errorf("%w: mapped columns not in the file: %s", ErrInvalidImport, strings.Join(missing, ", "))
+	}
+	if used["sku"] == "" && (used["name"] == "" || used["brand"] == "") {
+		return nil, nil, fmt.Errorf("%w: a sku column or both name and brand columns are required to match perfumes", ErrInvalidImport)
+	}
+
+	return columns, effective, nil
+}
+
+// blankRow reports whether every cell of a row is empty
+func blankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// StartPerfumeImport checks the file and its columns, records the job and imports the rows in the background.
+// Poll GetImportJob for progress.
+func StartPerfumeImport(request model.PerfumeImportRequest) (*model.ImportJob, error) {
+	format, rows, err := readSpreadsheet(request.Content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidImport)
+	}
+
+	columns, mapping, err := importColumns(rows[0], request.Mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, row := range rows[1:] {
+		if !blankRow(row) {
+			total++
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: the file has no rows below the header", ErrInvalidImport)
+	}
+	if total > MaxImportRows() {
+		return nil, fmt.Errorf("%w: %d rows is more than the limit of %d", ErrInvalidImport, total, MaxImportRows())
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	job := &model.ImportJob{
+		JobID:     primitive.NewObjectID(),
+		FileName:  request.FileName,
+		Format:    format,
+		DryRun:    request.DryRun,
+		Mapping:   mapping,
+		Status:    model.ImportQueued,
+		TotalRows: total,
+		Errors:    []model.ImportRowError{},
+		CreatedBy: request.Author,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	jobCollection := config.MongoDB.Collection("import_jobs")
+	if _, err := jobCollection.InsertOne(context.TODO(), job); err != nil {
+		return nil, fmt.Errorf("failed to create import job: %v", err)
+	}
+
+	// The job gets its own copy so the caller can return the queued state safely
+	running := *job
+	go runPerfumeImport(&running, columns, rows[1:])
+
+	return job, nil
+}
+
+// runPerfumeImport imports the rows one by one and saves the progress regularly
+func runPerfumeImport(job *model.ImportJob, columns []importColumn, rows [][]string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Import job %s crashed: %v", job.JobID.Hex(), r)
+			job.Status = model.ImportFailed
+			job.Error = fmt.Sprint(r)
+			finishImportJob(job)
+		}
+	}()
+
+	job.Status = model.ImportRunning
+	saveImportProgress(job)
+
+	seen := map[string]int{}
+	lastSave := time.Now()
+	for i, row := range rows {
+		if blankRow(row) {
+			continue
+		}
+
+		// Row 1 is the header
+		action, rowErrors := importPerfumeRow(job, columns, row, i+2, seen)
+		job.ProcessedRows++
+		switch {
+		case len(rowErrors) > 0:
+			job.Failed++
+			for _, rowError := range rowErrors {
+				if len(job.Errors) >= maxImportErrors {
+					job.ErrorsTruncated = true
+					break
+				}
+				job.Errors = append(job.Errors, rowError)
+			}
+		case action == "create":
+			job.Created++
+		case action == "update":
+			job.Updated++
+		case action == "unchanged":
+			job.Unchanged++
+		}
+
+		if job.ProcessedRows%25 == 0 || time.Since(lastSave) > 2*time.Second {
+			saveImportProgress(job)
+			lastSave = time.Now()
+		}
+	}
+
+	job.Status = model.ImportCompleted
+	finishImportJob(job)
+}
+
+func finishImportJob(job *model.ImportJob) {
+	finishedAt := primitive.NewDateTimeFromTime(time.Now())
+	job.FinishedAt = &finishedAt
+	saveImportProgress(job)
+}
+
+// saveImportProgress writes the counters and errors of a running job
+func saveImportProgress(job *model.ImportJob) {
+	jobCollection := config.MongoDB.Collection("import_jobs")
+
+	job.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+	_, err := jobCollection.UpdateOne(context.TODO(), bson.M{"_id": job.JobID}, bson.M{"$set": bson.M{
+		"status":           job.Status,
+		"processed_rows":   job.ProcessedRows,
+		"created":          job.Created,
+		"updated":          job.Updated,
+		"unchanged":        job.Unchanged,
+		"failed":           job.Failed,
+		"errors":           job.Errors,
+		"errors_truncated": job.ErrorsTruncated,
+		"error":            job.Error,
+		"updated_at":       job.UpdatedAt,
+		"finished_at":      job.FinishedAt,
+	}})
+	if err != nil {
+		log.Printf("Failed to save progress of import job %s: %v", job.JobID.Hex(), err)
+	}
+}
+
+// importPerfumeRow validates one row and creates or updates its perfume, unless the job is a dry run.
+// It returns create, update or unchanged, or the problems that kept the row from being imported.
+func importPerfumeRow(job *model.ImportJob, columns []importColumn, row []string, rowNumber int, seen map[string]int) (string, []model.ImportRowError) {
+	values := map[string]string{}
+	headers := map[string]string{}
+	for i, column := range columns {
+		if column.Field == "" || i >= len(row) {
+			continue
+		}
+		headers[column.Field] = column.Header
+		if value := strings.TrimSpace(row[i]); value != "" {
+			values[column.Field] = value
+		}
+	}
+
+	var err error
+	var rowErrors []model.ImportRowError
+	fail := func(field, message string) {
+		rowErrors = append(rowErrors, model.ImportRowError{Row: rowNumber, Column: headers[field], Value: values[field], Message: message})
+	}
+
+	// Plain fields go through the same validators as PATCH, empty cells leave the field as it is
+	set := bson.M{}
+	for _, column := range columns {
+		validate, ok := perfumePatchFields[column.Field]
+		value, filled := values[column.Field]
+		if !ok || !filled {
+			continue
+		}
+		normalized, err := validate(value)
+		if err != nil {
+			fail(column.Field, err.Error())
+			continue
+		}
+		set[column.Field] = normalized
+	}
+
+	// The same perfume may only appear once per file
+	key := ""
+	if sku := values["sku"]; sku != "" {
+		key = "sku:" + sku
+	} else if values["name"] != "" && values["brand"] != "" {
+		key = "name:" + strings.ToLower(values["brand"]) + "\x00" + strings.ToLower(values["name"])
+	} else {
+		fail("", "a sku or both name and brand are required")
+	}
+	if key != "" {
+		if first, ok := seen[key]; ok {
+			fail("", fmt.Sprintf("the same perfume is already in row %d", first))
+			key = ""
+		} else {
+			seen[key] = rowNumber
+		}
+	}
+
+	lifecycle := model.PerfumeStatusRequest{Status: strings.ToLower(values["status"])}
+	publishAt, hasPublishAt := values["publish_at"]
+	if hasPublishAt {
+		if lifecycle.PublishAt, err = parseImportDate(publishAt); err != nil {
+			fail("publish_at", err.Error())
+		}
+	}
+	unpublishAt, hasUnpublishAt := values["unpublish_at"]
+	if hasUnpublishAt {
+		if lifecycle.UnpublishAt, err = parseImportDate(unpublishAt); err != nil {
+			fail("unpublish_at", err.Error())
+		}
+	}
+	hasLifecycle := lifecycle.Status != "" || hasPublishAt || hasUnpublishAt
+
+	var categoryIDs []primitive.ObjectID
+	if value, ok := values["category_ids"]; ok {
+		ids, err := ParseCategoryIDs(strings.Split(value, ","))
+		if err != nil {
+			fail("category_ids", err.Error())
+		}
+		categoryIDs = ids
+	}
+
+	imageURLs, err := parseImageURLs(values["image_urls"])
+	if err != nil {
+		fail("image_urls", err.Error())
+	}
+
+	// Find the perfume the row updates, if there is one
+	var existing *model.Perfume
+	if key != "" {
+		existing, err = findImportMatch(values)
+		if err != nil {
+			if values["sku"] != "" {
+				fail("sku", err.Error())
+			} else {
+				fail("name", err.Error())
+			}
+		}
+	}
+
+	if existing == nil {
+		if values["name"] == "" || values["brand"] == "" {
+			fail("", "name and brand are required to create a perfume")
+		}
+		if len(rowErrors) > 0 {
+			return "", rowErrors
+		}
+		if field, err := createImportedPerfume(job.DryRun, set, lifecycle, categoryIDs, imageURLs); err != nil {
+			fail(field, err.Error())
+			return "", rowErrors
+		}
+		return "create", nil
+	}
+
+	// Work out what the row changes on the existing perfume
+	current := perfumeSnapshot(existing)
+	for field, value := range set {
+		if current[field] == value {
+			delete(set, field)
+		}
+	}
+	if hasLifecycle {
+		if lifecycle.Status == "" {
+			lifecycle.Status = existing.Status
+			if lifecycle.Status == "" {
+				lifecycle.Status = model.StatusPublished
+			}
+		}
+		if !hasPublishAt {
+			lifecycle.PublishAt = existing.PublishAt
+		}
+		if !hasUnpublishAt {
+			lifecycle.UnpublishAt = existing.UnpublishAt
+		}
+		check := model.Perfume{Status: lifecycle.Status, PublishAt: lifecycle.PublishAt, UnpublishAt: lifecycle.UnpublishAt}
+		if err := NormalizePerfumeLifecycle(&check); err != nil {
+			fail("status", err.Error())
+		}
+		hasLifecycle = check.Status != existing.Status || !sameDate(check.PublishAt, existing.PublishAt) || !sameDate(check.UnpublishAt, existing.UnpublishAt)
+		lifecycle = model.PerfumeStatusRequest{Status: check.Status, PublishAt: check.PublishAt, UnpublishAt: check.UnpublishAt}
+	}
+	if categoryIDs != nil && sameObjectIDs(categoryIDs, existing.CategoryIDs) {
+		categoryIDs = nil
+	}
+	// Images are only added to perfumes that have none, so importing the same file twice does not duplicate them
+	if len(existing.Images) > 0 || existing.Image != "" {
+		imageURLs = nil
+	}
+
+	if len(rowErrors) > 0 {
+		return "", rowErrors
+	}
+	if len(set) == 0 && !hasLifecycle && categoryIDs == nil && len(imageURLs) == 0 {
+		return "unchanged", nil
+	}
+	if job.DryRun {
+		return "update", nil
+	}
+
+	if field, err := updateImportedPerfume(job, existing, set, hasLifecycle, lifecycle, categoryIDs, imageURLs); err != nil {
+		fail(field, err.Error())
+		return "", rowErrors
+	}
+	return "update", nil
+}
+
+// updateImportedPerfume applies everything a row changes on a perfume in one write, so a row is either
+// imported completely or not at all. Images are downloaded and stored first and deleted again when the
+// write fails. The write only goes through if the perfume is still as the row was checked against.
+// On failure it returns the field the error belongs to.
+func updateImportedPerfume(job *model.ImportJob, existing *model.Perfume, set bson.M, hasLifecycle bool, lifecycle model.PerfumeStatusRequest, categoryIDs []primitive.ObjectID, imageURLs []string) (string, error) {
+	var uploaded []model.PerfumeImage
+	if len(imageURLs) > 0 {
+		uploads, err := fetchImportImages(imageURLs)
+		if err != nil {
+			return "image_urls", err
+		}
+		uploaded, err = uploadPerfumeImages(existing.PerfumeID, uploads)
+		if err != nil {
+			return "image_urls", err
+		}
+		// Only perfumes without images get the imported ones, so they are the whole gallery
+		uploaded = normalizePerfumeImages(uploaded)
+		set["images"] = uploaded
+		set["image"] = primaryImageURL(uploaded)
+	}
+	if hasLifecycle {
+		set["status"] = lifecycle.Status
+		set["publish_at"] = lifecycle.PublishAt
+		set["unpublish_at"] = lifecycle.UnpublishAt
+	}
+	if categoryIDs != nil {
+		set["category_ids"] = categoryIDs
+	}
+
+	err := writePerfumeFields(existing.PerfumeID, set, &existing.Version, job.CreatedBy, model.RevisionImport, nil)
+	if err != nil {
+		if len(uploaded) > 0 {
+			deleteStoredImages(uploaded, "import row failed")
+		}
+		if errors.Is(err, ErrVersionConflict) {
+			return "", fmt.Errorf("the perfume changed while the row was imported, nothing was updated, import the row again")
+		}
+		return "", err
+	}
+	return "", nil
+}
+
+// createImportedPerfume creates the perfume of a row, fetching its images first. With dryRun it only
+// validates the lifecycle. On failure it returns the field the error belongs to.
+func createImportedPerfume(dryRun bool, set bson.M, lifecycle model.PerfumeStatusRequest, categoryIDs []primitive.ObjectID, imageURLs []string) (string, error) {
+	text := func(field string) string {
+		value, _ := set[field].(string)
+		return value
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	perfume := &model.Perfume{
+		PerfumeID:   primitive.NewObjectID(),
+		Name:        text("name"),
+		Brand:       text("brand"),
+		SKU:         text("sku"),
+		Types:       text("types"),
+		Categories:  text("categories"),
+		Sizes:       text("sizes"),
+		Price:       text("price"),
+		Description: text("description"),
+		Stock:       text("stock"),
+		Weight:      text("weight"),
+		Status:      lifecycle.Status,
+		PublishAt:   lifecycle.PublishAt,
+		UnpublishAt: lifecycle.UnpublishAt,
+		CategoryIDs: categoryIDs,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if perfume.CategoryIDs == nil {
+		perfume.CategoryIDs = []primitive.ObjectID{}
+	}
+
+	// New perfumes start as drafts unless the row has a status
+	if err := NormalizePerfumeLifecycle(perfume); err != nil {
+		return "status", err
+	}
+	if dryRun {
+		return "", nil
+	}
+
+	if len(imageURLs) == 0 {
+		return "", CreatePerfumeWithImageURL(perfume)
+	}
+	uploads, err := fetchImportImages(imageURLs)
+	if err != nil {
+		return "image_urls", err
+	}
+	return "", CreatePerfume(perfume, uploads)
+}
+
+// findImportMatch looks up the perfume a row refers to by SKU, or by brand and name ignoring case
+func findImportMatch(values map[string]string) (*model.Perfume, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	if sku := values["sku"]; sku != "" {
+		var perfume model.Perfume
+		err := perfumeCollection.FindOne(context.TODO(), bson.M{"sku": sku}).Decode(&perfume)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to find perfume: %v", err)
+		}
+		if perfume.DeletedAt != nil {
+			return nil, fmt.Errorf("the sku belongs to a deleted perfume, restore it or use another sku")
+		}
+		return &perfume, nil
+	}
+
+	opts := options.Find().SetCollation(caseInsensitive).SetLimit(2)
+	cursor, err := perfumeCollection.Find(context.TODO(), notDeleted(bson.M{"brand": values["brand"], "name": values["name"]}), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find perfume: %v", err)
+	}
+	var perfumes []model.Perfume
+	if err := cursor.All(context.TODO(), &perfumes); err != nil {
+		return nil, fmt.Errorf("failed to decode perfume: %v", err)
+	}
+
+	switch len(perfumes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &perfumes[0], nil
+	}
+	return nil, fmt.Errorf("more than one perfume has this brand and name, add a sku column to tell them apart")
+}
+
+// parseImportDate reads RFC 3339 timestamps, plain dates and times, and Excel date serial numbers.
+// Values without a time zone are UTC.
+func parseImportDate(value string) (*primitive.DateTime, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			date := primitive.NewDateTimeFromTime(parsed)
+			return &date, nil
+		}
+	}
+
+	// XLSX cells formatted as dates hold the days since 1899-12-30
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		parsed := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC).Add(time.Duration(serial * float64(24*time.Hour))).Round(time.Second)
+		date := primitive.NewDateTimeFromTime(parsed)
+		return &date, nil
+	}
+
+	return nil, fmt.Errorf("must be a date such as 2024-04-01 or 2024-04-01T08:00:00Z")
+}
+
+// parseImageURLs splits a cell with one or more http(s) URLs separated by |, commas or spaces
+func parseImageURLs(value string) ([]string, error) {
+	urls := strings.FieldsFunc(value, func(r rune) bool {
+		return r == '|' || r == ',' || r == ' ' || r == '\n'
+	})
+	for _, imageURL := range urls {
+		parsed, err := url.Parse(imageURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%q is not an http or https URL", imageURL)
+		}
+	}
+	return urls, nil
+}
+
+// errPrivateAddress is returned for image URLs that lead into the server's own network
+var errPrivateAddress = errors.New("image URLs must not point to loopback, private or link-local addresses")
+
+// publicAddress tells whether an address is on the public internet. Import files come from users, their
+// URLs must not reach the server itself, the cloud metadata service or other machines on the private network.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkImportDial refuses connections to addresses that are not public. It runs after DNS resolution,
+// for every connection including those of redirects, so a host name cannot resolve its way past it.
+func checkImportDial(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// checkImportRedirect follows up to 5 redirects to other http(s) URLs. Hosts that are IP addresses are
+// checked here for a clear error, the dialer checks every other host.
+func checkImportRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 5 {
+		return errors.New("stopped after 5 redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirected to %q, which is not an http or https URL", req.URL.String())
+	}
+	if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !publicAddress(ip) {
+		return fmt.Errorf("%w: redirected to %s", errPrivateAddress, ip)
+	}
+	return nil
+}
+
+// importImageClient downloads the images of import files, only from public addresses and without a proxy,
+// which the dialer would otherwise check instead of the image host
+var importImageClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: checkImportDial}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: checkImportRedirect,
+}
+
+// fetchImportImages downloads the images of a row, they go through the usual image pipeline when saved
+func fetchImportImages(urls []string) ([]model.FumeImgUpload, error) {
+	uploads := []model.FumeImgUpload{}
+	for _, imageURL := range urls {
+		resp, err := importImageClient.Get(imageURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch image: %w", err)
+		}
+		content, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageBytes()+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch image %s: %v", imageURL, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch image %s: %s", imageURL, resp.Status)
+		}
+		if int64(len(content)) > MaxImageBytes() {
+			return nil, fmt.Errorf("image %s is larger than %d bytes", imageURL, MaxImageBytes())
+		}
+
+		parsed, _ := url.Parse(imageURL)
+		uploads = append(uploads, model.FumeImgUpload{
+			FileName:    path.Base(parsed.Path),
+			Content:     content,
+			ContentType: resp.Header.Get("Content-Type"),
+		})
+	}
+
+	return uploads, nil
+}
+
+// sameDate compares two optional dates
+func sameDate(a, b *primitive.DateTime) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// sameObjectIDs compares two ID lists ignoring order
+func sameObjectIDs(a, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[primitive.ObjectID]bool{}
+	for _, id := range b {
+		set[id] = true
+	}
+	for _, id := range a {
+		if !set[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetImportJob returns an import job with its progress and row errors
+func GetImportJob(id string) (*model.ImportJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid import job ID format: %v", err)
+	}
+
+	jobCollection := config.MongoDB.Collection("import_jobs")
+
+	var job model.ImportJob
+	if err := jobCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&job); err != nil {
+		return nil, fmt.Errorf("failed to find import job: %v", err)
+	}
+
+	return &job, nil
+}
+
+// GetImportJobs lists the 50 most recent import jobs without their row errors
+func GetImportJobs() ([]model.ImportJob, error) {
+	jobCollection := config.MongoDB.Collection("import_jobs")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(50).
+		SetProjection(bson.M{"errors": 0})
+	cursor, err := jobCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch import jobs: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	jobs := []model.ImportJob{}
+	if err = cursor.All(context.Background(), &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode import jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// FailInterruptedImportJobs marks jobs that stopped making progress, because the instance running them
+// was stopped, as failed. Rows imported before that are kept, the file can simply be imported again.
+func FailInterruptedImportJobs(ctx context.Context) (int64, error) {
+	jobCollection := config.MongoDB.Collection("import_jobs")
+
+	now := time.Now()
+	result, err := jobCollection.UpdateMany(ctx, bson.M{
+		"status":     bson.M{"$in": bson.A{model.ImportQueued, model.ImportRunning}},
+		"updated_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now.Add(-importInterruptedAfter))},
+	}, bson.M{"$set": bson.M{
+		"status":      model.ImportFailed,
+		"error":       "interrupted, the server stopped before the import finished",
+		"updated_at":  primitive.NewDateTimeFromTime(now),
+		"finished_at": primitive.NewDateTimeFromTime(now),
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted import jobs: %v", err)
+	}
+
+	return result.ModifiedCount, nil
+}
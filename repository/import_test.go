@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"140.82.112.3", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.0.0.8", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false}, // Cloud metadata service
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestFetchImportImagesRefusesPrivateAddresses(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	}))
+	defer server.Close()
+
+	// The test server listens on 127.0.0.1, and localhost resolves there
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for _, imageURL := range []string{server.URL + "/logo.png", "http://localhost:" + port + "/logo.png"} {
+		if _, err := fetchImportImages([]string{imageURL}); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("fetchImportImages(%s): %v, want errPrivateAddress", imageURL, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("the server received %d requests, want none", requests)
+	}
+}
+
+func TestCheckImportRedirect(t *testing.T) {
+	tests := []struct {
+		target string
+		ok     bool
+	}{
+		{"https://cdn.example.com/logo.png", true},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[::1]:8080/admin", false},
+		{"http://10.0.0.8/logo.png", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		if err := checkImportRedirect(req, []*http.Request{{}}); (err == nil) != tt.ok {
+			t.Errorf("checkImportRedirect(%s) = %v, want ok %v", tt.target, err, tt.ok)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://cdn.example.com/logo.png", nil)
+	if err := checkImportRedirect(req, make([]*http.Request, 5)); err == nil {
+		t.Error("checkImportRedirect followed a sixth redirect")
+	}
+}
+
+func TestImportPerfumeRowIsAtomic(t *testing.T) {
+	testDatabase(t)
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	perfumeID := primitive.NewObjectID()
+	_, err := config.MongoDB.Collection("perfumes").InsertOne(context.Background(), bson.M{
+		"_id": perfumeID, "name": "Sauvage", "brand": "Dior", "sku": "DIOR-SAUVAGE", "price": "100000", "stock": "3",
+		"status": model.StatusDraft, "category_ids": bson.A{}, "version": 1, "created_at": now, "updated_at": now,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert perfume: %v", err)
+	}
+
+	job := &model.ImportJob{JobID: primitive.NewObjectID()}
+	columns := []importColumn{{"SKU", "sku"}, {"Price", "price"}, {"Status", "status"}, {"Images", "image_urls"}}
+	current := func() model.Perfume {
+		t.Helper()
+		var perfume model.Perfume
+		if err := config.MongoDB.Collection("perfumes").FindOne(context.Background(), bson.M{"_id": perfumeID}).Decode(&perfume); err != nil {
+			t.Fatalf("failed to fetch perfume: %v", err)
+		}
+		return perfume
+	}
+
+	// The image cannot be fetched, so neither the price nor the status change
+	row := []string{"DIOR-SAUVAGE", "120000", "published", "http://127.0.0.1/sauvage.jpg"}
+	result, rowErrors := importPerfumeRow(job, columns, row, 2, map[string]int{})
+	if result != "" || len(rowErrors) != 1 || rowErrors[0].Column != "Images" {
+		t.Fatalf("importPerfumeRow = %q, %+v, want one error on Images", result, rowErrors)
+	}
+	if perfume := current(); perfume.Price != "100000" || perfume.Status != model.StatusDraft || perfume.Version != 1 {
+		t.Errorf("perfume after a failed row: price %s, status %s, version %d, want it unchanged", perfume.Price, perfume.Status, perfume.Version)
+	}
+
+	// Without images everything else is applied in one go
+	row = []string{"DIOR-SAUVAGE", "120000", "published", ""}
+	if result, rowErrors = importPerfumeRow(job, columns, row, 3, map[string]int{}); result != "update" || len(rowErrors) != 0 {
+		t.Fatalf("importPerfumeRow = %q, %+v, want update", result, rowErrors)
+	}
+	if perfume := current(); perfume.Price != "120000" || perfume.Status != model.StatusPublished || perfume.Version != 2 {
+		t.Errorf("perfume after the row: price %s, status %s, version %d, want 120000, published, 2", perfume.Price, perfume.Status, perfume.Version)
+	}
+}
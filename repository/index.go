@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GilangAndhika/elfume/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the repositories rely on, it is safe to run on every start
+func EnsureIndexes() error {
+	indexes := map[string][]mongo.IndexModel{
+		"idempotency_keys": {
+			// Expired keys are removed by MongoDB's TTL monitor
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"categories": {
+			{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+			{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+		},
+		"perfumes": {
+			{Keys: bson.D{{Key: "category_ids", Value: 1}}},
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "unpublish_at", Value: 1}}},
+			{Keys: bson.D{{Key: "slug", Value: 1}}},
+			{Keys: bson.D{{Key: "tags", Value: 1}}},
+			// SKUs are optional, only the ones that are set must be unique
+			{Keys: bson.D{{Key: "sku", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"sku": bson.M{"$gt": ""}})},
+			// Imports match perfumes by brand and name ignoring case
+			{Keys: bson.D{{Key: "brand", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetCollation(caseInsensitive)},
+		},
+		"perfume_slugs": {
+			{Keys: bson.D{{Key: "perfume_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+		"users": {
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		},
+		"uploads": {
+			// Upload records are kept for a week after their URL expired
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+		},
+		"perfume_revisions": {
+			{Keys: bson.D{{Key: "perfume_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"import_jobs": {
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"bulk_operations": {
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"carts": {
+			// A customer has one cart and a guest cart is found by its cookie token
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"user_id": bson.M{"$type": "objectId"}})},
+			{Keys: bson.D{{Key: "guest_token", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"guest_token": bson.M{"$type": "string"}})},
+			// Carts expire when nobody touched them for their TTL
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"orders": {
+			{Keys: bson.D{{Key: "order_number", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"payments": {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "reference", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"shipping_quotes": {
+			// Quotes are only needed until checkout, MongoDB removes them once they expired
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"shipments": {
+			// An order ships as one parcel and an AWB belongs to one parcel
+			{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "courier", Value: 1}, {Key: "awb", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// The tracking job picks the shipments that are due
+			{Keys: bson.D{{Key: "next_check_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+		"regions": {
+			// Cascading dropdowns list the children of a region by name
+			{Keys: bson.D{{Key: "parent", Value: 1}, {Key: "name", Value: 1}}},
+			{Keys: bson.D{{Key: "level", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		"addresses": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+			// A customer has at most one default address
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"is_default": true})},
+		},
+		"promotions": {
+			// Voucher codes are unique, automatic promotions have none
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"code": bson.M{"$type": "string"}})},
+			{Keys: bson.D{{Key: "active", Value: 1}, {Key: "ends_at", Value: 1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"promotion_usages": {
+			{Keys: bson.D{{Key: "promotion_id", Value: 1}}},
+		},
+		"flash_sales": {
+			{Keys: bson.D{{Key: "closed", Value: 1}, {Key: "ends_at", Value: 1}}},
+			{Keys: bson.D{{Key: "starts_at", Value: 1}}},
+		},
+		"image_cleanup": {
+			{Keys: bson.D{{Key: "status", Value: 1}}},
+		},
+	}
+
+	for collectionName, models := range indexes {
+		_, err := config.MongoDB.Collection(collectionName).Indexes().CreateMany(context.TODO(), models)
+		if err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %v", collectionName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidLifecycle is returned for unknown statuses and inconsistent publish dates
+var ErrInvalidLifecycle = errors.New("invalid lifecycle")
+
+// NormalizePerfumeLifecycle validates the status and publish dates of a perfume and settles the status
+// against the current time: an empty status becomes draft, a scheduled perfume whose publish_at has
+// passed is published, and publishing with a future publish_at schedules it instead.
+func NormalizePerfumeLifecycle(perfume *model.Perfume) error {
+	now := time.Now()
+
+	if perfume.PublishAt != nil && perfume.UnpublishAt != nil && !perfume.UnpublishAt.Time().After(perfume.PublishAt.Time()) {
+		return fmt.Errorf("%w: unpublish_at must be after publish_at", ErrInvalidLifecycle)
+	}
+
+	switch perfume.Status {
+	case "", model.StatusDraft:
+		perfume.Status = model.StatusDraft
+	case model.StatusScheduled:
+		if perfume.PublishAt == nil {
+			return fmt.Errorf("%w: publish_at is required to schedule a perfume", ErrInvalidLifecycle)
+		}
+		if !perfume.PublishAt.Time().After(now) {
+			perfume.Status = model.StatusPublished
+		}
+	case model.StatusPublished:
+		if perfume.PublishAt == nil {
+			publishAt := primitive.NewDateTimeFromTime(now)
+			perfume.PublishAt = &publishAt
+		} else if perfume.PublishAt.Time().After(now) {
+			perfume.Status = model.StatusScheduled
+		}
+	case model.StatusDiscontinued:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidLifecycle, perfume.Status)
+	}
+
+	// A perfume whose unpublish_at already passed ends up discontinued right away
+	if perfume.Status == model.StatusPublished && perfume.UnpublishAt != nil && !perfume.UnpublishAt.Time().After(now) {
+		perfume.Status = model.StatusDiscontinued
+	}
+
+	return nil
+}
+
+// published adds the conditions for perfumes the public may see to a filter: published and not
+// past unpublish_at, or scheduled with a publish_at that passed before the scheduler got to it.
+// Perfumes created before statuses existed have none and count as published.
+func published(filter bson.M, now time.Time) bson.M {
+	nowDate := primitive.NewDateTimeFromTime(now)
+	filter["$and"] = bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{"status": bson.M{"$in": bson.A{model.StatusPublished, nil}}},
+			bson.M{"status": model.StatusScheduled, "publish_at": bson.M{"$lte": nowDate}},
+		}},
+		bson.M{"$or": bson.A{
+			bson.M{"unpublish_at": nil},
+			bson.M{"unpublish_at": bson.M{"$gt": nowDate}},
+		}},
+	}
+	return filter
+}
+
+// catalogue is the filter of public perfume listings: not deleted, not archived and published
+func catalogue(filter bson.M) bson.M {
+	return published(listed(filter), time.Now())
+}
+
+// IsPerfumePublic reports whether a perfume would show up in the public catalogue right now
+func IsPerfumePublic(perfume *model.Perfume) bool {
+	if perfume.DeletedAt != nil {
+		return false
+	}
+
+	now := time.Now()
+	if perfume.UnpublishAt != nil && !perfume.UnpublishAt.Time().After(now) {
+		return false
+	}
+	switch perfume.Status {
+	case "", model.StatusPublished:
+		return true
+	case model.StatusScheduled:
+		return perfume.PublishAt != nil && !perfume.PublishAt.Time().After(now)
+	}
+	return false
+}
+
+// UpdatePerfumeStatus moves a perfume to another lifecycle status and returns the updated perfume
+func UpdatePerfumeStatus(id string, request model.PerfumeStatusRequest, expectedVersion *int64) (*model.Perfume, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+
+	lifecycle := model.Perfume{Status: request.Status, PublishAt: request.PublishAt, UnpublishAt: request.UnpublishAt}
+	if request.Status == "" {
+		return nil, fmt.Errorf("%w: status is required", ErrInvalidLifecycle)
+	}
+	if err := NormalizePerfumeLifecycle(&lifecycle); err != nil {
+		return nil, err
+	}
+
+	// Get database connection
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	set := bson.M{"status": lifecycle.Status, "updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	unset := bson.M{}
+	if lifecycle.PublishAt != nil {
+		set["publish_at"] = lifecycle.PublishAt
+	} else {
+		unset["publish_at"] = ""
+	}
+	if lifecycle.UnpublishAt != nil {
+		set["unpublish_at"] = lifecycle.UnpublishAt
+	} else {
+		unset["unpublish_at"] = ""
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	base := notDeleted(bson.M{"_id": objID})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var perfume model.Perfume
+	err = perfumeCollection.FindOneAndUpdate(context.TODO(), versionFilter(base, expectedVersion), update, opts).Decode(&perfume)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, versionMissError(perfumeCollection, base, fmt.Errorf("perfume not found"))
+		}
+		return nil, fmt.Errorf("failed to update perfume status: %v", err)
+	}
+
+	return &perfume, nil
+}
+
+// GetPerfumesByStatus lists the perfumes in a lifecycle status, including archived ones
+func GetPerfumesByStatus(status string) ([]model.Perfume, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	filter := notDeleted(bson.M{"status": status})
+	switch status {
+	case model.StatusPublished:
+		// Perfumes created before statuses existed are published
+		filter["status"] = bson.M{"$in": bson.A{model.StatusPublished, nil}}
+	case model.StatusDraft, model.StatusScheduled, model.StatusDiscontinued:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidLifecycle, status)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "publish_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := perfumeCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	perfumes := []model.Perfume{}
+	if err = cursor.All(context.Background(), &perfumes); err != nil {
+		return nil, fmt.Errorf("failed to decode perfumes: %v", err)
+	}
+
+	return perfumes, nil
+}
+
+// RunPerfumeScheduler publishes scheduled perfumes whose publish_at has passed and discontinues
+// published ones whose unpublish_at has passed. The status in each filter makes every transition
+// happen once, even when several instances run the scheduler at the same moment.
+func RunPerfumeScheduler(ctx context.Context) (publishedCount int64, discontinuedCount int64, err error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+
+	// Scheduled perfumes that are already past unpublish_at skip straight to discontinued
+	result, err := perfumeCollection.UpdateMany(ctx, bson.M{
+		"status":       model.StatusScheduled,
+		"publish_at":   bson.M{"$lte": now},
+		"unpublish_at": bson.M{"$lte": now},
+	}, bson.M{"$set": bson.M{"status": model.StatusDiscontinued, "updated_at": now}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to discontinue expired scheduled perfumes: %v", err)
+	}
+	discontinuedCount += result.ModifiedCount
+
+	result, err = perfumeCollection.UpdateMany(ctx, bson.M{
+		"status":     model.StatusScheduled,
+		"publish_at": bson.M{"$lte": now},
+	}, bson.M{"$set": bson.M{"status": model.StatusPublished, "updated_at": now}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return 0, discontinuedCount, fmt.Errorf("failed to publish scheduled perfumes: %v", err)
+	}
+	publishedCount = result.ModifiedCount
+
+	result, err = perfumeCollection.UpdateMany(ctx, bson.M{
+		"status":       model.StatusPublished,
+		"unpublish_at": bson.M{"$lte": now},
+	}, bson.M{"$set": bson.M{"status": model.StatusDiscontinued, "updated_at": now}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return publishedCount, discontinuedCount, fmt.Errorf("failed to discontinue perfumes: %v", err)
+	}
+	discontinuedCount += result.ModifiedCount
+
+	return publishedCount, discontinuedCount, nil
+}
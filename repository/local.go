@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalImageStore keeps images on the local filesystem, served by Fiber's static handler
+type LocalImageStore struct {
+	Dir     string // Directory the files are written to
+	BaseURL string // URL prefix the directory is served under (e.g. /uploads or https://cdn.example.com/uploads)
+}
+
+// NewLocalImageStore reads LOCAL_STORAGE_DIR and LOCAL_STORAGE_URL, defaulting to ./uploads served at /uploads
+func NewLocalImageStore() *LocalImageStore {
+	dir := os.Getenv("LOCAL_STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	baseURL := os.Getenv("LOCAL_STORAGE_URL")
+	if baseURL == "" {
+		baseURL = "/uploads"
+	}
+
+	return &LocalImageStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// path resolves a key inside the storage directory, rejecting keys that escape it
+func (s *LocalImageStore) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" {
+		return "", errors.New("invalid image key")
+	}
+	return filepath.Join(s.Dir, cleaned), nil
+}
+
+// Save writes the file to disk and returns its public URL
+func (s *LocalImageStore) Save(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write image: %v", err)
+	}
+
+	return s.BaseURL + "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+key)), "/"), nil
+}
+
+// Delete removes the file from disk, a missing file is not an error
+func (s *LocalImageStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete image: %v", err)
+	}
+
+	return nil
+}
+
+// List walks the storage directory and returns every file key
+func (s *LocalImageStore) List(ctx context.Context) ([]string, error) {
+	keys := []string{}
+	err := filepath.WalkDir(s.Dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		key, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(key))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+
+	return keys, nil
+}
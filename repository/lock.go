@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// instanceID identifies this process as the owner of locks
+var instanceID = func() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex())
+}()
+
+// AcquireLock takes the named lock for ttl so only one API instance runs a job at a time.
+// It returns false when another instance holds a lock that has not expired yet.
+// The owner may take its own lock again, which extends it.
+func AcquireLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	lockCollection := config.MongoDB.Collection("locks")
+
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+			bson.M{"owner": instanceID},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"owner":       instanceID,
+		"acquired_at": primitive.NewDateTimeFromTime(now),
+		"expires_at":  primitive.NewDateTimeFromTime(now.Add(ttl)),
+	}}
+
+	// When the lock is held by someone else the filter does not match and the upsert
+	// collides with the existing _id, which means we did not get the lock
+	_, err := lockCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to acquire lock %s: %v", name, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+)
+
+// MidtransProvider takes payments through Midtrans Snap: bank transfer and virtual accounts, e-wallets and cards.
+// Payments are created with the Snap API and confirmed by HTTP notifications signed with the server key.
+type MidtransProvider struct {
+	ServerKey       string
+	SnapURL         string // Root of the Snap API, https://app.sandbox.midtrans.com unless in production or pointed at a fake server
+	NotificationURL string // Sent as X-Override-Notification, the URL configured in the dashboard is used when empty
+	FinishURL       string // Where Snap sends the customer after paying
+	Expiry          time.Duration
+	HTTPClient      *http.Client
+}
+
+// NewMidtransProvider reads the MIDTRANS_* environment variables
+func NewMidtransProvider() (*MidtransProvider, error) {
+	snapURL := "https://app.sandbox.midtrans.com"
+	if production, _ := strconv.ParseBool(os.Getenv("MIDTRANS_PRODUCTION")); production {
+		snapURL = "https://app.midtrans.com"
+	}
+
+	provider := &MidtransProvider{
+		ServerKey:       os.Getenv("MIDTRANS_SERVER_KEY"),
+		SnapURL:         strings.TrimSuffix(envString("MIDTRANS_SNAP_URL", snapURL), "/"),
+		NotificationURL: os.Getenv("MIDTRANS_NOTIFICATION_URL"),
+		FinishURL:       os.Getenv("MIDTRANS_FINISH_URL"),
+		Expiry:          envDuration("PAYMENT_EXPIRY", 24*time.Hour),
+		HTTPClient:      &http.Client{Timeout: envDuration("MIDTRANS_TIMEOUT", 30*time.Second)},
+	}
+
+	// Validate Midtrans credentials
+	if provider.ServerKey == "" {
+		return nil, errors.New("MIDTRANS_SERVER_KEY is missing in environment variables")
+	}
+
+	return provider, nil
+}
+
+// Name identifies the provider on payment records
+func (m *MidtransProvider) Name() string {
+	return "midtrans"
+}
+
+// PaymentExpiry is how long a customer has to pay
+func (m *MidtransProvider) PaymentExpiry() time.Duration {
+	return m.Expiry
+}
+
+// midtransItem is a line of item_details, Snap requires their total to equal gross_amount
+type midtransItem struct {
+	ID       string `json:"id"`
+	Price    int64  `json:"price"`
+	Quantity int    `json:"quantity"`
+	Name     string `json:"name"`
+}
+
+// CreatePayment creates a Snap transaction and returns its token and payment page
+func (m *MidtransProvider) CreatePayment(ctx context.Context, request PaymentRequest) (*model.PaymentSession, error) {
+	grossAmount := int64(math.Round(request.Amount))
+
+	items := []midtransItem{}
+	var itemTotal int64
+	for _, item := range request.Items {
+		line := midtransItem{ID: item.ID, Price: int64(math.Round(item.Price)), Quantity: item.Quantity, Name: midtransName(item.Name)}
+		items = append(items, line)
+		itemTotal += line.Price * int64(line.Quantity)
+	}
+	// Shipping, discounts and rounding end up in one line so the items add up to the amount charged
+	if difference := grossAmount - itemTotal; difference != 0 {
+		items = append(items, midtransItem{ID: "adjustment", Price: difference, Quantity: 1, Name: "Shipping and discounts"})
+	}
+
+	body := map[string]interface{}{
+		"transaction_details": map[string]interface{}{"order_id": request.Reference, "gross_amount": grossAmount},
+		"item_details":        items,
+		"customer_details": map[string]interface{}{
+			"first_name": request.Customer.Name,
+			"email":      request.Customer.Email,
+			"phone":      request.Customer.Phone,
+		},
+		"expiry": map[string]interface{}{"unit": "minute", "duration": int(math.Ceil(m.Expiry.Minutes()))},
+	}
+	if m.FinishURL != "" {
+		body["callbacks"] = map[string]string{"finish": m.FinishURL}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payment: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.SnapURL+"/snap/v1/transactions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment request: %v", err)
+	}
+	req.SetBasicAuth(m.ServerKey, "")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if m.NotificationURL != "" {
+		req.Header.Set("X-Override-Notification", m.NotificationURL)
+	}
+
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Midtrans: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		var failure struct {
+			ErrorMessages []string `json:"error_messages"`
+		}
+		json.Unmarshal(respBody, &failure)
+		return nil, fmt.Errorf("Midtrans rejected the payment (%d): %s", resp.StatusCode, strings.Join(failure.ErrorMessages, ", "))
+	}
+
+	var session struct {
+		Token       string `json:"token"`
+		RedirectURL string `json:"redirect_url"`
+	}
+	if err := json.Unmarshal(respBody, &session); err != nil || session.Token == "" {
+		return nil, fmt.Errorf("failed to read Midtrans response: %s", respBody)
+	}
+
+	return &model.PaymentSession{Token: session.Token, RedirectURL: session.RedirectURL}, nil
+}
+
+// midtransName shortens item names to the 50 characters Snap accepts
+func midtransName(name string) string {
+	runes := []rune(name)
+	if len(runes) > 50 {
+		return string(runes[:50])
+	}
+	return name
+}
+
+// midtransNotification is the body of an HTTP notification
+type midtransNotification struct {
+	OrderID           string `json:"order_id"`
+	StatusCode        string `json:"status_code"`
+	GrossAmount       string `json:"gross_amount"`
+	SignatureKey      string `json:"signature_key"`
+	TransactionID     string `json:"transaction_id"`
+	TransactionStatus string `json:"transaction_status"`
+	FraudStatus       string `json:"fraud_status"`
+	PaymentType       string `json:"payment_type"`
+}
+
+// MidtransSignature is the signature_key of a notification: SHA-512 of order_id, status_code, gross_amount and the server key
+func MidtransSignature(orderID, statusCode, grossAmount, serverKey string) string {
+	sum := sha512.Sum512([]byte(orderID + statusCode + grossAmount + serverKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyNotification checks the signature of a notification and tells what it means for the payment
+func (m *MidtransProvider) VerifyNotification(body []byte) (*model.PaymentNotification, error) {
+	var notification midtransNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNotification, err)
+	}
+
+	expected := MidtransSignature(notification.OrderID, notification.StatusCode, notification.GrossAmount, m.ServerKey)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(notification.SignatureKey)), []byte(expected)) != 1 {
+		return nil, fmt.Errorf("%w: signature does not match", ErrInvalidNotification)
+	}
+
+	grossAmount, err := strconv.ParseFloat(notification.GrossAmount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid gross_amount %q", ErrInvalidNotification, notification.GrossAmount)
+	}
+
+	return &model.PaymentNotification{
+		Reference:         notification.OrderID,
+		TransactionID:     notification.TransactionID,
+		TransactionStatus: notification.TransactionStatus,
+		FraudStatus:       notification.FraudStatus,
+		PaymentType:       notification.PaymentType,
+		GrossAmount:       grossAmount,
+		Status:            midtransPaymentStatus(notification.TransactionStatus, notification.FraudStatus),
+	}, nil
+}
+
+// midtransPaymentStatus maps a Midtrans transaction status to a payment status. Card payments held for
+// a fraud review stay pending, partial refunds are left to an admin.
+func midtransPaymentStatus(transactionStatus, fraudStatus string) string {
+	switch transactionStatus {
+	case "capture":
+		switch fraudStatus {
+		case "", "accept":
+			return model.PaymentPaid
+		case "deny":
+			return model.PaymentFailed
+		}
+		return model.PaymentPending
+	case "settlement":
+		return model.PaymentPaid
+	case "pending", "authorize":
+		return model.PaymentPending
+	case "deny", "cancel", "failure":
+		return model.PaymentFailed
+	case "expire":
+		return model.PaymentExpired
+	case "refund":
+		return model.PaymentRefunded
+	}
+	return ""
+}
@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/fakeapi"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testMidtransKey = "SB-Mid-server-test"
+
+// midtransTestServer runs the fake Midtrans API and returns a provider using it. The fake delivers
+// no notifications, /_fake/pay answers with the signed notification so the test can hand it over.
+func midtransTestServer(t *testing.T) (*httptest.Server, *MidtransProvider) {
+	t.Helper()
+	server := httptest.NewServer(fakeapi.NewMidtrans(testMidtransKey, ""))
+	t.Cleanup(server.Close)
+
+	provider := &MidtransProvider{
+		ServerKey:  testMidtransKey,
+		SnapURL:    server.URL,
+		Expiry:     time.Hour,
+		HTTPClient: server.Client(),
+	}
+	return server, provider
+}
+
+// midtransPay moves a transaction of the fake to a status and returns the notification it signed
+func midtransPay(t *testing.T, server *httptest.Server, payment fakeapi.MidtransPayment) []byte {
+	t.Helper()
+	body, _ := json.Marshal(payment)
+	resp, err := http.Post(server.URL+"/_fake/pay", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to pay: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to pay: status %d", resp.StatusCode)
+	}
+
+	var answer struct {
+		Notification json.RawMessage `json:"notification"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatalf("failed to decode payment: %v", err)
+	}
+	return answer.Notification
+}
+
+// midtransTransaction creates a Snap transaction on the fake
+func midtransTransaction(t *testing.T, provider *MidtransProvider, reference string, amount float64) {
+	t.Helper()
+	_, err := provider.CreatePayment(context.Background(), PaymentRequest{
+		Reference: reference,
+		Amount:    amount,
+		Items:     []PaymentItem{{ID: "perfume", Name: "Perfume", Price: amount, Quantity: 1}},
+	})
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+}
+
+func TestMidtransCreatePayment(t *testing.T) {
+	server, provider := midtransTestServer(t)
+
+	// Items, shipping and a discount that do not add up on their own get an adjustment line
+	session, err := provider.CreatePayment(context.Background(), PaymentRequest{
+		Reference: "ELF-1",
+		Amount:    215000,
+		Items: []PaymentItem{
+			{ID: "a", Name: strings.Repeat("Very long perfume name ", 5), Price: 100000, Quantity: 2},
+			{ID: "discount", Name: "Discount", Price: -10000, Quantity: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	if session.Token == "" || !strings.HasPrefix(session.RedirectURL, server.URL+"/snap/v2/vtweb/") {
+		t.Errorf("session = %+v, want a token and a payment page of the fake", session)
+	}
+
+	// The fake rejects a reference it has seen
+	if _, err := provider.CreatePayment(context.Background(), PaymentRequest{Reference: "ELF-1", Amount: 1000}); err == nil {
+		t.Error("CreatePayment with a used reference succeeded")
+	}
+
+	provider.ServerKey = "wrong"
+	if _, err := provider.CreatePayment(context.Background(), PaymentRequest{Reference: "ELF-2", Amount: 1000}); err == nil {
+		t.Error("CreatePayment with a wrong server key succeeded")
+	}
+}
+
+func TestMidtransVerifyNotification(t *testing.T) {
+	tests := []struct {
+		transactionStatus string
+		fraudStatus       string
+		want              string
+	}{
+		{"settlement", "", model.PaymentPaid},
+		{"capture", "accept", model.PaymentPaid},
+		{"capture", "challenge", model.PaymentPending},
+		{"capture", "deny", model.PaymentFailed},
+		{"pending", "", model.PaymentPending},
+		{"deny", "", model.PaymentFailed},
+		{"cancel", "", model.PaymentFailed},
+		{"expire", "", model.PaymentExpired},
+		{"refund", "", model.PaymentRefunded},
+		{"partial_refund", "", ""},
+	}
+	server, provider := midtransTestServer(t)
+	midtransTransaction(t, provider, "ELF-1", 150000)
+
+	for _, test := range tests {
+		t.Run(test.transactionStatus+" "+test.fraudStatus, func(t *testing.T) {
+			body := midtransPay(t, server, fakeapi.MidtransPayment{OrderID: "ELF-1", TransactionStatus: test.transactionStatus, FraudStatus: test.fraudStatus})
+			notification, err := provider.VerifyNotification(body)
+			if err != nil {
+				t.Fatalf("VerifyNotification: %v", err)
+			}
+			if notification.Status != test.want {
+				t.Errorf("status = %q, want %q", notification.Status, test.want)
+			}
+			if notification.Reference != "ELF-1" || notification.GrossAmount != 150000 || notification.TransactionID == "" {
+				t.Errorf("notification = %+v, want ELF-1 over 150000 with a transaction ID", notification)
+			}
+		})
+	}
+}
+
+func TestMidtransVerifyNotificationRejects(t *testing.T) {
+	server, provider := midtransTestServer(t)
+	midtransTransaction(t, provider, "ELF-1", 150000)
+	body := midtransPay(t, server, fakeapi.MidtransPayment{OrderID: "ELF-1"})
+
+	// tamper changes one field of the signed notification
+	tamper := func(field, value string) []byte {
+		var notification map[string]string
+		json.Unmarshal(body, &notification)
+		notification[field] = value
+		tampered, _ := json.Marshal(notification)
+		return tampered
+	}
+	// resign signs a tampered notification with the right key, so only the field itself is wrong
+	resign := func(field, value string) []byte {
+		var notification map[string]string
+		json.Unmarshal(tamper(field, value), &notification)
+		notification["signature_key"] = MidtransSignature(notification["order_id"], notification["status_code"], notification["gross_amount"], testMidtransKey)
+		signed, _ := json.Marshal(notification)
+		return signed
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"not JSON", []byte("transaction_status=settlement")},
+		{"raised amount", tamper("gross_amount", "1.00")},
+		{"other order", tamper("order_id", "ELF-2")},
+		{"other status code", tamper("status_code", "201")},
+		{"missing signature", tamper("signature_key", "")},
+		{"invalid amount", resign("gross_amount", "free")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := provider.VerifyNotification(test.body); !errors.Is(err, ErrInvalidNotification) {
+				t.Errorf("err = %v, want ErrInvalidNotification", err)
+			}
+		})
+	}
+
+	other := &MidtransProvider{ServerKey: "another-key"}
+	if _, err := other.VerifyNotification(body); !errors.Is(err, ErrInvalidNotification) {
+		t.Errorf("err = %v with another server key, want ErrInvalidNotification", err)
+	}
+
+	// Signatures are hex and compared regardless of case
+	if _, err := provider.VerifyNotification(tamper("signature_key", strings.ToUpper(MidtransSignature("ELF-1", "200", "150000.00", testMidtransKey)))); err != nil {
+		t.Errorf("VerifyNotification with an upper case signature: %v", err)
+	}
+}
+
+// paymentFixture is an order waiting for payment and the perfume it bought
+type paymentFixture struct {
+	server    *httptest.Server
+	order     *model.Order
+	perfumeID primitive.ObjectID
+	payment   *model.Payment
+}
+
+// newPaymentFixture places an order of two bottles directly in the database, with three bottles
+// left in stock, and starts paying it through the fake Midtrans
+func newPaymentFixture(t *testing.T) *paymentFixture {
+	t.Helper()
+	testDatabase(t)
+	server, provider := midtransTestServer(t)
+	previous := Payments
+	Payments = provider
+	t.Cleanup(func() { Payments = previous })
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	perfumeID := primitive.NewObjectID()
+	_, err := config.MongoDB.Collection("perfumes").InsertOne(context.Background(), bson.M{
+		"_id": perfumeID, "name": "Sauvage", "brand": "Dior", "price": "100000", "stock": "3",
+		"status": model.StatusPublished, "version": 1, "created_at": now, "updated_at": now,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert perfume: %v", err)
+	}
+
+	order := &model.Order{
+		OrderID:     primitive.NewObjectID(),
+		UserID:      primitive.NewObjectID(),
+		Items:       []model.OrderItem{{PerfumeID: perfumeID, Name: "Sauvage", Brand: "Dior", UnitPrice: 100000, Quantity: 2, LineTotal: 200000}},
+		ItemCount:   2,
+		Subtotal:    200000,
+		Total:       200000,
+		Status:      model.OrderPendingPayment,
+		History:     []model.OrderStatusChange{{To: model.OrderPendingPayment, Actor: model.ActorCustomer, At: now}},
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		OrderNumber: "ELF-TEST-" + strings.ToUpper(primitive.NewObjectID().Hex()[18:]),
+	}
+	if _, err := config.MongoDB.Collection("orders").InsertOne(context.Background(), order); err != nil {
+		t.Fatalf("failed to insert order: %v", err)
+	}
+
+	payment, err := StartPayment(order.OrderID.Hex(), order.UserID)
+	if err != nil {
+		t.Fatalf("StartPayment: %v", err)
+	}
+	return &paymentFixture{server: server, order: order, perfumeID: perfumeID, payment: payment}
+}
+
+// notify has the fake move the payment to a status and hands its notification to the API
+func (f *paymentFixture) notify(t *testing.T, transactionStatus string) (*model.Payment, error) {
+	t.Helper()
+	body := midtransPay(t, f.server, fakeapi.MidtransPayment{OrderID: f.payment.Reference, TransactionStatus: transactionStatus})
+	return HandlePaymentNotification(body)
+}
+
+// currentOrder reads the order back
+func (f *paymentFixture) currentOrder(t *testing.T) *model.Order {
+	t.Helper()
+	order, err := GetOrder(f.order.OrderID.Hex(), nil)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	return order
+}
+
+// currentStock reads the stock of the perfume back
+func (f *paymentFixture) currentStock(t *testing.T) string {
+	t.Helper()
+	var perfume model.Perfume
+	if err := config.MongoDB.Collection("perfumes").FindOne(context.Background(), bson.M{"_id": f.perfumeID}).Decode(&perfume); err != nil {
+		t.Fatalf("failed to fetch perfume: %v", err)
+	}
+	return perfume.Stock
+}
+
+// countPaid counts how often the order became paid
+func countPaid(order *model.Order) int {
+	paid := 0
+	for _, change := range order.History {
+		if change.To == model.OrderPaid {
+			paid++
+		}
+	}
+	return paid
+}
+
+func TestHandlePaymentNotificationPaysOrderOnce(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	for i := 0; i < 3; i++ {
+		payment, err := f.notify(t, "settlement")
+		if err != nil {
+			t.Fatalf("HandlePaymentNotification delivery %d: %v", i+1, err)
+		}
+		if payment.Status != model.PaymentPaid || len(payment.Events) != 1 {
+			t.Errorf("delivery %d: payment %s with %d events, want paid with 1", i+1, payment.Status, len(payment.Events))
+		}
+	}
+
+	order := f.currentOrder(t)
+	if order.Status != model.OrderPaid || countPaid(order) != 1 {
+		t.Errorf("order %s, paid %d times, want paid once", order.Status, countPaid(order))
+	}
+}
+
+func TestHandlePaymentNotificationOutOfOrder(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	if _, err := f.notify(t, "settlement"); err != nil {
+		t.Fatalf("settlement: %v", err)
+	}
+	// A pending notification delivered late does not move the payment back
+	payment, err := f.notify(t, "pending")
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if payment.Status != model.PaymentPaid || len(payment.Events) != 2 {
+		t.Errorf("payment %s with %d events, want paid with 2", payment.Status, len(payment.Events))
+	}
+	if order := f.currentOrder(t); order.Status != model.OrderPaid {
+		t.Errorf("order %s, want paid", order.Status)
+	}
+
+	// A refund ranks above paid and refunds the order
+	payment, err = f.notify(t, "refund")
+	if err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if payment.Status != model.PaymentRefunded {
+		t.Errorf("payment %s, want refunded", payment.Status)
+	}
+	if order := f.currentOrder(t); order.Status != model.OrderRefunded || f.currentStock(t) != "5" {
+		t.Errorf("order %s with stock %s, want refunded with the bottles back at 5", order.Status, f.currentStock(t))
+	}
+}
+
+func TestHandlePaymentNotificationAmountMismatch(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// The transaction at Midtrans is for less than the payment we expect
+	_, err := config.MongoDB.Collection("payments").UpdateOne(context.Background(),
+		bson.M{"_id": f.payment.PaymentID}, bson.M{"$set": bson.M{"amount": 250000.0}})
+	if err != nil {
+		t.Fatalf("failed to change payment: %v", err)
+	}
+
+	if _, err := f.notify(t, "settlement"); !errors.Is(err, ErrInvalidNotification) {
+		t.Fatalf("err = %v, want ErrInvalidNotification", err)
+	}
+	if order := f.currentOrder(t); order.Status != model.OrderPendingPayment {
+		t.Errorf("order %s, want it still waiting for payment", order.Status)
+	}
+	var payment model.Payment
+	config.MongoDB.Collection("payments").FindOne(context.Background(), bson.M{"_id": f.payment.PaymentID}).Decode(&payment)
+	if payment.Status != model.PaymentPending || len(payment.Events) != 0 {
+		t.Errorf("payment %s with %d events, want pending without events", payment.Status, len(payment.Events))
+	}
+}
+
+func TestHandlePaymentNotificationExpiredThenPaid(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment, err := f.notify(t, "expire")
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if payment.Status != model.PaymentExpired {
+		t.Errorf("payment %s, want expired", payment.Status)
+	}
+	order := f.currentOrder(t)
+	if order.Status != model.OrderCancelled || !order.StockReturned || f.currentStock(t) != "5" {
+		t.Fatalf("order %s, stock returned %v, stock %s, want cancelled with the bottles back at 5", order.Status, order.StockReturned, f.currentStock(t))
+	}
+
+	// The customer paid just before the expiry reached us: the payment is recorded as paid, but
+	// the cancelled order is not revived and its stock is not taken twice
+	payment, err = f.notify(t, "settlement")
+	if err != nil {
+		t.Fatalf("settlement: %v", err)
+	}
+	if payment.Status != model.PaymentPaid || len(payment.Events) != 2 {
+		t.Errorf("payment %s with %d events, want paid with 2", payment.Status, len(payment.Events))
+	}
+	if order := f.currentOrder(t); order.Status != model.OrderCancelled || f.currentStock(t) != "5" {
+		t.Errorf("order %s with stock %s, want it to stay cancelled at 5", order.Status, f.currentStock(t))
+	}
+}
+
+func TestHandlePaymentNotificationUnknownPayment(t *testing.T) {
+	testDatabase(t)
+	_, provider := midtransTestServer(t)
+	previous := Payments
+	Payments = provider
+	t.Cleanup(func() { Payments = previous })
+
+	body, _ := json.Marshal(map[string]string{
+		"order_id":           "ELF-UNKNOWN-1",
+		"status_code":        "200",
+		"gross_amount":       "1000.00",
+		"transaction_status": "settlement",
+		"signature_key":      MidtransSignature("ELF-UNKNOWN-1", "200", "1000.00", testMidtransKey),
+	})
+	if _, err := HandlePaymentNotification(body); !errors.Is(err, ErrPaymentNotFound) {
+		t.Errorf("err = %v, want ErrPaymentNotFound", err)
+	}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDatabase points config.MongoDB at a new database for the test and drops it afterwards.
+// Tests that need MongoDB run against TEST_MONGO_URL, which must be a replica set since orders
+// use transactions, and are skipped without it.
+func testDatabase(t *testing.T) {
+	t.Helper()
+	mongoURL := os.Getenv("TEST_MONGO_URL")
+	if mongoURL == "" {
+		t.Skip("TEST_MONGO_URL is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURL))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("failed to ping MongoDB: %v", err)
+	}
+
+	previousClient, previousDB := config.MongoClient, config.MongoDB
+	config.MongoClient = client
+	config.MongoDB = client.Database("elfume_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		config.MongoDB.Drop(context.Background())
+		client.Disconnect(context.Background())
+		config.MongoClient, config.MongoDB = previousClient, previousDB
+	})
+
+	if err := EnsureIndexes(); err != nil {
+		t.Fatalf("failed to create indexes: %v", err)
+	}
+}
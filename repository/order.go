@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidOrder is returned when an order cannot be placed, such as from an empty cart
+var ErrInvalidOrder = errors.New("invalid order")
+
+// ErrOrderNotFound is returned when an order does not exist or belongs to another customer
+var ErrOrderNotFound = errors.New("order not found")
+
+// orderTimeout bounds the transaction that places an order, including its retries
+const orderTimeout = 30 * time.Second
+
+// OrderConflictError lists the cart lines that kept an order from being placed
+type OrderConflictError struct {
+	Items []model.OrderConflict
+}
+
+func (e *OrderConflictError) Error() string {
+	return fmt.Sprintf("%d items in the cart cannot be ordered", len(e.Items))
+}
+
+// PlaceOrder turns the customer's cart into an order. Stock is taken from the perfumes, the order is saved and
+// the cart removed in one MongoDB transaction, so two customers buying the last bottle at the same time cannot
+// both get it: the second transaction conflicts, is retried and then sees the stock is gone.
+// Lines that are out of stock, no longer sold or have a new price fail with an *OrderConflictError.
+// Transactions need MongoDB to run as a replica set or sharded cluster.
+func PlaceOrder(userID primitive.ObjectID, author model.RevisionAuthor, request model.OrderRequest) (*model.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+
+	session, err := config.MongoClient.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	order, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return placeOrder(sc, userID, author, request)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order.(*model.Order), nil
+}
+
+// placeOrder runs inside the transaction of PlaceOrder, it may run several times when the transaction is retried
+func placeOrder(ctx mongo.SessionContext, userID primitive.ObjectID, author model.RevisionAuthor, request model.OrderRequest) (*model.Order, error) {
+	owner := CartOwner{UserID: &userID}
+	cart, err := findCart(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil || len(cart.Items) == 0 {
+		return nil, fmt.Errorf("%w: the cart is empty", ErrInvalidOrder)
+	}
+
+	perfumes, err := cartPerfumes(ctx, cart.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines of the same perfume in different sizes share its stock
+	requested := map[primitive.ObjectID]int{}
+	for _, item := range cart.Items {
+		requested[item.PerfumeID] += item.Quantity
+	}
+
+	now := time.Now()
+	order := &model.Order{
+		OrderID: primitive.NewObjectID(),
+		UserID:  userID,
+		Items:   []model.OrderItem{},
+		Notes:   strings.TrimSpace(request.Notes),
+		Status:  model.OrderPendingPayment,
+		History: []model.OrderStatusChange{
+			{To: model.OrderPendingPayment, Actor: model.ActorCustomer, By: author, At: primitive.NewDateTimeFromTime(now)},
+		},
+		Version:   1,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+		UpdatedAt: primitive.NewDateTimeFromTime(now),
+	}
+	order.OrderNumber = orderNumber(order.OrderID, now)
+
+	conflicts := []model.OrderConflict{}
+	for _, item := range cart.Items {
+		conflict := model.OrderConflict{ItemID: item.ItemID, PerfumeID: item.PerfumeID, Size: item.Size, Requested: item.Quantity}
+
+		perfume, ok := perfumes[item.PerfumeID]
+		if !ok || !isPerfumeBuyable(perfume) {
+			if ok {
+				conflict.Name = perfume.Name
+			}
+			conflict.Reason = model.CartUnavailable
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		conflict.Name = perfume.Name
+		conflict.Available = perfumeStock(perfume)
+
+		if requested[item.PerfumeID] > conflict.Available {
+			conflict.Reason = model.CartOutOfStock
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+		// The customer must have seen the price they pay, reading the cart takes over the new one
+		if item.Price != perfume.Price {
+			conflict.Reason = model.CartPriceChanged
+			conflict.Price = perfume.Price
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+
+		unitPrice, _ := strconv.ParseFloat(strings.TrimSpace(perfume.Price), 64)
+		line := model.OrderItem{
+			PerfumeID: perfume.PerfumeID,
+			SKU:       perfume.SKU,
+			Name:      perfume.Name,
+			Brand:     perfume.Brand,
+			Slug:      perfume.Slug,
+			Image:     perfume.Image,
+			Size:      item.Size,
+			UnitPrice: unitPrice,
+			Quantity:  item.Quantity,
+			LineTotal: unitPrice * float64(item.Quantity),
+		}
+		order.Items = append(order.Items, line)
+		order.ItemCount += line.Quantity
+		order.Subtotal += line.LineTotal
+	}
+	if len(conflicts) > 0 {
+		return nil, &OrderConflictError{Items: conflicts}
+	}
+	order.Total = order.Subtotal
+
+	// Shipping is charged as quoted for the weight of the cart
+	if request.Shipping != nil {
+		shipping, err := orderShipping(ctx, request.Shipping, parcelWeight(cart.Items, perfumes), userID)
+		if err != nil {
+			return nil, err
+		}
+		order.Shipping = shipping
+		order.ShippingCost = shipping.Cost
+		order.Total = order.Subtotal + order.ShippingCost
+	}
+
+	// Promotions are priced on the lines and the shipping above and counted as used by this order
+	if err := applyOrderPromotions(ctx, order, cart, perfumes); err != nil {
+		return nil, err
+	}
+	order.Total = order.Subtotal - order.Discount + order.ShippingCost - order.ShippingDiscount
+
+	// Take the stock. The filter on the version read above makes a concurrent change fail the
+	// transaction instead of overwriting it.
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	for perfumeID, quantity := range requested {
+		perfume := perfumes[perfumeID]
+		result, err := perfumeCollection.UpdateOne(ctx,
+			bson.M{"_id": perfumeID, "version": versionMatch(perfume.Version)},
+			bson.M{
+				"$set": bson.M{"stock": strconv.Itoa(perfumeStock(perfume) - quantity), "updated_at": primitive.NewDateTimeFromTime(now)},
+				"$inc": bson.M{"version": 1},
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update stock: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrVersionConflict
+		}
+	}
+
+	orderCollection := config.MongoDB.Collection("orders")
+	if _, err := orderCollection.InsertOne(ctx, order); err != nil {
+		return nil, fmt.Errorf("failed to save order: %v", err)
+	}
+
+	cartCollection := config.MongoDB.Collection("carts")
+	if _, err := cartCollection.DeleteOne(ctx, bson.M{"_id": cart.CartID}); err != nil {
+		return nil, fmt.Errorf("failed to clear cart: %v", err)
+	}
+
+	return order, nil
+}
+
+// orderNumber builds the readable reference of an order from the date and the end of its ID
+func orderNumber(orderID primitive.ObjectID, now time.Time) string {
+	hex := orderID.Hex()
+	return fmt.Sprintf("ELF-%s-%s", now.UTC().Format("20060102"), strings.ToUpper(hex[len(hex)-6:]))
+}
+
+// GetOrder returns an order. With a user ID only that customer's orders are found.
+func GetOrder(id string, userID *primitive.ObjectID) (*model.Order, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrOrderNotFound
+	}
+
+	filter := bson.M{"_id": objID}
+	if userID != nil {
+		filter["user_id"] = *userID
+	}
+
+	orderCollection := config.MongoDB.Collection("orders")
+
+	var order model.Order
+	err = orderCollection.FindOne(context.TODO(), filter).Decode(&order)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch order: %v", err)
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidTransition is returned when an order cannot move from its status to the requested one
+var ErrInvalidTransition = errors.New("invalid order status change")
+
+// ErrTransitionForbidden is returned when the status change exists but the actor may not make it
+var ErrTransitionForbidden = errors.New("order status change not allowed")
+
+// orderTransitions lists for every status the statuses an order can move to and who may move it there.
+// Customers can only cancel before the order is packed, shipping and packing are up to admins, and
+// payment notifications mark orders paid or refunded.
+var orderTransitions = map[string]map[string][]string{
+	model.OrderPendingPayment: {
+		model.OrderPaid:      {model.ActorAdmin, model.ActorSystem},
+		model.OrderCancelled: {model.ActorCustomer, model.ActorAdmin, model.ActorSystem},
+	},
+	model.OrderPaid: {
+		model.OrderPacked:    {model.ActorAdmin},
+		model.OrderCancelled: {model.ActorCustomer, model.ActorAdmin},
+		model.OrderRefunded:  {model.ActorAdmin, model.ActorSystem},
+	},
+	model.OrderPacked: {
+		model.OrderShipped:   {model.ActorAdmin},
+		model.OrderCancelled: {model.ActorAdmin},
+	},
+	model.OrderShipped: {
+		model.OrderDelivered: {model.ActorAdmin, model.ActorSystem},
+	},
+	model.OrderDelivered: {
+		model.OrderRefunded: {model.ActorAdmin},
+	},
+	model.OrderCancelled: {
+		// Money of a paid order that was cancelled goes back to the customer
+		model.OrderRefunded: {model.ActorAdmin, model.ActorSystem},
+	},
+}
+
+// orderStatusAttempts is how often a status change is retried when the order changed while it was checked
+const orderStatusAttempts = 3
+
+// IsOrderStatus reports whether status is a known order status
+func IsOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok || status == model.OrderRefunded
+}
+
+// checkOrderTransition reports whether actor may move an order from one status to another
+func checkOrderTransition(order *model.Order, to string, actor string) error {
+	actors, ok := orderTransitions[order.Status][to]
+	if !ok {
+		return fmt.Errorf("%w: an order that is %s cannot become %s", ErrInvalidTransition, order.Status, to)
+	}
+	if order.Status == model.OrderCancelled && to == model.OrderRefunded && !orderWasPaid(order) {
+		return fmt.Errorf("%w: the order was cancelled before it was paid", ErrInvalidTransition)
+	}
+	for _, allowed := range actors {
+		if allowed == actor {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: a %s cannot move an order from %s to %s", ErrTransitionForbidden, actor, order.Status, to)
+}
+
+// orderWasPaid reports whether the order was paid at some point of its history
+func orderWasPaid(order *model.Order) bool {
+	for _, change := range order.History {
+		if change.To == model.OrderPaid {
+			return true
+		}
+	}
+	return false
+}
+
+// returnsStock reports whether moving an order to status puts its bottles back into stock:
+// it is cancelled or refunded before it was shipped, and its stock was not returned yet
+func returnsStock(order *model.Order, status string) bool {
+	if order.StockReturned || (status != model.OrderCancelled && status != model.OrderRefunded) {
+		return false
+	}
+	return order.Status != model.OrderShipped && order.Status != model.OrderDelivered
+}
+
+// TransitionOrder moves an order to another status and adds the change to its history. With a customer ID
+// only that customer's orders are found. Cancelling or refunding an order that was not shipped puts its
+// bottles back into stock in the same transaction.
+func TransitionOrder(id string, request model.OrderStatusRequest, actor string, author model.RevisionAuthor, customerID *primitive.ObjectID, expectedVersion *int64) (*model.Order, error) {
+	if !IsOrderStatus(request.Status) {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidOrder, request.Status)
+	}
+
+	for attempt := 0; attempt < orderStatusAttempts; attempt++ {
+		order, err := GetOrder(id, customerID)
+		if err != nil {
+			return nil, err
+		}
+		if expectedVersion != nil && *expectedVersion != order.Version {
+			return nil, ErrVersionConflict
+		}
+		if err := checkOrderTransition(order, request.Status, actor); err != nil {
+			return nil, err
+		}
+
+		change := model.OrderStatusChange{
+			From:  order.Status,
+			To:    request.Status,
+			Actor: actor,
+			By:    author,
+			Note:  strings.TrimSpace(request.Note),
+			At:    primitive.NewDateTimeFromTime(time.Now()),
+		}
+
+		var updated *model.Order
+		if returnsStock(order, request.Status) {
+			updated, err = transitionOrderReturningStock(order, change)
+		} else {
+			updated, err = writeOrderTransition(context.TODO(), order, change, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if updated != nil {
+			return updated, nil
+		}
+		// Someone else changed the order meanwhile, check the change again against its new status
+	}
+
+	return nil, ErrVersionConflict
+}
+
+// writeOrderTransition saves a status change if the order still has the status and version that were read.
+// It returns nil without an error when the order changed meanwhile.
+func writeOrderTransition(ctx context.Context, order *model.Order, change model.OrderStatusChange, stockReturned bool) (*model.Order, error) {
+	orderCollection := config.MongoDB.Collection("orders")
+
+	set := bson.M{"status": change.To, "updated_at": change.At}
+	if stockReturned {
+		set["stock_returned"] = true
+	}
+	filter := bson.M{"_id": order.OrderID, "status": order.Status, "version": order.Version}
+	update := bson.M{"$set": set, "$push": bson.M{"history": change}, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var updated model.Order
+	err := orderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to update order: %v", err)
+	}
+	return &updated, nil
+}
+
+// transitionOrderReturningStock saves a status change and puts the bottles of the order back into stock in one
+// transaction. The promotions the order redeemed can be used again.
+func transitionOrderReturningStock(order *model.Order, change model.OrderStatusChange) (*model.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+
+	session, err := config.MongoClient.StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	updated, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		updated, err := writeOrderTransition(sc, order, change, true)
+		if err != nil || updated == nil {
+			return updated, err
+		}
+		if err := returnOrderStock(sc, order); err != nil {
+			return nil, err
+		}
+		if err := releaseOrderPromotions(sc, order); err != nil {
+			return nil, err
+		}
+		return updated, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated.(*model.Order), nil
+}
+
+// returnOrderStock adds the quantities of an order back to the stock of its perfumes. Perfumes that were
+// deleted since are skipped. Bottles of a flash sale that is still running go back to its quota.
+func returnOrderStock(ctx mongo.SessionContext, order *model.Order) error {
+	items := order.Items
+	if order.FlashSaleID != nil {
+		var err error
+		if items, err = returnFlashSaleOrder(ctx, order); err != nil {
+			return err
+		}
+	}
+
+	quantities := map[primitive.ObjectID]int{}
+	for _, item := range items {
+		quantities[item.PerfumeID] += item.Quantity
+	}
+	for perfumeID, quantity := range quantities {
+		if err := addPerfumeStock(ctx, perfumeID, quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrders lists orders newest first, filtered by customer, status and the date they were placed
+func GetOrders(filter model.OrderFilter) ([]model.Order, int64, error) {
+	query := bson.M{}
+	if filter.UserID != nil {
+		query["user_id"] = *filter.UserID
+	}
+	if filter.Status != "" {
+		if !IsOrderStatus(filter.Status) {
+			return nil, 0, fmt.Errorf("%w: unknown status %q", ErrInvalidOrder, filter.Status)
+		}
+		query["status"] = filter.Status
+	}
+	placed := bson.M{}
+	if filter.From != nil {
+		placed["$gte"] = *filter.From
+	}
+	if filter.To != nil {
+		placed["$lt"] = *filter.To
+	}
+	if len(placed) > 0 {
+		query["created_at"] = placed
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	page := max(filter.Page, 1)
+
+	orderCollection := config.MongoDB.Collection("orders")
+
+	total, err := orderCollection.CountDocuments(context.TODO(), query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count orders: %v", err)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := orderCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch orders: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	orders := []model.Order{}
+	if err = cursor.All(context.Background(), &orders); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode orders: %v", err)
+	}
+
+	return orders, total, nil
+}
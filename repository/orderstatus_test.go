@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GilangAndhika/elfume/model"
+)
+
+func TestCheckOrderTransition(t *testing.T) {
+	paidThenCancelled := []model.OrderStatusChange{
+		{To: model.OrderPendingPayment}, {To: model.OrderPaid}, {To: model.OrderCancelled},
+	}
+	cancelledUnpaid := []model.OrderStatusChange{
+		{To: model.OrderPendingPayment}, {To: model.OrderCancelled},
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		history []model.OrderStatusChange
+		to      string
+		actor   string
+		err     error
+	}{
+		{"payment notification pays", model.OrderPendingPayment, nil, model.OrderPaid, model.ActorSystem, nil},
+		{"admin marks paid", model.OrderPendingPayment, nil, model.OrderPaid, model.ActorAdmin, nil},
+		{"customer cannot mark paid", model.OrderPendingPayment, nil, model.OrderPaid, model.ActorCustomer, ErrTransitionForbidden},
+		{"customer cancels unpaid", model.OrderPendingPayment, nil, model.OrderCancelled, model.ActorCustomer, nil},
+		{"expiry cancels unpaid", model.OrderPendingPayment, nil, model.OrderCancelled, model.ActorSystem, nil},
+		{"customer cancels paid", model.OrderPaid, nil, model.OrderCancelled, model.ActorCustomer, nil},
+		{"system cannot cancel paid", model.OrderPaid, nil, model.OrderCancelled, model.ActorSystem, ErrTransitionForbidden},
+		{"customer cannot cancel packed", model.OrderPacked, nil, model.OrderCancelled, model.ActorCustomer, ErrTransitionForbidden},
+		{"admin cancels packed", model.OrderPacked, nil, model.OrderCancelled, model.ActorAdmin, nil},
+		{"only admins pack", model.OrderPaid, nil, model.OrderPacked, model.ActorSystem, ErrTransitionForbidden},
+		{"unpaid cannot be packed", model.OrderPendingPayment, nil, model.OrderPacked, model.ActorAdmin, ErrInvalidTransition},
+		{"packing cannot be skipped", model.OrderPaid, nil, model.OrderShipped, model.ActorAdmin, ErrInvalidTransition},
+		{"admin ships", model.OrderPacked, nil, model.OrderShipped, model.ActorAdmin, nil},
+		{"shipped cannot be cancelled", model.OrderShipped, nil, model.OrderCancelled, model.ActorAdmin, ErrInvalidTransition},
+		{"courier tracking delivers", model.OrderShipped, nil, model.OrderDelivered, model.ActorSystem, nil},
+		{"customer cannot confirm delivery", model.OrderShipped, nil, model.OrderDelivered, model.ActorCustomer, ErrTransitionForbidden},
+		{"admin refunds delivered", model.OrderDelivered, nil, model.OrderRefunded, model.ActorAdmin, nil},
+		{"system cannot refund delivered", model.OrderDelivered, nil, model.OrderRefunded, model.ActorSystem, ErrTransitionForbidden},
+		{"refund of paid", model.OrderPaid, nil, model.OrderRefunded, model.ActorSystem, nil},
+		{"refund of a cancelled paid order", model.OrderCancelled, paidThenCancelled, model.OrderRefunded, model.ActorSystem, nil},
+		{"no refund of a cancelled unpaid order", model.OrderCancelled, cancelledUnpaid, model.OrderRefunded, model.ActorAdmin, ErrInvalidTransition},
+		{"cancelled stays cancelled", model.OrderCancelled, cancelledUnpaid, model.OrderPaid, model.ActorSystem, ErrInvalidTransition},
+		{"refunded is final", model.OrderRefunded, nil, model.OrderPaid, model.ActorAdmin, ErrInvalidTransition},
+		{"same status", model.OrderPaid, nil, model.OrderPaid, model.ActorAdmin, ErrInvalidTransition},
+		{"unknown status", model.OrderPaid, nil, "lost", model.ActorAdmin, ErrInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &model.Order{Status: tt.from, History: tt.history}
+			err := checkOrderTransition(order, tt.to, tt.actor)
+			if tt.err == nil && err != nil {
+				t.Errorf("checkOrderTransition(%s -> %s by %s): %v", tt.from, tt.to, tt.actor, err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("checkOrderTransition(%s -> %s by %s): %v, want %v", tt.from, tt.to, tt.actor, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned for malformed patch documents and invalid field values
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchTestFailed is returned when a JSON Patch "test" operation does not match the stored value
+	ErrPatchTestFailed = errors.New("patch test failed")
+	// ErrUnsupportedPatch is returned for patch media types other than merge patch and JSON Patch
+	ErrUnsupportedPatch = errors.New("unsupported patch format")
+)
+
+// Patch media types, plain application/json is treated as a merge patch
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// patchOperation is one operation of an RFC 6902 JSON Patch document
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// patchField validates and normalizes the new value of one field. A nil value means
+// the field is removed, the validator decides whether that is allowed and what is stored instead.
+type patchField func(value interface{}) (interface{}, error)
+
+// parsePatch turns a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) document into the
+// top-level fields it changes. current is the stored document as JSON, it is needed to evaluate
+// "test", "move" and "copy" operations.
+func parsePatch(contentType string, body []byte, current map[string]interface{}) (map[string]interface{}, error) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	switch mediaType {
+	case MergePatchContentType, "application/json", "":
+		changes := map[string]interface{}{}
+		if err := json.Unmarshal(body, &changes); err != nil {
+			return nil, fmt.Errorf("%w: merge patch must be a JSON object: %v", ErrInvalidPatch, err)
+		}
+		return changes, nil
+
+	case JSONPatchContentType:
+		var operations []patchOperation
+		if err := json.Unmarshal(body, &operations); err != nil {
+			return nil, fmt.Errorf("%w: JSON Patch must be an array of operations: %v", ErrInvalidPatch, err)
+		}
+
+		// Operations are applied to a copy so "test" sees the result of earlier operations
+		document := map[string]interface{}{}
+		for field, value := range current {
+			document[field] = value
+		}
+		changes := map[string]interface{}{}
+
+		for i, operation := range operations {
+			field, err := patchPathField(operation.Path)
+			if err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+			}
+
+			switch operation.Op {
+			case "add", "replace":
+				if operation.Value == nil {
+					return nil, fmt.Errorf("%w: operation %d: value is required", ErrInvalidPatch, i)
+				}
+				var value interface{}
+				if err := json.Unmarshal(*operation.Value, &value); err != nil {
+					return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+				}
+				if _, exists := document[field]; operation.Op == "replace" && !exists {
+					return nil, fmt.Errorf("%w: operation %d: %s does not exist", ErrInvalidPatch, i, operation.Path)
+				}
+				document[field] = value
+				changes[field] = value
+
+			case "remove":
+				if _, exists := document[field]; !exists {
+					return nil, fmt.Errorf("%w: operation %d: %s does not exist", ErrInvalidPatch, i, operation.Path)
+				}
+				delete(document, field)
+				changes[field] = nil
+
+			case "move", "copy":
+				from, err := patchPathField(operation.From)
+				if err != nil {
+					return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+				}
+				value, exists := document[from]
+				if !exists {
+					return nil, fmt.Errorf("%w: operation %d: %s does not exist", ErrInvalidPatch, i, operation.From)
+				}
+				document[field] = value
+				changes[field] = value
+				if operation.Op == "move" && from != field {
+					delete(document, from)
+					changes[from] = nil
+				}
+
+			case "test":
+				if operation.Value == nil {
+					return nil, fmt.Errorf("%w: operation %d: value is required", ErrInvalidPatch, i)
+				}
+				var expected interface{}
+				if err := json.Unmarshal(*operation.Value, &expected); err != nil {
+					return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+				}
+				if !reflect.DeepEqual(document[field], expected) {
+					return nil, fmt.Errorf("%w: %s does not match", ErrPatchTestFailed, operation.Path)
+				}
+
+			default:
+				return nil, fmt.Errorf("%w: operation %d: unknown op %q", ErrInvalidPatch, i, operation.Op)
+			}
+		}
+
+		return changes, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedPatch, contentType)
+}
+
+// patchPathField reads the field name of a JSON Pointer, only top-level fields can be patched
+func patchPathField(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("path %q must start with /", pointer)
+	}
+	field := pointer[1:]
+	if strings.Contains(field, "/") {
+		return "", fmt.Errorf("path %q: only top-level fields can be patched", pointer)
+	}
+	// Unescape ~1 and ~0 in that order as RFC 6901 requires
+	field = strings.ReplaceAll(field, "~1", "/")
+	field = strings.ReplaceAll(field, "~0", "~")
+	return field, nil
+}
+
+// toPatchDocument converts a model into the JSON object patches are applied to
+func toPatchDocument(value interface{}) (map[string]interface{}, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]interface{}{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// validatePatch runs every change through the validator of its field. The result maps
+// field names to the values to $set, fields without a validator cannot be patched.
+func validatePatch(changes map[string]interface{}, fields map[string]patchField) (map[string]interface{}, error) {
+	update := map[string]interface{}{}
+	for field, value := range changes {
+		validate, ok := fields[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %s cannot be patched", ErrInvalidPatch, field)
+		}
+		normalized, err := validate(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrInvalidPatch, field, err)
+		}
+		update[field] = normalized
+	}
+	return update, nil
+}
+
+// requiredString accepts a non-empty string, the field cannot be removed
+func requiredString(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, errors.New("cannot be removed")
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, errors.New("must be a string")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("cannot be empty")
+	}
+	return text, nil
+}
+
+// optionalString accepts any string, removing the field clears it
+func optionalString(value interface{}) (interface{}, error) {
+	if value == nil {
+		return "", nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, errors.New("must be a string")
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// numericString accepts a number or a numeric string and stores it as a string like the rest of the model.
+// With integer only whole numbers are accepted.
+func numericString(integer bool) patchField {
+	return func(value interface{}) (interface{}, error) {
+		var number float64
+		switch v := value.(type) {
+		case float64:
+			number = v
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, errors.New("must be a number")
+			}
+			number = parsed
+		default:
+			return nil, errors.New("must be a number")
+		}
+		if number < 0 {
+			return nil, errors.New("cannot be negative")
+		}
+		if integer {
+			if number != float64(int64(number)) {
+				return nil, errors.New("must be a whole number")
+			}
+			return strconv.FormatInt(int64(number), 10), nil
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	}
+}
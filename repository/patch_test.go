@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	current := map[string]interface{}{
+		"name":  "Sauvage",
+		"brand": "Dior",
+		"price": "100000",
+		"sku":   "DIOR-SAUVAGE",
+		"a/b":   "slash",
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        map[string]interface{}
+		err         error
+	}{
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"price": "120000", "sku": null}`,
+			want:        map[string]interface{}{"price": "120000", "sku": nil},
+		},
+		{
+			name:        "plain JSON with charset is a merge patch",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name": "Sauvage Elixir"}`,
+			want:        map[string]interface{}{"name": "Sauvage Elixir"},
+		},
+		{
+			name: "no content type is a merge patch",
+			body: `{"stock": 4}`,
+			want: map[string]interface{}{"stock": float64(4)},
+		},
+		{
+			name:        "merge patch must be an object",
+			contentType: "application/merge-patch+json",
+			body:        `[{"op": "remove", "path": "/sku"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "replace and remove",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "/price", "value": "90000"}, {"op": "remove", "path": "/sku"}]`,
+			want:        map[string]interface{}{"price": "90000", "sku": nil},
+		},
+		{
+			name:        "add a missing field",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "add", "path": "/description", "value": "Fresh"}]`,
+			want:        map[string]interface{}{"description": "Fresh"},
+		},
+		{
+			name:        "replace a missing field",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "/description", "value": "Fresh"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "remove a missing field",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "remove", "path": "/description"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "test passes",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "test", "path": "/price", "value": "100000"}, {"op": "replace", "path": "/price", "value": "110000"}]`,
+			want:        map[string]interface{}{"price": "110000"},
+		},
+		{
+			name:        "test fails",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "test", "path": "/price", "value": "99000"}, {"op": "replace", "path": "/price", "value": "110000"}]`,
+			err:         ErrPatchTestFailed,
+		},
+		{
+			name:        "test sees earlier operations",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "/price", "value": "110000"}, {"op": "test", "path": "/price", "value": "110000"}]`,
+			want:        map[string]interface{}{"price": "110000"},
+		},
+		{
+			name:        "move",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "move", "from": "/sku", "path": "/description"}]`,
+			want:        map[string]interface{}{"description": "DIOR-SAUVAGE", "sku": nil},
+		},
+		{
+			name:        "copy",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "copy", "from": "/name", "path": "/description"}]`,
+			want:        map[string]interface{}{"description": "Sauvage"},
+		},
+		{
+			name:        "move from a missing field",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "move", "from": "/types", "path": "/description"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "escaped pointer",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "/a~1b", "value": "x"}]`,
+			want:        map[string]interface{}{"a/b": "x"},
+		},
+		{
+			name:        "nested path",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "/images/0/alt_text", "value": "x"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "path without a slash",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "replace", "path": "price", "value": "1"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "missing value",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "add", "path": "/price"}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "unknown op",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "increment", "path": "/price", "value": 1}]`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "JSON Patch must be an array",
+			contentType: "application/json-patch+json",
+			body:        `{"price": "1"}`,
+			err:         ErrInvalidPatch,
+		},
+		{
+			name:        "unsupported media type",
+			contentType: "text/plain",
+			body:        `price=1`,
+			err:         ErrUnsupportedPatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePatch(tt.contentType, []byte(tt.body), current)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("parsePatch: %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePatch: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePatch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The stored document is never changed by the operations
+	if current["sku"] != "DIOR-SAUVAGE" || current["price"] != "100000" {
+		t.Errorf("parsePatch changed the current document: %v", current)
+	}
+}
+
+func TestValidatePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes map[string]interface{}
+		want    map[string]interface{}
+		err     bool
+	}{
+		{"trims strings", map[string]interface{}{"name": "  Sauvage ", "types": " EDT "}, map[string]interface{}{"name": "Sauvage", "types": "EDT"}, false},
+		{"removing an optional field clears it", map[string]interface{}{"sku": nil}, map[string]interface{}{"sku": ""}, false},
+		{"removing a required field", map[string]interface{}{"brand": nil}, nil, true},
+		{"empty required field", map[string]interface{}{"name": "   "}, nil, true},
+		{"string field given a number", map[string]interface{}{"name": float64(5)}, nil, true},
+		{"numbers are stored as strings", map[string]interface{}{"price": float64(99500.5), "stock": float64(7)}, map[string]interface{}{"price": "99500.5", "stock": "7"}, false},
+		{"numeric strings", map[string]interface{}{"price": " 120000 ", "weight": "350"}, map[string]interface{}{"price": "120000", "weight": "350"}, false},
+		{"negative price", map[string]interface{}{"price": float64(-1)}, nil, true},
+		{"fractional stock", map[string]interface{}{"stock": float64(1.5)}, nil, true},
+		{"stock that is not a number", map[string]interface{}{"stock": "many"}, nil, true},
+		{"removing stock", map[string]interface{}{"stock": nil}, nil, true},
+		{"field without a validator", map[string]interface{}{"images": []interface{}{}}, nil, true},
+		{"version cannot be patched", map[string]interface{}{"version": float64(9)}, nil, true},
+		{"nothing to change", map[string]interface{}{}, map[string]interface{}{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validatePatch(tt.changes, perfumePatchFields)
+			if tt.err {
+				if !errors.Is(err, ErrInvalidPatch) {
+					t.Fatalf("validatePatch = %v, %v, want ErrInvalidPatch", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validatePatch: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validatePatch = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
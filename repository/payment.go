@@ -0,0 +1,348 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrPaymentsDisabled is returned when no payment provider is configured
+var ErrPaymentsDisabled = errors.New("payments are not configured")
+
+// ErrInvalidPayment is returned when an order cannot be paid, such as one that was already paid or cancelled
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// ErrInvalidNotification is returned for payment notifications that are malformed or not signed by the provider
+var ErrInvalidNotification = errors.New("invalid payment notification")
+
+// ErrPaymentNotFound is returned when a notification names a payment we did not create
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// PaymentProvider is a payment gateway orders are paid through
+type PaymentProvider interface {
+	// Name identifies the provider on payment records
+	Name() string
+	// PaymentExpiry is how long a customer has to pay
+	PaymentExpiry() time.Duration
+	// CreatePayment starts a payment and returns where the customer pays
+	CreatePayment(ctx context.Context, request PaymentRequest) (*model.PaymentSession, error)
+	// VerifyNotification checks that a notification comes from the provider and tells what it means
+	VerifyNotification(body []byte) (*model.PaymentNotification, error)
+}
+
+// PaymentRequest is what a provider needs to start a payment
+type PaymentRequest struct {
+	Reference string // Unique per attempt, the provider rejects a reference it has seen
+	Amount    float64
+	Items     []PaymentItem
+	Customer  PaymentCustomer
+}
+
+// PaymentItem is a line shown on the payment page
+type PaymentItem struct {
+	ID       string
+	Name     string
+	Price    float64
+	Quantity int
+}
+
+// PaymentCustomer is who pays
+type PaymentCustomer struct {
+	Name  string
+	Email string
+	Phone string
+}
+
+// Payments is the payment provider selected by PAYMENT_PROVIDER, nil when payments are disabled
+var Payments PaymentProvider
+
+// InitPaymentProvider selects the payment provider (PAYMENT_PROVIDER=midtrans). Without PAYMENT_PROVIDER
+// Midtrans is used when MIDTRANS_SERVER_KEY is set, otherwise payments stay disabled.
+func InitPaymentProvider() error {
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "":
+		if os.Getenv("MIDTRANS_SERVER_KEY") == "" {
+			return nil
+		}
+		fallthrough
+	case "midtrans":
+		provider, err := NewMidtransProvider()
+		if err != nil {
+			return err
+		}
+		Payments = provider
+	case "none":
+	default:
+		return fmt.Errorf("unknown PAYMENT_PROVIDER %q", os.Getenv("PAYMENT_PROVIDER"))
+	}
+
+	return nil
+}
+
+// paymentAuthor is recorded in the order history for changes made by payment notifications
+var paymentAuthor = model.RevisionAuthor{Username: "payment"}
+
+// StartPayment starts paying an order of the customer. While an earlier attempt for the same amount can
+// still be paid it is returned instead of creating another one.
+func StartPayment(orderID string, userID primitive.ObjectID) (*model.Payment, error) {
+	if Payments == nil {
+		return nil, ErrPaymentsDisabled
+	}
+
+	order, err := GetOrder(orderID, &userID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != model.OrderPendingPayment {
+		return nil, fmt.Errorf("%w: the order is %s", ErrInvalidPayment, order.Status)
+	}
+
+	paymentCollection := config.MongoDB.Collection("payments")
+	now := time.Now()
+
+	var existing model.Payment
+	err = paymentCollection.FindOne(context.TODO(), bson.M{
+		"order_id":   order.OrderID,
+		"provider":   Payments.Name(),
+		"status":     model.PaymentPending,
+		"amount":     order.Total,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(now)},
+	}, options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})).Decode(&existing)
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to fetch payments: %v", err)
+	}
+
+	attempts, err := paymentCollection.CountDocuments(context.TODO(), bson.M{"order_id": order.OrderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count payments: %v", err)
+	}
+
+	request := PaymentRequest{
+		Reference: fmt.Sprintf("%s-%d", order.OrderNumber, attempts+1),
+		Amount:    order.Total,
+	}
+	for _, item := range order.Items {
+		name := item.Brand + " " + item.Name
+		if item.Size != "" {
+			name += " " + item.Size
+		}
+		request.Items = append(request.Items, PaymentItem{ID: item.PerfumeID.Hex(), Name: name, Price: item.UnitPrice, Quantity: item.Quantity})
+	}
+	if order.Shipping != nil && order.ShippingCost > 0 {
+		name := fmt.Sprintf("Shipping %s %s", order.Shipping.CourierName, order.Shipping.Service)
+		request.Items = append(request.Items, PaymentItem{ID: "shipping", Name: name, Price: order.ShippingCost, Quantity: 1})
+	}
+	// Discounts are lines with a negative price, so the lines still add up to the amount
+	if order.Discount > 0 {
+		request.Items = append(request.Items, PaymentItem{ID: "discount", Name: "Discount", Price: -order.Discount, Quantity: 1})
+	}
+	if order.ShippingDiscount > 0 {
+		request.Items = append(request.Items, PaymentItem{ID: "shipping-discount", Name: "Shipping discount", Price: -order.ShippingDiscount, Quantity: 1})
+	}
+	if user, err := GetUserByID(userID.Hex()); err == nil {
+		request.Customer = PaymentCustomer{Name: user.Username, Email: user.Email, Phone: user.Phone}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	session, err := Payments.CreatePayment(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := primitive.NewDateTimeFromTime(now.Add(Payments.PaymentExpiry()))
+	payment := model.Payment{
+		PaymentID:   primitive.NewObjectID(),
+		OrderID:     order.OrderID,
+		UserID:      userID,
+		Provider:    Payments.Name(),
+		Reference:   request.Reference,
+		Amount:      order.Total,
+		Status:      model.PaymentPending,
+		Token:       session.Token,
+		RedirectURL: session.RedirectURL,
+		Events:      []model.PaymentEvent{},
+		ExpiresAt:   &expiresAt,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		UpdatedAt:   primitive.NewDateTimeFromTime(now),
+	}
+	if _, err := paymentCollection.InsertOne(context.TODO(), payment); err != nil {
+		return nil, fmt.Errorf("failed to save payment: %v", err)
+	}
+
+	return &payment, nil
+}
+
+// GetOrderPayments lists the payment attempts of an order, newest first. With a user ID only that customer's orders are found.
+func GetOrderPayments(orderID string, userID *primitive.ObjectID) ([]model.Payment, error) {
+	order, err := GetOrder(orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentCollection := config.MongoDB.Collection("payments")
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := paymentCollection.Find(context.TODO(), bson.M{"order_id": order.OrderID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	payments := []model.Payment{}
+	if err = cursor.All(context.Background(), &payments); err != nil {
+		return nil, fmt.Errorf("failed to decode payments: %v", err)
+	}
+
+	return payments, nil
+}
+
+// paymentStatusRank orders payment statuses, a notification never moves a payment back to a lower rank.
+// Providers may deliver notifications late or out of order.
+var paymentStatusRank = map[string]int{
+	model.PaymentPending:  0,
+	model.PaymentFailed:   1,
+	model.PaymentExpired:  1,
+	model.PaymentPaid:     2,
+	model.PaymentRefunded: 3,
+}
+
+// HandlePaymentNotification verifies a notification from the provider and applies it to the payment and its order.
+// Providers repeat notifications until they are acknowledged, so the same notification can arrive several
+// times: the order change is only made when the order is still in the status it comes from, and each
+// notification is recorded once.
+func HandlePaymentNotification(body []byte) (*model.Payment, error) {
+	if Payments == nil {
+		return nil, ErrPaymentsDisabled
+	}
+
+	notification, err := Payments.VerifyNotification(body)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentCollection := config.MongoDB.Collection("payments")
+
+	var payment model.Payment
+	err = paymentCollection.FindOne(context.TODO(), bson.M{"provider": Payments.Name(), "reference": notification.Reference}).Decode(&payment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch payment: %v", err)
+	}
+	if math.Round(notification.GrossAmount) != math.Round(payment.Amount) {
+		return nil, fmt.Errorf("%w: amount %.2f does not match the payment of %.2f", ErrInvalidNotification, notification.GrossAmount, payment.Amount)
+	}
+
+	// The order is updated first: if that fails the notification is not acknowledged and the provider sends it again
+	if notification.Status != "" {
+		if err := applyPaymentToOrder(&payment, notification.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	event := model.PaymentEvent{
+		Key:               notification.TransactionID + ":" + notification.TransactionStatus + ":" + notification.FraudStatus,
+		TransactionID:     notification.TransactionID,
+		TransactionStatus: notification.TransactionStatus,
+		FraudStatus:       notification.FraudStatus,
+		PaymentType:       notification.PaymentType,
+		Status:            notification.Status,
+		ReceivedAt:        now,
+	}
+	_, err = paymentCollection.UpdateOne(context.TODO(),
+		bson.M{"_id": payment.PaymentID, "events.key": bson.M{"$ne": event.Key}},
+		bson.M{
+			"$push": bson.M{"events": event},
+			"$set":  bson.M{"transaction_id": notification.TransactionID, "payment_type": notification.PaymentType, "updated_at": now},
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record payment notification: %v", err)
+	}
+
+	if notification.Status != "" {
+		lower := bson.A{}
+		for status, rank := range paymentStatusRank {
+			if rank < paymentStatusRank[notification.Status] {
+				lower = append(lower, status)
+			}
+		}
+		_, err = paymentCollection.UpdateOne(context.TODO(),
+			bson.M{"_id": payment.PaymentID, "status": bson.M{"$in": lower}},
+			bson.M{"$set": bson.M{"status": notification.Status, "updated_at": now}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update payment: %v", err)
+		}
+	}
+
+	err = paymentCollection.FindOne(context.TODO(), bson.M{"_id": payment.PaymentID}).Decode(&payment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payment: %v", err)
+	}
+	return &payment, nil
+}
+
+// applyPaymentToOrder moves the order of a payment along: paid payments pay the order, refunds refund it and
+// an expired payment cancels the order, returning its stock, unless another attempt can still be paid.
+// Changes the order's status no longer allows, such as paying an order twice, are skipped.
+func applyPaymentToOrder(payment *model.Payment, status string) error {
+	request := model.OrderStatusRequest{Note: fmt.Sprintf("%s payment %s", payment.Provider, payment.Reference)}
+	switch status {
+	case model.PaymentPaid:
+		request.Status = model.OrderPaid
+	case model.PaymentRefunded:
+		request.Status = model.OrderRefunded
+	case model.PaymentExpired:
+		open, err := hasOpenPayment(payment)
+		if err != nil || open {
+			return err
+		}
+		request.Status = model.OrderCancelled
+		request.Note += " expired"
+	default:
+		return nil
+	}
+
+	_, err := TransitionOrder(payment.OrderID.Hex(), request, model.ActorSystem, paymentAuthor, nil, nil)
+	if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrTransitionForbidden) {
+		// A repeated notification of a payment that already paid the order is fine, any other one was paid twice
+		if status == model.PaymentPaid && payment.Status != model.PaymentPaid {
+			log.Printf("Payment %s was paid but its order %s cannot be marked paid, it needs a refund: %v", payment.Reference, payment.OrderID.Hex(), err)
+		}
+		return nil
+	}
+	return err
+}
+
+// hasOpenPayment reports whether another attempt to pay the order can still be paid or was paid
+func hasOpenPayment(payment *model.Payment) (bool, error) {
+	paymentCollection := config.MongoDB.Collection("payments")
+	count, err := paymentCollection.CountDocuments(context.TODO(), bson.M{
+		"order_id": payment.OrderID,
+		"_id":      bson.M{"$ne": payment.PaymentID},
+		"$or": bson.A{
+			bson.M{"status": model.PaymentPaid},
+			bson.M{"status": model.PaymentPending, "expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count payments: %v", err)
+	}
+	return count > 0, nil
+}
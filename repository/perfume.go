@@ -2,51 +2,72 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/GilangAndhika/elfume/config"
 	"github.com/GilangAndhika/elfume/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// CreatePerfume creates a new perfume product and uploads its image to GitHub
-func CreatePerfume(perfume *model.Perfume, imageBase64 string, fileName string) error {
+// CreatePerfume creates a new perfume product and uploads its images to the image store.
+// The upload and the insert run as a saga: if the insert fails, the uploaded files are deleted again.
+func CreatePerfume(perfume *model.Perfume, uploads []model.FumeImgUpload) error {
 	// Get database connection
 	perfumeCollection := config.MongoDB.Collection("perfumes")
 
-	// Upload image to GitHub
-	err := UploadtoGithub(fileName, imageBase64)
+	// Upload every image, a failed upload cleans up its own partial files
+	var createSaga saga
+	images, err := uploadPerfumeImages(perfume.PerfumeID, uploads)
 	if err != nil {
-		return fmt.Errorf("failed to upload image to GitHub: %v", err)
+		return err
 	}
+	createSaga.onRollback(func() { deleteStoredImages(images, "perfume insert failed") })
 
-	// Construct GitHub image URL
-	githubOwner := os.Getenv("GITHUB_OWNER")
-	githubRepo := os.Getenv("GITHUB_REPO")
-	imageURL := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/main/%s", githubOwner, githubRepo, fileName)
+	// Claim the slug, it is freed again if the insert fails
+	perfume.Slug, err = reservePerfumeSlug(perfume.PerfumeID, perfumeSlugBase(perfume.Brand, perfume.Name))
+	if err != nil {
+		createSaga.rollback()
+		return err
+	}
+	createSaga.onRollback(func() { releasePerfumeSlug(perfume.PerfumeID, perfume.Slug) })
 
-	// Assign ID and timestamps
-	perfume.Image = imageURL
+	// Attach the gallery, the first image becomes the primary one
+	perfume.Images = normalizePerfumeImages(images)
+	perfume.Image = primaryImageURL(perfume.Images)
+	perfume.Version = 1
 
 	// Insert perfume into the database
 	_, err = perfumeCollection.InsertOne(context.TODO(), bson.M{
-		"_id":         perfume.PerfumeID,
-		"name":        perfume.Name,
-		"brand":       perfume.Brand,
-		"types":       perfume.Types,
-		"categories":  perfume.Categories,
-		"sizes":       perfume.Sizes,
-		"image":       perfume.Image,
-		"price":       perfume.Price,
-		"description": perfume.Description,
-		"stock":       perfume.Stock,
-		"created_at":  perfume.CreatedAt,
-		"updated_at":  perfume.UpdatedAt,
+		"_id":          perfume.PerfumeID,
+		"name":         perfume.Name,
+		"slug":         perfume.Slug,
+		"sku":          perfume.SKU,
+		"tags":         normalizeTags(perfume.Tags),
+		"brand":        perfume.Brand,
+		"types":        perfume.Types,
+		"categories":   perfume.Categories,
+		"category_ids": perfume.CategoryIDs,
+		"sizes":        perfume.Sizes,
+		"image":        perfume.Image,
+		"images":       perfume.Images,
+		"price":        perfume.Price,
+		"description":  perfume.Description,
+		"stock":        perfume.Stock,
+		"weight":       perfume.Weight,
+		"version":      perfume.Version,
+		"status":       perfume.Status,
+		"publish_at":   perfume.PublishAt,
+		"unpublish_at": perfume.UnpublishAt,
+		"created_at":   perfume.CreatedAt,
+		"updated_at":   perfume.UpdatedAt,
 	})
 	if err != nil {
+		createSaga.rollback()
 		return fmt.Errorf("failed to insert perfume into database: %v", err)
 	}
 
@@ -58,8 +79,8 @@ func GetAllPerfumes() ([]model.Perfume, error) {
 	// Get database connection
 	perfumeCollection := config.MongoDB.Collection("perfumes")
 
-	// Find all perfumes
-	cursor, err := perfumeCollection.Find(context.TODO(), bson.M{})
+	// Find all perfumes the public may see
+	cursor, err := perfumeCollection.Find(context.TODO(), catalogue(bson.M{}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
 	}
@@ -85,9 +106,9 @@ func GetPerfumeByID(id string) (*model.Perfume, error) {
 	// Get database connection
 	perfumeCollection := config.MongoDB.Collection("perfumes")
 
-	// Find perfume by ID
+	// Find perfume by ID, soft deleted perfumes no longer exist for the API
 	var perfume model.Perfume
-	err = perfumeCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&perfume)
+	err = perfumeCollection.FindOne(context.TODO(), notDeleted(bson.M{"_id": objID})).Decode(&perfume)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find perfume: %v", err)
 	}
@@ -100,10 +121,9 @@ func GetFilteredPerfumes(filters map[string]string) ([]model.Perfume, error) {
 	// Get database connection
 	perfumeCollection := config.MongoDB.Collection("perfumes")
 
-	// Build MongoDB query filter
-	query := bson.M{}
-	for key, value := range filters {
-		query[key] = bson.M{"$regex": value, "$options": "i"} // Case-insensitive search
+	query, err := perfumeSearchFilter(filters)
+	if err != nil {
+		return nil, err
 	}
 
 	// Find perfumes using filter
@@ -122,51 +142,128 @@ func GetFilteredPerfumes(filters map[string]string) ([]model.Perfume, error) {
 	return perfumes, nil
 }
 
-// UpdatePerfume updates a perfume in the database
-func UpdatePerfume(id string, updatedPerfume model.Perfume) error {
+// perfumeSearchFilter builds the MongoDB query of a search, only published perfumes are ever listed
+func perfumeSearchFilter(filters map[string]string) (bson.M, error) {
+	return addPerfumeFilters(catalogue(bson.M{}), filters)
+}
+
+// addPerfumeFilters adds search criteria to a query: category_id matches the category and its
+// descendants, every other key is a case-insensitive pattern on the field of the same name
+func addPerfumeFilters(query bson.M, filters map[string]string) (bson.M, error) {
+	for key, value := range filters {
+		if key == "category_id" {
+			// Match the category together with everything below it in the tree
+			categoryID, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid category ID format: %v", err)
+			}
+			categoryIDs, err := GetDescendantCategoryIDs(categoryID)
+			if err != nil {
+				return nil, err
+			}
+			query["category_ids"] = bson.M{"$in": categoryIDs}
+			continue
+		}
+		query[key] = bson.M{"$regex": value, "$options": "i"} // Case-insensitive search
+	}
+
+	return query, nil
+}
+
+// UpdatePerfume updates a perfume in the database and records the change in its revision history.
+// With an expected version the update only happens if nobody changed the perfume since it was read.
+func UpdatePerfume(id string, updatedPerfume model.Perfume, expectedVersion *int64, author model.RevisionAuthor) error {
 	// Convert ID to ObjectID
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return fmt.Errorf("invalid perfume ID format: %v", err)
 	}
 
-	// Get database connection
-	perfumeCollection := config.MongoDB.Collection("perfumes")
+	// Define the update operation
+	set := bson.M{
+		"name":        updatedPerfume.Name,
+		"brand":       updatedPerfume.Brand,
+		"types":       updatedPerfume.Types,
+		"categories":  updatedPerfume.Categories,
+		"sizes":       updatedPerfume.Sizes,
+		"price":       updatedPerfume.Price,
+		"description": updatedPerfume.Description,
+		"stock":       updatedPerfume.Stock,
+	}
 
-	// Set updated timestamp
-	updatedPerfume.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
+	return writePerfumeFields(objID, set, expectedVersion, author, model.RevisionUpdate, nil)
+}
 
-	// Define the update operation
-	update := bson.M{
-		"$set": bson.M{
-			"name":        updatedPerfume.Name,
-			"brand":       updatedPerfume.Brand,
-			"types":       updatedPerfume.Types,
-			"categories":  updatedPerfume.Categories,
-			"sizes":       updatedPerfume.Sizes,
-			"price":       updatedPerfume.Price,
-			"description": updatedPerfume.Description,
-			"stock":       updatedPerfume.Stock,
-			"updated_at":  updatedPerfume.UpdatedAt,
-		},
+// perfumePatchFields are the perfume fields a PATCH may change. Images and categories have their own endpoints.
+var perfumePatchFields = map[string]patchField{
+	"name":        requiredString,
+	"brand":       requiredString,
+	"sku":         optionalString,
+	"types":       optionalString,
+	"categories":  optionalString,
+	"sizes":       optionalString,
+	"description": optionalString,
+	"price":       numericString(false),
+	"stock":       numericString(true),
+	"weight":      numericString(true),
+}
+
+// PatchPerfume applies a JSON Merge Patch or JSON Patch to a perfume, only the supplied fields are changed.
+// The patch is computed from the version that was read, so a concurrent write makes it fail with ErrVersionConflict.
+func PatchPerfume(id string, contentType string, body []byte, expectedVersion *int64, author model.RevisionAuthor) (*model.Perfume, error) {
+	perfume, err := GetPerfumeByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && *expectedVersion != perfume.Version {
+		return nil, ErrVersionConflict
 	}
 
-	// Perform the update
-	result, err := perfumeCollection.UpdateOne(context.TODO(), bson.M{"_id": objID}, update)
+	current, err := toPatchDocument(perfume)
 	if err != nil {
-		return fmt.Errorf("failed to update perfume: %v", err)
+		return nil, fmt.Errorf("failed to read perfume: %v", err)
+	}
+	changes, err := parsePatch(contentType, body, current)
+	if err != nil {
+		return nil, err
+	}
+	update, err := validatePatch(changes, perfumePatchFields)
+	if err != nil {
+		return nil, err
+	}
+	if len(update) == 0 {
+		return perfume, nil
 	}
 
-	// Check if the perfume was found and modified
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("perfume not found")
+	// Get database connection
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	update["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var patched model.Perfume
+	base := notDeleted(bson.M{"_id": perfume.PerfumeID})
+	filter := versionFilter(base, &perfume.Version)
+	err = perfumeCollection.FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": update, "$inc": bson.M{"version": 1}}, opts).Decode(&patched)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, versionMissError(perfumeCollection, base, fmt.Errorf("perfume not found"))
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: sku is already used by another perfume", ErrInvalidPatch)
+		}
+		return nil, fmt.Errorf("failed to update perfume: %v", err)
 	}
 
-	return nil
+	recordPerfumeRevision(patched.PerfumeID, patched.Version, perfumeSnapshot(perfume), perfumeSnapshot(&patched), author, model.RevisionPatch, nil)
+	refreshPerfumeSlug(patched.PerfumeID, patched.Slug, patched.Brand, patched.Name)
+
+	return &patched, nil
 }
 
-// DeletePerfume deletes a perfume by its ID
-func DeletePerfume(id string) error {
+// DeletePerfume soft deletes a perfume by its ID, with an expected version only if it is still at that version.
+// The document and its images are kept so orders can still refer to it, PurgeDeleted removes them after the retention period.
+func DeletePerfume(id string, expectedVersion *int64) error {
 	// Convert string ID to primitive.ObjectID
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -176,15 +273,18 @@ func DeletePerfume(id string) error {
 	// Get database connection
 	perfumeCollection := config.MongoDB.Collection("perfumes")
 
-	// Delete perfume by ID
-	result, err := perfumeCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	// Mark the perfume as deleted
+	base := notDeleted(bson.M{"_id": objID})
+	now := primitive.NewDateTimeFromTime(time.Now())
+	result, err := perfumeCollection.UpdateOne(context.TODO(), versionFilter(base, expectedVersion), bson.M{
+		"$set": bson.M{"deleted_at": now, "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete perfume: %v", err)
 	}
-
-	// Check if any document was actually deleted
-	if result.DeletedCount == 0 {
-		return fmt.Errorf("perfume not found")
+	if result.MatchedCount == 0 {
+		return versionMissError(perfumeCollection, base, fmt.Errorf("perfume not found"))
 	}
 
 	return nil
@@ -200,24 +300,55 @@ func CreatePerfumeWithImageURL(perfume *model.Perfume) error {
 	perfume.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	perfume.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 
+	// Turn a single image URL into a one picture gallery
+	if len(perfume.Images) == 0 && perfume.Image != "" {
+		perfume.Images = []model.PerfumeImage{{URL: perfume.Image, AltText: perfume.Name}}
+	}
+	for i := range perfume.Images {
+		if perfume.Images[i].ImageID.IsZero() {
+			perfume.Images[i].ImageID = primitive.NewObjectID()
+		}
+	}
+	perfume.Images = normalizePerfumeImages(perfume.Images)
+	perfume.Image = primaryImageURL(perfume.Images)
+	perfume.Version = 1
+
+	// Claim the slug, it is freed again if the insert fails
+	slug, err := reservePerfumeSlug(perfume.PerfumeID, perfumeSlugBase(perfume.Brand, perfume.Name))
+	if err != nil {
+		return err
+	}
+	perfume.Slug = slug
+
 	// Insert perfume into the database
-	_, err := perfumeCollection.InsertOne(context.TODO(), bson.M{
-		"_id":         perfume.PerfumeID,
-		"name":        perfume.Name,
-		"brand":       perfume.Brand,
-		"types":       perfume.Types,
-		"categories":  perfume.Categories,
-		"sizes":       perfume.Sizes,
-		"image":       perfume.Image,
-		"price":       perfume.Price,
-		"description": perfume.Description,
-		"stock":       perfume.Stock,
-		"created_at":  perfume.CreatedAt,
-		"updated_at":  perfume.UpdatedAt,
+	_, err = perfumeCollection.InsertOne(context.TODO(), bson.M{
+		"_id":          perfume.PerfumeID,
+		"name":         perfume.Name,
+		"slug":         perfume.Slug,
+		"sku":          perfume.SKU,
+		"tags":         normalizeTags(perfume.Tags),
+		"brand":        perfume.Brand,
+		"types":        perfume.Types,
+		"categories":   perfume.Categories,
+		"category_ids": perfume.CategoryIDs,
+		"sizes":        perfume.Sizes,
+		"image":        perfume.Image,
+		"images":       perfume.Images,
+		"price":        perfume.Price,
+		"description":  perfume.Description,
+		"stock":        perfume.Stock,
+		"weight":       perfume.Weight,
+		"version":      perfume.Version,
+		"status":       perfume.Status,
+		"publish_at":   perfume.PublishAt,
+		"unpublish_at": perfume.UnpublishAt,
+		"created_at":   perfume.CreatedAt,
+		"updated_at":   perfume.UpdatedAt,
 	})
 	if err != nil {
+		releasePerfumeSlug(perfume.PerfumeID, perfume.Slug)
 		return fmt.Errorf("failed to insert perfume into database: %v", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+// ErrInvalidImage is returned when an uploaded file is not an acceptable image
+var ErrInvalidImage = errors.New("invalid image")
+
+// imageRenditionSizes are the resized copies generated for every image, by longest side in pixels
+var imageRenditionSizes = []struct {
+	Name string
+	Size int
+}{
+	{"thumbnail", 200},
+	{"medium", 600},
+	{"large", 1200},
+}
+
+// imageFormats maps the sniffed MIME type to the format the image is re-encoded in
+var imageFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "png", // Only the first frame is kept, PNG keeps its transparency
+	"image/webp": "webp",
+}
+
+var formatContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+var formatExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+}
+
+// EncodedImage is one processed file ready to be stored
+type EncodedImage struct {
+	Name        string // "original" or the rendition name
+	Format      string
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+	Content     []byte
+}
+
+// ProcessedImage is the cleaned original together with its renditions
+type ProcessedImage struct {
+	Original   EncodedImage
+	Renditions []EncodedImage
+}
+
+// envInt reads a positive integer environment variable with a default
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// MaxImageBytes is the largest upload accepted, IMAGE_MAX_BYTES (default 10 MB)
+func MaxImageBytes() int64 {
+	return int64(envInt("IMAGE_MAX_BYTES", 10<<20))
+}
+
+// ProcessImage validates an uploaded image and re-encodes it.
+// The type is detected from the content (never from the file name), the size and dimensions
+// are checked against IMAGE_MAX_BYTES and IMAGE_MAX_DIMENSION, metadata such as EXIF is
+// dropped by re-encoding (JPEG orientation is applied first), and thumbnail/medium/large
+// renditions are generated in the original format plus WebP.
+func ProcessImage(content []byte) (*ProcessedImage, error) {
+	if int64(len(content)) > MaxImageBytes() {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidImage, MaxImageBytes())
+	}
+
+	// Sniff the real content type
+	contentType := http.DetectContentType(content)
+	format, ok := imageFormats[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported content type %s", ErrInvalidImage, contentType)
+	}
+
+	// Check dimensions before decoding the whole image
+	maxDimension := envInt("IMAGE_MAX_DIMENSION", 6000)
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width > maxDimension || config.Height > maxDimension {
+		return nil, fmt.Errorf("%w: image is %dx%d, the maximum is %dx%d", ErrInvalidImage, config.Width, config.Height, maxDimension, maxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(content))
+	}
+
+	// Re-encoding the decoded pixels leaves every metadata block behind
+	original, err := encodeImage("original", img, format)
+	if err != nil {
+		return nil, err
+	}
+	processed := &ProcessedImage{Original: *original}
+
+	for _, size := range imageRenditionSizes {
+		resized := resizeImage(img, size.Size)
+
+		formats := []string{format}
+		if format != "webp" {
+			formats = append(formats, "webp")
+		}
+		for _, renditionFormat := range formats {
+			rendition, err := encodeImage(size.Name, resized, renditionFormat)
+			if err != nil {
+				return nil, err
+			}
+			processed.Renditions = append(processed.Renditions, *rendition)
+		}
+	}
+
+	return processed, nil
+}
+
+// encodeImage writes the image in the given format
+func encodeImage(name string, img image.Image, format string) (*EncodedImage, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = fmt.Errorf("unknown image format %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s image: %v", format, err)
+	}
+
+	bounds := img.Bounds()
+	return &EncodedImage{
+		Name:        name,
+		Format:      format,
+		ContentType: formatContentTypes[format],
+		Extension:   formatExtensions[format],
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Content:     buf.Bytes(),
+	}, nil
+}
+
+// resizeImage scales the image so its longest side is at most size pixels, never upscaling
+func resizeImage(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+
+	resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
+	return resized
+}
+
+// jpegOrientation reads the EXIF orientation tag of a JPEG, returning 1 (normal) when absent
+func jpegOrientation(content []byte) int {
+	// Walk the JPEG segments until the APP1 Exif segment
+	for i := 2; i+4 <= len(content); {
+		if content[i] != 0xFF {
+			return 1
+		}
+		marker := content[i+1]
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(content) {
+			return 1 // Start of scan or a broken segment, no EXIF before the image data
+		}
+		segment := content[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation finds tag 0x0112 in the first IFD of an EXIF TIFF block
+func tiffOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates/flips the pixels so the image displays upright without EXIF
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	swap := orientation >= 5 // Orientations 5-8 are rotated by 90 degrees
+	outWidth, outHeight := width, height
+	if swap {
+		outWidth, outHeight = height, width
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirror horizontal
+				dx, dy = width-1-x, y
+			case 3: // Rotate 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // Mirror vertical
+				dx, dy = x, height-1-y
+			case 5: // Mirror horizontal and rotate 270 CW
+				dx, dy = y, x
+			case 6: // Rotate 90 CW
+				dx, dy = height-1-y, x
+			case 7: // Mirror horizontal and rotate 90 CW
+				dx, dy = height-1-y, width-1-x
+			case 8: // Rotate 270 CW
+				dx, dy = y, width-1-x
+			}
+			out.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return out
+}
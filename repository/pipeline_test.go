@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// testPicture is white with a red top-left quadrant, so rotations and flips can be told apart
+func testPicture(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if x < width/2 && y < height/2 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// exifSegment builds an APP1 segment whose first IFD holds the orientation tag
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8) // First IFD right after the header
+	order.PutUint16(tiff[8:], 1) // One entry
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEG encodes a picture and inserts the given segments right after the start of image marker
+func testJPEG(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+	content := buf.Bytes()
+	out := append([]byte{}, content[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, content[2:]...)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	picture := testPicture(8, 8)
+	app0 := []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    int
+	}{
+		{"no EXIF", testJPEG(t, picture), 1},
+		{"little endian", testJPEG(t, picture, exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", testJPEG(t, picture, exifSegment(binary.BigEndian, 8)), 8},
+		{"after another segment", testJPEG(t, picture, app0, exifSegment(binary.LittleEndian, 3)), 3},
+		{"out of range", testJPEG(t, picture, exifSegment(binary.LittleEndian, 9)), 1},
+		{"zero", testJPEG(t, picture, exifSegment(binary.BigEndian, 0)), 1},
+		{"broken segment length", append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}, exifSegment(binary.LittleEndian, 6)...), 1},
+		{"truncated", testJPEG(t, picture, exifSegment(binary.LittleEndian, 6))[:20], 1},
+		{"not a JPEG", []byte("GIF89a not a jpeg at all"), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.content); got != tt.want {
+				t.Errorf("jpegOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	picture := testPicture(1600, 800)
+	encode := func(format string) []byte {
+		var buf bytes.Buffer
+		var err error
+		switch format {
+		case "png":
+			err = png.Encode(&buf, picture)
+		case "gif":
+			err = gif.Encode(&buf, picture, nil)
+		case "webp":
+			err = nativewebp.Encode(&buf, picture, nil)
+		}
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", format, err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name        string
+		content     []byte
+		format      string
+		renditions  int
+		contentType string
+	}{
+		{"JPEG", testJPEG(t, picture), "jpeg", 6, "image/jpeg"},
+		{"PNG", encode("png"), "png", 6, "image/png"},
+		{"GIF becomes PNG", encode("gif"), "png", 6, "image/png"},
+		{"WebP has no extra WebP renditions", encode("webp"), "webp", 3, "image/webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := ProcessImage(tt.content)
+			if err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			original := processed.Original
+			if original.Format != tt.format || original.ContentType != tt.contentType || original.Width != 1600 || original.Height != 800 {
+				t.Errorf("original = %s %s %dx%d, want %s 1600x800", original.Format, original.ContentType, original.Width, original.Height, tt.format)
+			}
+			if len(processed.Renditions) != tt.renditions {
+				t.Fatalf("got %d renditions, want %d", len(processed.Renditions), tt.renditions)
+			}
+
+			// Renditions keep the aspect ratio and are never wider than their size
+			widths := map[string]int{"thumbnail": 200, "medium": 600, "large": 1200}
+			for _, rendition := range processed.Renditions {
+				if rendition.Width != widths[rendition.Name] || rendition.Height != widths[rendition.Name]/2 {
+					t.Errorf("%s %s rendition is %dx%d, want %dx%d", rendition.Name, rendition.Format, rendition.Width, rendition.Height, widths[rendition.Name], widths[rendition.Name]/2)
+				}
+				if rendition.Format != tt.format && rendition.Format != "webp" {
+					t.Errorf("%s rendition in %s, want %s or webp", rendition.Name, rendition.Format, tt.format)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessImageRejects(t *testing.T) {
+	t.Setenv("IMAGE_MAX_DIMENSION", "1000")
+	t.Setenv("IMAGE_MAX_BYTES", "200000")
+
+	noise := make([]byte, 200001)
+	copy(noise, testJPEG(t, testPicture(8, 8)))
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"text", []byte("<html><body>not an image</body></html>")},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)},
+		{"too wide", testJPEG(t, testPicture(1200, 10))},
+		{"too tall", testJPEG(t, testPicture(10, 1200))},
+		{"too many bytes", noise},
+		{"truncated", testJPEG(t, testPicture(64, 64))[:300]},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ProcessImage(tt.content); !errors.Is(err, ErrInvalidImage) {
+				t.Errorf("ProcessImage: %v, want ErrInvalidImage", err)
+			}
+		})
+	}
+}
+
+func TestProcessImageAppliesOrientation(t *testing.T) {
+	// Where the red quadrant of a 40x20 picture ends up, as a point inside it
+	tests := []struct {
+		orientation   int
+		width, height int
+		red           image.Point
+	}{
+		{1, 40, 20, image.Pt(10, 5)},
+		{2, 40, 20, image.Pt(30, 5)},
+		{3, 40, 20, image.Pt(30, 15)},
+		{4, 40, 20, image.Pt(10, 15)},
+		{5, 20, 40, image.Pt(5, 10)},
+		{6, 20, 40, image.Pt(15, 10)},
+		{7, 20, 40, image.Pt(15, 30)},
+		{8, 20, 40, image.Pt(5, 30)},
+	}
+	for _, tt := range tests {
+		content := testJPEG(t, testPicture(40, 20), exifSegment(binary.LittleEndian, uint16(tt.orientation)))
+		processed, err := ProcessImage(content)
+		if err != nil {
+			t.Fatalf("orientation %d: ProcessImage: %v", tt.orientation, err)
+		}
+		if bytes.Contains(processed.Original.Content, []byte("Exif")) {
+			t.Errorf("orientation %d: the EXIF block was kept", tt.orientation)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(processed.Original.Content))
+		if err != nil {
+			t.Fatalf("orientation %d: failed to decode the result: %v", tt.orientation, err)
+		}
+		if bounds := img.Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			continue
+		}
+		r, g, b, _ := img.At(tt.red.X, tt.red.Y).RGBA()
+		if r>>8 < 200 || g>>8 > 80 || b>>8 > 80 {
+			t.Errorf("orientation %d: pixel %v is %d,%d,%d, want red", tt.orientation, tt.red, r>>8, g>>8, b>>8)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrUploadsNotSupported is returned when the image store cannot hand out upload URLs
+	ErrUploadsNotSupported = errors.New("image store does not support direct uploads")
+	// ErrUploadNotFound is returned for unknown upload IDs
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadInProgress is returned while another request is confirming the same upload
+	ErrUploadInProgress = errors.New("upload is already being confirmed")
+)
+
+// uploadExtensions lists the content types that can be uploaded directly
+var uploadExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadURLExpiry is how long a presigned upload URL stays valid, UPLOAD_URL_EXPIRY (default 15m)
+func UploadURLExpiry() time.Duration {
+	return envDuration("UPLOAD_URL_EXPIRY", 15*time.Minute)
+}
+
+// CreateImageUpload registers a pending upload for a perfume and presigns the URL the client uploads to
+func CreateImageUpload(id string, request model.PresignUploadRequest) (*model.PresignUploadResponse, error) {
+	uploadCollection := config.MongoDB.Collection("uploads")
+
+	presigner, ok := Images.(ImagePresigner)
+	if !ok {
+		return nil, ErrUploadsNotSupported
+	}
+
+	// Validate the announced file before handing out a URL
+	contentType := strings.ToLower(strings.TrimSpace(request.ContentType))
+	extension, ok := uploadExtensions[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported content type %s", ErrInvalidImage, request.ContentType)
+	}
+	if request.Size <= 0 {
+		return nil, fmt.Errorf("%w: size is required", ErrInvalidImage)
+	}
+	if request.Size > MaxImageBytes() {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidImage, MaxImageBytes())
+	}
+
+	perfume, err := GetPerfumeByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploads land under incoming/ and are only copied into the gallery after confirmation.
+	// The name carries the perfume and upload IDs so orphan reconciliation can clean up abandoned uploads.
+	now := time.Now()
+	expiry := UploadURLExpiry()
+	upload := model.ImageUpload{
+		UploadID:    primitive.NewObjectID(),
+		PerfumeID:   perfume.PerfumeID,
+		FileName:    request.FileName,
+		ContentType: contentType,
+		Size:        request.Size,
+		AltText:     request.AltText,
+		Kind:        request.Kind,
+		Status:      model.UploadPending,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(expiry)),
+	}
+	upload.Key = "incoming/" + perfume.PerfumeID.Hex() + "-" + upload.UploadID.Hex() + extension
+
+	uploadURL, err := presigner.PresignUpload(context.TODO(), upload.Key, contentType, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %v", err)
+	}
+
+	if _, err := uploadCollection.InsertOne(context.TODO(), upload); err != nil {
+		return nil, fmt.Errorf("failed to insert upload: %v", err)
+	}
+
+	return &model.PresignUploadResponse{
+		UploadID:  upload.UploadID.Hex(),
+		UploadURL: uploadURL,
+		Method:    "PUT",
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: upload.ExpiresAt,
+	}, nil
+}
+
+// GetImageUpload returns an upload of a perfume
+func GetImageUpload(id, uploadID string) (*model.ImageUpload, error) {
+	uploadCollection := config.MongoDB.Collection("uploads")
+
+	perfumeID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+	uploadObjID, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload ID format: %v", err)
+	}
+
+	var upload model.ImageUpload
+	err = uploadCollection.FindOne(context.TODO(), bson.M{"_id": uploadObjID, "perfume_id": perfumeID}).Decode(&upload)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to find upload: %v", err)
+	}
+
+	return &upload, nil
+}
+
+// ConfirmImageUpload validates the uploaded object, runs it through the image pipeline and adds it
+// to the perfume gallery. Confirming an upload twice returns the gallery without adding it again.
+func ConfirmImageUpload(id, uploadID string) ([]model.PerfumeImage, error) {
+	uploadCollection := config.MongoDB.Collection("uploads")
+
+	presigner, ok := Images.(ImagePresigner)
+	if !ok {
+		return nil, ErrUploadsNotSupported
+	}
+
+	upload, err := GetImageUpload(id, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the upload so concurrent confirmations cannot add the image twice
+	result, err := uploadCollection.UpdateOne(context.TODO(),
+		bson.M{"_id": upload.UploadID, "status": model.UploadPending},
+		bson.M{"$set": bson.M{"status": model.UploadConfirming}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update upload: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		upload, err = GetImageUpload(id, uploadID)
+		if err != nil {
+			return nil, err
+		}
+		switch upload.Status {
+		case model.UploadConfirmed:
+			return GetPerfumeImages(id)
+		case model.UploadFailed:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImage, upload.Error)
+		default:
+			return nil, ErrUploadInProgress
+		}
+	}
+
+	// setStatus moves the upload out of "confirming" again
+	setStatus := func(update bson.M) {
+		if _, err := uploadCollection.UpdateOne(context.TODO(), bson.M{"_id": upload.UploadID}, bson.M{"$set": update}); err != nil {
+			log.Printf("Failed to update upload %s: %v", upload.UploadID.Hex(), err)
+		}
+	}
+
+	content, err := presigner.Fetch(context.TODO(), upload.Key, MaxImageBytes())
+	if err != nil {
+		if errors.Is(err, ErrInvalidImage) {
+			setStatus(bson.M{"status": model.UploadFailed, "error": err.Error()})
+			deleteStoredKey(upload.Key, "upload rejected")
+			return nil, err
+		}
+		// Nothing uploaded yet or a temporary failure, the client may confirm again
+		setStatus(bson.M{"status": model.UploadPending})
+		return nil, err
+	}
+
+	images, err := GetPerfumeImages(id)
+	if err != nil {
+		setStatus(bson.M{"status": model.UploadPending})
+		return nil, err
+	}
+
+	uploaded, err := uploadPerfumeImages(upload.PerfumeID, []model.FumeImgUpload{{
+		FileName:    upload.FileName,
+		Content:     content,
+		ContentType: upload.ContentType,
+		AltText:     upload.AltText,
+		Kind:        upload.Kind,
+	}})
+	if err != nil {
+		if errors.Is(err, ErrInvalidImage) {
+			setStatus(bson.M{"status": model.UploadFailed, "error": err.Error()})
+			deleteStoredKey(upload.Key, "upload rejected")
+		} else {
+			setStatus(bson.M{"status": model.UploadPending})
+		}
+		return nil, err
+	}
+
+	images, err = changePerfumeImages(id, func(images []model.PerfumeImage) ([]model.PerfumeImage, error) {
+		return append(images, uploaded...), nil
+	})
+	if err != nil {
+		deleteStoredImages(uploaded, "perfume update failed")
+		setStatus(bson.M{"status": model.UploadPending})
+		return nil, err
+	}
+
+	// The processed copies are in the gallery now, the raw upload is no longer needed
+	setStatus(bson.M{"status": model.UploadConfirmed, "image_id": uploaded[0].ImageID})
+	deleteStoredKey(upload.Key, "upload confirmed")
+
+	return images, nil
+}
@@ -0,0 +1,641 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidPromotion is returned for promotions with missing or invalid fields
+var ErrInvalidPromotion = errors.New("invalid promotion")
+
+// ErrPromotionNotFound is returned when a promotion, or a voucher code of a cart, does not exist
+var ErrPromotionNotFound = errors.New("promotion not found")
+
+// promoCodePattern is what voucher codes look like once upper cased
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// cartMaxPromoCodes is how many voucher codes a cart can hold
+const cartMaxPromoCodes = 5
+
+// normalizePromoCode upper cases a voucher code, customers type them in any case
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// buildPromotion validates a request and turns it into the stored fields of a promotion
+func buildPromotion(request model.PromotionRequest) (*model.Promotion, error) {
+	promotion := &model.Promotion{
+		Name:         strings.TrimSpace(request.Name),
+		Description:  strings.TrimSpace(request.Description),
+		Code:         normalizePromoCode(request.Code),
+		Type:         request.Type,
+		Value:        request.Value,
+		MaxDiscount:  request.MaxDiscount,
+		BuyQuantity:  request.BuyQuantity,
+		GetQuantity:  request.GetQuantity,
+		MinSpend:     request.MinSpend,
+		UsageLimit:   request.UsageLimit,
+		PerUserLimit: request.PerUserLimit,
+		Stackable:    request.Stackable,
+		Priority:     request.Priority,
+		Active:       request.Active == nil || *request.Active,
+		StartsAt:     request.StartsAt,
+		EndsAt:       request.EndsAt,
+		Scope: model.PromotionScope{
+			Brands:      []string{},
+			CategoryIDs: []primitive.ObjectID{},
+			PerfumeIDs:  []primitive.ObjectID{},
+		},
+	}
+
+	if promotion.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidPromotion)
+	}
+	if promotion.Code != "" && !promoCodePattern.MatchString(promotion.Code) {
+		return nil, fmt.Errorf("%w: code must be 3 to 32 letters, digits, dashes or underscores", ErrInvalidPromotion)
+	}
+
+	switch promotion.Type {
+	case model.PromotionPercentage:
+		if promotion.Value <= 0 || promotion.Value > 100 {
+			return nil, fmt.Errorf("%w: a percentage value must be above 0 and at most 100", ErrInvalidPromotion)
+		}
+	case model.PromotionFixed:
+		if promotion.Value <= 0 {
+			return nil, fmt.Errorf("%w: a fixed value must be above 0", ErrInvalidPromotion)
+		}
+	case model.PromotionFreeShipping:
+		if promotion.Value < 0 {
+			return nil, fmt.Errorf("%w: a free shipping value cannot be negative", ErrInvalidPromotion)
+		}
+	case model.PromotionBuyXGetY:
+		if promotion.BuyQuantity < 1 || promotion.GetQuantity < 1 {
+			return nil, fmt.Errorf("%w: buy_quantity and get_quantity must be at least 1", ErrInvalidPromotion)
+		}
+	default:
+		return nil, fmt.Errorf("%w: type must be percentage, fixed, free_shipping or buy_x_get_y", ErrInvalidPromotion)
+	}
+	if promotion.Type != model.PromotionBuyXGetY {
+		promotion.BuyQuantity, promotion.GetQuantity = 0, 0
+	}
+	if promotion.Type != model.PromotionPercentage {
+		promotion.MaxDiscount = 0
+	}
+
+	if promotion.MaxDiscount < 0 || promotion.MinSpend < 0 || promotion.UsageLimit < 0 || promotion.PerUserLimit < 0 {
+		return nil, fmt.Errorf("%w: max_discount, min_spend and the usage limits cannot be negative", ErrInvalidPromotion)
+	}
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && *promotion.EndsAt <= *promotion.StartsAt {
+		return nil, fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+
+	for _, brand := range request.Brands {
+		if brand = strings.TrimSpace(brand); brand != "" {
+			promotion.Scope.Brands = append(promotion.Scope.Brands, brand)
+		}
+	}
+	categoryIDs, err := ParseCategoryIDs(request.CategoryIDs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPromotion, err)
+	}
+	promotion.Scope.CategoryIDs = categoryIDs
+	for _, id := range request.PerfumeIDs {
+		objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid perfume ID %q", ErrInvalidPromotion, id)
+		}
+		if !slices.Contains(promotion.Scope.PerfumeIDs, objID) {
+			promotion.Scope.PerfumeIDs = append(promotion.Scope.PerfumeIDs, objID)
+		}
+	}
+
+	return promotion, nil
+}
+
+// CreatePromotion saves a new promotion
+func CreatePromotion(request model.PromotionRequest, author model.RevisionAuthor) (*model.Promotion, error) {
+	promotion, err := buildPromotion(request)
+	if err != nil {
+		return nil, err
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	promotion.PromotionID = primitive.NewObjectID()
+	promotion.Version = 1
+	promotion.CreatedBy = author
+	promotion.CreatedAt = now
+	promotion.UpdatedAt = now
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+	if _, err := promotionCollection.InsertOne(context.TODO(), promotion); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: the code %s is used by another promotion", ErrInvalidPromotion, promotion.Code)
+		}
+		return nil, fmt.Errorf("failed to save promotion: %v", err)
+	}
+
+	return promotion, nil
+}
+
+// GetPromotions lists promotions newest first. Active filters on the active switch, code finds a voucher.
+func GetPromotions(active *bool, code string) ([]model.Promotion, error) {
+	filter := bson.M{}
+	if active != nil {
+		filter["active"] = *active
+	}
+	if code = normalizePromoCode(code); code != "" {
+		filter["code"] = code
+	}
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := promotionCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch promotions: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	promotions := []model.Promotion{}
+	if err = cursor.All(context.TODO(), &promotions); err != nil {
+		return nil, fmt.Errorf("failed to decode promotions: %v", err)
+	}
+
+	return promotions, nil
+}
+
+// GetPromotion returns a promotion by ID
+func GetPromotion(id string) (*model.Promotion, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrPromotionNotFound
+	}
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+
+	var promotion model.Promotion
+	err = promotionCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&promotion)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch promotion: %v", err)
+	}
+
+	return &promotion, nil
+}
+
+// UpdatePromotion replaces the rules of a promotion. How often it was used is kept. With an expected version
+// the update only applies to that version.
+func UpdatePromotion(id string, request model.PromotionRequest, expectedVersion *int64) (*model.Promotion, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrPromotionNotFound
+	}
+	promotion, err := buildPromotion(request)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": objID}
+	if expectedVersion != nil {
+		filter["version"] = *expectedVersion
+	}
+	set := bson.M{
+		"name":           promotion.Name,
+		"description":    promotion.Description,
+		"type":           promotion.Type,
+		"value":          promotion.Value,
+		"max_discount":   promotion.MaxDiscount,
+		"buy_quantity":   promotion.BuyQuantity,
+		"get_quantity":   promotion.GetQuantity,
+		"scope":          promotion.Scope,
+		"min_spend":      promotion.MinSpend,
+		"usage_limit":    promotion.UsageLimit,
+		"per_user_limit": promotion.PerUserLimit,
+		"stackable":      promotion.Stackable,
+		"priority":       promotion.Priority,
+		"active":         promotion.Active,
+		"updated_at":     primitive.NewDateTimeFromTime(time.Now()),
+	}
+	unset := bson.M{}
+	for field, value := range map[string]interface{}{"code": promotion.Code, "starts_at": promotion.StartsAt, "ends_at": promotion.EndsAt} {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				unset[field] = ""
+				continue
+			}
+		case *primitive.DateTime:
+			if v == nil {
+				unset[field] = ""
+				continue
+			}
+		}
+		set[field] = value
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+
+	var updated model.Promotion
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = promotionCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&updated)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: the code %s is used by another promotion", ErrInvalidPromotion, promotion.Code)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if expectedVersion != nil {
+				if _, getErr := GetPromotion(id); getErr == nil {
+					return nil, ErrVersionConflict
+				}
+			}
+			return nil, ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("failed to update promotion: %v", err)
+	}
+
+	return &updated, nil
+}
+
+// DeletePromotion removes a promotion. Orders keep the promotions they redeemed, carts holding its code are
+// told the voucher no longer exists.
+func DeletePromotion(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrPromotionNotFound
+	}
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+	result, err := promotionCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
+	if err != nil {
+		return fmt.Errorf("failed to delete promotion: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrPromotionNotFound
+	}
+
+	usageCollection := config.MongoDB.Collection("promotion_usages")
+	if _, err := usageCollection.DeleteMany(context.TODO(), bson.M{"promotion_id": objID}); err != nil {
+		return fmt.Errorf("failed to delete promotion usage: %v", err)
+	}
+
+	return nil
+}
+
+// loadPromotions returns the automatic promotions that are switched on and the vouchers with the given codes
+func loadPromotions(ctx context.Context, codes []string) ([]model.Promotion, error) {
+	filter := bson.M{"code": bson.M{"$exists": false}, "active": true}
+	if len(codes) > 0 {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"code": bson.M{"$in": codes}}}}
+	}
+
+	promotionCollection := config.MongoDB.Collection("promotions")
+	cursor, err := promotionCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch promotions: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	promotions := []model.Promotion{}
+	if err = cursor.All(ctx, &promotions); err != nil {
+		return nil, fmt.Errorf("failed to decode promotions: %v", err)
+	}
+	return promotions, nil
+}
+
+// promotionUsageID is the ID of the document counting how often a customer used a promotion
+func promotionUsageID(promotionID, userID primitive.ObjectID) string {
+	return promotionID.Hex() + ":" + userID.Hex()
+}
+
+// userPromotionUsage returns how many orders of a customer used each of the promotions
+func userPromotionUsage(ctx context.Context, userID primitive.ObjectID, promotions []model.Promotion) (map[primitive.ObjectID]int, error) {
+	usage := map[primitive.ObjectID]int{}
+	if len(promotions) == 0 {
+		return usage, nil
+	}
+	ids := make([]string, 0, len(promotions))
+	for _, promotion := range promotions {
+		ids = append(ids, promotionUsageID(promotion.PromotionID, userID))
+	}
+
+	usageCollection := config.MongoDB.Collection("promotion_usages")
+	cursor, err := usageCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch promotion usage: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	var counts []struct {
+		PromotionID primitive.ObjectID `bson:"promotion_id"`
+		Count       int                `bson:"count"`
+	}
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, fmt.Errorf("failed to decode promotion usage: %v", err)
+	}
+	for _, count := range counts {
+		usage[count.PromotionID] = count.Count
+	}
+	return usage, nil
+}
+
+// categoryLineage returns for each category its ID and the IDs of every category above it
+func categoryLineage(ctx context.Context, perfumes map[primitive.ObjectID]*model.Perfume) (map[primitive.ObjectID][]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{}
+	for _, perfume := range perfumes {
+		ids = append(ids, perfume.CategoryIDs...)
+	}
+	lineage := map[primitive.ObjectID][]primitive.ObjectID{}
+	if len(ids) == 0 {
+		return lineage, nil
+	}
+
+	categoryCollection := config.MongoDB.Collection("categories")
+	cursor, err := categoryCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"ancestors": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch categories: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	var categories []model.Category
+	if err = cursor.All(ctx, &categories); err != nil {
+		return nil, fmt.Errorf("failed to decode categories: %v", err)
+	}
+	for _, category := range categories {
+		lineage[category.CategoryID] = append([]primitive.ObjectID{category.CategoryID}, category.Ancestors...)
+	}
+	return lineage, nil
+}
+
+// promotionLines describes perfumes bought in some quantity at a unit price for the promotion rules
+func promotionLines(ctx context.Context, perfumeIDs []primitive.ObjectID, unitPrices []float64, quantities []int, perfumes map[primitive.ObjectID]*model.Perfume) ([]promotionLine, error) {
+	lineage, err := categoryLineage(ctx, perfumes)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]promotionLine, len(perfumeIDs))
+	for i, perfumeID := range perfumeIDs {
+		line := promotionLine{PerfumeID: perfumeID, UnitPrice: unitPrices[i], Quantity: quantities[i]}
+		if perfume, ok := perfumes[perfumeID]; ok {
+			line.Brand = perfume.Brand
+			for _, categoryID := range perfume.CategoryIDs {
+				line.Categories = append(line.Categories, lineage[categoryID]...)
+			}
+		}
+		lines[i] = line
+	}
+	return lines, nil
+}
+
+// priceCart applies the promotions to a revalidated cart. Lines that cannot be bought do not count.
+func priceCart(ctx context.Context, owner CartOwner, cart *model.Cart, perfumes map[primitive.ObjectID]*model.Perfume) error {
+	cart.Discount = 0
+	cart.Total = cart.Subtotal
+	cart.Promotions = []model.AppliedPromotion{}
+	cart.PromotionNotices = []model.PromotionNotice{}
+
+	promotions, err := loadPromotions(ctx, cart.PromoCodes)
+	if err != nil {
+		return err
+	}
+	if len(promotions) == 0 && len(cart.PromoCodes) == 0 {
+		return nil
+	}
+
+	perfumeIDs, unitPrices, quantities := []primitive.ObjectID{}, []float64{}, []int{}
+	for _, item := range cart.Items {
+		if !item.Available {
+			continue
+		}
+		price, _ := strconv.ParseFloat(strings.TrimSpace(item.Price), 64)
+		perfumeIDs = append(perfumeIDs, item.PerfumeID)
+		unitPrices = append(unitPrices, price)
+		quantities = append(quantities, item.Quantity)
+	}
+	lines, err := promotionLines(ctx, perfumeIDs, unitPrices, quantities, perfumes)
+	if err != nil {
+		return err
+	}
+
+	input := promotionCart{Lines: lines, Codes: cart.PromoCodes, Now: time.Now()}
+	if owner.UserID != nil {
+		if input.UserUsage, err = userPromotionUsage(ctx, *owner.UserID, promotions); err != nil {
+			return err
+		}
+	}
+
+	result := evaluatePromotions(promotions, input)
+	cart.Discount = result.Discount
+	cart.Total = cart.Subtotal - result.Discount
+	cart.Promotions = result.Applied
+	cart.PromotionNotices = result.Notices
+	return nil
+}
+
+// AddPromoCode applies a voucher code to the owner's cart. Codes that do not exist, are switched off, outside
+// their dates or used up are refused. Codes that do not fit the cart yet, such as below their minimum spend,
+// are kept and reported in the cart's promotion notices.
+func AddPromoCode(owner CartOwner, code string) (*model.Cart, error) {
+	code = normalizePromoCode(code)
+	if !promoCodePattern.MatchString(code) {
+		return nil, fmt.Errorf("%w: unknown voucher code", ErrInvalidCart)
+	}
+
+	promotions, err := GetPromotions(nil, code)
+	if err != nil {
+		return nil, err
+	}
+	if len(promotions) == 0 {
+		return nil, fmt.Errorf("%w: unknown voucher code %s", ErrInvalidCart, code)
+	}
+	promotion := promotions[0]
+	if _, reason, message := checkPromotion(&promotion, promotionCart{Now: time.Now()}); reason == model.PromotionNotActive || reason == model.PromotionUsedUp {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCart, message)
+	}
+
+	cart, err := changeCart(owner, func(cart *model.Cart) error {
+		if slices.Contains(cart.PromoCodes, code) {
+			return nil
+		}
+		if len(cart.PromoCodes) >= cartMaxPromoCodes {
+			return fmt.Errorf("%w: a cart holds at most %d voucher codes", ErrInvalidCart, cartMaxPromoCodes)
+		}
+		cart.PromoCodes = append(cart.PromoCodes, code)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// RemovePromoCode takes a voucher code off the owner's cart
+func RemovePromoCode(owner CartOwner, code string) (*model.Cart, error) {
+	code = normalizePromoCode(code)
+
+	cart, err := changeCart(owner, func(cart *model.Cart) error {
+		i := slices.Index(cart.PromoCodes, code)
+		if i < 0 {
+			return ErrPromotionNotFound
+		}
+		cart.PromoCodes = slices.Delete(cart.PromoCodes, i, i+1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revalidateCart(context.TODO(), owner, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// applyOrderPromotions prices an order being placed with the promotions of its cart. Vouchers the customer
+// applied must still be usable, except when a better combination of promotions replaced them.
+func applyOrderPromotions(ctx mongo.SessionContext, order *model.Order, cart *model.Cart, perfumes map[primitive.ObjectID]*model.Perfume) error {
+	promotions, err := loadPromotions(ctx, cart.PromoCodes)
+	if err != nil {
+		return err
+	}
+	if len(promotions) == 0 && len(cart.PromoCodes) == 0 {
+		return nil
+	}
+
+	perfumeIDs, unitPrices, quantities := []primitive.ObjectID{}, []float64{}, []int{}
+	for _, item := range order.Items {
+		perfumeIDs = append(perfumeIDs, item.PerfumeID)
+		unitPrices = append(unitPrices, item.UnitPrice)
+		quantities = append(quantities, item.Quantity)
+	}
+	lines, err := promotionLines(ctx, perfumeIDs, unitPrices, quantities, perfumes)
+	if err != nil {
+		return err
+	}
+	usage, err := userPromotionUsage(ctx, order.UserID, promotions)
+	if err != nil {
+		return err
+	}
+
+	shippingCost := order.ShippingCost
+	result := evaluatePromotions(promotions, promotionCart{
+		Lines:        lines,
+		Codes:        cart.PromoCodes,
+		ShippingCost: &shippingCost,
+		UserUsage:    usage,
+		Now:          time.Now(),
+	})
+	for _, notice := range result.Notices {
+		if notice.Reason != model.PromotionNotCombinable && notice.Reason != model.PromotionNoShipping {
+			return fmt.Errorf("%w: voucher %s cannot be used: %s", ErrInvalidOrder, notice.Code, notice.Message)
+		}
+	}
+
+	for i := range order.Items {
+		order.Items[i].Discount = result.LineDiscounts[i]
+	}
+	order.Discount = result.Discount
+	order.ShippingDiscount = result.ShippingDiscount
+	if len(result.Applied) > 0 {
+		order.Promotions = result.Applied
+	}
+
+	for _, applied := range result.Applied {
+		if err := redeemPromotion(ctx, applied.PromotionID, order.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redeemPromotion counts an order using a promotion, within the order's transaction. The limits are part of
+// the updates' filters, and two orders counting the same promotion at once conflict and are retried by the
+// transaction, so a limit is never exceeded.
+func redeemPromotion(ctx mongo.SessionContext, promotionID, userID primitive.ObjectID) error {
+	promotionCollection := config.MongoDB.Collection("promotions")
+	usageCollection := config.MongoDB.Collection("promotion_usages")
+
+	var promotion model.Promotion
+	err := promotionCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": promotionID, "$expr": bson.M{"$or": bson.A{
+			bson.M{"$lte": bson.A{"$usage_limit", 0}},
+			bson.M{"$lt": bson.A{"$used_count", "$usage_limit"}},
+		}}},
+		bson.M{"$inc": bson.M{"used_count": 1}},
+	).Decode(&promotion)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: a promotion of the cart was used up meanwhile", ErrInvalidOrder)
+		}
+		return fmt.Errorf("failed to redeem promotion: %v", err)
+	}
+
+	usageID := promotionUsageID(promotionID, userID)
+	filter := bson.M{"_id": usageID}
+	if promotion.PerUserLimit > 0 {
+		filter["count"] = bson.M{"$lt": promotion.PerUserLimit}
+	}
+	result, err := usageCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to count promotion usage: %v", err)
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// The customer's first use, or the limit was reached: then the document exists and the insert fails
+	_, err = usageCollection.InsertOne(ctx, bson.M{"_id": usageID, "promotion_id": promotionID, "user_id": userID, "count": 1})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("%w: you already used %s as often as allowed", ErrInvalidOrder, promotion.Name)
+		}
+		return fmt.Errorf("failed to count promotion usage: %v", err)
+	}
+	return nil
+}
+
+// releaseOrderPromotions gives back the promotions an order redeemed, when it is cancelled before it shipped
+func releaseOrderPromotions(ctx mongo.SessionContext, order *model.Order) error {
+	promotionCollection := config.MongoDB.Collection("promotions")
+	usageCollection := config.MongoDB.Collection("promotion_usages")
+
+	for _, applied := range order.Promotions {
+		_, err := promotionCollection.UpdateOne(ctx,
+			bson.M{"_id": applied.PromotionID, "used_count": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"used_count": -1}})
+		if err != nil {
+			return fmt.Errorf("failed to release promotion: %v", err)
+		}
+		_, err = usageCollection.UpdateOne(ctx,
+			bson.M{"_id": promotionUsageID(applied.PromotionID, order.UserID), "count": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"count": -1}})
+		if err != nil {
+			return fmt.Errorf("failed to release promotion usage: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// unknownShippingCost stands in for the shipping cost of a cart whose shipping was not picked yet, so
+// free shipping promotions can be compared by how much they could take off
+const unknownShippingCost = math.MaxInt32
+
+// promotionLine is a cart or order line as promotions see it
+type promotionLine struct {
+	PerfumeID  primitive.ObjectID
+	Brand      string
+	Categories []primitive.ObjectID // The perfume's categories and every category above them
+	UnitPrice  float64
+	Quantity   int
+}
+
+// promotionCart is what promotions are evaluated against
+type promotionCart struct {
+	Lines        []promotionLine
+	Codes        []string                   // Voucher codes the shopper applied
+	ShippingCost *float64                   // Nil until shipping is picked
+	UserUsage    map[primitive.ObjectID]int // Orders of the customer per promotion, nil for guests
+	Now          time.Time
+}
+
+// promotionResult is the best combination of promotions for a cart
+type promotionResult struct {
+	Applied          []model.AppliedPromotion
+	Discount         float64
+	ShippingDiscount float64
+	LineDiscounts    []float64 // Discount of each line, in the order of the cart's lines
+	Notices          []model.PromotionNotice
+}
+
+// promotionCandidate is a promotion that fits the cart, with the lines in its scope
+type promotionCandidate struct {
+	promotion *model.Promotion
+	eligible  []bool
+}
+
+// inScope tells whether a line counts for a promotion, every line counts for one without a scope
+func inScope(promotion *model.Promotion, line promotionLine) bool {
+	scope := promotion.Scope
+	if len(scope.Brands) == 0 && len(scope.CategoryIDs) == 0 && len(scope.PerfumeIDs) == 0 {
+		return true
+	}
+	if slices.Contains(scope.PerfumeIDs, line.PerfumeID) {
+		return true
+	}
+	for _, brand := range scope.Brands {
+		if strings.EqualFold(brand, line.Brand) {
+			return true
+		}
+	}
+	for _, categoryID := range line.Categories {
+		if slices.Contains(scope.CategoryIDs, categoryID) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPromotion tells why a promotion cannot be used on a cart, or returns the lines in its scope
+func checkPromotion(promotion *model.Promotion, cart promotionCart) ([]bool, string, string) {
+	switch {
+	case !promotion.Active,
+		promotion.StartsAt != nil && cart.Now.Before(promotion.StartsAt.Time()),
+		promotion.EndsAt != nil && !cart.Now.Before(promotion.EndsAt.Time()):
+		return nil, model.PromotionNotActive, "This voucher cannot be used at the moment"
+	case promotion.UsageLimit > 0 && promotion.UsedCount >= promotion.UsageLimit:
+		return nil, model.PromotionUsedUp, "This voucher has been used up"
+	case promotion.PerUserLimit > 0 && cart.UserUsage != nil && cart.UserUsage[promotion.PromotionID] >= promotion.PerUserLimit:
+		return nil, model.PromotionUserLimit, fmt.Sprintf("You already used this voucher %d times", cart.UserUsage[promotion.PromotionID])
+	case promotion.Type == model.PromotionFreeShipping && cart.ShippingCost != nil && *cart.ShippingCost <= 0:
+		return nil, model.PromotionNoShipping, "Free shipping only applies to orders that are shipped"
+	}
+
+	eligible := make([]bool, len(cart.Lines))
+	spend, units := 0.0, 0
+	for i, line := range cart.Lines {
+		if inScope(promotion, line) {
+			eligible[i] = true
+			spend += line.UnitPrice * float64(line.Quantity)
+			units += line.Quantity
+		}
+	}
+	switch {
+	case units == 0:
+		return nil, model.PromotionNoItems, "None of the perfumes in the cart are part of this promotion"
+	case spend < promotion.MinSpend:
+		return nil, model.PromotionMinSpend, fmt.Sprintf("Spend Rp%.0f more on perfumes of this promotion to use it", promotion.MinSpend-spend)
+	case promotion.Type == model.PromotionBuyXGetY && units < promotion.BuyQuantity+promotion.GetQuantity:
+		return nil, model.PromotionNoItems, fmt.Sprintf("Add %d more perfumes of this promotion to get %d free", promotion.BuyQuantity+promotion.GetQuantity-units, promotion.GetQuantity)
+	}
+	return eligible, "", ""
+}
+
+// spreadDiscount shares a discount between the eligible lines in proportion to what is left of them, in whole rupiah
+func spreadDiscount(amount float64, remaining []float64, eligible []bool) []float64 {
+	shares := make([]float64, len(remaining))
+	base := 0.0
+	for i := range remaining {
+		if eligible[i] {
+			base += remaining[i]
+		}
+	}
+	amount = math.Min(math.Floor(amount), math.Floor(base))
+	if amount <= 0 {
+		return shares
+	}
+
+	given := 0.0
+	for i := range remaining {
+		if eligible[i] {
+			shares[i] = math.Floor(amount * remaining[i] / base)
+			given += shares[i]
+		}
+	}
+	// Rounding down leaves a few rupiah, they go to the lines with room left
+	for i := 0; given < amount && i < len(remaining); i++ {
+		if eligible[i] {
+			extra := math.Min(amount-given, math.Floor(remaining[i]-shares[i]))
+			shares[i] += extra
+			given += extra
+		}
+	}
+	return shares
+}
+
+// lineDiscounts works out what an item promotion takes off each line, given what is left of the lines
+// after the promotions applied before it
+func lineDiscounts(promotion *model.Promotion, lines []promotionLine, remaining []float64, eligible []bool) []float64 {
+	switch promotion.Type {
+	case model.PromotionPercentage:
+		base := 0.0
+		for i := range remaining {
+			if eligible[i] {
+				base += remaining[i]
+			}
+		}
+		amount := base * promotion.Value / 100
+		if promotion.MaxDiscount > 0 {
+			amount = math.Min(amount, promotion.MaxDiscount)
+		}
+		return spreadDiscount(amount, remaining, eligible)
+
+	case model.PromotionFixed:
+		return spreadDiscount(promotion.Value, remaining, eligible)
+
+	case model.PromotionBuyXGetY:
+		// Bottles are grouped most expensive first, the cheapest of every group are free
+		type unit struct {
+			line  int
+			price float64
+		}
+		units := []unit{}
+		for i, line := range lines {
+			for n := 0; eligible[i] && n < line.Quantity; n++ {
+				units = append(units, unit{line: i, price: line.UnitPrice})
+			}
+		}
+		sort.SliceStable(units, func(a, b int) bool { return units[a].price > units[b].price })
+		free := len(units) / (promotion.BuyQuantity + promotion.GetQuantity) * promotion.GetQuantity
+
+		discounts := make([]float64, len(lines))
+		for _, u := range units[len(units)-free:] {
+			discounts[u.line] += u.price
+		}
+		for i := range discounts {
+			discounts[i] = math.Floor(math.Min(discounts[i], remaining[i]))
+		}
+		return discounts
+	}
+	return make([]float64, len(lines))
+}
+
+// promotionApplication is a combination of promotions of one kind and what each takes off
+type promotionApplication struct {
+	promotions []*model.Promotion
+	discounts  []float64
+	lines      []float64
+	total      float64
+}
+
+// applyItemPromotions applies promotions one after the other, each on what is left of the lines
+func applyItemPromotions(candidates []promotionCandidate, lines []promotionLine) promotionApplication {
+	remaining := make([]float64, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.UnitPrice * float64(line.Quantity)
+	}
+
+	application := promotionApplication{lines: make([]float64, len(lines))}
+	for _, candidate := range candidates {
+		discount := 0.0
+		for i, amount := range lineDiscounts(candidate.promotion, lines, remaining, candidate.eligible) {
+			remaining[i] -= amount
+			application.lines[i] += amount
+			discount += amount
+		}
+		application.promotions = append(application.promotions, candidate.promotion)
+		application.discounts = append(application.discounts, discount)
+		application.total += discount
+	}
+	return application
+}
+
+// applyShippingPromotions applies free shipping promotions one after the other on what is left of the shipping cost
+func applyShippingPromotions(candidates []promotionCandidate, shippingCost float64) promotionApplication {
+	application := promotionApplication{}
+	remaining := shippingCost
+	for _, candidate := range candidates {
+		discount := remaining
+		if candidate.promotion.Value > 0 {
+			discount = math.Min(candidate.promotion.Value, remaining)
+		}
+		remaining -= discount
+		application.promotions = append(application.promotions, candidate.promotion)
+		application.discounts = append(application.discounts, discount)
+		application.total += discount
+	}
+	return application
+}
+
+// bestApplication picks what takes off the most: every stackable promotion together, or one of the others alone
+func bestApplication(candidates []promotionCandidate, apply func([]promotionCandidate) promotionApplication) promotionApplication {
+	stackable := []promotionCandidate{}
+	exclusive := []promotionCandidate{}
+	for _, candidate := range candidates {
+		if candidate.promotion.Stackable {
+			stackable = append(stackable, candidate)
+		} else {
+			exclusive = append(exclusive, candidate)
+		}
+	}
+
+	best := apply(stackable)
+	for _, candidate := range exclusive {
+		if application := apply([]promotionCandidate{candidate}); application.total > best.total {
+			best = application
+		}
+	}
+	return best
+}
+
+// evaluatePromotions finds the best combination of promotions for a cart. Promotions on perfumes and on
+// shipping are combined separately: within each, the stackable ones apply together (highest priority first,
+// each on what the ones before left) unless one that does not stack takes off more on its own.
+// Vouchers the shopper applied that are not used are reported with the reason.
+func evaluatePromotions(promotions []model.Promotion, cart promotionCart) promotionResult {
+	result := promotionResult{
+		Applied:       []model.AppliedPromotion{},
+		LineDiscounts: make([]float64, len(cart.Lines)),
+		Notices:       []model.PromotionNotice{},
+	}
+
+	// Highest priority first, then the oldest, so the outcome does not depend on how they were loaded
+	sorted := make([]*model.Promotion, len(promotions))
+	for i := range promotions {
+		sorted[i] = &promotions[i]
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return sorted[i].PromotionID.Hex() < sorted[j].PromotionID.Hex()
+	})
+
+	applied := map[string]bool{}
+	for _, code := range cart.Codes {
+		applied[code] = true
+	}
+	found := map[string]bool{}
+
+	itemCandidates := []promotionCandidate{}
+	shippingCandidates := []promotionCandidate{}
+	for _, promotion := range sorted {
+		if promotion.Code != "" {
+			if !applied[promotion.Code] {
+				continue
+			}
+			found[promotion.Code] = true
+		}
+		eligible, reason, message := checkPromotion(promotion, cart)
+		if reason != "" {
+			if promotion.Code != "" {
+				result.Notices = append(result.Notices, model.PromotionNotice{Code: promotion.Code, Reason: reason, Message: message})
+			}
+			continue
+		}
+		if promotion.Type == model.PromotionFreeShipping {
+			shippingCandidates = append(shippingCandidates, promotionCandidate{promotion: promotion, eligible: eligible})
+		} else {
+			itemCandidates = append(itemCandidates, promotionCandidate{promotion: promotion, eligible: eligible})
+		}
+	}
+	for _, code := range cart.Codes {
+		if !found[code] {
+			result.Notices = append(result.Notices, model.PromotionNotice{Code: code, Reason: model.PromotionNotFound, Message: "This voucher does not exist"})
+		}
+	}
+
+	items := bestApplication(itemCandidates, func(candidates []promotionCandidate) promotionApplication {
+		return applyItemPromotions(candidates, cart.Lines)
+	})
+	shippingCost := float64(unknownShippingCost)
+	if cart.ShippingCost != nil {
+		shippingCost = *cart.ShippingCost
+	}
+	shipping := bestApplication(shippingCandidates, func(candidates []promotionCandidate) promotionApplication {
+		return applyShippingPromotions(candidates, shippingCost)
+	})
+
+	used := map[primitive.ObjectID]bool{}
+	for i, promotion := range items.promotions {
+		if items.discounts[i] <= 0 {
+			continue
+		}
+		used[promotion.PromotionID] = true
+		result.Applied = append(result.Applied, model.AppliedPromotion{
+			PromotionID: promotion.PromotionID,
+			Name:        promotion.Name,
+			Code:        promotion.Code,
+			Type:        promotion.Type,
+			Discount:    items.discounts[i],
+		})
+		result.Discount += items.discounts[i]
+	}
+	if items.lines != nil {
+		result.LineDiscounts = items.lines
+	}
+	for i, promotion := range shipping.promotions {
+		if shipping.discounts[i] <= 0 {
+			continue
+		}
+		used[promotion.PromotionID] = true
+		applied := model.AppliedPromotion{
+			PromotionID: promotion.PromotionID,
+			Name:        promotion.Name,
+			Code:        promotion.Code,
+			Type:        promotion.Type,
+		}
+		// Until shipping is picked the promotion is listed without an amount
+		if cart.ShippingCost != nil {
+			applied.ShippingDiscount = shipping.discounts[i]
+			result.ShippingDiscount += shipping.discounts[i]
+		}
+		result.Applied = append(result.Applied, applied)
+	}
+
+	for _, candidate := range append(itemCandidates, shippingCandidates...) {
+		if candidate.promotion.Code != "" && !used[candidate.promotion.PromotionID] {
+			result.Notices = append(result.Notices, model.PromotionNotice{
+				Code:    candidate.promotion.Code,
+				Reason:  model.PromotionNotCombinable,
+				Message: "A better combination of promotions was applied instead of this voucher",
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bottles builds cart lines of one bottle each at the given prices
+func bottles(prices ...float64) []promotionLine {
+	lines := make([]promotionLine, len(prices))
+	for i, price := range prices {
+		lines[i] = promotionLine{PerfumeID: primitive.NewObjectID(), Brand: "Dior", UnitPrice: price, Quantity: 1}
+	}
+	return lines
+}
+
+func TestSpreadDiscount(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    float64
+		remaining []float64
+		eligible  []bool
+		want      []float64
+	}{
+		{"in proportion", 100, []float64{300, 100}, []bool{true, true}, []float64{75, 25}},
+		{"leftover rupiah go to the first lines", 100, []float64{100, 100, 100}, []bool{true, true, true}, []float64{34, 33, 33}},
+		{"fractions of a rupiah are dropped", 99.9, []float64{200}, []bool{true}, []float64{99}},
+		{"leftover skips lines without room", 10, []float64{0.5, 100}, []bool{true, true}, []float64{0, 10}},
+		{"lines out of scope get nothing", 50, []float64{100, 100}, []bool{true, false}, []float64{50, 0}},
+		{"never more than what is left", 500, []float64{100, 50}, []bool{true, true}, []float64{100, 50}},
+		{"nothing left", 100, []float64{0, 0}, []bool{true, true}, []float64{0, 0}},
+		{"nothing in scope", 100, []float64{100}, []bool{false}, []float64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := spreadDiscount(tt.amount, tt.remaining, tt.eligible); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spreadDiscount(%v, %v) = %v, want %v", tt.amount, tt.remaining, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineDiscounts(t *testing.T) {
+	buyXGetY := func(buy, get int) *model.Promotion {
+		return &model.Promotion{Type: model.PromotionBuyXGetY, BuyQuantity: buy, GetQuantity: get}
+	}
+	line := func(price float64, quantity int) promotionLine {
+		return promotionLine{UnitPrice: price, Quantity: quantity}
+	}
+
+	tests := []struct {
+		name      string
+		promotion *model.Promotion
+		lines     []promotionLine
+		remaining []float64
+		eligible  []bool
+		want      []float64
+	}{
+		{
+			name:      "percentage",
+			promotion: &model.Promotion{Type: model.PromotionPercentage, Value: 10},
+			lines:     []promotionLine{line(150000, 1), line(50000, 2)},
+			remaining: []float64{150000, 100000},
+			eligible:  []bool{true, true},
+			want:      []float64{15000, 10000},
+		},
+		{
+			name:      "percentage capped and rounded",
+			promotion: &model.Promotion{Type: model.PromotionPercentage, Value: 10, MaxDiscount: 20000},
+			lines:     []promotionLine{line(200000, 1), line(100000, 1)},
+			remaining: []float64{200000, 100000},
+			eligible:  []bool{true, true},
+			want:      []float64{13334, 6666},
+		},
+		{
+			name:      "percentage of what earlier promotions left",
+			promotion: &model.Promotion{Type: model.PromotionPercentage, Value: 10},
+			lines:     []promotionLine{line(100000, 1)},
+			remaining: []float64{90000},
+			eligible:  []bool{true},
+			want:      []float64{9000},
+		},
+		{
+			name:      "fixed",
+			promotion: &model.Promotion{Type: model.PromotionFixed, Value: 25000},
+			lines:     []promotionLine{line(100000, 1), line(50000, 1)},
+			remaining: []float64{100000, 50000},
+			eligible:  []bool{true, false},
+			want:      []float64{25000, 0},
+		},
+		{
+			name:      "buy 2 get 1, the cheapest is free",
+			promotion: buyXGetY(2, 1),
+			lines:     []promotionLine{line(300000, 2), line(100000, 1)},
+			remaining: []float64{600000, 100000},
+			eligible:  []bool{true, true},
+			want:      []float64{0, 100000},
+		},
+		{
+			name:      "buy 1 get 1, one free bottle per pair",
+			promotion: buyXGetY(1, 1),
+			lines:     []promotionLine{line(300000, 3), line(100000, 1)},
+			remaining: []float64{900000, 100000},
+			eligible:  []bool{true, true},
+			want:      []float64{300000, 100000},
+		},
+		{
+			name:      "incomplete group",
+			promotion: buyXGetY(2, 1),
+			lines:     []promotionLine{line(200000, 5)},
+			remaining: []float64{1000000},
+			eligible:  []bool{true},
+			want:      []float64{200000},
+		},
+		{
+			name:      "lines out of scope are not counted",
+			promotion: buyXGetY(1, 1),
+			lines:     []promotionLine{line(300000, 1), line(100000, 1)},
+			remaining: []float64{300000, 100000},
+			eligible:  []bool{true, false},
+			want:      []float64{0, 0},
+		},
+		{
+			name:      "free bottles never take off more than what is left",
+			promotion: buyXGetY(1, 1),
+			lines:     []promotionLine{line(100000, 2)},
+			remaining: []float64{59999.5},
+			eligible:  []bool{true},
+			want:      []float64{59999},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiscounts(tt.promotion, tt.lines, tt.remaining, tt.eligible); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lineDiscounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatePromotions(t *testing.T) {
+	now := time.Now()
+	shipping := func(cost float64) *float64 { return &cost }
+	ended := primitive.NewDateTimeFromTime(now.Add(-time.Hour))
+
+	tests := []struct {
+		name         string
+		promotions   []model.Promotion
+		lines        []promotionLine
+		codes        []string
+		shippingCost *float64
+		discount     float64
+		shipping     float64
+		lineDiscount []float64
+		applied      []string // Name, discount and shipping discount of every promotion applied, in order
+		notices      []string // Code and reason of every notice
+	}{
+		{
+			name: "stacked highest priority first",
+			promotions: []model.Promotion{
+				{Name: "Fixed", Type: model.PromotionFixed, Value: 10000, Stackable: true, Priority: 1},
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 10, Stackable: true, Priority: 2},
+			},
+			lines:        bottles(100000),
+			discount:     20000,
+			lineDiscount: []float64{20000},
+			applied:      []string{"Percent 10000/0", "Fixed 10000/0"},
+		},
+		{
+			name: "the percentage applies to what the fixed discount left",
+			promotions: []model.Promotion{
+				{Name: "Fixed", Type: model.PromotionFixed, Value: 10000, Stackable: true, Priority: 2},
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 10, Stackable: true, Priority: 1},
+			},
+			lines:        bottles(100000),
+			discount:     19000,
+			lineDiscount: []float64{19000},
+			applied:      []string{"Fixed 10000/0", "Percent 9000/0"},
+		},
+		{
+			name: "one that does not stack wins when it takes off more",
+			promotions: []model.Promotion{
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 5, Stackable: true, Priority: 2},
+				{Name: "Voucher", Code: "HEMAT", Type: model.PromotionFixed, Value: 5000, Stackable: true, Priority: 1},
+				{Name: "Sale", Type: model.PromotionPercentage, Value: 20},
+			},
+			lines:        bottles(100000),
+			codes:        []string{"HEMAT"},
+			discount:     20000,
+			lineDiscount: []float64{20000},
+			applied:      []string{"Sale 20000/0"},
+			notices:      []string{"HEMAT " + model.PromotionNotCombinable},
+		},
+		{
+			name: "the stack wins when it takes off more",
+			promotions: []model.Promotion{
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 10, Stackable: true, Priority: 2},
+				{Name: "Fixed", Type: model.PromotionFixed, Value: 5000, Stackable: true, Priority: 1},
+				{Name: "Voucher", Code: "SALE", Type: model.PromotionPercentage, Value: 12},
+			},
+			lines:        bottles(100000),
+			codes:        []string{"SALE"},
+			discount:     15000,
+			lineDiscount: []float64{15000},
+			applied:      []string{"Percent 10000/0", "Fixed 5000/0"},
+			notices:      []string{"SALE " + model.PromotionNotCombinable},
+		},
+		{
+			name: "a tie keeps the stack",
+			promotions: []model.Promotion{
+				{Name: "Fixed", Type: model.PromotionFixed, Value: 10000, Stackable: true},
+				{Name: "Other", Type: model.PromotionFixed, Value: 10000},
+			},
+			lines:        bottles(100000),
+			discount:     10000,
+			lineDiscount: []float64{10000},
+			applied:      []string{"Fixed 10000/0"},
+		},
+		{
+			name: "rounding never loses a rupiah",
+			promotions: []model.Promotion{
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 15, Stackable: true},
+			},
+			lines:        bottles(999, 999, 999),
+			discount:     449,
+			lineDiscount: []float64{151, 149, 149},
+			applied:      []string{"Percent 449/0"},
+		},
+		{
+			name: "stacked rounding",
+			promotions: []model.Promotion{
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 33, Stackable: true, Priority: 2},
+				{Name: "Fixed", Type: model.PromotionFixed, Value: 1001, Stackable: true, Priority: 1},
+			},
+			lines:        bottles(1000, 2000),
+			discount:     1991,
+			lineDiscount: []float64{664, 1327},
+			applied:      []string{"Percent 990/0", "Fixed 1001/0"},
+		},
+		{
+			name: "scope limits the lines",
+			promotions: []model.Promotion{
+				{Name: "Chanel", Type: model.PromotionPercentage, Value: 50, Scope: model.PromotionScope{Brands: []string{"chanel"}}},
+			},
+			lines: []promotionLine{
+				{PerfumeID: primitive.NewObjectID(), Brand: "Dior", UnitPrice: 100000, Quantity: 1},
+				{PerfumeID: primitive.NewObjectID(), Brand: "Chanel", UnitPrice: 80000, Quantity: 1},
+			},
+			discount:     40000,
+			lineDiscount: []float64{0, 40000},
+			applied:      []string{"Chanel 40000/0"},
+		},
+		{
+			name: "buy X get Y stacks with a percentage",
+			promotions: []model.Promotion{
+				{Name: "Bundle", Type: model.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Stackable: true, Priority: 2},
+				{Name: "Percent", Type: model.PromotionPercentage, Value: 10, Stackable: true, Priority: 1},
+			},
+			lines:        bottles(300000, 200000, 100000),
+			discount:     150000,
+			lineDiscount: []float64{30000, 20000, 100000},
+			applied:      []string{"Bundle 100000/0", "Percent 50000/0"},
+		},
+		{
+			name: "free shipping stacks on what is left of the shipping",
+			promotions: []model.Promotion{
+				{Name: "Ongkir 15k", Type: model.PromotionFreeShipping, Value: 15000, Stackable: true, Priority: 2},
+				{Name: "Ongkir", Type: model.PromotionFreeShipping, Stackable: true, Priority: 1},
+			},
+			lines:        bottles(100000),
+			shippingCost: shipping(20000),
+			shipping:     20000,
+			lineDiscount: []float64{0},
+			applied:      []string{"Ongkir 15k 0/15000", "Ongkir 0/5000"},
+		},
+		{
+			name: "free shipping before shipping is picked",
+			promotions: []model.Promotion{
+				{Name: "Ongkir", Type: model.PromotionFreeShipping, Value: 15000},
+			},
+			lines:        bottles(100000),
+			lineDiscount: []float64{0},
+			applied:      []string{"Ongkir 0/0"},
+		},
+		{
+			name: "perfumes and shipping are combined separately",
+			promotions: []model.Promotion{
+				{Name: "Sale", Type: model.PromotionPercentage, Value: 10},
+				{Name: "Ongkir", Type: model.PromotionFreeShipping},
+			},
+			lines:        bottles(100000),
+			shippingCost: shipping(12000),
+			discount:     10000,
+			shipping:     12000,
+			lineDiscount: []float64{10000},
+			applied:      []string{"Sale 10000/0", "Ongkir 0/12000"},
+		},
+		{
+			name: "vouchers that do not apply are reported",
+			promotions: []model.Promotion{
+				{Name: "Big spender", Code: "BIG", Type: model.PromotionFixed, Value: 50000, MinSpend: 500000},
+				{Name: "Ended", Code: "OLD", Type: model.PromotionFixed, Value: 50000, EndsAt: &ended},
+				{Name: "Ongkir", Code: "ONGKIR", Type: model.PromotionFreeShipping},
+				{Name: "Not applied", Code: "LATER", Type: model.PromotionFixed, Value: 50000},
+			},
+			lines:        bottles(100000),
+			codes:        []string{"BIG", "OLD", "ONGKIR", "NOPE"},
+			shippingCost: shipping(0),
+			lineDiscount: []float64{0},
+			notices: []string{
+				"BIG " + model.PromotionMinSpend,
+				"OLD " + model.PromotionNotActive,
+				"ONGKIR " + model.PromotionNoShipping,
+				"NOPE " + model.PromotionNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.promotions {
+				tt.promotions[i].PromotionID = primitive.NewObjectID()
+				tt.promotions[i].Active = tt.promotions[i].EndsAt == nil
+			}
+			result := evaluatePromotions(tt.promotions, promotionCart{Lines: tt.lines, Codes: tt.codes, ShippingCost: tt.shippingCost, Now: now})
+
+			if result.Discount != tt.discount || result.ShippingDiscount != tt.shipping {
+				t.Errorf("discount %v and shipping discount %v, want %v and %v", result.Discount, result.ShippingDiscount, tt.discount, tt.shipping)
+			}
+			if !reflect.DeepEqual(result.LineDiscounts, tt.lineDiscount) {
+				t.Errorf("line discounts %v, want %v", result.LineDiscounts, tt.lineDiscount)
+			}
+			lineTotal := 0.0
+			for _, amount := range result.LineDiscounts {
+				lineTotal += amount
+			}
+			if lineTotal != result.Discount {
+				t.Errorf("line discounts add up to %v, the discount is %v", lineTotal, result.Discount)
+			}
+
+			applied := []string{}
+			for _, promotion := range result.Applied {
+				applied = append(applied, fmt.Sprintf("%s %.0f/%.0f", promotion.Name, promotion.Discount, promotion.ShippingDiscount))
+			}
+			if len(applied) != len(tt.applied) || (len(applied) > 0 && !reflect.DeepEqual(applied, tt.applied)) {
+				t.Errorf("applied %q, want %q", applied, tt.applied)
+			}
+			notices := []string{}
+			for _, notice := range result.Notices {
+				notices = append(notices, notice.Code+" "+notice.Reason)
+			}
+			if len(notices) != len(tt.notices) || (len(notices) > 0 && !reflect.DeepEqual(notices, tt.notices)) {
+				t.Errorf("notices %q, want %q", notices, tt.notices)
+			}
+		})
+	}
+}
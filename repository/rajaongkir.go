@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RajaOngkirProvider prices parcels and tracks airway bills through the RajaOngkir Pro API, which covers
+// JNE, J&T and SiCepat among others
+type RajaOngkirProvider struct {
+	APIKey     string
+	BaseURL    string // https://pro.rajaongkir.com/api unless pointed at a fake server
+	HTTPClient *http.Client
+}
+
+// NewRajaOngkirProvider reads the RAJAONGKIR_* environment variables
+func NewRajaOngkirProvider() (*RajaOngkirProvider, error) {
+	provider := &RajaOngkirProvider{
+		APIKey:     os.Getenv("RAJAONGKIR_API_KEY"),
+		BaseURL:    strings.TrimSuffix(envString("RAJAONGKIR_URL", "https://pro.rajaongkir.com/api"), "/"),
+		HTTPClient: &http.Client{Timeout: envDuration("RAJAONGKIR_TIMEOUT", 15*time.Second)},
+	}
+
+	// Validate RajaOngkir credentials and the warehouse it prices from
+	if provider.APIKey == "" {
+		return nil, errors.New("RAJAONGKIR_API_KEY is missing in environment variables")
+	}
+	if origin := ShippingOrigin(); origin.CityID == "" && origin.SubdistrictID == "" {
+		return nil, errors.New("SHIPPING_ORIGIN_CITY_ID or SHIPPING_ORIGIN_SUBDISTRICT_ID is required for RajaOngkir")
+	}
+
+	return provider, nil
+}
+
+// Name identifies the provider on quotes
+func (r *RajaOngkirProvider) Name() string {
+	return "rajaongkir"
+}
+
+// rajaOngkirStatus is the status every RajaOngkir response carries besides the HTTP status
+type rajaOngkirStatus struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+// rajaOngkirError is a request RajaOngkir answered with an error status
+type rajaOngkirError struct {
+	Code        int
+	Description string
+}
+
+func (e *rajaOngkirError) Error() string {
+	return fmt.Sprintf("RajaOngkir answered %d: %s", e.Code, e.Description)
+}
+
+// post sends a form to RajaOngkir and decodes the "rajaongkir" object of the answer into out
+func (r *RajaOngkirProvider) post(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create RajaOngkir request: %v", err)
+	}
+	req.Header.Set("key", r.APIKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach RajaOngkir: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	var envelope struct {
+		RajaOngkir json.RawMessage `json:"rajaongkir"`
+	}
+	var status struct {
+		Status rajaOngkirStatus `json:"status"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.RajaOngkir == nil {
+		return &rajaOngkirError{Code: resp.StatusCode, Description: http.StatusText(resp.StatusCode)}
+	}
+	json.Unmarshal(envelope.RajaOngkir, &status)
+	if resp.StatusCode != http.StatusOK || (status.Status.Code != 0 && status.Status.Code != http.StatusOK) {
+		code := status.Status.Code
+		if code == 0 {
+			code = resp.StatusCode
+		}
+		return &rajaOngkirError{Code: code, Description: status.Status.Description}
+	}
+
+	if err := json.Unmarshal(envelope.RajaOngkir, out); err != nil {
+		return fmt.Errorf("failed to read RajaOngkir response: %v", err)
+	}
+	return nil
+}
+
+// rajaOngkirLocation returns the ID and type RajaOngkir knows a place by, the subdistrict when known
+func rajaOngkirLocation(location model.ShippingLocation) (string, string) {
+	if location.SubdistrictID != "" {
+		return location.SubdistrictID, "subdistrict"
+	}
+	return location.CityID, "city"
+}
+
+// rajaOngkirCourier turns a courier code of a response into ours, RajaOngkir answers J&T as "J&T"
+func rajaOngkirCourier(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "j&t" {
+		return "jnt"
+	}
+	return code
+}
+
+// Quote asks RajaOngkir for the rates of every courier in one request
+func (r *RajaOngkirProvider) Quote(ctx context.Context, origin, destination model.ShippingLocation, weight int, couriers []string) ([]model.ShippingRate, error) {
+	destinationID, destinationType := rajaOngkirLocation(destination)
+	if destinationID == "" {
+		return nil, fmt.Errorf("%w: RajaOngkir needs the destination city_id or subdistrict_id", ErrInvalidShipping)
+	}
+	originID, originType := rajaOngkirLocation(origin)
+
+	form := url.Values{}
+	form.Set("origin", originID)
+	form.Set("originType", originType)
+	form.Set("destination", destinationID)
+	form.Set("destinationType", destinationType)
+	form.Set("weight", strconv.Itoa(weight))
+	form.Set("courier", strings.Join(couriers, ":"))
+
+	var answer struct {
+		Results []struct {
+			Code  string `json:"code"`
+			Costs []struct {
+				Service     string `json:"service"`
+				Description string `json:"description"`
+				Cost        []struct {
+					Value float64 `json:"value"`
+					ETD   string  `json:"etd"`
+				} `json:"cost"`
+			} `json:"costs"`
+		} `json:"results"`
+	}
+	if err := r.post(ctx, "/cost", form, &answer); err != nil {
+		var apiErr *rajaOngkirError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			// Unknown cities and subdistricts are the caller's mistake, retrying elsewhere does not help
+			return nil, fmt.Errorf("%w: %s", ErrInvalidShipping, apiErr.Description)
+		}
+		return nil, err
+	}
+
+	rates := []model.ShippingRate{}
+	for _, result := range answer.Results {
+		courier := rajaOngkirCourier(result.Code)
+		for _, cost := range result.Costs {
+			if len(cost.Cost) == 0 || cost.Cost[0].Value <= 0 {
+				continue
+			}
+			rates = append(rates, model.ShippingRate{
+				Courier:     courier,
+				CourierName: courierNames[courier],
+				Service:     cost.Service,
+				Description: cost.Description,
+				Cost:        cost.Cost[0].Value,
+				ETD:         strings.TrimSpace(strings.TrimSuffix(strings.ToUpper(cost.Cost[0].ETD), "HARI")),
+			})
+		}
+	}
+	return rates, nil
+}
+
+// rajaOngkirZone is the time zone of the dates in waybill manifests (WIB)
+var rajaOngkirZone = time.FixedZone("WIB", 7*60*60)
+
+// rajaOngkirTime reads a manifest date and time such as "2024-04-01" and "20:33" or "20:33:00"
+func rajaOngkirTime(date, clock string) primitive.DateTime {
+	clock = strings.TrimSpace(clock)
+	if len(clock) == len("15:04") {
+		clock += ":00"
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSpace(date)+" "+clock, rajaOngkirZone)
+	if err != nil {
+		at, _ = time.ParseInLocation("2006-01-02", strings.TrimSpace(date), rajaOngkirZone)
+	}
+	return primitive.NewDateTimeFromTime(at)
+}
+
+// Track reads the manifest of an airway bill. A bill the courier does not know yet is reported as created,
+// couriers often register the AWB hours after the label was printed.
+func (r *RajaOngkirProvider) Track(ctx context.Context, courier, awb string) (*model.Tracking, error) {
+	form := url.Values{}
+	form.Set("waybill", awb)
+	form.Set("courier", courier)
+
+	var answer struct {
+		Result struct {
+			Delivered bool `json:"delivered"`
+			Summary   struct {
+				Status string `json:"status"`
+			} `json:"summary"`
+			DeliveryStatus struct {
+				Status      string `json:"status"`
+				PodReceiver string `json:"pod_receiver"`
+				PodDate     string `json:"pod_date"`
+				PodTime     string `json:"pod_time"`
+			} `json:"delivery_status"`
+			Manifest []struct {
+				Description string `json:"manifest_description"`
+				Date        string `json:"manifest_date"`
+				Time        string `json:"manifest_time"`
+				City        string `json:"city_name"`
+			} `json:"manifest"`
+		} `json:"result"`
+	}
+	if err := r.post(ctx, "/waybill", form, &answer); err != nil {
+		var apiErr *rajaOngkirError
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Description), "waybill") {
+			return &model.Tracking{Status: model.ShipmentCreated, Events: []model.TrackingEvent{}}, nil
+		}
+		return nil, err
+	}
+
+	result := answer.Result
+	tracking := &model.Tracking{Status: model.ShipmentCreated, Events: []model.TrackingEvent{}}
+	for _, line := range result.Manifest {
+		tracking.Events = append(tracking.Events, model.TrackingEvent{
+			Description: strings.TrimSpace(line.Description),
+			Location:    strings.TrimSpace(line.City),
+			At:          rajaOngkirTime(line.Date, line.Time),
+		})
+	}
+	// Couriers list the manifest newest first or oldest first, we keep it oldest first
+	if len(tracking.Events) > 1 && tracking.Events[0].At > tracking.Events[len(tracking.Events)-1].At {
+		for i, j := 0, len(tracking.Events)-1; i < j; i, j = i+1, j-1 {
+			tracking.Events[i], tracking.Events[j] = tracking.Events[j], tracking.Events[i]
+		}
+	}
+
+	status := strings.ToUpper(result.Summary.Status + " " + result.DeliveryStatus.Status)
+	switch {
+	case result.Delivered:
+		tracking.Status = model.ShipmentDelivered
+		tracking.Receiver = strings.TrimSpace(result.DeliveryStatus.PodReceiver)
+		if result.DeliveryStatus.PodDate != "" {
+			deliveredAt := rajaOngkirTime(result.DeliveryStatus.PodDate, result.DeliveryStatus.PodTime)
+			tracking.DeliveredAt = &deliveredAt
+		}
+	case strings.Contains(status, "RETUR"):
+		tracking.Status = model.ShipmentReturned
+	case len(tracking.Events) > 0:
+		tracking.Status = model.ShipmentInTransit
+	}
+	return tracking, nil
+}
@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/fakeapi"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testRajaOngkirKey = "rajaongkir-test"
+
+// rajaOngkirTestServer runs the fake RajaOngkir API and returns a provider using it
+func rajaOngkirTestServer(t *testing.T) (*httptest.Server, *RajaOngkirProvider) {
+	t.Helper()
+	server := httptest.NewServer(fakeapi.NewRajaOngkir(testRajaOngkirKey))
+	t.Cleanup(server.Close)
+
+	provider := &RajaOngkirProvider{
+		APIKey:     testRajaOngkirKey,
+		BaseURL:    server.URL,
+		HTTPClient: server.Client(),
+	}
+	return server, provider
+}
+
+// rajaOngkirFake posts a body to one of the /_fake routes of the fake
+func rajaOngkirFake(t *testing.T, server *httptest.Server, path string, body interface{}) {
+	t.Helper()
+	content, _ := json.Marshal(body)
+	resp, err := http.Post(server.URL+path, "application/json", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("failed to post %s: %v", path, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed to post %s: status %d", path, resp.StatusCode)
+	}
+}
+
+// useShipping makes the fake the shipping provider, with the rate table as fallback when asked for,
+// and sets up a warehouse in Bandung
+func useShipping(t *testing.T, provider ShippingProvider, fallback bool) {
+	t.Helper()
+	previous, previousFallback := Shipping, shippingFallback
+	Shipping, shippingFallback = provider, nil
+	if fallback {
+		shippingFallback = &TableShipping{Rates: defaultTableRates}
+	}
+	t.Cleanup(func() { Shipping, shippingFallback = previous, previousFallback })
+
+	t.Setenv("SHIPPING_ORIGIN_CITY_ID", "23")
+	t.Setenv("SHIPPING_ORIGIN_CITY", "Kota Bandung")
+	t.Setenv("SHIPPING_ORIGIN_PROVINCE", "Jawa Barat")
+	t.Setenv("SHIPPING_COURIERS", "jne,jnt,sicepat")
+}
+
+// findRate picks a rate by courier and service
+func findRate(rates []model.ShippingRate, courier, service string) *model.ShippingRate {
+	for i := range rates {
+		if rates[i].Courier == courier && rates[i].Service == service {
+			return &rates[i]
+		}
+	}
+	return nil
+}
+
+func TestRajaOngkirQuote(t *testing.T) {
+	_, provider := rajaOngkirTestServer(t)
+	origin := model.ShippingLocation{CityID: "23", City: "Kota Bandung", Province: "Jawa Barat"}
+
+	tests := []struct {
+		name        string
+		destination model.ShippingLocation
+		weight      int
+		courier     string
+		service     string
+		cost        float64
+		etd         string
+	}{
+		{"same city", model.ShippingLocation{CityID: "23"}, 600, "jne", "REG", 9000, "2-3"},
+		{"another city", model.ShippingLocation{CityID: "152"}, 600, "jne", "REG", 24000, "2-3"},
+		{"started kilograms", model.ShippingLocation{CityID: "152"}, 2100, "jne", "REG", 72000, "2-3"},
+		{"J&T answered as jnt", model.ShippingLocation{CityID: "152"}, 1000, "jnt", "EZ", 22000, "2-4"},
+		{"subdistrict", model.ShippingLocation{SubdistrictID: "23"}, 1000, "sicepat", "BEST", 35000, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates, err := provider.Quote(context.Background(), origin, tt.destination, tt.weight, []string{"jne", "jnt", "sicepat"})
+			if err != nil {
+				t.Fatalf("Quote: %v", err)
+			}
+			if len(rates) != 6 {
+				t.Errorf("got %d rates, want 6", len(rates))
+			}
+			rate := findRate(rates, tt.courier, tt.service)
+			if rate == nil {
+				t.Fatalf("no %s %s rate in %+v", tt.courier, tt.service, rates)
+			}
+			if rate.Cost != tt.cost || rate.ETD != tt.etd || rate.CourierName != courierNames[tt.courier] {
+				t.Errorf("%s %s = %v, ETD %q, name %q, want %v, ETD %q", tt.courier, tt.service, rate.Cost, rate.ETD, rate.CourierName, tt.cost, tt.etd)
+			}
+		})
+	}
+}
+
+func TestRajaOngkirQuoteRejects(t *testing.T) {
+	server, provider := rajaOngkirTestServer(t)
+	origin := model.ShippingLocation{CityID: "23"}
+
+	// Without an ID RajaOngkir is not asked at all
+	if _, err := provider.Quote(context.Background(), origin, model.ShippingLocation{City: "Kota Bandung"}, 500, []string{"jne"}); !errors.Is(err, ErrInvalidShipping) {
+		t.Errorf("Quote without a destination ID: %v, want ErrInvalidShipping", err)
+	}
+	// RajaOngkir answering 400 is the caller's mistake
+	if _, err := provider.Quote(context.Background(), origin, model.ShippingLocation{CityID: "bandung"}, 500, []string{"jne"}); !errors.Is(err, ErrInvalidShipping) {
+		t.Errorf("Quote to an unknown city: %v, want ErrInvalidShipping", err)
+	}
+	// A failing API is not
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusServiceUnavailable})
+	_, err := provider.Quote(context.Background(), origin, model.ShippingLocation{CityID: "152"}, 500, []string{"jne"})
+	if err == nil || errors.Is(err, ErrInvalidShipping) {
+		t.Errorf("Quote during an outage: %v, want an unavailable error", err)
+	}
+
+	provider.APIKey = "wrong"
+	if _, err := provider.Quote(context.Background(), origin, model.ShippingLocation{CityID: "152"}, 500, []string{"jne"}); err == nil {
+		t.Error("Quote with a wrong key succeeded")
+	}
+}
+
+func TestQuoteRatesFallsBackToTable(t *testing.T) {
+	server, provider := rajaOngkirTestServer(t)
+	useShipping(t, provider, true)
+	destination := model.ShippingLocation{CityID: "152", City: "Kota Jakarta Pusat", Province: "DKI Jakarta"}
+
+	rates, name, estimated, err := quoteRates(context.Background(), destination, 1500)
+	if err != nil || name != "rajaongkir" || estimated {
+		t.Fatalf("quoteRates = %s, estimated %v, %v, want rajaongkir", name, estimated, err)
+	}
+	if rate := findRate(rates, "jne", "REG"); rate == nil || rate.Cost != 48000 {
+		t.Errorf("RajaOngkir JNE REG = %+v, want 48000", rate)
+	}
+
+	// An outage falls back to the national rates of the table
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusInternalServerError})
+	rates, name, estimated, err = quoteRates(context.Background(), destination, 1500)
+	if err != nil || name != "table" || !estimated {
+		t.Fatalf("quoteRates during an outage = %s, estimated %v, %v, want table, estimated", name, estimated, err)
+	}
+	if rate := findRate(rates, "jne", "REG"); rate == nil || rate.Cost != 48000 || rate.ETD != "3-5" {
+		t.Errorf("table JNE REG = %+v, want 48000 in 3-5 days", rate)
+	}
+	if len(rates) != 5 {
+		t.Errorf("table gave %d rates, want 5", len(rates))
+	}
+
+	// A bad destination is not an outage, the table is not asked
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusBadRequest})
+	if _, _, _, err := quoteRates(context.Background(), destination, 1500); !errors.Is(err, ErrInvalidShipping) {
+		t.Errorf("quoteRates after a 400: %v, want ErrInvalidShipping", err)
+	}
+}
+
+func TestQuoteRatesWithoutFallback(t *testing.T) {
+	server, provider := rajaOngkirTestServer(t)
+	useShipping(t, provider, false)
+
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusBadGateway})
+	_, _, _, err := quoteRates(context.Background(), model.ShippingLocation{CityID: "152"}, 500)
+	if !errors.Is(err, ErrShippingUnavailable) {
+		t.Errorf("quoteRates during an outage: %v, want ErrShippingUnavailable", err)
+	}
+}
+
+func TestRajaOngkirTrack(t *testing.T) {
+	server, provider := rajaOngkirTestServer(t)
+
+	// Couriers register bills some time after the label was printed
+	tracking, err := provider.Track(context.Background(), "jne", "CGK0000001")
+	if err != nil || tracking.Status != model.ShipmentCreated || len(tracking.Events) != 0 {
+		t.Fatalf("Track of an unknown bill = %+v, %v, want created", tracking, err)
+	}
+
+	picked := time.Date(2024, 4, 1, 9, 30, 0, 0, rajaOngkirZone)
+	rajaOngkirFake(t, server, "/_fake/waybills", fakeapi.RajaOngkirWaybill{
+		Courier: "jne", Waybill: "CGK0000001",
+		Manifest: []fakeapi.RajaOngkirManifest{{Description: "SHIPMENT PICKED UP", City: "BANDUNG", At: picked}},
+	})
+	rajaOngkirFake(t, server, "/_fake/waybills/CGK0000001/manifest", fakeapi.RajaOngkirManifest{Description: "ARRIVED AT HUB", City: "JAKARTA", At: picked.Add(20 * time.Hour)})
+
+	tracking, err = provider.Track(context.Background(), "jne", "CGK0000001")
+	if err != nil {
+		t.Fatalf("Track: %v", err)
+	}
+	if tracking.Status != model.ShipmentInTransit || len(tracking.Events) != 2 {
+		t.Fatalf("Track = %s with %d events, want in_transit with 2", tracking.Status, len(tracking.Events))
+	}
+	// The fake lists the newest scan first, tracking keeps the oldest first
+	if tracking.Events[0].Description != "SHIPMENT PICKED UP" || !tracking.Events[0].At.Time().Equal(picked) {
+		t.Errorf("first event = %+v, want the pick up at %s", tracking.Events[0], picked)
+	}
+
+	deliveredAt := picked.Add(26 * time.Hour)
+	rajaOngkirFake(t, server, "/_fake/waybills", fakeapi.RajaOngkirWaybill{
+		Courier: "jne", Waybill: "CGK0000001", Delivered: true, Receiver: "BUDI",
+		Manifest: []fakeapi.RajaOngkirManifest{
+			{Description: "SHIPMENT PICKED UP", City: "BANDUNG", At: picked},
+			{Description: "DELIVERED TO BUDI", City: "JAKARTA", At: deliveredAt},
+		},
+	})
+	tracking, err = provider.Track(context.Background(), "jne", "CGK0000001")
+	if err != nil || tracking.Status != model.ShipmentDelivered || tracking.Receiver != "BUDI" {
+		t.Fatalf("Track of a delivered bill = %+v, %v, want delivered to BUDI", tracking, err)
+	}
+	if tracking.DeliveredAt == nil || !tracking.DeliveredAt.Time().Equal(deliveredAt) {
+		t.Errorf("delivered at %v, want %s", tracking.DeliveredAt, deliveredAt)
+	}
+
+	rajaOngkirFake(t, server, "/_fake/waybills", fakeapi.RajaOngkirWaybill{
+		Courier: "sicepat", Waybill: "SCP0000002", Returned: true,
+		Manifest: []fakeapi.RajaOngkirManifest{{Description: "RETURN TO SHIPPER", City: "SURABAYA"}},
+	})
+	if tracking, err = provider.Track(context.Background(), "sicepat", "SCP0000002"); err != nil || tracking.Status != model.ShipmentReturned {
+		t.Errorf("Track of a returned bill = %+v, %v, want returned", tracking, err)
+	}
+
+	// An outage is an error, not a bill nobody knows about
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusServiceUnavailable})
+	if _, err := provider.Track(context.Background(), "jne", "CGK0000001"); err == nil {
+		t.Error("Track during an outage succeeded")
+	}
+}
+
+// newShipmentFixture puts a shipped order and its shipment, due to be checked, in the database
+func newShipmentFixture(t *testing.T, awb string) (*model.Order, *model.Shipment) {
+	t.Helper()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	order := &model.Order{
+		OrderID:   primitive.NewObjectID(),
+		UserID:    primitive.NewObjectID(),
+		Items:     []model.OrderItem{{PerfumeID: primitive.NewObjectID(), Name: "Sauvage", Brand: "Dior", UnitPrice: 100000, Quantity: 1, LineTotal: 100000}},
+		ItemCount: 1,
+		Subtotal:  100000,
+		Total:     100000,
+		Status:    model.OrderShipped,
+		History: []model.OrderStatusChange{
+			{To: model.OrderPendingPayment, Actor: model.ActorCustomer, At: now},
+			{From: model.OrderPendingPayment, To: model.OrderPaid, Actor: model.ActorSystem, At: now},
+			{From: model.OrderPaid, To: model.OrderShipped, Actor: model.ActorAdmin, At: now},
+		},
+		Version:   3,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := config.MongoDB.Collection("orders").InsertOne(context.Background(), order); err != nil {
+		t.Fatalf("failed to insert order: %v", err)
+	}
+
+	due := primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute))
+	shipment := &model.Shipment{
+		ShipmentID:  primitive.NewObjectID(),
+		OrderID:     order.OrderID,
+		UserID:      order.UserID,
+		Courier:     "jne",
+		Service:     "REG",
+		AWB:         awb,
+		Status:      model.ShipmentCreated,
+		Events:      []model.TrackingEvent{},
+		NextCheckAt: &due,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := config.MongoDB.Collection("shipments").InsertOne(context.Background(), shipment); err != nil {
+		t.Fatalf("failed to insert shipment: %v", err)
+	}
+	return order, shipment
+}
+
+// currentShipment reads a shipment back
+func currentShipment(t *testing.T, shipmentID primitive.ObjectID) bson.M {
+	t.Helper()
+	var shipment bson.M
+	if err := config.MongoDB.Collection("shipments").FindOne(context.Background(), bson.M{"_id": shipmentID}).Decode(&shipment); err != nil {
+		t.Fatalf("failed to fetch shipment: %v", err)
+	}
+	return shipment
+}
+
+func TestPollShipmentsDeliversOrder(t *testing.T) {
+	testDatabase(t)
+	server, provider := rajaOngkirTestServer(t)
+	useShipping(t, provider, true)
+
+	order, shipment := newShipmentFixture(t, "CGK0000003")
+	rajaOngkirFake(t, server, "/_fake/waybills", fakeapi.RajaOngkirWaybill{
+		Courier: "jne", Waybill: "CGK0000003", Delivered: true, Receiver: "SITI",
+		Manifest: []fakeapi.RajaOngkirManifest{{Description: "DELIVERED TO SITI", City: "JAKARTA"}},
+	})
+
+	delivered, err := PollShipments(context.Background())
+	if err != nil || delivered != 1 {
+		t.Fatalf("PollShipments = %d, %v, want 1 delivered", delivered, err)
+	}
+
+	saved := currentShipment(t, shipment.ShipmentID)
+	if saved["status"] != model.ShipmentDelivered || saved["receiver"] != "SITI" {
+		t.Errorf("shipment %v received by %v, want delivered to SITI", saved["status"], saved["receiver"])
+	}
+	if _, ok := saved["next_check_at"]; ok {
+		t.Error("a delivered shipment is still checked")
+	}
+	current, err := GetOrder(order.OrderID.Hex(), nil)
+	if err != nil || current.Status != model.OrderDelivered {
+		t.Errorf("order = %+v, %v, want delivered", current, err)
+	}
+
+	// Nothing is due any more
+	if delivered, err := PollShipments(context.Background()); err != nil || delivered != 0 {
+		t.Errorf("second PollShipments = %d, %v, want 0", delivered, err)
+	}
+}
+
+func TestPollShipmentsKeepsTrackingOnFailure(t *testing.T) {
+	testDatabase(t)
+	server, provider := rajaOngkirTestServer(t)
+	useShipping(t, provider, true)
+
+	order, shipment := newShipmentFixture(t, "CGK0000004")
+	rajaOngkirFake(t, server, "/_fake/faults", fakeapi.RajaOngkirFault{Status: http.StatusInternalServerError})
+
+	if delivered, err := PollShipments(context.Background()); err != nil || delivered != 0 {
+		t.Fatalf("PollShipments = %d, %v, want 0 without an error", delivered, err)
+	}
+
+	saved := currentShipment(t, shipment.ShipmentID)
+	if saved["track_attempts"] != int32(1) || saved["last_error"] == nil {
+		t.Errorf("shipment after a failure: attempts %v, last error %v, want 1 and an error", saved["track_attempts"], saved["last_error"])
+	}
+	next, ok := saved["next_check_at"].(primitive.DateTime)
+	if !ok || !next.Time().After(time.Now()) {
+		t.Errorf("next check at %v, want later", saved["next_check_at"])
+	}
+	if current, err := GetOrder(order.OrderID.Hex(), nil); err != nil || current.Status != model.OrderShipped {
+		t.Errorf("order = %+v, %v, want still shipped", current, err)
+	}
+}
+
+func TestOrderShippingChecksQuote(t *testing.T) {
+	testDatabase(t)
+
+	userID := primitive.NewObjectID()
+	insertQuote := func(weight int, expiresIn time.Duration, address *model.Address) string {
+		t.Helper()
+		quote := model.ShippingQuote{
+			QuoteID:     primitive.NewObjectID(),
+			Provider:    "rajaongkir",
+			Destination: model.ShippingLocation{CityID: "152", City: "Kota Jakarta Pusat", Province: "DKI Jakarta"},
+			Address:     address,
+			Weight:      weight,
+			Rates: []model.ShippingRate{
+				{Courier: "jne", CourierName: courierNames["jne"], Service: "REG", Cost: 24000, ETD: "2-3"},
+			},
+			ExpiresAt: primitive.NewDateTimeFromTime(time.Now().Add(expiresIn)),
+			CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+		}
+		if _, err := config.MongoDB.Collection("shipping_quotes").InsertOne(context.Background(), quote); err != nil {
+			t.Fatalf("failed to insert quote: %v", err)
+		}
+		return quote.QuoteID.Hex()
+	}
+
+	valid := insertQuote(600, time.Hour, nil)
+	shipping, err := orderShipping(context.Background(), &model.ShippingChoice{QuoteID: valid, Courier: "JNE", Service: "reg"}, 600, userID)
+	if err != nil {
+		t.Fatalf("orderShipping: %v", err)
+	}
+	if shipping.Courier != "jne" || shipping.Service != "REG" || shipping.Cost != 24000 || shipping.Weight != 600 {
+		t.Errorf("orderShipping = %+v, want JNE REG for 24000", shipping)
+	}
+
+	tests := []struct {
+		name   string
+		choice model.ShippingChoice
+		weight int
+	}{
+		{"expired quote", model.ShippingChoice{QuoteID: insertQuote(600, -time.Minute, nil), Courier: "jne", Service: "REG"}, 600},
+		{"changed weight", model.ShippingChoice{QuoteID: valid, Courier: "jne", Service: "REG"}, 1000},
+		{"service not quoted", model.ShippingChoice{QuoteID: valid, Courier: "jne", Service: "YES"}, 600},
+		{"unknown quote", model.ShippingChoice{QuoteID: primitive.NewObjectID().Hex(), Courier: "jne", Service: "REG"}, 600},
+		{"invalid quote ID", model.ShippingChoice{QuoteID: "quote", Courier: "jne", Service: "REG"}, 600},
+		{"another customer's address", model.ShippingChoice{QuoteID: insertQuote(600, time.Hour, &model.Address{AddressID: primitive.NewObjectID(), UserID: primitive.NewObjectID()}), Courier: "jne", Service: "REG"}, 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := orderShipping(context.Background(), &tt.choice, tt.weight, userID); !errors.Is(err, ErrInvalidOrder) {
+				t.Errorf("orderShipping: %v, want ErrInvalidOrder", err)
+			}
+		})
+	}
+}
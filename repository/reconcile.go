@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ImageOrphanGrace is how old an unreferenced image must be before it is deleted, IMAGE_ORPHAN_GRACE (default 1h)
+func ImageOrphanGrace() time.Duration {
+	return envDuration("IMAGE_ORPHAN_GRACE", time.Hour)
+}
+
+// ImageReconcileDeletes tells whether the background reconcile job deletes orphaned images,
+// IMAGE_RECONCILE_DELETE (default false, the job only logs what it would delete)
+func ImageReconcileDeletes() bool {
+	deletes, _ := strconv.ParseBool(os.Getenv("IMAGE_RECONCILE_DELETE"))
+	return deletes
+}
+
+// referencedImageNames collects every stored file name a perfume still points at.
+// Besides the keys, the last segment of each URL is kept so images of perfumes
+// created before keys were recorded are never treated as orphans.
+func referencedImageNames(ctx context.Context) (map[string]bool, map[string]bool, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	opts := options.Find().SetProjection(bson.M{"image": 1, "images": 1})
+	cursor, err := perfumeCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := map[string]bool{}
+	urlNames := map[string]bool{}
+	for cursor.Next(ctx) {
+		var perfume model.Perfume
+		if err := cursor.Decode(&perfume); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode perfume: %v", err)
+		}
+
+		if perfume.Image != "" {
+			urlNames[path.Base(perfume.Image)] = true
+		}
+		for _, image := range perfume.Images {
+			for _, key := range storedImageKeys(image) {
+				keys[key] = true
+			}
+			urlNames[path.Base(image.URL)] = true
+			for _, rendition := range image.Renditions {
+				urlNames[path.Base(rendition.URL)] = true
+			}
+		}
+	}
+
+	return keys, urlNames, cursor.Err()
+}
+
+// imageKeyTime reads the creation time from the ObjectIDs an image key starts with
+// (<perfumeID>-<imageID>...), ok is false for files that were not named by this API
+func imageKeyTime(key string) (time.Time, bool) {
+	name := path.Base(key)
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	var created time.Time
+	for _, part := range strings.Split(name, "-") {
+		objID, err := primitive.ObjectIDFromHex(part)
+		if err != nil {
+			continue
+		}
+		if objID.Timestamp().After(created) {
+			created = objID.Timestamp()
+		}
+	}
+
+	return created, !created.IsZero()
+}
+
+// ReconcileImages finds stored images that no perfume references anymore and deletes them.
+// Files younger than grace are kept because their perfume may still be in the middle of being saved,
+// and files whose name does not contain an ObjectID are never touched.
+// With dryRun the orphans are only reported.
+func ReconcileImages(ctx context.Context, grace time.Duration, dryRun bool) ([]string, error) {
+	lister, ok := Images.(ImageLister)
+	if !ok {
+		return nil, fmt.Errorf("image store does not support listing files")
+	}
+
+	stored, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, urlNames, err := referencedImageNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []string{}
+	for _, key := range stored {
+		if keys[key] || urlNames[path.Base(key)] {
+			continue
+		}
+		created, ok := imageKeyTime(key)
+		if !ok || time.Since(created) < grace {
+			continue
+		}
+		orphans = append(orphans, key)
+	}
+
+	if dryRun {
+		return orphans, nil
+	}
+
+	deleted := []string{}
+	for _, key := range orphans {
+		if err := Images.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete orphaned image %s: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRegionNotFound is returned for region codes that are not in the dataset
+var ErrRegionNotFound = errors.New("region not found")
+
+// bundledRegions is the region dataset shipped with the API: every province, the larger cities and the
+// districts and sub-districts of a few of them. REGIONS_FILE replaces it with a complete dataset.
+//
+//go:embed regions.csv
+var bundledRegions []byte
+
+// regionCodePattern matches province (32), city (32.73), district (32.73.02) and sub-district (32.73.02.1006) codes
+var regionCodePattern = regexp.MustCompile(`^[0-9]{2}(\.[0-9]{2}(\.[0-9]{2}(\.[0-9]{4})?)?)?$`)
+
+// regionSeedBatch is how many regions are written to MongoDB per request while seeding
+const regionSeedBatch = 1000
+
+// regionLevel is the level of a region code, from the number of its parts
+func regionLevel(code string) string {
+	return model.RegionLevels[strings.Count(code, ".")]
+}
+
+// regionParent is the code of the region a region belongs to, empty for provinces
+func regionParent(code string) string {
+	if i := strings.LastIndex(code, "."); i >= 0 {
+		return code[:i]
+	}
+	return ""
+}
+
+// regionAncestry is the code of a region and of every region above it, the province first
+func regionAncestry(code string) []string {
+	codes := []string{}
+	for code != "" {
+		codes = append([]string{code}, codes...)
+		code = regionParent(code)
+	}
+	return codes
+}
+
+// loadRegionDataset reads REGIONS_FILE, or the bundled dataset, and returns its regions and checksum
+func loadRegionDataset() ([]model.Region, string, error) {
+	content := bundledRegions
+	if path := os.Getenv("REGIONS_FILE"); path != "" {
+		var err error
+		content, err = os.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read regions: %v", err)
+		}
+	}
+
+	regions, err := parseRegions(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(content)
+	return regions, hex.EncodeToString(sum[:]), nil
+}
+
+// parseRegions reads a region CSV with the columns code and name, and optionally postal_code and rajaongkir_id.
+// Every region's parent must be in the file as well.
+func parseRegions(reader io.Reader) ([]model.Region, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read regions header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["code"]; !ok {
+		return nil, errors.New("the regions file needs a code column")
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("the regions file needs a name column")
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	regions := []model.Region{}
+	seen := map[string]bool{}
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read regions line %d: %v", line, err)
+		}
+
+		region := model.Region{
+			Code:         column(record, "code"),
+			Name:         column(record, "name"),
+			PostalCode:   column(record, "postal_code"),
+			RajaOngkirID: column(record, "rajaongkir_id"),
+		}
+		if !regionCodePattern.MatchString(region.Code) {
+			return nil, fmt.Errorf("invalid region code %q on line %d", region.Code, line)
+		}
+		if region.Name == "" {
+			return nil, fmt.Errorf("region %s on line %d has no name", region.Code, line)
+		}
+		if seen[region.Code] {
+			return nil, fmt.Errorf("region %s is listed twice, again on line %d", region.Code, line)
+		}
+		seen[region.Code] = true
+		region.Level = regionLevel(region.Code)
+		region.Parent = regionParent(region.Code)
+		regions = append(regions, region)
+	}
+
+	for _, region := range regions {
+		if region.Parent != "" && !seen[region.Parent] {
+			return nil, fmt.Errorf("region %s belongs to %s, which is not in the file", region.Code, region.Parent)
+		}
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("the regions file has no regions")
+	}
+	return regions, nil
+}
+
+// SeedRegions loads the region dataset into the regions collection. Nothing is written when the dataset did
+// not change since the last start, otherwise regions are upserted and the ones no longer in the dataset removed.
+// It returns how many regions were written.
+func SeedRegions(ctx context.Context) (int, error) {
+	regions, checksum, err := loadRegionDataset()
+	if err != nil {
+		return 0, err
+	}
+
+	datasetCollection := config.MongoDB.Collection("region_datasets")
+
+	var current struct {
+		Checksum string `bson:"checksum"`
+	}
+	err = datasetCollection.FindOne(ctx, bson.M{"_id": "current"}).Decode(&current)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, fmt.Errorf("failed to fetch region dataset: %v", err)
+	}
+	if current.Checksum == checksum {
+		return 0, nil
+	}
+
+	regionCollection := config.MongoDB.Collection("regions")
+	codes := make([]string, 0, len(regions))
+	for start := 0; start < len(regions); start += regionSeedBatch {
+		end := min(start+regionSeedBatch, len(regions))
+		writes := make([]mongo.WriteModel, 0, end-start)
+		for _, region := range regions[start:end] {
+			writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": region.Code}).SetReplacement(region).SetUpsert(true))
+			codes = append(codes, region.Code)
+		}
+		if _, err := regionCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return 0, fmt.Errorf("failed to save regions: %v", err)
+		}
+	}
+	if _, err := regionCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$nin": codes}}); err != nil {
+		return 0, fmt.Errorf("failed to remove old regions: %v", err)
+	}
+
+	// The checksum is saved last, an interrupted seed runs again on the next start
+	_, err = datasetCollection.UpdateOne(ctx, bson.M{"_id": "current"}, bson.M{"$set": bson.M{
+		"checksum":  checksum,
+		"count":     len(regions),
+		"seeded_at": primitive.NewDateTimeFromTime(time.Now()),
+	}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return 0, fmt.Errorf("failed to save region dataset: %v", err)
+	}
+
+	return len(regions), nil
+}
+
+// GetRegion returns a region by its code
+func GetRegion(code string) (*model.Region, error) {
+	code = strings.TrimSpace(code)
+	if !regionCodePattern.MatchString(code) {
+		return nil, ErrRegionNotFound
+	}
+
+	regionCollection := config.MongoDB.Collection("regions")
+
+	var region model.Region
+	err := regionCollection.FindOne(context.TODO(), bson.M{"_id": code}).Decode(&region)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrRegionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch region: %v", err)
+	}
+
+	return &region, nil
+}
+
+// GetRegionChildren returns the regions directly inside a region sorted by name, the provinces when code is
+// empty. A search narrows them down to names containing it, for long lists of villages.
+func GetRegionChildren(code, search string) ([]model.Region, error) {
+	filter := bson.M{"level": model.RegionProvince}
+	if code != "" {
+		// An unknown region has no children rather than an empty list
+		if _, err := GetRegion(code); err != nil {
+			return nil, err
+		}
+		filter = bson.M{"parent": code}
+	}
+	if search = strings.TrimSpace(search); search != "" {
+		filter["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+	}
+
+	regionCollection := config.MongoDB.Collection("regions")
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := regionCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch regions: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	regions := []model.Region{}
+	if err = cursor.All(context.TODO(), &regions); err != nil {
+		return nil, fmt.Errorf("failed to decode regions: %v", err)
+	}
+
+	return regions, nil
+}
+
+// regionPath returns a region and every region above it by level
+func regionPath(ctx context.Context, code string) (map[string]model.Region, error) {
+	codes := regionAncestry(code)
+
+	regionCollection := config.MongoDB.Collection("regions")
+	cursor, err := regionCollection.Find(ctx, bson.M{"_id": bson.M{"$in": codes}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch regions: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	regions := []model.Region{}
+	if err = cursor.All(context.TODO(), &regions); err != nil {
+		return nil, fmt.Errorf("failed to decode regions: %v", err)
+	}
+	if len(regions) != len(codes) {
+		return nil, ErrRegionNotFound
+	}
+
+	path := map[string]model.Region{}
+	for _, region := range regions {
+		path[region.Level] = region
+	}
+	return path, nil
+}
+
+// hasRegionChildren tells whether the dataset has regions inside a region
+func hasRegionChildren(ctx context.Context, code string) (bool, error) {
+	regionCollection := config.MongoDB.Collection("regions")
+	count, err := regionCollection.CountDocuments(ctx, bson.M{"parent": code}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch regions: %v", err)
+	}
+	return count > 0, nil
+}
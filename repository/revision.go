@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// revisionFields are the perfume fields whose history is kept, in the order diffs are reported
+var revisionFields = []string{"name", "brand", "sku", "types", "categories", "sizes", "price", "description", "stock", "weight"}
+
+// perfumeSnapshot returns the tracked fields of a perfume
+func perfumeSnapshot(perfume *model.Perfume) map[string]string {
+	return map[string]string{
+		"name":        perfume.Name,
+		"brand":       perfume.Brand,
+		"sku":         perfume.SKU,
+		"types":       perfume.Types,
+		"categories":  perfume.Categories,
+		"sizes":       perfume.Sizes,
+		"price":       perfume.Price,
+		"description": perfume.Description,
+		"stock":       perfume.Stock,
+		"weight":      perfume.Weight,
+	}
+}
+
+// applySnapshot overwrites the snapshot with the tracked fields of a $set document
+func applySnapshot(snapshot map[string]string, set bson.M) map[string]string {
+	after := map[string]string{}
+	for field, value := range snapshot {
+		after[field] = value
+	}
+	for _, field := range revisionFields {
+		if value, ok := set[field].(string); ok {
+			after[field] = value
+		}
+	}
+	return after
+}
+
+// diffSnapshots lists the fields that differ between two snapshots
+func diffSnapshots(before, after map[string]string) []model.FieldChange {
+	changes := []model.FieldChange{}
+	for _, field := range revisionFields {
+		if before[field] != after[field] {
+			changes = append(changes, model.FieldChange{Field: field, From: before[field], To: after[field]})
+		}
+	}
+	return changes
+}
+
+// recordPerfumeRevision stores the change that produced version of a perfume. Changes that did not touch
+// a tracked field are skipped. A failure is only logged because the perfume itself was already saved.
+func recordPerfumeRevision(perfumeID primitive.ObjectID, version int64, before, after map[string]string, author model.RevisionAuthor, source string, restoredFrom *primitive.ObjectID) {
+	changes := diffSnapshots(before, after)
+	if len(changes) == 0 {
+		return
+	}
+
+	revisionCollection := config.MongoDB.Collection("perfume_revisions")
+	_, err := revisionCollection.InsertOne(context.TODO(), model.PerfumeRevision{
+		RevisionID:   primitive.NewObjectID(),
+		PerfumeID:    perfumeID,
+		Version:      version,
+		Source:       source,
+		RestoredFrom: restoredFrom,
+		Author:       author,
+		Changes:      changes,
+		Snapshot:     after,
+		CreatedAt:    primitive.NewDateTimeFromTime(time.Now()),
+	})
+	if err != nil {
+		log.Printf("Failed to record revision of perfume %s: %v", perfumeID.Hex(), err)
+	}
+}
+
+// writePerfumeFields sets tracked fields of a perfume and records the change as a revision
+func writePerfumeFields(objID primitive.ObjectID, set bson.M, expectedVersion *int64, author model.RevisionAuthor, source string, restoredFrom *primitive.ObjectID) error {
+	// Get database connection
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	set["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+
+	// Keep the previous document to know what changed
+	base := notDeleted(bson.M{"_id": objID})
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var before model.Perfume
+	err := perfumeCollection.FindOneAndUpdate(context.TODO(), versionFilter(base, expectedVersion), update, opts).Decode(&before)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return versionMissError(perfumeCollection, base, fmt.Errorf("perfume not found"))
+		}
+		return fmt.Errorf("failed to update perfume: %v", err)
+	}
+
+	snapshot := perfumeSnapshot(&before)
+	after := applySnapshot(snapshot, set)
+	recordPerfumeRevision(objID, before.Version+1, snapshot, after, author, source, restoredFrom)
+	refreshPerfumeSlug(objID, before.Slug, after["brand"], after["name"])
+
+	return nil
+}
+
+// GetPerfumeRevisions returns the change history of a perfume, newest first
+func GetPerfumeRevisions(id string) ([]model.PerfumeRevision, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+
+	revisionCollection := config.MongoDB.Collection("perfume_revisions")
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := revisionCollection.Find(context.TODO(), bson.M{"perfume_id": objID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revisions: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	revisions := []model.PerfumeRevision{}
+	if err = cursor.All(context.Background(), &revisions); err != nil {
+		return nil, fmt.Errorf("failed to decode revisions: %v", err)
+	}
+
+	return revisions, nil
+}
+
+// RestorePerfumeRevision puts the fields of a perfume back to how they were after a revision.
+// The restore is saved as a new change, so the history itself is never rewritten. Stock is
+// left as it is: it has moved with orders since, and winding it back would oversell or lose bottles.
+func RestorePerfumeRevision(id, revisionID string, expectedVersion *int64, author model.RevisionAuthor) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid perfume ID format: %v", err)
+	}
+	revisionObjID, err := primitive.ObjectIDFromHex(revisionID)
+	if err != nil {
+		return fmt.Errorf("invalid revision ID format: %v", err)
+	}
+
+	revisionCollection := config.MongoDB.Collection("perfume_revisions")
+
+	var revision model.PerfumeRevision
+	err = revisionCollection.FindOne(context.TODO(), bson.M{"_id": revisionObjID, "perfume_id": objID}).Decode(&revision)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("revision not found")
+		}
+		return fmt.Errorf("failed to find revision: %v", err)
+	}
+
+	set := bson.M{}
+	for _, field := range revisionFields {
+		if field == "stock" {
+			continue
+		}
+		if value, ok := revision.Snapshot[field]; ok {
+			set[field] = value
+		}
+	}
+
+	return writePerfumeFields(objID, set, expectedVersion, author, model.RevisionRestore, &revision.RevisionID)
+}
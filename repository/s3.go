@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3ImageStore keeps images in an S3 compatible bucket (AWS S3, MinIO, ...) using path-style URLs
+type S3ImageStore struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PublicURL string // Base URL clients download from, defaults to the bucket URL
+	Client    *http.Client
+}
+
+// NewS3ImageStore reads the S3_* environment variables
+func NewS3ImageStore() (*S3ImageStore, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	bucket := os.Getenv("S3_BUCKET")
+	accessKey := os.Getenv("S3_ACCESS_KEY")
+	secretKey := os.Getenv("S3_SECRET_KEY")
+
+	// Validate S3 credentials
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, errors.New("S3 connection details are missing in environment variables")
+	}
+
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %v", err)
+	}
+
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	store := &S3ImageStore{
+		Endpoint:  endpointURL,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PublicURL: strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/"),
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if store.PublicURL == "" {
+		store.PublicURL = endpointURL.String() + "/" + bucket
+	}
+
+	return store, nil
+}
+
+// objectURL returns the path-style API URL of an object
+func (s *S3ImageStore) objectURL(key string) *url.URL {
+	objectURL := *s.Endpoint
+	objectURL.Path = "/" + s.Bucket + "/" + strings.TrimPrefix(key, "/")
+	objectURL.RawPath = "/" + s.Bucket + "/" + s3EscapePath(strings.TrimPrefix(key, "/"))
+	return &objectURL
+}
+
+// Save uploads the object and returns its public URL
+func (s *S3ImageStore) Save(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, content, time.Now())
+
+	if err := s.do(req, http.StatusOK); err != nil {
+		return "", fmt.Errorf("failed to upload image to S3: %v", err)
+	}
+
+	return s.PublicURL + "/" + s3EscapePath(strings.TrimPrefix(key, "/")), nil
+}
+
+// Delete removes the object, S3 treats deleting a missing object as success
+func (s *S3ImageStore) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	s.sign(req, nil, time.Now())
+
+	if err := s.do(req, http.StatusNoContent, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to delete image from S3: %v", err)
+	}
+
+	return nil
+}
+
+// List returns every object key in the bucket using ListObjectsV2
+func (s *S3ImageStore) List(ctx context.Context) ([]string, error) {
+	keys := []string{}
+	continuationToken := ""
+	for {
+		listURL := *s.Endpoint
+		listURL.Path = "/" + s.Bucket
+		query := url.Values{"list-type": {"2"}}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		listURL.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		s.sign(req, nil, time.Now())
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to list images: %s, response: %s", resp.Status, string(body))
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %v", err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// PresignUpload returns a URL the client can PUT the object to without credentials.
+// The content type is part of the signature, so the upload must send exactly that Content-Type.
+func (s *S3ImageStore) PresignUpload(ctx context.Context, key string, contentType string, expires time.Duration) (string, error) {
+	return s.presign(http.MethodPut, key, map[string]string{"content-type": contentType}, expires, time.Now()), nil
+}
+
+// Fetch downloads an object, refusing objects larger than maxBytes
+func (s *S3ImageStore) Fetch(ctx context.Context, key string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	s.sign(req, nil, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to download object: %s, response: %s", resp.Status, string(body))
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidImage, maxBytes)
+	}
+
+	// Read one byte past the limit to notice objects without a Content-Length that are too large
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object: %v", err)
+	}
+	if int64(len(content)) > maxBytes {
+		return nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidImage, maxBytes)
+	}
+
+	return content, nil
+}
+
+// presign builds a query-string signed URL (SigV4 with an unsigned payload)
+func (s *S3ImageStore) presign(method, key string, headers map[string]string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+	scope := shortDate + "/" + s.Region + "/s3/aws4_request"
+
+	presignedURL := s.objectURL(key)
+
+	// Canonical headers: host plus the headers the client has to send, sorted by name
+	signed := map[string]string{"host": presignedURL.Host}
+	for name, value := range headers {
+		if value != "" {
+			signed[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {fmt.Sprintf("%d", int64(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {signedHeaders},
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		presignedURL.EscapedPath(),
+		s3CanonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	signature := s.signature(shortDate, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+sha256Hex([]byte(canonicalRequest)))
+	presignedURL.RawQuery = s3CanonicalQuery(query) + "&X-Amz-Signature=" + signature
+
+	return presignedURL.String()
+}
+
+// do sends a signed request and checks the status code
+func (s *S3ImageStore) do(req *http.Request, expected ...int) error {
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s, response: %s", resp.Status, string(body))
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to the request
+func (s *S3ImageStore) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Canonical headers: host plus every x-amz-* and content-type header, sorted by name
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := shortDate + "/" + s.Region + "/s3/aws4_request"
+	signature := s.signature(shortDate, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+sha256Hex([]byte(canonicalRequest)))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// signature derives the signing key for the day and signs the string
+func (s *S3ImageStore) signature(shortDate, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), shortDate)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3EscapePath URI-encodes every character of the key except unreserved ones and '/'
+func s3EscapePath(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// s3CanonicalQuery sorts and encodes the query string the way SigV4 expects
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3EscapeQuery(key)+"="+s3EscapeQuery(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3EscapeQuery(value string) string {
+	return strings.ReplaceAll(s3EscapePath(value), "/", "%2F")
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// saga collects compensating actions for the steps of a multi-step operation,
+// so a failure half way through can undo the steps that already succeeded
+type saga struct {
+	compensations []func()
+}
+
+// onRollback registers the compensation of a step that just succeeded
+func (s *saga) onRollback(compensation func()) {
+	s.compensations = append(s.compensations, compensation)
+}
+
+// rollback runs the compensations in reverse order
+func (s *saga) rollback() {
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		s.compensations[i]()
+	}
+	s.compensations = nil
+}
+
+// deleteStoredKey removes one file from the image store. When that fails the key is
+// recorded as a pending cleanup so the background job can retry it later.
+func deleteStoredKey(key string, reason string) {
+	if Images == nil {
+		return
+	}
+	err := Images.Delete(context.TODO(), key)
+	if err == nil {
+		return
+	}
+
+	log.Printf("Failed to delete stored image %s, marking it for cleanup: %v", key, err)
+	cleanupCollection := config.MongoDB.Collection("image_cleanup")
+	_, err = cleanupCollection.UpdateOne(context.TODO(), bson.M{"_id": key}, bson.M{
+		"$set": bson.M{
+			"reason":     reason,
+			"status":     "pending",
+			"last_error": err.Error(),
+		},
+		"$setOnInsert": bson.M{"created_at": primitive.NewDateTimeFromTime(time.Now())},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Failed to record pending cleanup for %s: %v", key, err)
+	}
+}
+
+// RetryImageCleanup deletes the files whose earlier deletion failed, returning the keys removed
+func RetryImageCleanup(ctx context.Context) ([]string, error) {
+	if Images == nil {
+		return nil, nil
+	}
+	cleanupCollection := config.MongoDB.Collection("image_cleanup")
+
+	cursor, err := cleanupCollection.Find(ctx, bson.M{"status": "pending"})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var pending []model.ImageCleanup
+	if err := cursor.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	for _, cleanup := range pending {
+		if err := Images.Delete(ctx, cleanup.Key); err != nil {
+			cleanupCollection.UpdateOne(ctx, bson.M{"_id": cleanup.Key}, bson.M{
+				"$set": bson.M{"last_error": err.Error()},
+				"$inc": bson.M{"attempts": 1},
+			})
+			continue
+		}
+		cleanupCollection.DeleteOne(ctx, bson.M{"_id": cleanup.Key})
+		deleted = append(deleted, cleanup.Key)
+	}
+
+	return deleted, nil
+}
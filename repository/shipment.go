@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrShipmentNotFound is returned when an order was not shipped yet
+var ErrShipmentNotFound = errors.New("shipment not found")
+
+// awbPattern is what airway bill numbers of the supported couriers look like
+var awbPattern = regexp.MustCompile(`^[A-Z0-9-]{6,40}$`)
+
+// shipmentPollBatch is how many shipments one run of the tracking job checks at most
+const shipmentPollBatch = 50
+
+// courierAuthor is recorded in the order history for changes made by courier tracking
+var courierAuthor = model.RevisionAuthor{Username: "courier"}
+
+// ShipmentRecheckInterval is how long to wait before checking a parcel again, SHIPPING_TRACKING_RECHECK (default 2h)
+func ShipmentRecheckInterval() time.Duration {
+	return envDuration("SHIPPING_TRACKING_RECHECK", 2*time.Hour)
+}
+
+// ShipmentTrackingMaxAge is how long a parcel is followed before tracking gives up, SHIPPING_TRACKING_MAX_AGE (default 720h)
+func ShipmentTrackingMaxAge() time.Duration {
+	return envDuration("SHIPPING_TRACKING_MAX_AGE", 30*24*time.Hour)
+}
+
+// CreateShipment records the airway bill of a packed order and marks the order shipped. The courier and service
+// default to the ones chosen at checkout. Tracking starts with the next run of the tracking job.
+func CreateShipment(orderID string, request model.ShipmentRequest, author model.RevisionAuthor) (*model.Shipment, error) {
+	order, err := GetOrder(orderID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != model.OrderPacked {
+		return nil, fmt.Errorf("%w: only packed orders can be shipped, the order is %s", ErrInvalidTransition, order.Status)
+	}
+
+	courier := strings.ToLower(strings.TrimSpace(request.Courier))
+	service := strings.TrimSpace(request.Service)
+	if courier == "" && order.Shipping != nil {
+		courier = order.Shipping.Courier
+	}
+	if service == "" && order.Shipping != nil && courier == order.Shipping.Courier {
+		service = order.Shipping.Service
+	}
+	if _, ok := courierNames[courier]; !ok {
+		return nil, fmt.Errorf("%w: unknown courier %q", ErrInvalidShipping, courier)
+	}
+	awb := strings.ToUpper(strings.TrimSpace(request.AWB))
+	if !awbPattern.MatchString(awb) {
+		return nil, fmt.Errorf("%w: the AWB must be 6 to 40 letters, digits or dashes", ErrInvalidShipping)
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	shipment := &model.Shipment{
+		ShipmentID:  primitive.NewObjectID(),
+		OrderID:     order.OrderID,
+		UserID:      order.UserID,
+		Courier:     courier,
+		Service:     service,
+		AWB:         awb,
+		Status:      model.ShipmentCreated,
+		Events:      []model.TrackingEvent{},
+		NextCheckAt: &now,
+		CreatedBy:   author,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	shipmentCollection := config.MongoDB.Collection("shipments")
+	if _, err := shipmentCollection.InsertOne(context.TODO(), shipment); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%w: the order already has a shipment or the AWB is used by another one", ErrInvalidShipping)
+		}
+		return nil, fmt.Errorf("failed to save shipment: %v", err)
+	}
+
+	// The shipment is removed again when the order cannot become shipped, so it never points at a packed order
+	note := strings.TrimSpace(strings.Join([]string{courierNames[courier], service, awb, strings.TrimSpace(request.Note)}, " "))
+	_, err = TransitionOrder(orderID, model.OrderStatusRequest{Status: model.OrderShipped, Note: note}, model.ActorAdmin, author, nil, nil)
+	if err != nil {
+		if _, deleteErr := shipmentCollection.DeleteOne(context.TODO(), bson.M{"_id": shipment.ShipmentID}); deleteErr != nil {
+			log.Printf("Failed to remove shipment %s of order %s: %v", shipment.ShipmentID.Hex(), orderID, deleteErr)
+		}
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// GetShipment returns the shipment of an order. With a user ID only that customer's orders are found.
+func GetShipment(orderID string, userID *primitive.ObjectID) (*model.Shipment, error) {
+	order, err := GetOrder(orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	shipmentCollection := config.MongoDB.Collection("shipments")
+
+	var shipment model.Shipment
+	err = shipmentCollection.FindOne(context.TODO(), bson.M{"order_id": order.OrderID}).Decode(&shipment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrShipmentNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch shipment: %v", err)
+	}
+
+	return &shipment, nil
+}
+
+// RefreshShipment asks the courier about the shipment of an order right away
+func RefreshShipment(orderID string) (*model.Shipment, error) {
+	shipment, err := GetShipment(orderID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if Shipping == nil {
+		return nil, ErrTrackingUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := trackShipment(ctx, shipment); err != nil {
+		return nil, err
+	}
+	return GetShipment(orderID, nil)
+}
+
+// PollShipments checks the parcels that are due with the courier, marking orders delivered when their parcel
+// arrived. Parcels that were delivered, returned or followed for longer than ShipmentTrackingMaxAge are no
+// longer checked. It returns how many parcels were delivered.
+func PollShipments(ctx context.Context) (int, error) {
+	if Shipping == nil {
+		return 0, nil
+	}
+
+	shipmentCollection := config.MongoDB.Collection("shipments")
+
+	now := time.Now()
+	opts := options.Find().SetSort(bson.D{{Key: "next_check_at", Value: 1}}).SetLimit(shipmentPollBatch)
+	cursor, err := shipmentCollection.Find(ctx, bson.M{"next_check_at": bson.M{"$lte": primitive.NewDateTimeFromTime(now)}}, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch shipments: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	shipments := []model.Shipment{}
+	if err = cursor.All(context.Background(), &shipments); err != nil {
+		return 0, fmt.Errorf("failed to decode shipments: %v", err)
+	}
+
+	delivered := 0
+	for i := range shipments {
+		shipment := &shipments[i]
+		if now.Sub(shipment.CreatedAt.Time()) > ShipmentTrackingMaxAge() {
+			log.Printf("Stopped tracking %s %s of order %s, it was not delivered after %s", shipment.Courier, shipment.AWB, shipment.OrderID.Hex(), ShipmentTrackingMaxAge())
+			if err := stopTracking(ctx, shipment, "tracking gave up before the parcel was delivered"); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		if err := trackShipment(ctx, shipment); err != nil {
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+			if errors.Is(err, ErrTrackingUnsupported) {
+				continue
+			}
+			log.Printf("Failed to track %s %s of order %s: %v", shipment.Courier, shipment.AWB, shipment.OrderID.Hex(), err)
+			continue
+		}
+		if shipment.Status == model.ShipmentDelivered {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// trackShipment asks the courier about a parcel and saves what it reports, the shipment is updated in place.
+// Failures are saved on the shipment and checked again later, backing off while they continue.
+func trackShipment(ctx context.Context, shipment *model.Shipment) error {
+	shipmentCollection := config.MongoDB.Collection("shipments")
+	now := time.Now()
+
+	tracking, err := Shipping.Track(ctx, shipment.Courier, shipment.AWB)
+	if errors.Is(err, ErrTrackingUnsupported) {
+		if stopErr := stopTracking(ctx, shipment, err.Error()); stopErr != nil {
+			return stopErr
+		}
+		return err
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		attempts := shipment.TrackAttempts + 1
+		wait := ShipmentRecheckInterval() << min(attempts, 4)
+		nextCheckAt := primitive.NewDateTimeFromTime(now.Add(min(wait, 24*time.Hour)))
+		_, updateErr := shipmentCollection.UpdateOne(ctx, bson.M{"_id": shipment.ShipmentID}, bson.M{"$set": bson.M{
+			"last_error":     err.Error(),
+			"track_attempts": attempts,
+			"next_check_at":  nextCheckAt,
+			"updated_at":     primitive.NewDateTimeFromTime(now),
+		}})
+		if updateErr != nil {
+			return fmt.Errorf("failed to update shipment: %v", updateErr)
+		}
+		return fmt.Errorf("%w: %v", ErrShippingUnavailable, err)
+	}
+
+	// The order is updated first: if that fails the shipment stays due and is checked again
+	if tracking.Status == model.ShipmentDelivered {
+		note := fmt.Sprintf("%s %s delivered", courierNames[shipment.Courier], shipment.AWB)
+		if tracking.Receiver != "" {
+			note += ", received by " + tracking.Receiver
+		}
+		_, err := TransitionOrder(shipment.OrderID.Hex(), model.OrderStatusRequest{Status: model.OrderDelivered, Note: note}, model.ActorSystem, courierAuthor, nil, nil)
+		if err != nil && !errors.Is(err, ErrInvalidTransition) && !errors.Is(err, ErrTransitionForbidden) {
+			return err
+		}
+		if tracking.DeliveredAt == nil {
+			deliveredAt := primitive.NewDateTimeFromTime(now)
+			tracking.DeliveredAt = &deliveredAt
+		}
+	}
+
+	checkedAt := primitive.NewDateTimeFromTime(now)
+	set := bson.M{
+		"status":         tracking.Status,
+		"events":         tracking.Events,
+		"checked_at":     checkedAt,
+		"track_attempts": 0,
+		"updated_at":     checkedAt,
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"last_error": ""}}
+	switch tracking.Status {
+	case model.ShipmentDelivered:
+		set["delivered_at"] = tracking.DeliveredAt
+		set["receiver"] = tracking.Receiver
+		update["$unset"] = bson.M{"last_error": "", "next_check_at": ""}
+	case model.ShipmentReturned:
+		log.Printf("Parcel %s %s of order %s is being returned", shipment.Courier, shipment.AWB, shipment.OrderID.Hex())
+		update["$unset"] = bson.M{"last_error": "", "next_check_at": ""}
+	default:
+		set["next_check_at"] = primitive.NewDateTimeFromTime(now.Add(ShipmentRecheckInterval()))
+	}
+
+	if _, err := shipmentCollection.UpdateOne(ctx, bson.M{"_id": shipment.ShipmentID}, update); err != nil {
+		return fmt.Errorf("failed to update shipment: %v", err)
+	}
+
+	shipment.Status = tracking.Status
+	shipment.Events = tracking.Events
+	return nil
+}
+
+// stopTracking takes a shipment off the tracking job's list
+func stopTracking(ctx context.Context, shipment *model.Shipment, reason string) error {
+	shipmentCollection := config.MongoDB.Collection("shipments")
+	_, err := shipmentCollection.UpdateOne(ctx, bson.M{"_id": shipment.ShipmentID}, bson.M{
+		"$set":   bson.M{"last_error": reason, "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+		"$unset": bson.M{"next_check_at": ""},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update shipment: %v", err)
+	}
+	return nil
+}
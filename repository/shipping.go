@@ -0,0 +1,437 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidShipping is returned for quotes and shipments that cannot be made, such as an unknown destination or courier
+var ErrInvalidShipping = errors.New("invalid shipping")
+
+// ErrShippingUnavailable is returned when the courier API cannot be reached or fails
+var ErrShippingUnavailable = errors.New("shipping provider unavailable")
+
+// ErrTrackingUnsupported is returned by providers that cannot follow parcels
+var ErrTrackingUnsupported = errors.New("tracking is not supported by the shipping provider")
+
+// ShippingProvider prices parcels and follows them once they are shipped
+type ShippingProvider interface {
+	// Name identifies the provider on quotes
+	Name() string
+	// Quote returns the rates of the couriers' services for a parcel of weight grams
+	Quote(ctx context.Context, origin, destination model.ShippingLocation, weight int, couriers []string) ([]model.ShippingRate, error)
+	// Track returns what the courier reports about an airway bill
+	Track(ctx context.Context, courier, awb string) (*model.Tracking, error)
+}
+
+// Shipping is the provider selected by SHIPPING_PROVIDER
+var Shipping ShippingProvider
+
+// shippingFallback prices parcels from the rate table when the courier API fails, nil when Shipping is the table
+var shippingFallback ShippingProvider
+
+// courierNames are the couriers parcels can be sent with
+var courierNames = map[string]string{
+	"jne":     "JNE",
+	"jnt":     "J&T Express",
+	"sicepat": "SiCepat",
+}
+
+// InitShippingProvider selects the shipping provider (SHIPPING_PROVIDER=rajaongkir|table). Without SHIPPING_PROVIDER
+// RajaOngkir is used when RAJAONGKIR_API_KEY is set, with the rate table as fallback, otherwise only the table.
+func InitShippingProvider() error {
+	table, err := NewTableShipping()
+	if err != nil {
+		return err
+	}
+
+	switch os.Getenv("SHIPPING_PROVIDER") {
+	case "":
+		if os.Getenv("RAJAONGKIR_API_KEY") == "" {
+			Shipping = table
+			return nil
+		}
+		fallthrough
+	case "rajaongkir":
+		provider, err := NewRajaOngkirProvider()
+		if err != nil {
+			return err
+		}
+		Shipping = provider
+		shippingFallback = table
+	case "table":
+		Shipping = table
+	default:
+		return fmt.Errorf("unknown SHIPPING_PROVIDER %q", os.Getenv("SHIPPING_PROVIDER"))
+	}
+
+	return nil
+}
+
+// ShippingOrigin is the warehouse parcels are sent from, SHIPPING_ORIGIN_*
+func ShippingOrigin() model.ShippingLocation {
+	return model.ShippingLocation{
+		SubdistrictID: os.Getenv("SHIPPING_ORIGIN_SUBDISTRICT_ID"),
+		CityID:        os.Getenv("SHIPPING_ORIGIN_CITY_ID"),
+		City:          os.Getenv("SHIPPING_ORIGIN_CITY"),
+		Province:      os.Getenv("SHIPPING_ORIGIN_PROVINCE"),
+		PostalCode:    os.Getenv("SHIPPING_ORIGIN_POSTAL_CODE"),
+	}
+}
+
+// ShippingCouriers are the couriers offered at checkout, SHIPPING_COURIERS (default jne,jnt,sicepat)
+func ShippingCouriers() []string {
+	couriers := []string{}
+	for _, courier := range strings.Split(envString("SHIPPING_COURIERS", "jne,jnt,sicepat"), ",") {
+		courier = strings.ToLower(strings.TrimSpace(courier))
+		if _, ok := courierNames[courier]; ok {
+			couriers = append(couriers, courier)
+		}
+	}
+	return couriers
+}
+
+// DefaultPerfumeWeight is the weight in grams of a bottle without a weight, SHIPPING_DEFAULT_WEIGHT (default 400)
+func DefaultPerfumeWeight() int {
+	return envInt("SHIPPING_DEFAULT_WEIGHT", 400)
+}
+
+// PackagingWeight is the weight in grams of the box and padding added to every parcel, SHIPPING_PACKAGING_WEIGHT (default 200)
+func PackagingWeight() int {
+	return envInt("SHIPPING_PACKAGING_WEIGHT", 200)
+}
+
+// ShippingQuoteTTL is how long a quote can be used at checkout, SHIPPING_QUOTE_TTL (default 30m)
+func ShippingQuoteTTL() time.Duration {
+	return envDuration("SHIPPING_QUOTE_TTL", 30*time.Minute)
+}
+
+// perfumeWeight is the shipping weight of one bottle in grams
+func perfumeWeight(perfume *model.Perfume) int {
+	weight, err := strconv.Atoi(strings.TrimSpace(perfume.Weight))
+	if err != nil || weight <= 0 {
+		return DefaultPerfumeWeight()
+	}
+	return weight
+}
+
+// parcelWeight is the weight in grams of a parcel with the given lines, packaging included
+func parcelWeight(items []model.CartItem, perfumes map[primitive.ObjectID]*model.Perfume) int {
+	weight := PackagingWeight()
+	for _, item := range items {
+		if perfume, ok := perfumes[item.PerfumeID]; ok {
+			weight += perfumeWeight(perfume) * item.Quantity
+		}
+	}
+	return weight
+}
+
+// checkShippingDestination makes sure a destination names a place the provider can price
+func checkShippingDestination(destination *model.ShippingLocation) error {
+	destination.SubdistrictID = strings.TrimSpace(destination.SubdistrictID)
+	destination.CityID = strings.TrimSpace(destination.CityID)
+	destination.City = strings.TrimSpace(destination.City)
+	destination.Province = strings.TrimSpace(destination.Province)
+	destination.PostalCode = strings.TrimSpace(destination.PostalCode)
+
+	if destination.SubdistrictID == "" && destination.CityID == "" && destination.City == "" && destination.Province == "" {
+		return fmt.Errorf("%w: the destination needs a city or province", ErrInvalidShipping)
+	}
+	return nil
+}
+
+// TableRate is a row of the rate table: what a courier service charges per kilogram in a zone
+type TableRate struct {
+	Courier     string  `json:"courier"`
+	Service     string  `json:"service"`
+	Description string  `json:"description"`
+	Zone        string  `json:"zone"` // city, province or national
+	PerKg       float64 `json:"per_kg"`
+	ETD         string  `json:"etd"`
+}
+
+// Zones of the rate table, from the origin warehouse's point of view
+const (
+	zoneCity     = "city"
+	zoneProvince = "province"
+	zoneNational = "national"
+)
+
+// defaultTableRates are typical rates in rupiah of the supported couriers
+var defaultTableRates = []TableRate{
+	{Courier: "jne", Service: "REG", Description: "Layanan Reguler", Zone: zoneCity, PerKg: 9000, ETD: "1-2"},
+	{Courier: "jne", Service: "REG", Description: "Layanan Reguler", Zone: zoneProvince, PerKg: 14000, ETD: "2-3"},
+	{Courier: "jne", Service: "REG", Description: "Layanan Reguler", Zone: zoneNational, PerKg: 24000, ETD: "3-5"},
+	{Courier: "jne", Service: "YES", Description: "Yakin Esok Sampai", Zone: zoneCity, PerKg: 18000, ETD: "1"},
+	{Courier: "jne", Service: "YES", Description: "Yakin Esok Sampai", Zone: zoneProvince, PerKg: 26000, ETD: "1"},
+	{Courier: "jne", Service: "YES", Description: "Yakin Esok Sampai", Zone: zoneNational, PerKg: 40000, ETD: "1"},
+	{Courier: "jnt", Service: "EZ", Description: "Regular Service", Zone: zoneCity, PerKg: 8000, ETD: "1-2"},
+	{Courier: "jnt", Service: "EZ", Description: "Regular Service", Zone: zoneProvince, PerKg: 13000, ETD: "2-3"},
+	{Courier: "jnt", Service: "EZ", Description: "Regular Service", Zone: zoneNational, PerKg: 22000, ETD: "3-6"},
+	{Courier: "sicepat", Service: "REG", Description: "Layanan Reguler", Zone: zoneCity, PerKg: 8500, ETD: "1-2"},
+	{Courier: "sicepat", Service: "REG", Description: "Layanan Reguler", Zone: zoneProvince, PerKg: 13500, ETD: "2-3"},
+	{Courier: "sicepat", Service: "REG", Description: "Layanan Reguler", Zone: zoneNational, PerKg: 23000, ETD: "3-5"},
+	{Courier: "sicepat", Service: "BEST", Description: "Besok Sampai Tujuan", Zone: zoneCity, PerKg: 15000, ETD: "1"},
+	{Courier: "sicepat", Service: "BEST", Description: "Besok Sampai Tujuan", Zone: zoneProvince, PerKg: 22000, ETD: "1"},
+	{Courier: "sicepat", Service: "BEST", Description: "Besok Sampai Tujuan", Zone: zoneNational, PerKg: 35000, ETD: "1-2"},
+}
+
+// TableShipping prices parcels from a rate table by zone and weight. It needs no network, cannot track
+// parcels and is used when the courier API is not configured or fails.
+type TableShipping struct {
+	Rates []TableRate
+}
+
+// NewTableShipping loads the rate table from SHIPPING_RATES_FILE, or uses the built-in rates
+func NewTableShipping() (*TableShipping, error) {
+	path := os.Getenv("SHIPPING_RATES_FILE")
+	if path == "" {
+		return &TableShipping{Rates: defaultTableRates}, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shipping rates: %v", err)
+	}
+	var rates []TableRate
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return nil, fmt.Errorf("failed to parse shipping rates: %v", err)
+	}
+	for i, rate := range rates {
+		if _, ok := courierNames[rate.Courier]; !ok || rate.Service == "" || rate.PerKg <= 0 {
+			return nil, fmt.Errorf("invalid shipping rate %d: a known courier, a service and per_kg are required", i+1)
+		}
+		if rate.Zone != zoneCity && rate.Zone != zoneProvince && rate.Zone != zoneNational {
+			return nil, fmt.Errorf("invalid shipping rate %d: unknown zone %q", i+1, rate.Zone)
+		}
+	}
+	return &TableShipping{Rates: rates}, nil
+}
+
+// Name identifies the provider on quotes
+func (t *TableShipping) Name() string {
+	return "table"
+}
+
+// shippingZone tells how far a destination is from the origin, places are compared by name
+func shippingZone(origin, destination model.ShippingLocation) string {
+	switch {
+	case origin.City != "" && strings.EqualFold(origin.City, destination.City) && (destination.Province == "" || strings.EqualFold(origin.Province, destination.Province)):
+		return zoneCity
+	case origin.Province != "" && strings.EqualFold(origin.Province, destination.Province):
+		return zoneProvince
+	}
+	return zoneNational
+}
+
+// Quote prices the parcel per started kilogram, as couriers bill
+func (t *TableShipping) Quote(ctx context.Context, origin, destination model.ShippingLocation, weight int, couriers []string) ([]model.ShippingRate, error) {
+	if destination.City == "" && destination.Province == "" {
+		return nil, fmt.Errorf("%w: the rate table needs the destination city or province", ErrInvalidShipping)
+	}
+
+	zone := shippingZone(origin, destination)
+	kilograms := math.Max(1, math.Ceil(float64(weight)/1000))
+
+	rates := []model.ShippingRate{}
+	for _, courier := range couriers {
+		for _, rate := range t.Rates {
+			if rate.Courier != courier || rate.Zone != zone {
+				continue
+			}
+			rates = append(rates, model.ShippingRate{
+				Courier:     rate.Courier,
+				CourierName: courierNames[rate.Courier],
+				Service:     rate.Service,
+				Description: rate.Description,
+				Cost:        rate.PerKg * kilograms,
+				ETD:         rate.ETD,
+			})
+		}
+	}
+	return rates, nil
+}
+
+// Track is not possible without a courier API
+func (t *TableShipping) Track(ctx context.Context, courier, awb string) (*model.Tracking, error) {
+	return nil, ErrTrackingUnsupported
+}
+
+// quoteRates asks the provider for rates and falls back to the rate table when the courier API fails
+func quoteRates(ctx context.Context, destination model.ShippingLocation, weight int) ([]model.ShippingRate, string, bool, error) {
+	couriers := ShippingCouriers()
+	if len(couriers) == 0 {
+		return nil, "", false, fmt.Errorf("%w: no couriers are configured", ErrInvalidShipping)
+	}
+
+	rates, err := Shipping.Quote(ctx, ShippingOrigin(), destination, weight, couriers)
+	if err == nil {
+		return rates, Shipping.Name(), false, nil
+	}
+	if errors.Is(err, ErrInvalidShipping) {
+		return nil, "", false, err
+	}
+	if shippingFallback == nil {
+		return nil, "", false, fmt.Errorf("%w: %v", ErrShippingUnavailable, err)
+	}
+
+	log.Printf("Failed to get shipping rates from %s, using the rate table: %v", Shipping.Name(), err)
+	rates, fallbackErr := shippingFallback.Quote(ctx, ShippingOrigin(), destination, weight, couriers)
+	if fallbackErr != nil {
+		return nil, "", false, fmt.Errorf("%w: %v", ErrShippingUnavailable, err)
+	}
+	return rates, shippingFallback.Name(), true, nil
+}
+
+// QuoteShipping prices shipping the given perfumes, or the owner's cart when there are none, to a destination.
+// The quote is saved so checkout can charge exactly the rate the customer picked.
+func QuoteShipping(request model.ShippingQuoteRequest, owner CartOwner) (*model.ShippingQuote, error) {
+	if Shipping == nil {
+		return nil, fmt.Errorf("%w: no shipping provider is configured", ErrInvalidShipping)
+	}
+
+	// A saved address replaces the destination, only its owner can ship to it
+	var address *model.Address
+	if request.AddressID != "" {
+		if owner.UserID == nil {
+			return nil, fmt.Errorf("%w: log in to ship to a saved address", ErrInvalidShipping)
+		}
+		var err error
+		address, err = GetAddress(request.AddressID, *owner.UserID)
+		if err != nil {
+			if errors.Is(err, ErrAddressNotFound) {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidShipping, err)
+			}
+			return nil, err
+		}
+		request.Destination, err = addressDestination(context.TODO(), address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := checkShippingDestination(&request.Destination); err != nil {
+		return nil, err
+	}
+
+	items := []model.CartItem{}
+	for _, item := range request.Items {
+		objID, err := primitive.ObjectIDFromHex(item.PerfumeID)
+		if err != nil || item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: every item needs a perfume_id and a quantity", ErrInvalidShipping)
+		}
+		items = append(items, model.CartItem{PerfumeID: objID, Quantity: item.Quantity})
+	}
+	if len(items) == 0 && owner.valid() {
+		cart, err := findCart(context.TODO(), owner)
+		if err != nil {
+			return nil, err
+		}
+		if cart != nil {
+			items = cart.Items
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: there is nothing to ship", ErrInvalidShipping)
+	}
+
+	perfumes, err := cartPerfumes(context.TODO(), items)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if _, ok := perfumes[item.PerfumeID]; !ok {
+			return nil, fmt.Errorf("%w: perfume %s not found", ErrInvalidShipping, item.PerfumeID.Hex())
+		}
+	}
+
+	weight := parcelWeight(items, perfumes)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rates, provider, estimated, err := quoteRates(ctx, request.Destination, weight)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rates, func(i, j int) bool { return rates[i].Cost < rates[j].Cost })
+
+	now := time.Now()
+	quote := &model.ShippingQuote{
+		QuoteID:     primitive.NewObjectID(),
+		Provider:    provider,
+		Destination: request.Destination,
+		Address:     address,
+		Weight:      weight,
+		Rates:       rates,
+		Estimated:   estimated,
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(ShippingQuoteTTL())),
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+	}
+
+	quoteCollection := config.MongoDB.Collection("shipping_quotes")
+	if _, err := quoteCollection.InsertOne(context.TODO(), quote); err != nil {
+		return nil, fmt.Errorf("failed to save shipping quote: %v", err)
+	}
+
+	return quote, nil
+}
+
+// orderShipping looks up the rate picked at checkout. The quote must not have expired and must be for the
+// weight being ordered, otherwise the cart changed since and the customer has to quote again. A quote for a
+// saved address can only be used by the address's owner.
+func orderShipping(ctx context.Context, choice *model.ShippingChoice, weight int, userID primitive.ObjectID) (*model.OrderShipping, error) {
+	quoteID, err := primitive.ObjectIDFromHex(choice.QuoteID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid shipping quote_id", ErrInvalidOrder)
+	}
+
+	quoteCollection := config.MongoDB.Collection("shipping_quotes")
+
+	var quote model.ShippingQuote
+	err = quoteCollection.FindOne(ctx, bson.M{"_id": quoteID}).Decode(&quote)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("%w: the shipping quote expired, quote again", ErrInvalidOrder)
+		}
+		return nil, fmt.Errorf("failed to fetch shipping quote: %v", err)
+	}
+	if quote.ExpiresAt.Time().Before(time.Now()) {
+		return nil, fmt.Errorf("%w: the shipping quote expired, quote again", ErrInvalidOrder)
+	}
+	if quote.Weight != weight {
+		return nil, fmt.Errorf("%w: the cart changed since shipping was quoted, quote again", ErrInvalidOrder)
+	}
+	if quote.Address != nil && quote.Address.UserID != userID {
+		return nil, fmt.Errorf("%w: the shipping quote is for another customer's address", ErrInvalidOrder)
+	}
+
+	for _, rate := range quote.Rates {
+		if strings.EqualFold(rate.Courier, choice.Courier) && strings.EqualFold(rate.Service, choice.Service) {
+			return &model.OrderShipping{
+				Destination: quote.Destination,
+				Address:     quote.Address,
+				Courier:     rate.Courier,
+				CourierName: rate.CourierName,
+				Service:     rate.Service,
+				Cost:        rate.Cost,
+				ETD:         rate.ETD,
+				Weight:      quote.Weight,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the shipping quote has no %s %s service", ErrInvalidOrder, choice.Courier, choice.Service)
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength keeps product URLs readable, longer slugs are cut at a word boundary
+const maxSlugLength = 80
+
+// slugLetters transliterates letters that do not decompose into an ASCII letter and a combining mark
+var slugLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+	'\'': "", '’': "", '&': " and ", '+': " plus ", '@': " at ",
+}
+
+// slugify turns a display name into a URL friendly identifier: accents are stripped, other letters
+// transliterated and every run of remaining characters becomes a single hyphen
+func slugify(text string) string {
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if replacement, ok := slugLetters[r]; ok {
+			for _, c := range replacement {
+				hyphen = writeSlugRune(&slug, c, hyphen)
+			}
+			continue
+		}
+		hyphen = writeSlugRune(&slug, r, hyphen)
+	}
+
+	result := strings.Trim(slug.String(), "-")
+	if len(result) > maxSlugLength {
+		result = result[:maxSlugLength]
+		if cut := strings.LastIndex(result, "-"); cut > 0 {
+			result = result[:cut]
+		}
+	}
+	return result
+}
+
+// writeSlugRune appends an ASCII letter or digit, anything else becomes a hyphen unless one was just written
+func writeSlugRune(slug *strings.Builder, r rune, hyphen bool) bool {
+	if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+		slug.WriteRune(r)
+		return false
+	}
+	if !hyphen && slug.Len() > 0 {
+		slug.WriteByte('-')
+	}
+	return true
+}
+
+// perfumeSlugBase is the slug a perfume gets before any collision suffix
+func perfumeSlugBase(brand, name string) string {
+	base := slugify(brand + " " + name)
+	if base == "" {
+		base = "perfume"
+	}
+	return base
+}
+
+// reservePerfumeSlug claims the first free slug of base, base-2, base-3... for a perfume.
+// Every slug ever used is a document in perfume_slugs keyed by the slug itself, so two perfumes
+// can never claim the same one, and a perfume that gets its old name back reclaims its old slug.
+func reservePerfumeSlug(perfumeID primitive.ObjectID, base string) (string, error) {
+	slugCollection := config.MongoDB.Collection("perfume_slugs")
+
+	for n := 1; n <= 1000; n++ {
+		candidate := base
+		if n > 1 {
+			candidate = base + "-" + strconv.Itoa(n)
+		}
+
+		_, err := slugCollection.InsertOne(context.TODO(), bson.M{
+			"_id":        candidate,
+			"perfume_id": perfumeID,
+			"created_at": primitive.NewDateTimeFromTime(time.Now()),
+		})
+		if err == nil {
+			return candidate, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return "", fmt.Errorf("failed to reserve slug: %v", err)
+		}
+
+		// Taken, unless it already belongs to this perfume
+		var existing model.PerfumeSlug
+		if err := slugCollection.FindOne(context.TODO(), bson.M{"_id": candidate}).Decode(&existing); err != nil {
+			return "", fmt.Errorf("failed to check slug: %v", err)
+		}
+		if existing.PerfumeID == perfumeID {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free slug for %q", base)
+}
+
+// releasePerfumeSlug frees a slug reserved for a perfume that was never saved
+func releasePerfumeSlug(perfumeID primitive.ObjectID, slug string) {
+	slugCollection := config.MongoDB.Collection("perfume_slugs")
+	slugCollection.DeleteOne(context.TODO(), bson.M{"_id": slug, "perfume_id": perfumeID})
+}
+
+// refreshPerfumeSlug gives a perfume a new slug after its brand or name changed. The old slug stays
+// reserved for the perfume, so old URLs keep redirecting to the new one. Slugs are derived from
+// the perfume and do not change its version.
+func refreshPerfumeSlug(perfumeID primitive.ObjectID, currentSlug, brand, name string) {
+	base := perfumeSlugBase(brand, name)
+	if currentSlug != "" && slugHasBase(currentSlug, base) {
+		return
+	}
+
+	slug, err := reservePerfumeSlug(perfumeID, base)
+	if err != nil {
+		log.Printf("Failed to update slug of perfume %s: %v", perfumeID.Hex(), err)
+		return
+	}
+
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	if _, err := perfumeCollection.UpdateOne(context.TODO(), bson.M{"_id": perfumeID}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+		log.Printf("Failed to save slug of perfume %s: %v", perfumeID.Hex(), err)
+	}
+}
+
+// slugHasBase reports whether slug is base itself or base with a collision suffix
+func slugHasBase(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+	suffix, found := strings.CutPrefix(slug, base+"-")
+	if !found {
+		return false
+	}
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// GetPerfumeBySlug finds the perfume a slug belongs to, current or old. Callers compare the slug with
+// perfume.Slug to tell whether it is the canonical one or an old one that should redirect.
+func GetPerfumeBySlug(slug string) (*model.Perfume, error) {
+	slugCollection := config.MongoDB.Collection("perfume_slugs")
+
+	var perfumeSlug model.PerfumeSlug
+	err := slugCollection.FindOne(context.TODO(), bson.M{"_id": strings.ToLower(slug)}).Decode(&perfumeSlug)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("perfume not found")
+		}
+		return nil, fmt.Errorf("failed to find slug: %v", err)
+	}
+
+	return GetPerfumeByID(perfumeSlug.PerfumeID.Hex())
+}
+
+// GetSitemapPerfumes returns the slug and last change of every published perfume for the sitemap
+func GetSitemapPerfumes(ctx context.Context) ([]model.Perfume, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	filter := catalogue(bson.M{"slug": bson.M{"$nin": bson.A{nil, ""}}})
+	opts := options.Find().
+		SetProjection(bson.M{"slug": 1, "updated_at": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := perfumeCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	perfumes := []model.Perfume{}
+	if err = cursor.All(ctx, &perfumes); err != nil {
+		return nil, fmt.Errorf("failed to decode perfumes: %v", err)
+	}
+
+	return perfumes, nil
+}
+
+// BackfillPerfumeSlugs gives every perfume created before slugs existed its slug, returning how many were updated
+func BackfillPerfumeSlugs(ctx context.Context) (int, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	opts := options.Find().SetProjection(bson.M{"brand": 1, "name": 1}).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := perfumeCollection.Find(ctx, bson.M{"slug": bson.M{"$in": bson.A{nil, ""}}}, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch perfumes without slug: %v", err)
+	}
+	var perfumes []model.Perfume
+	if err := cursor.All(ctx, &perfumes); err != nil {
+		return 0, fmt.Errorf("failed to decode perfumes without slug: %v", err)
+	}
+
+	updated := 0
+	for _, perfume := range perfumes {
+		slug, err := reservePerfumeSlug(perfume.PerfumeID, perfumeSlugBase(perfume.Brand, perfume.Name))
+		if err != nil {
+			return updated, err
+		}
+		if _, err := perfumeCollection.UpdateOne(ctx, bson.M{"_id": perfume.PerfumeID}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+			return updated, fmt.Errorf("failed to save slug: %v", err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
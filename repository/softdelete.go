@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GilangAndhika/elfume/config"
+	"github.com/GilangAndhika/elfume/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Lifecycle states used by the admin listings
+const (
+	StateArchived = "archived"
+	StateDeleted  = "deleted"
+)
+
+// notDeleted adds the condition that excludes soft deleted documents to a filter
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	return filter
+}
+
+// listed adds the conditions that hide soft deleted and archived documents from public listings
+func listed(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	filter["archived"] = bson.M{"$ne": true}
+	return filter
+}
+
+// stateFilter matches documents in one of the inactive states
+func stateFilter(state string) (bson.M, error) {
+	switch state {
+	case StateDeleted:
+		return bson.M{"deleted_at": bson.M{"$ne": nil}}, nil
+	case StateArchived:
+		return bson.M{"deleted_at": nil, "archived": true}, nil
+	}
+	return nil, fmt.Errorf("unknown state %q, use %s or %s", state, StateArchived, StateDeleted)
+}
+
+// SoftDeleteRetention is how long soft deleted documents are kept before they are purged,
+// SOFT_DELETE_RETENTION (default 720h, 30 days)
+func SoftDeleteRetention() time.Duration {
+	return envDuration("SOFT_DELETE_RETENTION", 30*24*time.Hour)
+}
+
+// setArchived archives or unarchives a document that is not deleted
+func setArchived(collection *mongo.Collection, id string, archived bool, expectedVersion *int64, notFound error) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid ID format: %v", err)
+	}
+
+	set := bson.M{"archived": archived, "updated_at": primitive.NewDateTimeFromTime(time.Now())}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if archived {
+		set["archived_at"] = primitive.NewDateTimeFromTime(time.Now())
+	} else {
+		update["$unset"] = bson.M{"archived_at": ""}
+	}
+
+	base := notDeleted(bson.M{"_id": objID})
+	result, err := collection.UpdateOne(context.TODO(), versionFilter(base, expectedVersion), update)
+	if err != nil {
+		return fmt.Errorf("failed to update archive state: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return versionMissError(collection, base, notFound)
+	}
+
+	return nil
+}
+
+// restore brings back a soft deleted or archived document
+func restore(collection *mongo.Collection, id string, notFound error) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid ID format: %v", err)
+	}
+
+	filter := bson.M{"_id": objID, "$or": bson.A{
+		bson.M{"deleted_at": bson.M{"$ne": nil}},
+		bson.M{"archived": true},
+	}}
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{
+		"$set":   bson.M{"archived": false, "updated_at": primitive.NewDateTimeFromTime(time.Now())},
+		"$unset": bson.M{"deleted_at": "", "archived_at": ""},
+		"$inc":   bson.M{"version": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// ArchivePerfume hides a perfume from listings while keeping it readable by ID
+func ArchivePerfume(id string, expectedVersion *int64) error {
+	return setArchived(config.MongoDB.Collection("perfumes"), id, true, expectedVersion, fmt.Errorf("perfume not found"))
+}
+
+// RestorePerfume brings back a soft deleted or archived perfume
+func RestorePerfume(id string) error {
+	return restore(config.MongoDB.Collection("perfumes"), id, fmt.Errorf("perfume not found or not deleted"))
+}
+
+// GetInactivePerfumes lists archived or soft deleted perfumes
+func GetInactivePerfumes(state string) ([]model.Perfume, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+
+	filter, err := stateFilter(state)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := perfumeCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch perfumes: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	perfumes := []model.Perfume{}
+	if err = cursor.All(context.Background(), &perfumes); err != nil {
+		return nil, fmt.Errorf("failed to decode perfumes: %v", err)
+	}
+
+	return perfumes, nil
+}
+
+// ArchiveUser deactivates a user: hidden from listings and unable to log in
+func ArchiveUser(id string, expectedVersion *int64) error {
+	return setArchived(config.MongoDB.Collection("users"), id, true, expectedVersion, fmt.Errorf("user not found"))
+}
+
+// RestoreUser brings back a soft deleted or archived user
+func RestoreUser(id string) error {
+	return restore(config.MongoDB.Collection("users"), id, fmt.Errorf("user not found or not deleted"))
+}
+
+// GetInactiveUsers lists archived or soft deleted users
+func GetInactiveUsers(state string) ([]model.User, error) {
+	userCollection := config.MongoDB.Collection("users")
+
+	filter, err := stateFilter(state)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := userCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %v", err)
+	}
+	defer cursor.Close(context.Background())
+
+	users := []model.User{}
+	if err = cursor.All(context.Background(), &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %v", err)
+	}
+
+	return users, nil
+}
+
+// PurgeDeleted permanently removes perfumes and users that were soft deleted longer than retention ago.
+// The image files of purged perfumes and the addresses of purged users are deleted as well. It returns how many documents were removed.
+func PurgeDeleted(ctx context.Context, retention time.Duration) (int, error) {
+	perfumeCollection := config.MongoDB.Collection("perfumes")
+	userCollection := config.MongoDB.Collection("users")
+	slugCollection := config.MongoDB.Collection("perfume_slugs")
+
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-retention))
+	expired := bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": cutoff}}
+
+	cursor, err := perfumeCollection.Find(ctx, expired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch deleted perfumes: %v", err)
+	}
+	var perfumes []model.Perfume
+	if err := cursor.All(ctx, &perfumes); err != nil {
+		return 0, fmt.Errorf("failed to decode deleted perfumes: %v", err)
+	}
+
+	purged := 0
+	for _, perfume := range perfumes {
+		// Delete one by one so a perfume restored in the meantime is left alone
+		var removed model.Perfume
+		err := perfumeCollection.FindOneAndDelete(ctx, bson.M{"_id": perfume.PerfumeID, "deleted_at": expired["deleted_at"]}).Decode(&removed)
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				log.Printf("Failed to purge perfume %s: %v", perfume.PerfumeID.Hex(), err)
+			}
+			continue
+		}
+		deleteStoredImages(removed.Images, "perfume purged")
+		slugCollection.DeleteMany(ctx, bson.M{"perfume_id": removed.PerfumeID})
+		purged++
+	}
+
+	userIDs, err := userCollection.Distinct(ctx, "_id", expired)
+	if err != nil {
+		return purged, fmt.Errorf("failed to fetch deleted users: %v", err)
+	}
+	addressCollection := config.MongoDB.Collection("addresses")
+	for _, userID := range userIDs {
+		result, err := userCollection.DeleteOne(ctx, bson.M{"_id": userID, "deleted_at": expired["deleted_at"]})
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge deleted users: %v", err)
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		// Saved addresses are personal data and go with their user
+		if _, err := addressCollection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+			log.Printf("Failed to purge addresses of user %v: %v", userID, err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxSpreadsheetPart limits how much of one file inside an XLSX archive is decompressed
+const maxSpreadsheetPart = 64 << 20
+
+// readSpreadsheet returns the rows of a CSV file or of the first sheet of an XLSX workbook.
+// The format is detected from the content, XLSX files are ZIP archives.
+func readSpreadsheet(content []byte) (string, [][]string, error) {
+	if bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+		rows, err := readXLSX(content)
+		return "xlsx", rows, err
+	}
+	rows, err := readCSV(content)
+	return "csv", rows, err
+}
+
+// readCSV parses comma or semicolon separated values, whichever the header line uses
+// (spreadsheets saved with an Indonesian or European locale use semicolons)
+func readCSV(content []byte) ([][]string, error) {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	header, _, _ := bytes.Cut(content, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(content))
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	return rows, nil
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxText is a plain (<t>) or rich text (<r><t>) string
+type xlsxText struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"`
+}
+
+func (t xlsxText) String() string {
+	return t.Text + strings.Join(t.Runs, "")
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads the cell values of the first worksheet. Only what an import needs is supported:
+// shared, inline and formula strings, numbers and booleans. Styles are ignored, so dates arrive
+// as Excel serial numbers.
+func readXLSX(content []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX: %v", err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	// Find the first sheet through the workbook relationships
+	var workbook xlsxWorkbook
+	if err := readXLSXPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("failed to read XLSX: workbook has no sheets")
+	}
+	var relationships xlsxRelationships
+	if err := readXLSXPart(files, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, relationship := range relationships.Relationships {
+		if relationship.ID == workbook.Sheets[0].RelID {
+			sheetPath = relationship.Target
+			if strings.HasPrefix(sheetPath, "/") {
+				sheetPath = strings.TrimPrefix(sheetPath, "/")
+			} else {
+				sheetPath = path.Join("xl", sheetPath)
+			}
+		}
+	}
+	if sheetPath == "" {
+		return nil, fmt.Errorf("failed to read XLSX: first sheet not found")
+	}
+
+	// Shared strings are optional, a workbook with only numbers has none
+	var sharedStrings xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readXLSXPart(files, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheet xlsxSheet
+	if err := readXLSXPart(files, sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{}
+	for _, row := range sheet.Rows {
+		// Empty rows are left out of the sheet, keep the row numbers the user sees
+		for row.Number > len(rows)+1 {
+			rows = append(rows, []string{})
+		}
+
+		values := []string{}
+		for i, cell := range row.Cells {
+			column := xlsxColumn(cell.Ref)
+			if column < 0 {
+				column = i
+			}
+			for len(values) <= column {
+				values = append(values, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("failed to read XLSX: invalid shared string in %s", cell.Ref)
+				}
+				values[column] = sharedStrings.Items[index].String()
+			case "inlineStr":
+				values[column] = cell.Inline.String()
+			case "b":
+				values[column] = strconv.FormatBool(cell.Value == "1")
+			default:
+				values[column] = cell.Value
+			}
+		}
+		rows = append(rows, values)
+	}
+
+	return rows, nil
+}
+
+// readXLSXPart decodes one XML file of the archive
+func readXLSXPart(files map[string]*zip.File, name string, target interface{}) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("failed to read XLSX: %s is missing", name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read XLSX: %v", err)
+	}
+	defer reader.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(reader, maxSpreadsheetPart)).Decode(target); err != nil {
+		return fmt.Errorf("failed to read XLSX %s: %v", name, err)
+	}
+	return nil
+}
+
+// xlsxColumn converts the letters of a cell reference (e.g. "AB12") to a zero based column index,
+// -1 when the reference is missing
+func xlsxColumn(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+	}
+	return column - 1
+}
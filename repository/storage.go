@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ImageStore saves image files somewhere clients can download them from
+type ImageStore interface {
+	// Save stores the content under key and returns its public URL
+	Save(ctx context.Context, key string, content []byte, contentType string) (string, error)
+	// Delete removes the file stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// ImageLister is implemented by image stores that can enumerate their files, used to find orphans
+type ImageLister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// ImagePresigner is implemented by image stores clients can upload to directly
+type ImagePresigner interface {
+	// PresignUpload returns a URL that accepts a PUT of the object with the given content type until it expires
+	PresignUpload(ctx context.Context, key string, contentType string, expires time.Duration) (string, error)
+	// Fetch downloads an object, failing for objects larger than maxBytes
+	Fetch(ctx context.Context, key string, maxBytes int64) ([]byte, error)
+}
+
+// ErrObjectNotFound is returned by Fetch when nothing was uploaded under the key
+var ErrObjectNotFound = errors.New("object not found")
+
+// Images is the image store selected by InitImageStore
+var Images ImageStore
+
+// InitImageStore selects the image store from the IMAGE_STORE environment variable.
+// Supported values are "github" (default), "local" and "s3".
+func InitImageStore() error {
+	switch os.Getenv("IMAGE_STORE") {
+	case "", "github":
+		store, err := NewGithubImageStore()
+		if err != nil {
+			return err
+		}
+		Images = store
+	case "local":
+		Images = NewLocalImageStore()
+	case "s3":
+		store, err := NewS3ImageStore()
+		if err != nil {
+			return err
+		}
+		Images = store
+	default:
+		return fmt.Errorf("unknown IMAGE_STORE %q", os.Getenv("IMAGE_STORE"))
+	}
+
+	return nil
+}
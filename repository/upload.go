@@ -1,66 +1,39 @@
 package repository
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"os"
-
-	"github.com/GilangAndhika/elfume/model"
+	"context"
 )
 
-// UploadtoGithub uploads a file to GitHub repository
-func UploadtoGithub(fileName, Content string) error {
-	// Get GitHub credentials from environment variables
-	githubOwner := os.Getenv("GITHUB_OWNER")
-	githubRepo := os.Getenv("GITHUB_REPO")
-	githubToken := os.Getenv("GITHUB_TOKEN")
-
-	// Validate GitHub credentials
-	if githubOwner == "" || githubRepo == "" || githubToken == "" {
-		return errors.New("GitHub connection details are missing in environment variables")
-	}
-
-	// Create GitHub API URL
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", githubOwner, githubRepo, fileName)
-
-	// Create request payload
-	uploadRequest := model.GithubUploadRequest{
-		Message: "Upload file image " + fileName,
-		Content: Content,
-	}
+// GithubImageStore keeps images in a GitHub repository through the Contents API
+type GithubImageStore struct {
+	Client *GithubClient
+}
 
-	jsonData, err := json.Marshal(uploadRequest)
+// NewGithubImageStore creates a store backed by a GithubClient configured from the environment
+func NewGithubImageStore() (*GithubImageStore, error) {
+	client, err := NewGithubClient()
 	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, err
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("PUT", apiURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-	req.Header.Set("Authorization", "Bearer "+githubToken)
-	req.Header.Set("Content-Type", "application/json")
+	return &GithubImageStore{Client: client}, nil
+}
 
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+// Save uploads (or overwrites) the file and returns its raw.githubusercontent.com URL
+func (s *GithubImageStore) Save(ctx context.Context, key string, content []byte, contentType string) (string, error) {
+	if err := s.Client.PutFile(ctx, key, content, "Upload file image "+key); err != nil {
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, _ := ioutil.ReadAll(resp.Body)
+	return s.Client.RawURL(key), nil
+}
 
-	// Fix: Correctly handle 201 Created as success
-	if resp.StatusCode == http.StatusCreated {
-		return nil // Success, return no error
-	}
+// Delete removes the file from the repository
+func (s *GithubImageStore) Delete(ctx context.Context, key string) error {
+	return s.Client.DeleteFile(ctx, key, "Delete file image "+key)
+}
 
-	return fmt.Errorf("failed to upload file: %s, response: %s", resp.Status, string(body))
+// List returns every file below the configured path prefix
+func (s *GithubImageStore) List(ctx context.Context) ([]string, error) {
+	return s.Client.ListFiles(ctx)
 }
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrVersionConflict is returned when a write expected a version the document no longer has
+var ErrVersionConflict = errors.New("document was modified by another request")
+
+// versionMatch builds the filter value for a document version. Documents written before
+// versions existed have no version field and count as version 0.
+func versionMatch(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// versionFilter returns a copy of filter that also requires the expected version,
+// a nil expected version matches any version
+func versionFilter(filter bson.M, expectedVersion *int64) bson.M {
+	versioned := bson.M{}
+	for key, value := range filter {
+		versioned[key] = value
+	}
+	if expectedVersion != nil {
+		versioned["version"] = versionMatch(*expectedVersion)
+	}
+	return versioned
+}
+
+// versionMissError explains why a versioned write matched nothing: either no document matches
+// the filter without the version, or the document was changed
+func versionMissError(collection *mongo.Collection, filter bson.M, notFound error) error {
+	count, err := collection.CountDocuments(context.TODO(), filter)
+	if err == nil && count > 0 {
+		return ErrVersionConflict
+	}
+	return notFound
+}
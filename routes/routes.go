@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/GilangAndhika/elfume/controller"
 	"github.com/GilangAndhika/elfume/middleware"
+	"github.com/GilangAndhika/elfume/model"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
@@ -14,6 +15,9 @@ func URL(app *fiber.App) {
 		return c.SendString("Hello, Elfume connected!")
 	})
 
+	// Sitemap of every published perfume
+	app.Get("/sitemap.xml", controller.GetSitemap)
+
 	// Auth routes (Registration & Login)
 	AuthRoutes := app.Group("/auth")
 	AuthRoutes.Post("/register", controller.Registration)
@@ -25,21 +29,139 @@ func URL(app *fiber.App) {
 	UserRoutes.Get("/all", controller.GetAllUsers)
 	UserRoutes.Get("/id/:id", controller.GetUserByID)
 	UserRoutes.Put("/update/:id", controller.UpdateUser)
+	UserRoutes.Patch("/update/:id", middleware.JWTMiddleware(), controller.PatchUser)
 	UserRoutes.Delete("/delete/:id", controller.DeleteUser)
 
+	// Admin only: archived and deleted users
+	UserRoutes.Get("/inactive", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetInactiveUsers)
+	UserRoutes.Put("/archive/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.ArchiveUser)
+	UserRoutes.Put("/restore/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.RestoreUser)
+
 	// Role routes
 	RoleRoutes := app.Group("/role")
 	RoleRoutes.Post("/create", controller.CreateRole)
 
+	// Address book of the logged in customer
+	AddressRoutes := app.Group("/addresses", middleware.JWTMiddleware())
+	AddressRoutes.Get("/", controller.GetAddresses)
+	AddressRoutes.Post("/", controller.CreateAddress)
+	AddressRoutes.Get("/:id", controller.GetAddress)
+	AddressRoutes.Put("/:id", controller.UpdateAddress)
+	AddressRoutes.Put("/:id/default", controller.SetDefaultAddress)
+	AddressRoutes.Delete("/:id", controller.DeleteAddress)
+
+	// Indonesian administrative regions for the address form's dropdowns
+	RegionRoutes := app.Group("/regions")
+	RegionRoutes.Get("/", controller.GetProvinces)
+	RegionRoutes.Get("/:code", controller.GetRegion)
+	RegionRoutes.Get("/:code/children", controller.GetRegionChildren)
+
+	// Cart routes, for guests (cart cookie) and logged in customers
+	CartRoutes := app.Group("/cart", middleware.OptionalJWT())
+	CartRoutes.Get("/", controller.GetCart)
+	CartRoutes.Delete("/", controller.ClearCart)
+	CartRoutes.Post("/items", controller.AddCartItem)
+	CartRoutes.Put("/items/:itemId", controller.UpdateCartItem)
+	CartRoutes.Delete("/items/:itemId", controller.RemoveCartItem)
+	CartRoutes.Post("/promo-codes", controller.AddPromoCode)
+	CartRoutes.Delete("/promo-codes/:code", controller.RemovePromoCode)
+
+	// Vouchers and automatic promotions, for admins
+	PromotionRoutes := app.Group("/promotions", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin))
+	PromotionRoutes.Get("/", controller.GetPromotions)
+	PromotionRoutes.Post("/", controller.CreatePromotion)
+	PromotionRoutes.Get("/:id", controller.GetPromotion)
+	PromotionRoutes.Put("/:id", controller.UpdatePromotion)
+	PromotionRoutes.Delete("/:id", controller.DeletePromotion)
+
+	// Shipping rates, for guests (cart cookie) and logged in customers
+	ShippingRoutes := app.Group("/shipping", middleware.OptionalJWT())
+	ShippingRoutes.Post("/quote", controller.QuoteShipping)
+
+	// Order routes, for logged in customers
+	OrderRoutes := app.Group("/orders", middleware.JWTMiddleware())
+	OrderRoutes.Post("/", middleware.Idempotency("order.create"), controller.PlaceOrder)
+	OrderRoutes.Get("/", controller.GetMyOrders)
+	OrderRoutes.Get("/all", middleware.RequireRole(model.RoleAdmin), controller.GetAllOrders)
+	OrderRoutes.Get("/:id", controller.GetOrder)
+	OrderRoutes.Post("/:id/cancel", controller.CancelOrder)
+	OrderRoutes.Put("/:id/status", middleware.RequireRole(model.RoleAdmin), controller.UpdateOrderStatus)
+	OrderRoutes.Post("/:id/payment", middleware.Idempotency("order.payment"), controller.StartPayment)
+	OrderRoutes.Get("/:id/payments", controller.GetOrderPayments)
+	OrderRoutes.Get("/:id/shipment", controller.GetShipment)
+	OrderRoutes.Post("/:id/shipment", middleware.RequireRole(model.RoleAdmin), controller.CreateShipment)
+	OrderRoutes.Post("/:id/shipment/refresh", middleware.RequireRole(model.RoleAdmin), controller.RefreshShipment)
+
+	// Flash sales. Buying goes through the waiting room, which turns buyers away with 503 when this instance is overloaded.
+	FlashSaleRoutes := app.Group("/flash-sales")
+	FlashSaleRoutes.Get("/", controller.GetFlashSales)
+	FlashSaleRoutes.Get("/:id", controller.GetFlashSale)
+	FlashSaleRoutes.Post("/", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.CreateFlashSale)
+	FlashSaleRoutes.Post("/:id/end", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.EndFlashSale)
+	FlashSaleRoutes.Post("/:id/buy", middleware.JWTMiddleware(), middleware.WaitingRoom(), middleware.Idempotency("flashsale.buy"), controller.BuyFlashSale)
+
+	// Payment provider notifications, verified by their signature
+	app.Post("/payments/notification", controller.PaymentNotification)
+
 	// Perfume routes
 	PerfumeRoutes := app.Group("/fume")
-	PerfumeRoutes.Post("/create", controller.CreatePerfume)
-	PerfumeRoutes.Post("/insert", controller.CreatePerfumeWithoutImage)
+	PerfumeRoutes.Post("/create", middleware.Idempotency("perfume.create"), controller.CreatePerfume)
+	PerfumeRoutes.Post("/insert", middleware.Idempotency("perfume.insert"), controller.CreatePerfumeWithoutImage)
 	PerfumeRoutes.Get("/all", controller.GetAllPerfumes)
-	PerfumeRoutes.Get("/id/:id", controller.GetPerfumeByID)
+	PerfumeRoutes.Get("/id/:id", middleware.OptionalJWT(), controller.GetPerfumeByID)
+	PerfumeRoutes.Get("/slug/:slug", middleware.OptionalJWT(), controller.GetPerfumeBySlug)
 	PerfumeRoutes.Get("/search", controller.GetFilteredPerfumes)
-	PerfumeRoutes.Put("/update/:id", controller.UpdatePerfume)
+	PerfumeRoutes.Get("/export", controller.ExportPerfumes)
+	PerfumeRoutes.Put("/update/:id", middleware.OptionalJWT(), controller.UpdatePerfume)
+	PerfumeRoutes.Patch("/update/:id", middleware.OptionalJWT(), controller.PatchPerfume)
 	PerfumeRoutes.Delete("/delete/:id", controller.DeletePerfume)
+	PerfumeRoutes.Put("/categories/:id", controller.SetPerfumeCategories)
+
+	// Admin only: archived and deleted perfumes
+	PerfumeRoutes.Get("/inactive", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetInactivePerfumes)
+	PerfumeRoutes.Put("/archive/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.ArchivePerfume)
+	PerfumeRoutes.Put("/restore/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.RestorePerfume)
+
+	// Admin only: perfume lifecycle (draft, scheduled, published, discontinued)
+	PerfumeRoutes.Get("/status", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetPerfumesByStatus)
+	PerfumeRoutes.Put("/status/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.UpdatePerfumeStatus)
+
+	// Admin only: spreadsheet imports
+	PerfumeRoutes.Post("/import", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), middleware.Idempotency("perfume.import"), controller.ImportPerfumes)
+	PerfumeRoutes.Get("/import", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetImportJobs)
+	PerfumeRoutes.Get("/import/:jobId", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetImportJob)
+
+	// Admin only: bulk catalogue updates
+	PerfumeRoutes.Post("/bulk/preview", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.PreviewBulkUpdate)
+	PerfumeRoutes.Post("/bulk", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), middleware.Idempotency("perfume.bulk"), controller.RunBulkUpdate)
+	PerfumeRoutes.Get("/bulk", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetBulkOperations)
+	PerfumeRoutes.Get("/bulk/:id", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetBulkOperation)
+
+	// Perfume revision history
+	PerfumeRoutes.Get("/:id/revisions", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.GetPerfumeRevisions)
+	PerfumeRoutes.Post("/:id/revisions/:revisionId/restore", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.RestorePerfumeRevision)
+
+	// Perfume image gallery routes
+	PerfumeRoutes.Post("/images/reconcile", middleware.JWTMiddleware(), middleware.RequireRole(model.RoleAdmin), controller.ReconcileImages)
+	PerfumeRoutes.Get("/:id/images", controller.GetPerfumeImages)
+	PerfumeRoutes.Post("/:id/images", controller.AddPerfumeImages)
+	PerfumeRoutes.Post("/:id/images/presign", controller.PresignPerfumeImage)
+	PerfumeRoutes.Post("/:id/images/uploads/:uploadId/confirm", controller.ConfirmPerfumeImage)
+	PerfumeRoutes.Put("/:id/images/order", controller.ReorderPerfumeImages)
+	PerfumeRoutes.Put("/:id/images/:imageId", controller.UpdatePerfumeImage)
+	PerfumeRoutes.Put("/:id/images/:imageId/primary", controller.SetPrimaryPerfumeImage)
+	PerfumeRoutes.Put("/:id/images/:imageId/file", controller.ReplacePerfumeImage)
+	PerfumeRoutes.Delete("/:id/images/:imageId", controller.DeletePerfumeImage)
+
+	// Category routes
+	CategoryRoutes := app.Group("/category")
+	CategoryRoutes.Post("/create", controller.CreateCategory)
+	CategoryRoutes.Get("/all", controller.GetAllCategories)
+	CategoryRoutes.Get("/tree", controller.GetCategoryTree)
+	CategoryRoutes.Get("/id/:id", controller.GetCategoryByID)
+	CategoryRoutes.Get("/id/:id/perfumes", controller.GetCategoryPerfumes)
+	CategoryRoutes.Put("/update/:id", controller.UpdateCategory)
+	CategoryRoutes.Delete("/delete/:id", controller.DeleteCategory)
 
 	// Protected route (requires authentication)
 	app.Get("/protected", middleware.JWTMiddleware(), func(c *fiber.Ctx) error {